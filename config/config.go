@@ -1,5 +1,11 @@
 package config
 
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
 type MinioConfig struct {
 	Endpoint        string `yaml:"endpoint"`
 	AccessKeyID     string `yaml:"accesskeyid"`
@@ -7,10 +13,166 @@ type MinioConfig struct {
 	UseSSL          bool   `yaml:"usessl"`
 	BucketName      string `yaml:"bucketname"`
 	FolderPath      string `yaml:"folderpath"`
+	// TrustETag set to false ignores the ETags of the endpoint, for gateways
+	// returning random ETags; objects are then compared by size and
+	// modification time
+	TrustETag *bool `yaml:"trustETag,omitempty"`
+	// Encryption is the encryption at rest of the objects in the bucket
+	Encryption *EncryptionConfig `yaml:"encryption,omitempty"`
+	// Credentials loads the keys from a source that can change while a sync
+	// runs, see CredentialsConfig
+	Credentials *CredentialsConfig `yaml:"credentials,omitempty"`
+}
+
+// CredentialsConfig loads the keys of an endpoint from a file, the
+// environment or STS instead of the config file. They are loaded again when
+// a request is denied, when the file changes and every RefreshInterval, so
+// long runs survive key rotations.
+type CredentialsConfig struct {
+	// Source is "file", "env" or "sts"
+	Source string `yaml:"source"`
+	// File is a credentials file in the format of the AWS CLI, by default
+	// the one named by AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials.
+	// Profiles with a credential_process run it to get the keys.
+	File    string `yaml:"file,omitempty"`
+	Profile string `yaml:"profile,omitempty"`
+	// STSEndpoint issues temporary keys for the access keys of the endpoint,
+	// by default the endpoint itself as supported by MinIO
+	STSEndpoint string `yaml:"stsEndpoint,omitempty"`
+	// RoleARN is the role assumed with AWS STS
+	RoleARN string `yaml:"roleARN,omitempty"`
+	// Duration is how long STS keys are valid, default 1h
+	Duration time.Duration `yaml:"duration,omitempty"`
+	// RefreshInterval loads the keys again at this interval
+	RefreshInterval time.Duration `yaml:"refreshInterval,omitempty"`
+}
+
+// Sources of CredentialsConfig
+const (
+	CredentialsFile = "file"
+	CredentialsEnv  = "env"
+	CredentialsSTS  = "sts"
+)
+
+// EncryptionConfig is the server-side encryption objects are read and
+// written with. Without it, uploads follow the default encryption of the
+// bucket.
+type EncryptionConfig struct {
+	// Type is "sse-c" for keys provided by the client, "sse-kms" for keys
+	// held by the KMS of the server or "sse-s3"; empty picks it by which of
+	// the keys is set
+	Type string `yaml:"type,omitempty"`
+	// Key is the base64 encoded 256-bit SSE-C key. It is sent with every
+	// read and write, as objects encrypted with it can't be read without.
+	Key string `yaml:"key,omitempty"`
+	// KMSKeyID is the SSE-KMS key uploads are encrypted with
+	KMSKeyID string `yaml:"kmsKeyID,omitempty"`
+}
+
+// Server-side encryption types
+const (
+	EncryptionSSEC   = "sse-c"
+	EncryptionSSEKMS = "sse-kms"
+	EncryptionSSES3  = "sse-s3"
+)
+
+// ETagTrusted reports whether the ETags of the endpoint are used
+func (c *MinioConfig) ETagTrusted() bool {
+	return c.TrustETag == nil || *c.TrustETag
+}
+
+// S3Config is an S3-compatible destination such as AWS S3
+type S3Config struct {
+	// Endpoint defaults to s3.amazonaws.com
+	Endpoint        string `yaml:"endpoint,omitempty"`
+	Region          string `yaml:"region,omitempty"`
+	AccessKeyID     string `yaml:"accesskeyid"`
+	SecretAccessKey string `yaml:"secretaccesskey"`
+	// SessionToken is set for temporary credentials, e.g. from AWS STS
+	SessionToken string `yaml:"sessiontoken,omitempty"`
+	UseSSL       bool   `yaml:"usessl"`
+	// Addressing is "path" (endpoint/bucket), "virtual-hosted"
+	// (bucket.endpoint) or empty to pick virtual-hosted for AWS endpoints
+	// and path-style for everything else
+	Addressing string `yaml:"addressing,omitempty"`
+	BucketName string `yaml:"bucketname"`
+	FolderPath string `yaml:"folderpath"`
+	// TrustETag works as for MinioConfig
+	TrustETag *bool `yaml:"trustETag,omitempty"`
+	// Encryption works as for MinioConfig
+	Encryption *EncryptionConfig `yaml:"encryption,omitempty"`
+	// Credentials works as for MinioConfig
+	Credentials *CredentialsConfig `yaml:"credentials,omitempty"`
+}
+
+// ETagTrusted reports whether the ETags of the endpoint are used
+func (c *S3Config) ETagTrusted() bool {
+	return c.TrustETag == nil || *c.TrustETag
+}
+
+// GCSConfig is a Google Cloud Storage destination
+type GCSConfig struct {
+	// Endpoint defaults to storage.googleapis.com
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// CredentialsFile is the JSON key file of a service account, by default
+	// the file named by GOOGLE_APPLICATION_CREDENTIALS
+	CredentialsFile string `yaml:"credentialsfile,omitempty"`
+	BucketName      string `yaml:"bucketname"`
+	FolderPath      string `yaml:"folderpath"`
+	// TrustETag works as for MinioConfig
+	TrustETag *bool `yaml:"trustETag,omitempty"`
+}
+
+// ETagTrusted reports whether the ETags of the endpoint are used
+func (c *GCSConfig) ETagTrusted() bool {
+	return c.TrustETag == nil || *c.TrustETag
 }
 
 type LocalConfig struct {
 	Path string `yaml:"path"`
+	// Encryption encrypts the saved files, see LocalEncryptionConfig
+	Encryption *LocalEncryptionConfig `yaml:"encryption,omitempty"`
+	// Compress compresses the saved files with zstd or gzip, adding the
+	// extension of the format to their names; empty or none stores them as
+	// they are
+	Compress string `yaml:"compress,omitempty"`
+	// Pack packs small files into tar archives, see PackConfig
+	Pack *PackConfig `yaml:"pack,omitempty"`
+	// FileMode sets the permissions of saved files, an octal number like
+	// "0640"; empty keeps the default 0644 less the umask
+	FileMode string `yaml:"fileMode,omitempty"`
+	// Owner sets the owner of saved files, "user:group" with names or
+	// numeric IDs where either part may be left out
+	Owner string `yaml:"owner,omitempty"`
+}
+
+// PackConfig packs small files saved to the local main destination into
+// rolling tar archives instead of one file each, saving inodes. The project
+// database records the archive and offset of every packed file.
+type PackConfig struct {
+	// MaxFileSize is the size up to which files are packed, e.g. "64KB"
+	MaxFileSize string `yaml:"maxFileSize"`
+	// ArchiveSize starts a new archive once the current one has reached it,
+	// default 1GB
+	ArchiveSize string `yaml:"archiveSize,omitempty"`
+}
+
+// Formats files saved to a local destination can be compressed with
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+// LocalEncryptionConfig encrypts files saved to a local destination with
+// AES-256-GCM. Each file gets a sidecar holding what is needed to decrypt
+// it except the key.
+type LocalEncryptionConfig struct {
+	// Key is the base64 encoded 256-bit key
+	Key string `yaml:"key,omitempty"`
+	// KeyEnv names the environment variable holding the key instead, so it
+	// isn't stored in the config file
+	KeyEnv string `yaml:"keyEnv,omitempty"`
 }
 
 type DestinationType string
@@ -18,22 +180,447 @@ type DestinationType string
 const (
 	DestinationMinio DestinationType = "minio"
 	DestinationLocal DestinationType = "local"
+	DestinationS3    DestinationType = "s3"
+	DestinationGCS   DestinationType = "gcs"
 )
 
+// IsObjectStore reports whether the destination is a bucket rather than a
+// local folder
+func (t DestinationType) IsObjectStore() bool {
+	return t == DestinationMinio || t == DestinationS3 || t == DestinationGCS
+}
+
+// DestinationConfig is an additional destination of a multi-destination
+// project. Include and exclude take glob patterns matched against the full
+// object key, or against the file name when the pattern has no "/".
+type DestinationConfig struct {
+	Name    string          `yaml:"name"`
+	Type    DestinationType `yaml:"type"`
+	Dest    *MinioConfig    `yaml:"dest,omitempty"`
+	S3      *S3Config       `yaml:"s3,omitempty"`
+	GCS     *GCSConfig      `yaml:"gcs,omitempty"`
+	Local   *LocalConfig    `yaml:"local,omitempty"`
+	Include []string        `yaml:"include,omitempty"`
+	Exclude []string        `yaml:"exclude,omitempty"`
+	// Range copies only a part of every file, e.g. to collect the headers of
+	// large media files for analysis
+	Range *ByteRangeConfig `yaml:"range,omitempty"`
+}
+
+// ByteRangeConfig selects Length bytes of a file starting at Offset, which
+// defaults to the start of the file. Sizes are given like "64KB". Files that
+// end within the range are copied up to their end.
+type ByteRangeConfig struct {
+	Offset string `yaml:"offset,omitempty"`
+	Length string `yaml:"length"`
+}
+
+// OrderingRule holds back files matching Files until every other file in
+// the same folder is completed, e.g. to copy "*.manifest" files only after
+// the data files they reference. After narrows the files waited for; it uses
+// the same pattern syntax as DestinationConfig.
+type OrderingRule struct {
+	Files string   `yaml:"files"`
+	After []string `yaml:"after,omitempty"`
+}
+
+// MetadataRule adds metadata and tags to the files matching Files when they
+// are copied to an object storage destination, e.g. to record where they
+// were migrated from. Files uses the pattern syntax of DestinationConfig and
+// matches every file when empty. Values may contain the placeholders
+// {project}, {source_endpoint}, {source_bucket}, {path}, {etag} and {run_id}.
+type MetadataRule struct {
+	Files string `yaml:"files,omitempty"`
+	// Metadata are user metadata keys, with or without the X-Amz-Meta-
+	// prefix, and their values
+	Metadata map[string]string `yaml:"metadata,omitempty"`
+	Tags     map[string]string `yaml:"tags,omitempty"`
+}
+
+// PrefixBudget reserves Workers for the files below Prefix, so that a run
+// copies latency-sensitive folders with more workers than bulk data. Files
+// below no configured prefix use the regular worker count.
+type PrefixBudget struct {
+	Prefix  string `yaml:"prefix"`
+	Workers int    `yaml:"workers"`
+}
+
+// AtomicCommitConfig makes groups of files visible at the main destination
+// all at once. Files are uploaded below StagingPrefix and moved to their final
+// keys only after every file of their group was copied.
+type AtomicCommitConfig struct {
+	// GroupDepth is the number of folder levels below the source folder that
+	// identify a group, e.g. 1 makes every top-level folder a group. Zero
+	// disables atomic commits.
+	GroupDepth int `yaml:"groupDepth,omitempty"`
+	// StagingPrefix defaults to ".staging"
+	StagingPrefix string `yaml:"stagingPrefix,omitempty"`
+}
+
+// CompressionConfig gzips matching files on their way to MinIO and S3
+// destinations. The objects are stored with Content-Encoding: gzip, which HTTP
+// clients decode transparently, and keep the original size and ETag as metadata.
+type CompressionConfig struct {
+	// Patterns select the files to compress, e.g. "*.csv" or "logs/*.json"
+	Patterns []string `yaml:"patterns,omitempty"`
+	// MinSize leaves smaller files uncompressed, e.g. "4KB"
+	MinSize string `yaml:"minSize,omitempty"`
+}
+
+// MultipartConfig controls resumable uploads of large objects to MinIO and
+// S3 destinations. The uploaded parts are tracked in the project database, so
+// an interrupted transfer resumes at the first missing part.
+type MultipartConfig struct {
+	// Threshold is the object size from which uploads are resumable,
+	// default "256MB"
+	Threshold string `yaml:"threshold,omitempty"`
+	// PartSize defaults to "64MB" and grows if an object would need more
+	// than 10000 parts
+	PartSize string `yaml:"partSize,omitempty"`
+	// Streams is how many parts of one object are read from the source and
+	// uploaded at once, default 1. Several streams speed up single large
+	// objects over links where one TCP connection can't fill the bandwidth.
+	Streams int `yaml:"streams,omitempty"`
+}
+
+// ChangePolicy decides what happens when a source object changes after it
+// was copied
+type ChangePolicy string
+
+const (
+	// ChangeRequeue copies the changed object again, replacing the copy
+	ChangeRequeue ChangePolicy = "requeue"
+	// ChangeKeep keeps the first copy and flags the file as drifted
+	ChangeKeep ChangePolicy = "keep"
+	// ChangeVersion moves the existing copy below VersionPrefix, then copies
+	// the changed object
+	ChangeVersion ChangePolicy = "version"
+)
+
+// ChangeConfig configures the handling of source objects that change after
+// they were copied
+type ChangeConfig struct {
+	// Policy defaults to requeue
+	Policy ChangePolicy `yaml:"policy,omitempty"`
+	// VersionPrefix defaults to ".versions"
+	VersionPrefix string `yaml:"versionPrefix,omitempty"`
+}
+
+// RestoreConfig controls source objects in archive storage classes (e.g.
+// GLACIER), which can't be read until they are restored
+type RestoreConfig struct {
+	// Request issues a restore request when such an object is found;
+	// otherwise the restore is left to the operator
+	Request bool `yaml:"request,omitempty"`
+	// Days the restored copy stays readable, default 7
+	Days int `yaml:"days,omitempty"`
+	// Tier is the retrieval tier: Standard (default), Bulk or Expedited
+	Tier string `yaml:"tier,omitempty"`
+	// Window is how long to wait before the object is read again,
+	// default 12h
+	Window time.Duration `yaml:"window,omitempty"`
+}
+
+// RunMarkerConfig publishes the outcome of every successful sync at the
+// main destination, so consumers of the copy can tell how fresh it is
+// without access to the copier
+type RunMarkerConfig struct {
+	// Key of the JSON object the run statistics are written to, e.g.
+	// _sync/last_run.json; empty to write none
+	Key string `yaml:"key,omitempty"`
+	// BucketTags sets the statistics as tags of the destination bucket,
+	// for MinIO and S3 destinations
+	BucketTags bool `yaml:"bucketTags,omitempty"`
+}
+
+// AllowlistConfig lets a service decide which files a project may copy, for
+// organizations that manage data residency rules centrally. The service is
+// asked about the keys of the listed and pending files, and files it denies
+// are left out like those excluded by the filters.
+type AllowlistConfig struct {
+	// URL the keys are posted to; empty to copy every file
+	URL string `yaml:"url,omitempty"`
+	// Token is sent as bearer token, if set
+	Token string `yaml:"token,omitempty"`
+	// CacheTTL is how long answers are reused, default 10m
+	CacheTTL time.Duration `yaml:"cacheTTL,omitempty"`
+	// Timeout of a request, default 30s
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// FailOpen copies files the service gave no answer for; by default
+	// they are held back until it does
+	FailOpen bool `yaml:"failOpen,omitempty"`
+}
+
+// ErasureConfig lets a service request the erasure of keys, so that
+// right-to-erasure requests reach long-term copies. Its feed is read before
+// every sync and by the forget command.
+type ErasureConfig struct {
+	// FeedURL returns the erasure requests; empty if keys are only erased
+	// with the forget command
+	FeedURL string `yaml:"feedURL,omitempty"`
+	// Token is sent as bearer token, if set
+	Token string `yaml:"token,omitempty"`
+	// Timeout of a request, default 30s
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// LegalHoldConfig protects destination objects under litigation hold from
+// deletion and overwrites, whatever the mirror, change, overwrite and
+// erasure settings are. Held keys that don't exist yet are still copied.
+type LegalHoldConfig struct {
+	// Keys are held destination keys, or prefixes when they end with a slash
+	Keys []string `yaml:"keys,omitempty"`
+	// Tags hold the objects at MinIO, S3 and GCS destinations that have one
+	// of these tags with the given value
+	Tags map[string]string `yaml:"tags,omitempty"`
+}
+
+// StateBackendConfig keeps the state of a project, its file list and run
+// history, in a database server instead of the SQLite file in its project
+// directory, so several copier hosts can share it
+type StateBackendConfig struct {
+	// Driver is sqlite, the default, or postgres
+	Driver string `yaml:"driver,omitempty"`
+	// DSN is the connection string of the server, e.g.
+	// postgres://copier:secret@db:5432/copier?sslmode=disable
+	DSN string `yaml:"dsn,omitempty"`
+}
+
+// DistributedConfig lets several copier instances sync one project at the
+// same time, sharing its state in a database server. Every instance claims a
+// file before copying it, so no file is copied twice.
+type DistributedConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// InstanceID names the instance holding a claim, by default the host
+	// name and process ID
+	InstanceID string `yaml:"instanceId,omitempty"`
+	// Lease is how long a claim lasts unless its instance renews it, so the
+	// files of a crashed instance are picked up by the others; default 5m
+	Lease time.Duration `yaml:"lease,omitempty"`
+}
+
+// ConflictPolicy decides which side wins when an object changed at both the
+// source and the destination since the last bidirectional sync
+type ConflictPolicy string
+
+const (
+	// ConflictNewestWins keeps the object modified last
+	ConflictNewestWins ConflictPolicy = "newest-wins"
+	// ConflictSourceWins always keeps the source object
+	ConflictSourceWins ConflictPolicy = "source-wins"
+	// ConflictSkip leaves both sides alone and reports the conflict
+	ConflictSkip ConflictPolicy = "skip"
+)
+
+// OverwritePolicy decides whether a sync replaces an object that already
+// exists at the main destination
+type OverwritePolicy string
+
+const (
+	// OverwriteNever keeps every existing destination object
+	OverwriteNever OverwritePolicy = "never"
+	// OverwriteIfNewer replaces destination objects modified before the
+	// source object
+	OverwriteIfNewer OverwritePolicy = "if-newer"
+	// OverwriteIfDifferent replaces destination objects whose size or ETag
+	// differ from the source object
+	OverwriteIfDifferent OverwritePolicy = "if-different"
+	// OverwriteAlways copies every file without checking the destination
+	OverwriteAlways OverwritePolicy = "always"
+)
+
+// TuningConfig holds per-project defaults for the tuning flags of sync, run
+// and apply. Flags given on the command line take precedence.
+type TuningConfig struct {
+	Workers int `yaml:"workers,omitempty"`
+	// SkipExisting also checks files requeued to be copied again for objects
+	// already present at the destination, SkipExistingByListing finds them
+	// with a listing
+	SkipExisting          bool `yaml:"skipExisting,omitempty"`
+	SkipExistingByListing bool `yaml:"skipExistingByListing,omitempty"`
+	// BandwidthLimit caps the rate all workers together read from the
+	// source, e.g. "50MB/s"; empty means unlimited
+	BandwidthLimit string `yaml:"bandwidthLimit,omitempty"`
+	// Retries is how often a failing MinIO or S3 request is attempted,
+	// RetryInterval the pause between attempts
+	Retries       int           `yaml:"retries,omitempty"`
+	RetryInterval time.Duration `yaml:"retryInterval,omitempty"`
+	// MaxRetries is how often a failing file is attempted before it is set
+	// to failed, RetryBackoff the pause before its next attempt, doubled
+	// with every further failure
+	MaxRetries   int           `yaml:"maxRetries,omitempty"`
+	RetryBackoff time.Duration `yaml:"retryBackoff,omitempty"`
+	// PaceLatency reduces the transfers in flight while the p95 latency of
+	// destination requests exceeds it; zero disables pacing
+	PaceLatency time.Duration `yaml:"paceLatency,omitempty"`
+	// Nice lowers the CPU priority of the copier like nice(1), from 1 to
+	// 19; on Windows it selects a lower priority class. Zero leaves the
+	// priority unchanged.
+	Nice int `yaml:"nice,omitempty"`
+	// IOPriority is the disk I/O priority on Linux, "idle" or "low"
+	IOPriority string `yaml:"ioPriority,omitempty"`
+	// DiskWriteLimit caps the rate files are written to a local
+	// destination, e.g. "20MB/s"; empty means unlimited
+	DiskWriteLimit string `yaml:"diskWriteLimit,omitempty"`
+	// Checksum compares local copies with the source by their SHA256
+	// checksums instead of their size and modification time
+	Checksum bool `yaml:"checksum,omitempty"`
+	// Overwrite decides whether existing destination objects are replaced,
+	// default if-different
+	Overwrite OverwritePolicy `yaml:"overwrite,omitempty"`
+	// ChunkConcurrency downloads objects larger than ChunkSize, default
+	// "64MB", to a local destination in that many concurrent byte ranges;
+	// both also override the part size and streams of multipart uploads
+	ChunkSize        string `yaml:"chunkSize,omitempty"`
+	ChunkConcurrency int    `yaml:"chunkConcurrency,omitempty"`
+}
+
+// AlertConfig defines the thresholds that trigger alerts during a sync run
+type AlertConfig struct {
+	// MinThroughput is the minimum acceptable transfer rate per second, e.g. "10MB"
+	MinThroughput string `yaml:"minThroughput,omitempty"`
+	// MaxErrorRate is the maximum acceptable fraction of failed files, e.g. 0.05
+	MaxErrorRate float64 `yaml:"maxErrorRate,omitempty"`
+	// BreachDuration is how long a breach must persist before alerting
+	BreachDuration time.Duration `yaml:"breachDuration,omitempty"`
+	// AbortOnBreach stops the run once an alert was emitted
+	AbortOnBreach bool `yaml:"abortOnBreach,omitempty"`
+	// WebhookURL receives alerts as JSON POST requests
+	WebhookURL string `yaml:"webhookURL,omitempty"`
+	// MaxPendingAge alerts after a sync when a file has been pending for
+	// longer, e.g. "24h"; zero disables the check
+	MaxPendingAge time.Duration `yaml:"maxPendingAge,omitempty"`
+}
+
+// EndpointConfig limits the load all projects together put on one endpoint
+type EndpointConfig struct {
+	// MaxTransfers is the number of concurrent transfers to or from the
+	// endpoint across all running projects; zero means no limit
+	MaxTransfers int `yaml:"maxTransfers,omitempty"`
+}
+
 // ProjectMinioConfig represents the YAML structure
 type ProjectMinioConfig struct {
 	Source   MinioConfig     `yaml:"source"`
 	DestType DestinationType `yaml:"destType"`
 	Dest     *MinioConfig    `yaml:"dest,omitempty"`
+	S3       *S3Config       `yaml:"s3,omitempty"`
+	GCS      *GCSConfig      `yaml:"gcs,omitempty"`
 	Local    *LocalConfig    `yaml:"local,omitempty"`
+	Alerts   AlertConfig     `yaml:"alerts,omitempty"`
+	// Include and exclude select the source files that are copied, see the
+	// -include and -exclude flags
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+	// MinSize and MaxSize select the source files by size, e.g. "5GB"
+	MinSize string `yaml:"minSize,omitempty"`
+	MaxSize string `yaml:"maxSize,omitempty"`
+	// NewerThan and OlderThan select the source files by the time since
+	// they were last modified, e.g. "7d"
+	NewerThan string `yaml:"newerThan,omitempty"`
+	OlderThan string `yaml:"olderThan,omitempty"`
+	// Destinations are copied to in addition to the main destination
+	Destinations []DestinationConfig `yaml:"destinations,omitempty"`
+	// Ordering delays files until the files they depend on are copied
+	Ordering []OrderingRule `yaml:"ordering,omitempty"`
+	// Prefixes copy the files below some prefixes with their own workers
+	Prefixes     []PrefixBudget     `yaml:"prefixes,omitempty"`
+	AtomicCommit AtomicCommitConfig `yaml:"atomicCommit,omitempty"`
+	Compression  CompressionConfig  `yaml:"compression,omitempty"`
+	OnChange     ChangeConfig       `yaml:"onChange,omitempty"`
+	Multipart    MultipartConfig    `yaml:"multipart,omitempty"`
+	Conflict     ConflictPolicy     `yaml:"conflict,omitempty"`
+	ProtectNewer bool               `yaml:"protectNewer,omitempty"`
+	// PreserveMetadata copies the content type, content encoding, user
+	// metadata, tags, storage class and ACL of source objects to object
+	// storage destinations
+	PreserveMetadata bool          `yaml:"preserveMetadata,omitempty"`
+	Schedule         string        `yaml:"schedule,omitempty"`
+	ClockSkew        time.Duration `yaml:"clockSkew,omitempty"`
+	Tuning           TuningConfig  `yaml:"tuning,omitempty"`
+	Restore          RestoreConfig `yaml:"restore,omitempty"`
+	// ReplicationStatus only copies source objects whose MinIO bucket
+	// replication failed or is still pending, and those not replicated
+	ReplicationStatus bool            `yaml:"replicationStatus,omitempty"`
+	RunMarker         RunMarkerConfig `yaml:"runMarker,omitempty"`
+	// StateBackend keeps the project state in a database server
+	StateBackend StateBackendConfig `yaml:"stateBackend,omitempty"`
+	Distributed  DistributedConfig  `yaml:"distributed,omitempty"`
+	// AddMetadata adds metadata and tags to copied objects
+	AddMetadata []MetadataRule `yaml:"addMetadata,omitempty"`
+	// Allowlist asks a central service which files may be copied
+	Allowlist AllowlistConfig `yaml:"allowlist,omitempty"`
+	// Erasure reads the erasure requests of a central service
+	Erasure ErasureConfig `yaml:"erasure,omitempty"`
+	// LegalHold protects destination objects from deletion and overwrites
+	LegalHold LegalHoldConfig `yaml:"legalHold,omitempty"`
 }
 
 // ProjectConfig represents the internal structure
 type ProjectConfig struct {
-	ProjectName   string         `yaml:"projectname"`
-	SourceMinio   MinioConfig    `yaml:"sourceminio"`
-	DestType      DestinationType `yaml:"desttype"`
-	DestMinio     MinioConfig    `yaml:"destminio"`
-	DestLocal     LocalConfig    `yaml:"destlocal"`
-	DatabasePath  string         `yaml:"databasepath"`
+	ProjectName      string              `yaml:"projectname"`
+	SourceMinio      MinioConfig         `yaml:"sourceminio"`
+	DestType         DestinationType     `yaml:"desttype"`
+	DestMinio        MinioConfig         `yaml:"destminio"`
+	DestS3           S3Config            `yaml:"dests3"`
+	DestGCS          GCSConfig           `yaml:"destgcs"`
+	DestLocal        LocalConfig         `yaml:"destlocal"`
+	Alerts           AlertConfig         `yaml:"alerts"`
+	Include          []string            `yaml:"include"`
+	Exclude          []string            `yaml:"exclude"`
+	MinSize          string              `yaml:"minsize"`
+	MaxSize          string              `yaml:"maxsize"`
+	NewerThan        string              `yaml:"newerthan"`
+	OlderThan        string              `yaml:"olderthan"`
+	Destinations     []DestinationConfig `yaml:"destinations"`
+	Ordering         []OrderingRule      `yaml:"ordering"`
+	Prefixes         []PrefixBudget      `yaml:"prefixes"`
+	AtomicCommit     AtomicCommitConfig  `yaml:"atomiccommit"`
+	Compression      CompressionConfig   `yaml:"compression"`
+	OnChange         ChangeConfig        `yaml:"onchange"`
+	Multipart        MultipartConfig     `yaml:"multipart"`
+	Conflict         ConflictPolicy      `yaml:"conflict"`
+	ProtectNewer     bool                `yaml:"protectnewer"`
+	PreserveMetadata bool                `yaml:"preservemetadata"`
+	AddMetadata      []MetadataRule      `yaml:"addmetadata"`
+	Schedule         string              `yaml:"schedule"`
+	ClockSkew        time.Duration       `yaml:"clockskew"`
+	Tuning           TuningConfig        `yaml:"tuning"`
+	Restore          RestoreConfig       `yaml:"restore"`
+	DatabasePath     string              `yaml:"databasepath"`
+	// ReplicationStatus leaves objects out that the source replicates itself
+	ReplicationStatus bool            `yaml:"replicationstatus"`
+	RunMarker         RunMarkerConfig `yaml:"runmarker"`
+	// ServerSideCopy overrides whether files are copied on the server, which
+	// is detected from the endpoints when nil, see the -server-side-copy flag
+	ServerSideCopy *bool `yaml:"-"`
+	// ListingCachePath is the bucket listing cache shared between projects
+	ListingCachePath string `yaml:"listingcachepath"`
+	// Endpoints holds the limits of endpoints shared between projects, by
+	// endpoint, and EndpointSlotsPath the database coordinating them
+	Endpoints         map[string]EndpointConfig `yaml:"endpoints"`
+	EndpointSlotsPath string                    `yaml:"endpointslotspath"`
+	// StateBackend keeps the project state in a database server instead of
+	// the database at DatabasePath
+	StateBackend StateBackendConfig `yaml:"statebackend"`
+	Distributed  DistributedConfig  `yaml:"distributed"`
+	Allowlist    AllowlistConfig    `yaml:"allowlist"`
+	Erasure      ErasureConfig      `yaml:"erasure"`
+	LegalHold    LegalHoldConfig    `yaml:"legalhold"`
+}
+
+// DestinationID identifies the storage of the main destination, so projects
+// writing to the same bucket or directory can be found
+func (c *ProjectConfig) DestinationID() string {
+	switch c.DestType {
+	case DestinationMinio:
+		return fmt.Sprintf("minio:%s/%s", c.DestMinio.Endpoint, c.DestMinio.BucketName)
+	case DestinationS3:
+		return fmt.Sprintf("s3:%s/%s", c.DestS3.Endpoint, c.DestS3.BucketName)
+	case DestinationGCS:
+		return fmt.Sprintf("gcs:%s/%s", c.DestGCS.Endpoint, c.DestGCS.BucketName)
+	case DestinationLocal:
+		return "local:" + filepath.Clean(c.DestLocal.Path)
+	}
+	return string(c.DestType)
 }
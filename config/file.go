@@ -10,6 +10,8 @@ import (
 
 type FileConfig struct {
 	Projects map[string]ProjectMinioConfig `yaml:"projects"`
+	// Endpoints limits the load on endpoints shared between projects
+	Endpoints map[string]EndpointConfig `yaml:"endpoints,omitempty"`
 }
 
 func LoadConfig(projectsDir string) (*FileConfig, error) {
@@ -71,8 +73,41 @@ func (f *FileConfig) GetProjectConfig(projectName string) (*ProjectConfig, error
 			UseSSL:         minioConfig.Source.UseSSL,
 			BucketName:     minioConfig.Source.BucketName,
 			FolderPath:     minioConfig.Source.FolderPath,
+			TrustETag:      minioConfig.Source.TrustETag,
+			Encryption: minioConfig.Source.Encryption,
+			Credentials: minioConfig.Source.Credentials,
 		},
-		DestType: minioConfig.DestType,
+		DestType:     minioConfig.DestType,
+		Alerts:       minioConfig.Alerts,
+		Include: minioConfig.Include,
+		Exclude: minioConfig.Exclude,
+		MinSize: minioConfig.MinSize,
+		MaxSize: minioConfig.MaxSize,
+		NewerThan: minioConfig.NewerThan,
+		OlderThan: minioConfig.OlderThan,
+		Destinations: minioConfig.Destinations,
+		Ordering:     minioConfig.Ordering,
+		Prefixes:     minioConfig.Prefixes,
+		AtomicCommit: minioConfig.AtomicCommit,
+		Compression:  minioConfig.Compression,
+		OnChange:     minioConfig.OnChange,
+		Multipart:    minioConfig.Multipart,
+		Conflict:     minioConfig.Conflict,
+		ProtectNewer: minioConfig.ProtectNewer,
+		PreserveMetadata: minioConfig.PreserveMetadata,
+		AddMetadata: minioConfig.AddMetadata,
+		ReplicationStatus: minioConfig.ReplicationStatus,
+		RunMarker: minioConfig.RunMarker,
+		StateBackend: minioConfig.StateBackend,
+		Distributed:  minioConfig.Distributed,
+		Allowlist:    minioConfig.Allowlist,
+		Erasure:      minioConfig.Erasure,
+		LegalHold:    minioConfig.LegalHold,
+		Schedule:     minioConfig.Schedule,
+		ClockSkew:    minioConfig.ClockSkew,
+		Tuning:       minioConfig.Tuning,
+		Restore:      minioConfig.Restore,
+		Endpoints:    f.Endpoints,
 	}
 
 	switch minioConfig.DestType {
@@ -85,12 +120,28 @@ func (f *FileConfig) GetProjectConfig(projectName string) (*ProjectConfig, error
 				UseSSL:         minioConfig.Dest.UseSSL,
 				BucketName:     minioConfig.Dest.BucketName,
 				FolderPath:     minioConfig.Dest.FolderPath,
+			TrustETag:      minioConfig.Dest.TrustETag,
+			Encryption: minioConfig.Dest.Encryption,
+			Credentials: minioConfig.Dest.Credentials,
 			}
 		}
+	case DestinationS3:
+		if minioConfig.S3 != nil {
+			config.DestS3 = *minioConfig.S3
+		}
+	case DestinationGCS:
+		if minioConfig.GCS != nil {
+			config.DestGCS = *minioConfig.GCS
+		}
 	case DestinationLocal:
 		if minioConfig.Local != nil {
 			config.DestLocal = LocalConfig{
 				Path: minioConfig.Local.Path,
+				Encryption: minioConfig.Local.Encryption,
+				Compress: minioConfig.Local.Compress,
+				Pack: minioConfig.Local.Pack,
+				FileMode: minioConfig.Local.FileMode,
+				Owner: minioConfig.Local.Owner,
 			}
 		}
 	}
@@ -112,8 +163,40 @@ func (f *FileConfig) SetProjectConfig(projectName string, cfg ProjectConfig) {
 			UseSSL:         cfg.SourceMinio.UseSSL,
 			BucketName:     cfg.SourceMinio.BucketName,
 			FolderPath:     cfg.SourceMinio.FolderPath,
+			TrustETag:      cfg.SourceMinio.TrustETag,
+			Encryption: cfg.SourceMinio.Encryption,
+			Credentials: cfg.SourceMinio.Credentials,
 		},
-		DestType: cfg.DestType,
+		DestType:     cfg.DestType,
+		Alerts:       cfg.Alerts,
+		Include: cfg.Include,
+		Exclude: cfg.Exclude,
+		MinSize: cfg.MinSize,
+		MaxSize: cfg.MaxSize,
+		NewerThan: cfg.NewerThan,
+		OlderThan: cfg.OlderThan,
+		Destinations: cfg.Destinations,
+		Ordering:     cfg.Ordering,
+		Prefixes:     cfg.Prefixes,
+		AtomicCommit: cfg.AtomicCommit,
+		Compression:  cfg.Compression,
+		OnChange:     cfg.OnChange,
+		Multipart:    cfg.Multipart,
+		Conflict:     cfg.Conflict,
+		ProtectNewer: cfg.ProtectNewer,
+		PreserveMetadata: cfg.PreserveMetadata,
+		AddMetadata: cfg.AddMetadata,
+		ReplicationStatus: cfg.ReplicationStatus,
+		RunMarker: cfg.RunMarker,
+		StateBackend: cfg.StateBackend,
+		Distributed:  cfg.Distributed,
+		Allowlist:    cfg.Allowlist,
+		Erasure:      cfg.Erasure,
+		LegalHold:    cfg.LegalHold,
+		Schedule:     cfg.Schedule,
+		ClockSkew:    cfg.ClockSkew,
+		Tuning:       cfg.Tuning,
+		Restore:      cfg.Restore,
 	}
 
 	switch cfg.DestType {
@@ -125,10 +208,24 @@ func (f *FileConfig) SetProjectConfig(projectName string, cfg ProjectConfig) {
 			UseSSL:         cfg.DestMinio.UseSSL,
 			BucketName:     cfg.DestMinio.BucketName,
 			FolderPath:     cfg.DestMinio.FolderPath,
+			TrustETag:      cfg.DestMinio.TrustETag,
+			Encryption: cfg.DestMinio.Encryption,
+			Credentials: cfg.DestMinio.Credentials,
 		}
+	case DestinationS3:
+		s3Config := cfg.DestS3
+		minioConfig.S3 = &s3Config
+	case DestinationGCS:
+		gcsConfig := cfg.DestGCS
+		minioConfig.GCS = &gcsConfig
 	case DestinationLocal:
 		minioConfig.Local = &LocalConfig{
 			Path: cfg.DestLocal.Path,
+			Encryption: cfg.DestLocal.Encryption,
+			Compress: cfg.DestLocal.Compress,
+			Pack: cfg.DestLocal.Pack,
+			FileMode: cfg.DestLocal.FileMode,
+			Owner: cfg.DestLocal.Owner,
 		}
 	}
 
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses a human readable size such as "50MB", "1.5G" or "1024"
+// into bytes. Units are binary (1KB = 1024 bytes). An empty string is zero.
+func ParseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", value, err)
+	}
+	if number < 0 {
+		return 0, fmt.Errorf("size must not be negative")
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// ParseRate parses a transfer rate such as "50MB/s" or "50MB" into bytes per
+// second. An empty string is zero.
+func ParseRate(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if trimmed, ok := strings.CutSuffix(strings.ToUpper(value), "/S"); ok {
+		value = trimmed
+	}
+	return ParseSize(value)
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Age brackets of the aging report
+const (
+	AgeUnderHour = "<1h"
+	AgeUnderDay  = "1h-24h"
+	AgeOverDay   = ">24h"
+)
+
+var ageBrackets = []string{AgeUnderHour, AgeUnderDay, AgeOverDay}
+
+// AgingCount is the number of files of a status in an age bracket. Pending
+// files age from their last status change, failed files from their first
+// failure.
+type AgingCount struct {
+	Status  FileStatus
+	Bracket string
+	Count   int64
+	Size    int64
+}
+
+// GetAgingCounts returns the pending and failed files of a project by age
+// bracket as of now
+func (d *Database) GetAgingCounts(projectName string, now time.Time) ([]AgingCount, error) {
+	since := d.db.dialect.timeValue(`CASE WHEN status = 'error' THEN COALESCE(first_error_at, updated_at) ELSE updated_at END`)
+	bound := d.db.dialect.timeValue("?")
+	query := `
+	SELECT status,
+		CASE WHEN ` + since + ` >= ` + bound + ` THEN 0 WHEN ` + since + ` >= ` + bound + ` THEN 1 ELSE 2 END AS bracket,
+		COUNT(*), COALESCE(SUM(size), 0)
+	FROM file_entries
+	WHERE project_name = ? AND status IN (?, ?)
+	GROUP BY 1, 2
+	ORDER BY 1 DESC, 2`
+
+	rows, err := d.db.Query(query,
+		now.Add(-time.Hour).UTC(), now.Add(-24*time.Hour).UTC(),
+		projectName, StatusPending, StatusError,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get aging counts: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []AgingCount
+	for rows.Next() {
+		var count AgingCount
+		var bracket int
+		if err := rows.Scan(&count.Status, &bracket, &count.Count, &count.Size); err != nil {
+			return nil, fmt.Errorf("failed to scan aging count: %w", err)
+		}
+		count.Bracket = ageBrackets[bracket]
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// GetOldestPending returns the number of pending and failed files of a
+// project and the time the longest waiting of them became pending or first
+// failed, zero if there are none
+func (d *Database) GetOldestPending(projectName string) (int64, time.Time, error) {
+	var count int64
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM file_entries WHERE project_name = ? AND status IN (?, ?)`,
+		projectName, StatusPending, StatusError).Scan(&count)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to count pending files: %w", err)
+	}
+	if count == 0 {
+		return 0, time.Time{}, nil
+	}
+
+	var updatedAt time.Time
+	var firstErrorAt sql.NullTime
+	since := d.db.dialect.timeValue(`CASE WHEN status = 'error' THEN COALESCE(first_error_at, updated_at) ELSE updated_at END`)
+	query := `
+	SELECT updated_at, first_error_at FROM file_entries
+	WHERE project_name = ? AND status IN (?, ?)
+	ORDER BY ` + since + `
+	LIMIT 1`
+	err = d.db.QueryRow(query, projectName, StatusPending, StatusError).Scan(&updatedAt, &firstErrorAt)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to get oldest pending file: %w", err)
+	}
+	if firstErrorAt.Valid {
+		return count, firstErrorAt.Time, nil
+	}
+	return count, updatedAt, nil
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+)
+
+// maxBatchLookup keeps lookups below SQLite's limit of bound parameters
+const maxBatchLookup = 500
+
+// Batch groups file entry writes into a single transaction
+type Batch struct {
+	tx *tx
+}
+
+func (d *Database) BeginBatch() (*Batch, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return &Batch{tx: tx}, nil
+}
+
+func (b *Batch) InsertFileEntry(entry *FileEntry) error {
+	return insertFileEntry(b.tx, entry)
+}
+
+func (b *Batch) UpdateFileEntry(entry *FileEntry) error {
+	return updateFileEntry(b.tx, entry)
+}
+
+func (b *Batch) SetDriftETag(id int64, etag string) error {
+	return setDriftETag(b.tx, id, etag)
+}
+
+func (b *Batch) SetStorageClass(id int64, storageClass string) error {
+	return setStorageClass(b.tx, id, storageClass)
+}
+
+func (b *Batch) InsertFileChange(change *FileChange) error {
+	return insertFileChange(b.tx, change)
+}
+
+func (b *Batch) SetPendingVersion(projectName, path, supersededPath string) error {
+	return setPendingVersion(b.tx, projectName, path, supersededPath)
+}
+
+func (b *Batch) UpdateFileStatus(id int64, status FileStatus, errorMessage string) error {
+	return updateFileStatus(b.tx, id, status, errorMessage)
+}
+
+func (b *Batch) UpdateFileStatusReason(id int64, status FileStatus, reason string) error {
+	return updateFileStatusReason(b.tx, id, status, reason)
+}
+
+func (b *Batch) RecordFailure(id int64, status FileStatus, errorMessage string, attempts int, retryAt time.Time) error {
+	return recordFailure(b.tx, id, status, errorMessage, attempts, retryAt)
+}
+
+func (b *Batch) RequestRestore(id int64, reason string, retryAt time.Time) error {
+	return requestRestore(b.tx, id, reason, retryAt)
+}
+
+func (b *Batch) SetSHA256(id int64, checksum string) error {
+	return setSHA256(b.tx, id, checksum)
+}
+
+func (b *Batch) SetStoredSize(id int64, storedSize int64) error {
+	return setStoredSize(b.tx, id, storedSize)
+}
+
+func (b *Batch) SetDestinationStatus(projectName, destination string, entry *FileEntry, status FileStatus, reason, errorMessage string) error {
+	return setDestinationStatus(b.tx, projectName, destination, entry, status, reason, errorMessage)
+}
+
+func (b *Batch) Commit() error {
+	if err := b.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+	return nil
+}
+
+func (b *Batch) Rollback() error {
+	return b.tx.Rollback()
+}
+
+// GetFilesByPaths returns the tracked entries of the given paths, keyed by path
+func (d *Database) GetFilesByPaths(projectName string, paths []string) (map[string]*FileEntry, error) {
+	entries := make(map[string]*FileEntry, len(paths))
+	for start := 0; start < len(paths); start += maxBatchLookup {
+		chunk := paths[start:min(start+maxBatchLookup, len(paths))]
+
+		args := make([]any, 0, len(chunk)+1)
+		args = append(args, projectName)
+		for _, path := range chunk {
+			args = append(args, keys.Encode(path))
+		}
+
+		query := `
+		SELECT ` + fileEntryColumns + `
+		FROM file_entries
+		WHERE project_name = ? AND path IN (?` + strings.Repeat(", ?", len(chunk)-1) + `)`
+
+		rows, err := d.db.Query(query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get files by path: %w", err)
+		}
+		for rows.Next() {
+			entry, err := scanFileEntry(rows)
+			if err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan file entry: %w", err)
+			}
+			if _, ok := entries[entry.Path]; !ok {
+				entries[entry.Path] = entry
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+)
+
+// BisyncState is what a bidirectional sync last saw of a path on both sides.
+// A side whose current ETag differs from the recorded one has changed since.
+type BisyncState struct {
+	Path       string
+	SourceETag string
+	SourceSize int64
+	DestETag   string
+	DestSize   int64
+	SyncedAt   time.Time
+}
+
+// GetBisyncStates returns the recorded states of a project, keyed by path
+func (d *Database) GetBisyncStates(projectName string) (map[string]*BisyncState, error) {
+	rows, err := d.db.Query(`
+	SELECT path, source_etag, source_size, dest_etag, dest_size, synced_at
+	FROM bisync_state
+	WHERE project_name = ?`, projectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bisync states: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[string]*BisyncState)
+	for rows.Next() {
+		var state BisyncState
+		if err := rows.Scan(&state.Path, &state.SourceETag, &state.SourceSize, &state.DestETag, &state.DestSize, &state.SyncedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan bisync state: %w", err)
+		}
+		state.Path = keys.Decode(state.Path)
+		states[state.Path] = &state
+	}
+	return states, rows.Err()
+}
+
+// SaveBisyncState records that both sides of a path are in sync
+func (d *Database) SaveBisyncState(projectName string, state *BisyncState) error {
+	state.SyncedAt = time.Now()
+	_, err := d.db.Exec(`
+	INSERT INTO bisync_state (
+		project_name, path, source_etag, source_size, dest_etag, dest_size, synced_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (project_name, path) DO UPDATE SET
+		source_etag = excluded.source_etag,
+		source_size = excluded.source_size,
+		dest_etag = excluded.dest_etag,
+		dest_size = excluded.dest_size,
+		synced_at = excluded.synced_at`,
+		projectName, keys.Encode(state.Path), state.SourceETag, state.SourceSize, state.DestETag, state.DestSize, state.SyncedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save bisync state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+)
+
+// FileChange records a source object that changed after it was copied, and
+// how the change policy handled it
+type FileChange struct {
+	ProjectName string
+	Path        string
+	OldETag     string
+	OldSize     int64
+	NewETag     string
+	NewSize     int64
+	Policy      string
+	// SupersededPath is where the version policy keeps the previous copy,
+	// once the file is copied again
+	SupersededPath string
+	DetectedAt     time.Time
+}
+
+// ChangeCount is the number of recorded changes per policy
+type ChangeCount struct {
+	Policy string
+	Count  int64
+}
+
+func (d *Database) InsertFileChange(change *FileChange) error {
+	return insertFileChange(d.db, change)
+}
+
+func insertFileChange(ex execer, change *FileChange) error {
+	query := `
+	INSERT INTO file_changes (
+		project_name, path, old_etag, old_size, new_etag, new_size, policy, superseded_path, detected_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	change.DetectedAt = time.Now()
+	_, err := ex.Exec(query,
+		change.ProjectName,
+		keys.Encode(change.Path),
+		change.OldETag,
+		change.OldSize,
+		change.NewETag,
+		change.NewSize,
+		change.Policy,
+		keys.Encode(change.SupersededPath),
+		change.DetectedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record file change: %w", err)
+	}
+	return nil
+}
+
+// SetPendingVersion records that the copy of path has to be kept at
+// supersededPath before it is replaced. A copy already waiting to be kept
+// keeps its first path, as it is still the copy of the earlier version.
+func (d *Database) SetPendingVersion(projectName, path, supersededPath string) error {
+	return setPendingVersion(d.db, projectName, path, supersededPath)
+}
+
+func setPendingVersion(ex execer, projectName, path, supersededPath string) error {
+	_, err := ex.Exec(`
+	INSERT INTO pending_versions (project_name, path, superseded_path) VALUES (?, ?, ?)
+	ON CONFLICT (project_name, path) DO NOTHING`,
+		projectName, keys.Encode(path), keys.Encode(supersededPath))
+	if err != nil {
+		return fmt.Errorf("failed to record previous copy of %s: %w", path, err)
+	}
+	return nil
+}
+
+// GetPendingVersion returns where the copy of path has to be kept before it
+// is replaced, or an empty string
+func (d *Database) GetPendingVersion(projectName, path string) (string, error) {
+	var supersededPath string
+	err := d.db.QueryRow(`SELECT superseded_path FROM pending_versions WHERE project_name = ? AND path = ?`,
+		projectName, keys.Encode(path)).Scan(&supersededPath)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get previous copy of %s: %w", path, err)
+	}
+	return keys.Decode(supersededPath), nil
+}
+
+// DeletePendingVersion records that the copy of path was kept
+func (d *Database) DeletePendingVersion(projectName, path string) error {
+	_, err := d.db.Exec(`DELETE FROM pending_versions WHERE project_name = ? AND path = ?`, projectName, keys.Encode(path))
+	if err != nil {
+		return fmt.Errorf("failed to delete previous copy record of %s: %w", path, err)
+	}
+	return nil
+}
+
+// SetDriftETag flags a kept copy as drifted from its source, or clears the
+// flag when etag is empty
+func (d *Database) SetDriftETag(id int64, etag string) error {
+	return setDriftETag(d.db, id, etag)
+}
+
+func setDriftETag(ex execer, id int64, etag string) error {
+	_, err := ex.Exec(`UPDATE file_entries SET drift_etag = ?, updated_at = ? WHERE id = ?`, etag, time.Now(), id)
+	return err
+}
+
+// GetFileChangeCounts returns the number of recorded changes per policy
+func (d *Database) GetFileChangeCounts(projectName string) ([]ChangeCount, error) {
+	query := `
+	SELECT policy, COUNT(*)
+	FROM file_changes
+	WHERE project_name = ?
+	GROUP BY policy
+	ORDER BY policy`
+
+	rows, err := d.db.Query(query, projectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file change counts: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []ChangeCount
+	for rows.Next() {
+		var count ChangeCount
+		if err := rows.Scan(&count.Policy, &count.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan file change count: %w", err)
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// CountDriftedFiles returns the number of kept copies whose source changed
+func (d *Database) CountDriftedFiles(projectName string) (int64, error) {
+	var count int64
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM file_entries WHERE project_name = ? AND drift_etag != ''`, projectName).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count drifted files: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,19 @@
+package db
+
+import (
+	"fmt"
+)
+
+// SetSHA256 records the hex encoded SHA256 checksum of the content of a
+// file, computed while it was copied to a local destination. An empty
+// checksum clears it.
+func (d *Database) SetSHA256(id int64, checksum string) error {
+	return setSHA256(d.db, id, checksum)
+}
+
+func setSHA256(ex execer, id int64, checksum string) error {
+	if _, err := ex.Exec(`UPDATE file_entries SET sha256 = ? WHERE id = ?`, checksum, id); err != nil {
+		return fmt.Errorf("failed to set checksum: %w", err)
+	}
+	return nil
+}
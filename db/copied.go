@@ -0,0 +1,66 @@
+package db
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+)
+
+// ListCopiedFiles returns up to limit files present at the destination,
+// copied or found there already, whose path starts with prefix and sorts
+// after startAfter, in path order
+func (d *Database) ListCopiedFiles(projectName, prefix, startAfter string, limit int) ([]*FileEntry, error) {
+	query := `
+	SELECT ` + fileEntryColumns + `
+	FROM file_entries
+	WHERE project_name = ? AND status IN (?, ?) AND substr(path, 1, ?) = ? AND path > ?
+	ORDER BY path ASC
+	LIMIT ?`
+
+	prefix = keys.Encode(prefix)
+	rows, err := d.db.Query(query, projectName, StatusCompleted, StatusSkippedExisting,
+		utf8.RuneCountInString(prefix), prefix, keys.Encode(startAfter), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list copied files: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*FileEntry
+	for rows.Next() {
+		entry, err := scanFileEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// ForEachKnownPath calls fn with every path below prefix the project
+// recorded in its file list, except deleted ones, or in its last inventory
+func (d *Database) ForEachKnownPath(projectName, prefix string, fn func(path string)) error {
+	query := `
+	SELECT path FROM file_entries
+	WHERE project_name = ? AND status != ? AND substr(path, 1, ?) = ?
+	UNION
+	SELECT path FROM inventory_objects
+	WHERE project_name = ? AND substr(path, 1, ?) = ?`
+
+	prefix = keys.Encode(prefix)
+	length := utf8.RuneCountInString(prefix)
+	rows, err := d.db.Query(query, projectName, StatusDeleted, length, prefix, projectName, length, prefix)
+	if err != nil {
+		return fmt.Errorf("failed to get known paths: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return fmt.Errorf("failed to scan path: %w", err)
+		}
+		fn(keys.Decode(path))
+	}
+	return rows.Err()
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+)
+
+// ListedObject is an object found by listing the main destination
+type ListedObject struct {
+	Path string
+	Size int64
+	ETag string
+}
+
+// listingBatchSize is how many listed objects are recorded per transaction,
+// so that the write lock is never held while the destination is listed
+const listingBatchSize = 1000
+
+// MarkListedFiles joins a listing of the main destination against the
+// pending files. Files found with the same size and ETag are marked
+// skipped_existing with reason. It returns how many files were skipped and
+// the pending paths that exist at the destination with different content.
+// The listing is recorded in batches of short transactions while list runs,
+// and the files are marked in one transaction once it is done.
+func (d *Database) MarkListedFiles(projectName, reason string, list func(add func(ListedObject) error) error) (int64, map[string]bool, error) {
+	if err := d.clearDestinationListing(projectName); err != nil {
+		return 0, nil, err
+	}
+	// The listing is only needed for the join below
+	defer d.clearDestinationListing(projectName)
+
+	batch := make([]ListedObject, 0, listingBatchSize)
+	err := list(func(obj ListedObject) error {
+		batch = append(batch, obj)
+		if len(batch) < listingBatchSize {
+			return nil
+		}
+		err := d.recordListedObjects(projectName, batch)
+		batch = batch[:0]
+		return err
+	})
+	if err == nil {
+		err = d.recordListedObjects(projectName, batch)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	UPDATE file_entries
+	SET status = ?, status_reason = ?, error_message = '', updated_at = ?,
+		verify_status = '', verify_message = '', verify_attempts = 0
+	WHERE project_name = ? AND status IN (?, ?) AND EXISTS (
+		SELECT 1 FROM destination_listing dl
+		WHERE dl.project_name = file_entries.project_name AND dl.path = file_entries.path
+			AND dl.size = file_entries.size AND dl.etag = file_entries.etag
+	)`,
+		StatusSkippedExisting, reason, time.Now(),
+		projectName, StatusPending, StatusError,
+	)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to mark existing files: %w", err)
+	}
+	skipped, err := result.RowsAffected()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	rows, err := tx.Query(`
+	SELECT fe.path
+	FROM file_entries fe
+	JOIN destination_listing dl ON dl.project_name = fe.project_name AND dl.path = fe.path
+	WHERE fe.project_name = ? AND fe.status IN (?, ?)`,
+		projectName, StatusPending, StatusError,
+	)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to get listed pending files: %w", err)
+	}
+	present := make(map[string]bool)
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("failed to scan listed file: %w", err)
+		}
+		present[keys.Decode(path)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("failed to commit destination listing: %w", err)
+	}
+	return skipped, present, nil
+}
+
+// recordListedObjects stores a batch of the destination listing
+func (d *Database) recordListedObjects(projectName string, objects []ListedObject) error {
+	if len(objects) == 0 {
+		return nil
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+	INSERT INTO destination_listing (project_name, path, size, etag)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT (project_name, path) DO UPDATE SET
+		size = excluded.size, etag = excluded.etag`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, obj := range objects {
+		if _, err := stmt.Exec(projectName, keys.Encode(obj.Path), obj.Size, obj.ETag); err != nil {
+			return fmt.Errorf("failed to record listed object %s: %w", obj.Path, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit destination listing: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) clearDestinationListing(projectName string) error {
+	if _, err := d.db.Exec("DELETE FROM destination_listing WHERE project_name = ?", projectName); err != nil {
+		return fmt.Errorf("failed to clear destination listing: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+)
+
+// DestinationCount is the number of files per status for one additional
+// destination of a multi-destination project
+type DestinationCount struct {
+	Destination string
+	Status      FileStatus
+	Count       int64
+	Size        int64
+}
+
+// GetDestinationWork returns the tracked files that still have to be
+// evaluated for an additional destination: files never seen by it, files
+// that failed or were filtered, and files whose source changed since they
+// were copied there
+func (d *Database) GetDestinationWork(projectName, destination string) ([]*FileEntry, error) {
+	query := `
+	SELECT ` + prefixedFileEntryColumns("fe") + `
+	FROM file_entries fe
+	LEFT JOIN destination_files df
+		ON df.project_name = fe.project_name AND df.destination = ? AND df.path = fe.path
+	WHERE fe.project_name = ? AND fe.status NOT IN (?, ?, ?, ?) AND (
+		df.path IS NULL OR df.status IN (?, ?, ?) OR df.etag != fe.etag
+	)
+	ORDER BY fe.id ASC`
+
+	rows, err := d.db.Query(query,
+		destination, projectName,
+		StatusSkippedFiltered, StatusCorrupt, StatusDeleted, StatusRestoreRequested,
+		StatusPending, StatusError, StatusSkippedFiltered,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get destination work: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*FileEntry
+	for rows.Next() {
+		entry, err := scanFileEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// SetDestinationStatus records the state of a file at an additional destination
+func (d *Database) SetDestinationStatus(projectName, destination string, entry *FileEntry, status FileStatus, reason, errorMessage string) error {
+	return setDestinationStatus(d.db, projectName, destination, entry, status, reason, errorMessage)
+}
+
+func setDestinationStatus(ex execer, projectName, destination string, entry *FileEntry, status FileStatus, reason, errorMessage string) error {
+	query := `
+	INSERT INTO destination_files (
+		project_name, destination, path, etag, status, status_reason, error_message, updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (project_name, destination, path) DO UPDATE SET
+		etag = excluded.etag,
+		status = excluded.status,
+		status_reason = excluded.status_reason,
+		error_message = excluded.error_message,
+		updated_at = excluded.updated_at`
+
+	_, err := ex.Exec(query, projectName, destination, keys.Encode(entry.Path), entry.ETag, status, reason, errorMessage, time.Now())
+	return err
+}
+
+func (d *Database) GetDestinationStatusCounts(projectName string) ([]DestinationCount, error) {
+	query := `
+	SELECT df.destination, df.status, COUNT(*), COALESCE(SUM(fe.size), 0)
+	FROM destination_files df
+	JOIN file_entries fe ON fe.project_name = df.project_name AND fe.path = df.path
+	WHERE df.project_name = ?
+	GROUP BY df.destination, df.status
+	ORDER BY df.destination, df.status`
+
+	rows, err := d.db.Query(query, projectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get destination status counts: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []DestinationCount
+	for rows.Next() {
+		var count DestinationCount
+		var status string
+		if err := rows.Scan(&count.Destination, &status, &count.Count, &count.Size); err != nil {
+			return nil, fmt.Errorf("failed to scan destination status count: %w", err)
+		}
+		count.Status = FileStatus(status)
+		counts = append(counts, count)
+	}
+
+	return counts, nil
+}
+
+// GetUnfinishedDestinationPaths returns the paths of the tracked files
+// directly inside folder that are not yet copied to an additional destination
+// or skipped by its filters
+func (d *Database) GetUnfinishedDestinationPaths(projectName, destination, folder string) ([]string, error) {
+	prefix := ""
+	if folder != "." && folder != "" {
+		prefix = folder + "/"
+	}
+
+	query := `
+	SELECT fe.path
+	FROM file_entries fe
+	LEFT JOIN destination_files df
+		ON df.project_name = fe.project_name AND df.destination = ? AND df.path = fe.path
+	WHERE fe.project_name = ? AND substr(fe.path, 1, ?) = ? AND fe.status NOT IN (?, ?, ?, ?) AND (
+		df.path IS NULL OR df.status NOT IN (?, ?) OR df.etag != fe.etag
+	)`
+
+	encodedPrefix := keys.Encode(prefix)
+	rows, err := d.db.Query(query,
+		destination, projectName, utf8.RuneCountInString(encodedPrefix), encodedPrefix,
+		StatusSkippedFiltered, StatusCorrupt, StatusDeleted, StatusRestoreRequested,
+		StatusCompleted, StatusSkippedFiltered,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unfinished destination files: %w", err)
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("failed to scan unfinished destination file: %w", err)
+		}
+		path = keys.Decode(path)
+		if !strings.Contains(path[len(prefix):], "/") {
+			paths = append(paths, path)
+		}
+	}
+	return paths, rows.Err()
+}
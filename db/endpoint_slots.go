@@ -0,0 +1,122 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// EndpointSlots coordinates the transfer slots of endpoints between the
+// processes of all projects through a database shared between them. A slot
+// is a lease that its holder renews while it is in use, so the slots of a
+// killed process expire.
+type EndpointSlots struct {
+	db *sql.DB
+}
+
+func NewEndpointSlots(dbPath string) (*EndpointSlots, error) {
+	// Writers lock the database when the transaction starts, so two
+	// processes can't both see the same free slot
+	db, err := sql.Open("sqlite3", dbPath+sqliteOptions+"&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS endpoint_slots (
+		endpoint TEXT NOT NULL,
+		slot INTEGER NOT NULL,
+		holder TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		PRIMARY KEY (endpoint, slot)
+	);
+	`
+	if _, err := db.Exec(createTableSQL); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize endpoint slots: %w", err)
+	}
+
+	return &EndpointSlots{db: db}, nil
+}
+
+func (e *EndpointSlots) Close() error {
+	return e.db.Close()
+}
+
+// TryAcquire takes a free slot of endpoint for holder if fewer than limit
+// slots are held, and returns its number, or -1 if all are taken
+func (e *EndpointSlots) TryAcquire(endpoint string, limit int, holder string, lease time.Duration) (int, error) {
+	tx, err := e.db.Begin()
+	if err != nil {
+		return -1, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	if _, err := tx.Exec("DELETE FROM endpoint_slots WHERE endpoint = ? AND expires_at < ?", endpoint, now); err != nil {
+		return -1, fmt.Errorf("failed to expire endpoint slots: %w", err)
+	}
+
+	rows, err := tx.Query("SELECT slot FROM endpoint_slots WHERE endpoint = ?", endpoint)
+	if err != nil {
+		return -1, fmt.Errorf("failed to get endpoint slots: %w", err)
+	}
+	taken := make(map[int]bool)
+	for rows.Next() {
+		var slot int
+		if err := rows.Scan(&slot); err != nil {
+			rows.Close()
+			return -1, fmt.Errorf("failed to scan endpoint slot: %w", err)
+		}
+		taken[slot] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return -1, fmt.Errorf("failed to get endpoint slots: %w", err)
+	}
+	if len(taken) >= limit {
+		return -1, nil
+	}
+
+	slot := 0
+	for taken[slot] {
+		slot++
+	}
+	_, err = tx.Exec(
+		"INSERT INTO endpoint_slots (endpoint, slot, holder, expires_at) VALUES (?, ?, ?, ?)",
+		endpoint, slot, holder, now.Add(lease),
+	)
+	if err != nil {
+		return -1, fmt.Errorf("failed to take endpoint slot: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return -1, fmt.Errorf("failed to commit endpoint slot: %w", err)
+	}
+	return slot, nil
+}
+
+// Renew extends the leases of all slots held by holder
+func (e *EndpointSlots) Renew(holder string, lease time.Duration) error {
+	_, err := e.db.Exec("UPDATE endpoint_slots SET expires_at = ? WHERE holder = ?", time.Now().UTC().Add(lease), holder)
+	if err != nil {
+		return fmt.Errorf("failed to renew endpoint slots: %w", err)
+	}
+	return nil
+}
+
+// Release gives back a slot taken by holder
+func (e *EndpointSlots) Release(endpoint string, slot int, holder string) error {
+	_, err := e.db.Exec("DELETE FROM endpoint_slots WHERE endpoint = ? AND slot = ? AND holder = ?", endpoint, slot, holder)
+	if err != nil {
+		return fmt.Errorf("failed to release endpoint slot: %w", err)
+	}
+	return nil
+}
+
+// ReleaseAll gives back every slot still held by holder
+func (e *EndpointSlots) ReleaseAll(holder string) error {
+	if _, err := e.db.Exec("DELETE FROM endpoint_slots WHERE holder = ?", holder); err != nil {
+		return fmt.Errorf("failed to release endpoint slots: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+)
+
+// errorHistorySize is how many error messages are kept per file
+const errorHistorySize = 10
+
+// ErrorRecord is an error a file failed with
+type ErrorRecord struct {
+	Message    string
+	OccurredAt time.Time
+}
+
+// appendErrorHistory adds an error to the history of a file and drops the
+// oldest errors beyond errorHistorySize
+func appendErrorHistory(ex execer, fileID int64, errorMessage string, occurredAt time.Time) error {
+	_, err := ex.Exec(`INSERT INTO error_history (file_id, error_message, occurred_at) VALUES (?, ?, ?)`,
+		fileID, errorMessage, occurredAt)
+	if err != nil {
+		return fmt.Errorf("failed to record error history: %w", err)
+	}
+	_, err = ex.Exec(`
+	DELETE FROM error_history
+	WHERE file_id = ? AND id NOT IN (
+		SELECT id FROM error_history WHERE file_id = ? ORDER BY id DESC LIMIT ?
+	)`, fileID, fileID, errorHistorySize)
+	if err != nil {
+		return fmt.Errorf("failed to trim error history: %w", err)
+	}
+	return nil
+}
+
+// GetErrorHistory returns the last errors of a file, newest first
+func (d *Database) GetErrorHistory(fileID int64) ([]ErrorRecord, error) {
+	rows, err := d.db.Query(`
+	SELECT error_message, occurred_at FROM error_history
+	WHERE file_id = ?
+	ORDER BY id DESC`, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get error history: %w", err)
+	}
+	defer rows.Close()
+
+	var records []ErrorRecord
+	for rows.Next() {
+		var record ErrorRecord
+		if err := rows.Scan(&record.Message, &record.OccurredAt); err != nil {
+			return nil, fmt.Errorf("failed to scan error history: %w", err)
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// GetFiles returns the files of a project whose path starts with prefix,
+// ordered by path. Only files with one of statuses are returned, or all
+// files if statuses is empty.
+func (d *Database) GetFiles(projectName string, statuses []FileStatus, prefix string) ([]*FileEntry, error) {
+	prefix = keys.Encode(prefix)
+	query := `
+	SELECT ` + fileEntryColumns + `
+	FROM file_entries
+	WHERE project_name = ? AND substr(path, 1, ?) = ?`
+	args := []any{projectName, utf8.RuneCountInString(prefix), prefix}
+	if len(statuses) > 0 {
+		query += " AND status IN (?" + strings.Repeat(", ?", len(statuses)-1) + ")"
+		for _, status := range statuses {
+			args = append(args, status)
+		}
+	}
+	query += " ORDER BY path ASC"
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get files: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*FileEntry
+	for rows.Next() {
+		entry, err := scanFileEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
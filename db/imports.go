@@ -0,0 +1,78 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ImportCheckpoint is the progress of an interrupted import-list run. The
+// import file is identified by its path, size and modification time, so a
+// checkpoint of a replaced file is not resumed.
+type ImportCheckpoint struct {
+	ProjectName string
+	FilePath    string
+	FileSize    int64
+	FileModTime time.Time
+	// Line is the number of lines of the file already recorded
+	Line int64
+	// ObjectCount and TotalSize count the files imported so far
+	ObjectCount int64
+	TotalSize   int64
+	StartedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// GetImportCheckpoint returns the checkpoint of an import of filePath, or nil
+// if there is none
+func (d *Database) GetImportCheckpoint(projectName, filePath string) (*ImportCheckpoint, error) {
+	cp := &ImportCheckpoint{ProjectName: projectName, FilePath: filePath}
+	err := d.db.QueryRow(`
+	SELECT file_size, file_mod_time, line, object_count, total_size, started_at, updated_at
+	FROM import_checkpoints
+	WHERE project_name = ? AND file_path = ?`, projectName, filePath).Scan(
+		&cp.FileSize, &cp.FileModTime, &cp.Line, &cp.ObjectCount, &cp.TotalSize, &cp.StartedAt, &cp.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get import checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+func (b *Batch) SaveImportCheckpoint(cp *ImportCheckpoint) error {
+	return saveImportCheckpoint(b.tx, cp)
+}
+
+func saveImportCheckpoint(ex execer, cp *ImportCheckpoint) error {
+	_, err := ex.Exec(`
+	INSERT INTO import_checkpoints (
+		project_name, file_path, file_size, file_mod_time, line, object_count, total_size, started_at, updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (project_name, file_path) DO UPDATE SET
+		file_size = excluded.file_size,
+		file_mod_time = excluded.file_mod_time,
+		line = excluded.line,
+		object_count = excluded.object_count,
+		total_size = excluded.total_size,
+		started_at = excluded.started_at,
+		updated_at = excluded.updated_at`,
+		cp.ProjectName, cp.FilePath, cp.FileSize, cp.FileModTime.UTC(), cp.Line,
+		cp.ObjectCount, cp.TotalSize, cp.StartedAt, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save import checkpoint: %w", err)
+	}
+	return nil
+}
+
+// DeleteImportCheckpoint forgets the checkpoint of a finished import
+func (d *Database) DeleteImportCheckpoint(projectName, filePath string) error {
+	_, err := d.db.Exec("DELETE FROM import_checkpoints WHERE project_name = ? AND file_path = ?", projectName, filePath)
+	if err != nil {
+		return fmt.Errorf("failed to delete import checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+)
+
+// Kinds of inventory changes
+const (
+	InventoryNew      = "new"
+	InventoryModified = "modified"
+	InventoryDeleted  = "deleted"
+)
+
+// InventoryRun is one pass of the inventory command over the source listing
+type InventoryRun struct {
+	ID          int64
+	ProjectName string
+	// Baseline is set for the first run of a project, whose objects are all
+	// counted as new but not recorded as changes
+	Baseline    bool
+	Complete    bool
+	ObjectCount int64
+	TotalSize   int64
+	New         int64
+	Modified    int64
+	Deleted     int64
+	StartedAt   time.Time
+	FinishedAt  time.Time
+}
+
+// InventoryObject is the state of a source object as of the last inventory
+type InventoryObject struct {
+	Path         string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// InventoryChange is a source object that appeared, changed or disappeared
+// between two inventory runs
+type InventoryChange struct {
+	RunID   int64
+	Path    string
+	Change  string
+	OldSize int64
+	NewSize int64
+	OldETag string
+	NewETag string
+}
+
+// StartInventoryRun records the start of an inventory run
+func (d *Database) StartInventoryRun(projectName string) (*InventoryRun, error) {
+	run := &InventoryRun{ProjectName: projectName, StartedAt: time.Now()}
+
+	var previous int64
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM inventory_runs WHERE project_name = ? AND complete`, projectName).Scan(&previous); err != nil {
+		return nil, fmt.Errorf("failed to count inventory runs: %w", err)
+	}
+	run.Baseline = previous == 0
+
+	err := d.db.QueryRow(`
+	INSERT INTO inventory_runs (project_name, baseline, complete, started_at)
+	VALUES (?, ?, ?, ?)
+	RETURNING id`, projectName, run.Baseline, false, run.StartedAt).Scan(&run.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start inventory run: %w", err)
+	}
+	return run, nil
+}
+
+// RecordInventoryObjects compares listed objects with their last known
+// state in a single transaction, records what changed and marks them as seen
+// by the run. The counts of the run are updated once the transaction is
+// committed.
+func (d *Database) RecordInventoryObjects(run *InventoryRun, objects []InventoryObject) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	known := make(map[string]InventoryObject, len(objects))
+	for start := 0; start < len(objects); start += maxBatchLookup {
+		chunk := objects[start:min(start+maxBatchLookup, len(objects))]
+		args := make([]any, 0, len(chunk)+1)
+		args = append(args, run.ProjectName)
+		for _, obj := range chunk {
+			args = append(args, keys.Encode(obj.Path))
+		}
+
+		rows, err := tx.Query(`
+		SELECT path, size, etag
+		FROM inventory_objects
+		WHERE project_name = ? AND path IN (?`+strings.Repeat(", ?", len(chunk)-1)+`)`, args...)
+		if err != nil {
+			return fmt.Errorf("failed to get inventory objects: %w", err)
+		}
+		for rows.Next() {
+			var obj InventoryObject
+			if err := rows.Scan(&obj.Path, &obj.Size, &obj.ETag); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan inventory object: %w", err)
+			}
+			obj.Path = keys.Decode(obj.Path)
+			known[obj.Path] = obj
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	var added, modified, size int64
+	for _, obj := range objects {
+		size += obj.Size
+		change := &InventoryChange{RunID: run.ID, Path: obj.Path, NewSize: obj.Size, NewETag: obj.ETag}
+		if old, ok := known[obj.Path]; !ok {
+			change.Change = InventoryNew
+			added++
+		} else if old.ETag != obj.ETag || old.Size != obj.Size {
+			change.Change = InventoryModified
+			change.OldSize, change.OldETag = old.Size, old.ETag
+			modified++
+		}
+
+		_, err := tx.Exec(`
+		INSERT INTO inventory_objects (project_name, path, size, etag, last_modified, seen_run)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (project_name, path) DO UPDATE SET
+			size = excluded.size,
+			etag = excluded.etag,
+			last_modified = excluded.last_modified,
+			seen_run = excluded.seen_run`,
+			run.ProjectName, keys.Encode(obj.Path), obj.Size, obj.ETag, obj.LastModified, run.ID)
+		if err != nil {
+			return fmt.Errorf("failed to record inventory object: %w", err)
+		}
+		if change.Change != "" && !run.Baseline {
+			if err := insertInventoryChange(tx, run.ProjectName, change); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+	run.ObjectCount += int64(len(objects))
+	run.TotalSize += size
+	run.New += added
+	run.Modified += modified
+	return nil
+}
+
+// FinishInventoryRun records the outcome of a run. After a complete listing
+// the objects the run didn't see are recorded as deleted and forgotten; an
+// incomplete listing can't tell deleted objects from unlisted ones.
+func (d *Database) FinishInventoryRun(run *InventoryRun, complete bool) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	run.Complete = complete
+	run.FinishedAt = time.Now()
+	if complete && run.Baseline {
+		// Leftovers of interrupted runs before the baseline
+		if _, err := tx.Exec(`DELETE FROM inventory_objects WHERE project_name = ? AND seen_run != ?`, run.ProjectName, run.ID); err != nil {
+			return fmt.Errorf("failed to forget deleted inventory objects: %w", err)
+		}
+	} else if complete {
+		result, err := tx.Exec(`
+		INSERT INTO inventory_changes (run_id, project_name, path, change, old_size, new_size, old_etag, new_etag)
+		SELECT CAST(? AS INTEGER), project_name, path, CAST(? AS TEXT), size, 0, etag, ''
+		FROM inventory_objects
+		WHERE project_name = ? AND seen_run != ?`,
+			run.ID, InventoryDeleted, run.ProjectName, run.ID)
+		if err != nil {
+			return fmt.Errorf("failed to record deleted inventory objects: %w", err)
+		}
+		if run.Deleted, err = result.RowsAffected(); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM inventory_objects WHERE project_name = ? AND seen_run != ?`, run.ProjectName, run.ID); err != nil {
+			return fmt.Errorf("failed to forget deleted inventory objects: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`
+	UPDATE inventory_runs
+	SET complete = ?, object_count = ?, total_size = ?, new = ?, modified = ?, deleted = ?, finished_at = ?
+	WHERE id = ?`,
+		run.Complete, run.ObjectCount, run.TotalSize, run.New, run.Modified, run.Deleted, run.FinishedAt, run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to finish inventory run: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit inventory run: %w", err)
+	}
+	return nil
+}
+
+func insertInventoryChange(ex execer, projectName string, change *InventoryChange) error {
+	_, err := ex.Exec(`
+	INSERT INTO inventory_changes (run_id, project_name, path, change, old_size, new_size, old_etag, new_etag)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		change.RunID, projectName, keys.Encode(change.Path), change.Change, change.OldSize, change.NewSize, change.OldETag, change.NewETag)
+	if err != nil {
+		return fmt.Errorf("failed to record inventory change: %w", err)
+	}
+	return nil
+}
+
+// GetInventoryRuns returns the latest inventory runs of a project, newest
+// first
+func (d *Database) GetInventoryRuns(projectName string, limit int) ([]*InventoryRun, error) {
+	rows, err := d.db.Query(`
+	SELECT id, baseline, complete, object_count, total_size, new, modified, deleted, started_at, finished_at
+	FROM inventory_runs
+	WHERE project_name = ?
+	ORDER BY id DESC
+	LIMIT ?`, projectName, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inventory runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []*InventoryRun
+	for rows.Next() {
+		run := &InventoryRun{ProjectName: projectName}
+		var finishedAt sql.NullTime
+		if err := rows.Scan(&run.ID, &run.Baseline, &run.Complete, &run.ObjectCount, &run.TotalSize,
+			&run.New, &run.Modified, &run.Deleted, &run.StartedAt, &finishedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan inventory run: %w", err)
+		}
+		run.FinishedAt = finishedAt.Time
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// GetInventoryChanges returns the changes recorded by an inventory run of a
+// project, ordered by path
+func (d *Database) GetInventoryChanges(projectName string, runID int64) ([]*InventoryChange, error) {
+	rows, err := d.db.Query(`
+	SELECT path, change, old_size, new_size, old_etag, new_etag
+	FROM inventory_changes
+	WHERE project_name = ? AND run_id = ?
+	ORDER BY path`, projectName, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inventory changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []*InventoryChange
+	for rows.Next() {
+		change := &InventoryChange{RunID: runID}
+		if err := rows.Scan(&change.Path, &change.Change, &change.OldSize, &change.NewSize, &change.OldETag, &change.NewETag); err != nil {
+			return nil, fmt.Errorf("failed to scan inventory change: %w", err)
+		}
+		change.Path = keys.Decode(change.Path)
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// ClaimFile leases a pending file to owner until the given time, so that
+// other instances sharing the database don't copy it as well. It reports
+// false if the file is no longer pending or another owner holds an unexpired
+// lease on it.
+func (d *Database) ClaimFile(id int64, owner string, until time.Time) (bool, error) {
+	return claimFile(d.db, id, owner, until)
+}
+
+func (b *Batch) ClaimFile(id int64, owner string, until time.Time) (bool, error) {
+	return claimFile(b.tx, id, owner, until)
+}
+
+func claimFile(ex execer, id int64, owner string, until time.Time) (bool, error) {
+	query := `
+	UPDATE file_entries
+	SET lease_owner = ?, lease_expires = ?
+	WHERE id = ? AND status IN (?, ?, ?)
+		AND (lease_owner = '' OR lease_owner = ? OR lease_expires < ?)`
+
+	result, err := ex.Exec(query,
+		owner, until.UTC(),
+		id, StatusPending, StatusError, StatusRestoreRequested,
+		owner, time.Now().UTC(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim file: %w", err)
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim file: %w", err)
+	}
+	return claimed > 0, nil
+}
+
+// RenewLeases extends the leases of owner in a project until the given time
+func (d *Database) RenewLeases(projectName, owner string, until time.Time) error {
+	query := `UPDATE file_entries SET lease_expires = ? WHERE project_name = ? AND lease_owner = ?`
+	if _, err := d.db.Exec(query, until.UTC(), projectName, owner); err != nil {
+		return fmt.Errorf("failed to renew leases: %w", err)
+	}
+	return nil
+}
+
+// ReleaseLeases drops the leases of owner in a project, so files it didn't
+// finish can be claimed by other instances right away
+func (d *Database) ReleaseLeases(projectName, owner string) error {
+	query := `UPDATE file_entries SET lease_owner = '', lease_expires = NULL WHERE project_name = ? AND lease_owner = ?`
+	if _, err := d.db.Exec(query, projectName, owner); err != nil {
+		return fmt.Errorf("failed to release leases: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ListingRun records the outcome of one pass over the source listing
+type ListingRun struct {
+	ID          int64
+	ProjectName string
+	Source      string // "update-list" or "import-list"
+	ObjectCount int64
+	TotalSize   int64
+	Complete    bool
+	StartedAt   time.Time
+	FinishedAt  time.Time
+}
+
+// Accounting compares the tracked file entries with the last listing
+type Accounting struct {
+	LastListing  *ListingRun
+	TrackedCount int64
+	TrackedSize  int64
+	Duplicates   int64
+}
+
+func (d *Database) InsertListingRun(run *ListingRun) error {
+	query := `
+	INSERT INTO listing_runs (
+		project_name, source, object_count, total_size, complete, started_at, finished_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?)
+	RETURNING id`
+
+	err := d.db.QueryRow(query,
+		run.ProjectName,
+		run.Source,
+		run.ObjectCount,
+		run.TotalSize,
+		run.Complete,
+		run.StartedAt,
+		run.FinishedAt,
+	).Scan(&run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to insert listing run: %w", err)
+	}
+	return nil
+}
+
+// GetLastCompleteListingRun returns the most recent complete listing, or nil
+// if the project has never been fully listed
+func (d *Database) GetLastCompleteListingRun(projectName string) (*ListingRun, error) {
+	query := `
+	SELECT id, project_name, source, object_count, total_size, complete, started_at, finished_at
+	FROM listing_runs
+	WHERE project_name = ? AND complete = ?
+	ORDER BY finished_at DESC
+	LIMIT 1`
+
+	run := &ListingRun{}
+	err := d.db.QueryRow(query, projectName, true).Scan(
+		&run.ID,
+		&run.ProjectName,
+		&run.Source,
+		&run.ObjectCount,
+		&run.TotalSize,
+		&run.Complete,
+		&run.StartedAt,
+		&run.FinishedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last listing run: %w", err)
+	}
+	return run, nil
+}
+
+// GetAccounting gathers the totals needed to check that every listed source
+// object is accounted for by exactly one file entry. Paths deleted by a
+// mirror sync are no longer in the source and not counted.
+func (d *Database) GetAccounting(projectName string) (*Accounting, error) {
+	lastListing, err := d.GetLastCompleteListingRun(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	SELECT COUNT(*), COALESCE(SUM(size), 0), COUNT(*) - COUNT(DISTINCT path)
+	FROM file_entries
+	WHERE project_name = ? AND status != ?`
+
+	accounting := &Accounting{LastListing: lastListing}
+	err = d.db.QueryRow(query, projectName, StatusDeleted).Scan(
+		&accounting.TrackedCount,
+		&accounting.TrackedSize,
+		&accounting.Duplicates,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get accounting totals: %w", err)
+	}
+	return accounting, nil
+}
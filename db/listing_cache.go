@@ -0,0 +1,170 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+)
+
+// CachedObject is an object in the shared bucket listing cache
+type CachedObject struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// ListingCache stores full bucket listings in a database shared between
+// projects, so projects reading the same bucket with different prefixes
+// need only one listing pass
+type ListingCache struct {
+	db *sql.DB
+}
+
+func NewListingCache(dbPath string) (*ListingCache, error) {
+	db, err := sql.Open("sqlite3", dbPath+sqliteOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS bucket_listings (
+		endpoint TEXT NOT NULL,
+		bucket TEXT NOT NULL,
+		object_count INTEGER NOT NULL,
+		listed_at DATETIME NOT NULL,
+		PRIMARY KEY (endpoint, bucket)
+	);
+	CREATE TABLE IF NOT EXISTS bucket_objects (
+		endpoint TEXT NOT NULL,
+		bucket TEXT NOT NULL,
+		key TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		etag TEXT NOT NULL,
+		last_modified DATETIME NOT NULL,
+		PRIMARY KEY (endpoint, bucket, key)
+	);
+	`
+	if _, err := db.Exec(createTableSQL); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize listing cache: %w", err)
+	}
+
+	return &ListingCache{db: db}, nil
+}
+
+func (c *ListingCache) Close() error {
+	return c.db.Close()
+}
+
+// ListedAt returns when the bucket was last fully listed, or the zero time
+// if it is not cached
+func (c *ListingCache) ListedAt(endpoint, bucket string) (time.Time, error) {
+	var listedAt time.Time
+	err := c.db.QueryRow(
+		"SELECT listed_at FROM bucket_listings WHERE endpoint = ? AND bucket = ?",
+		endpoint, bucket,
+	).Scan(&listedAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read listing cache: %w", err)
+	}
+	return listedAt, nil
+}
+
+// Refresh replaces the cached listing of a bucket with the objects passed to
+// add by list. The previous listing is kept if list fails.
+func (c *ListingCache) Refresh(endpoint, bucket string, list func(add func(CachedObject) error) error) (int64, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM bucket_objects WHERE endpoint = ? AND bucket = ?", endpoint, bucket); err != nil {
+		return 0, fmt.Errorf("failed to clear listing cache: %w", err)
+	}
+
+	stmt, err := tx.Prepare(`
+	INSERT INTO bucket_objects (endpoint, bucket, key, size, etag, last_modified)
+	VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer stmt.Close()
+
+	var count int64
+	err = list(func(obj CachedObject) error {
+		// Times are stored in UTC so Objects can compare them as text
+		if _, err := stmt.Exec(endpoint, bucket, keys.Encode(obj.Key), obj.Size, obj.ETag, obj.LastModified.UTC()); err != nil {
+			return fmt.Errorf("failed to cache object %s: %w", obj.Key, err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO bucket_listings (endpoint, bucket, object_count, listed_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT (endpoint, bucket) DO UPDATE SET
+		object_count = excluded.object_count,
+		listed_at = excluded.listed_at`,
+		endpoint, bucket, count, time.Now(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record listing: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit listing cache: %w", err)
+	}
+	return count, nil
+}
+
+// Objects passes the cached objects of a bucket under prefix to fn in key
+// order. Unless zero, after and before limit them to objects last modified
+// within [after, before).
+func (c *ListingCache) Objects(endpoint, bucket, prefix string, after, before time.Time, fn func(CachedObject) error) error {
+	query := `
+	SELECT key, size, etag, last_modified
+	FROM bucket_objects
+	WHERE endpoint = ? AND bucket = ? AND key >= ?`
+	prefix = keys.Encode(prefix)
+	args := []interface{}{endpoint, bucket, prefix}
+	if !after.IsZero() {
+		query += " AND last_modified >= ?"
+		args = append(args, after.UTC())
+	}
+	if !before.IsZero() {
+		query += " AND last_modified < ?"
+		args = append(args, before.UTC())
+	}
+	rows, err := c.db.Query(query+" ORDER BY key ASC", args...)
+	if err != nil {
+		return fmt.Errorf("failed to read listing cache: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var obj CachedObject
+		if err := rows.Scan(&obj.Key, &obj.Size, &obj.ETag, &obj.LastModified); err != nil {
+			return fmt.Errorf("failed to scan cached object: %w", err)
+		}
+		if !strings.HasPrefix(obj.Key, prefix) {
+			break
+		}
+		obj.Key = keys.Decode(obj.Key)
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -4,8 +4,12 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -18,8 +22,41 @@ const (
 	StatusCopying   FileStatus = "copying"
 	StatusCompleted FileStatus = "completed"
 	StatusError     FileStatus = "error"
+	// StatusCorrupt quarantines a copy that failed verification
+	StatusCorrupt FileStatus = "corrupt"
+	// StatusStaged is a copy under the staging prefix waiting for the rest
+	// of its group before it is committed to its final key
+	StatusStaged FileStatus = "staged"
+
+	// Source objects that are tracked but intentionally not copied; the
+	// reason is kept in StatusReason
+	StatusSkippedFiltered FileStatus = "skipped_filtered"
+	StatusSkippedExisting FileStatus = "skipped_existing"
+
+	// StatusDeleted is a path removed from the destination by a mirror sync
+	// because it no longer exists in the source
+	StatusDeleted FileStatus = "deleted"
+
+	// StatusRestoreRequested is a source object in an archive storage class
+	// that is retried once its restore window has passed
+	StatusRestoreRequested FileStatus = "restore_requested"
+
+	// StatusDestNewer is a file not copied because the destination holds a
+	// different object modified after the source, left for manual review
+	StatusDestNewer FileStatus = "dest_newer"
+
+	// StatusFailed is a file that failed as often as the retry policy
+	// allows and is no longer attempted
+	StatusFailed FileStatus = "failed"
 )
 
+// FileStatuses lists every status of file entries
+var FileStatuses = []FileStatus{
+	StatusPending, StatusExists, StatusNotFound, StatusCopying, StatusCompleted, StatusError,
+	StatusCorrupt, StatusStaged, StatusSkippedFiltered, StatusSkippedExisting, StatusDeleted,
+	StatusRestoreRequested, StatusDestNewer, StatusFailed,
+}
+
 type FileEntry struct {
 	ID           int64
 	ProjectName  string
@@ -28,23 +65,112 @@ type FileEntry struct {
 	ETag         string
 	LastModified time.Time
 	Status       FileStatus
+	StatusReason string
 	ErrorMessage string
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
+
+	// Verification state of a completed copy
+	VerifyStatus   VerifyStatus
+	VerifyMessage  string
+	VerifyAttempts int
+	RepairAttempts int
+
+	// DriftETag is the ETag of a changed source object whose first copy was
+	// kept by the keep change policy
+	DriftETag string
+
+	// StorageClass is the storage class of the source object as listed,
+	// empty if the listing didn't report one
+	StorageClass string
+
+	// Attempts counts the failed copies of the file since it last changed
+	// status otherwise
+	Attempts int
+
+	// SHA256 is the checksum of the content of the current source object,
+	// recorded when it was copied to a local destination, or empty
+	SHA256 string
 }
 
 type StatusCount struct {
 	Status FileStatus
+	Reason string
 	Count  int64
 	Size   int64
 }
 
 type Database struct {
-	db *sql.DB
+	db *conn
 }
 
+// fileEntryColumns lists the columns read by scanFileEntry, in order
+const fileEntryColumns = `id, project_name, path, size, etag, last_modified, status, status_reason, error_message, created_at, updated_at,
+	verify_status, verify_message, verify_attempts, repair_attempts, drift_etag, storage_class, attempts, sha256`
+
+// prefixedFileEntryColumns qualifies fileEntryColumns with a table alias
+func prefixedFileEntryColumns(alias string) string {
+	columns := strings.Split(fileEntryColumns, ",")
+	for i, column := range columns {
+		columns[i] = alias + "." + strings.TrimSpace(column)
+	}
+	return strings.Join(columns, ", ")
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanFileEntry(row rowScanner) (*FileEntry, error) {
+	entry := &FileEntry{}
+	err := row.Scan(
+		&entry.ID,
+		&entry.ProjectName,
+		&entry.Path,
+		&entry.Size,
+		&entry.ETag,
+		&entry.LastModified,
+		&entry.Status,
+		&entry.StatusReason,
+		&entry.ErrorMessage,
+		&entry.CreatedAt,
+		&entry.UpdatedAt,
+		&entry.VerifyStatus,
+		&entry.VerifyMessage,
+		&entry.VerifyAttempts,
+		&entry.RepairAttempts,
+		&entry.DriftETag,
+		&entry.StorageClass,
+		&entry.Attempts,
+		&entry.SHA256,
+	)
+	if err != nil {
+		return nil, err
+	}
+	entry.Path = keys.Decode(entry.Path)
+	return entry, nil
+}
+
+// schemaVersion is stored in the user_version of initialized databases. It
+// must be increased whenever Initialize creates a new table or column.
+const schemaVersion = 20
+
+// sqliteOptions puts databases into WAL mode, so that commands reading the
+// database don't block a running sync and the other way round, and lets
+// writers wait for each other instead of failing with SQLITE_BUSY
+const sqliteOptions = "?_journal_mode=WAL&_busy_timeout=5000"
+
 func NewDatabase(dbPath string) (*Database, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	// Transactions take the write lock when they start, so one that reads
+	// before it writes waits for other writers instead of failing with
+	// "database is locked" when it upgrades its lock
+	db, err := sql.Open("sqlite3", dbPath+sqliteOptions+"&_txlock=immediate")
 	if err != nil {
 		return nil, err
 	}
@@ -53,11 +179,55 @@ func NewDatabase(dbPath string) (*Database, error) {
 		return nil, err
 	}
 
-	return &Database{db: db}, nil
+	return &Database{db: &conn{DB: db, dialect: DriverSQLite}}, nil
+}
+
+// NewReadOnlyDatabase opens an existing database for commands that only read
+// it, such as status, while another process may be writing to it. Databases
+// written by an older version are migrated first.
+func NewReadOnlyDatabase(dbPath string) (*Database, error) {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		if err := migrateDatabase(dbPath); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version < schemaVersion {
+		if err := migrateDatabase(dbPath); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &Database{db: &conn{DB: db, dialect: DriverSQLite}}, nil
+}
+
+// migrateDatabase brings the schema of the database at dbPath up to date
+func migrateDatabase(dbPath string) error {
+	database, err := NewDatabase(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer database.Close()
+
+	if err := database.Initialize(); err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	return nil
 }
 
 func (d *Database) Initialize() error {
-	createTableSQL := `
+	createTableSQL := d.db.dialect.schema(`
 	CREATE TABLE IF NOT EXISTS file_entries (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		project_name TEXT NOT NULL,
@@ -66,16 +236,470 @@ func (d *Database) Initialize() error {
 		etag TEXT NOT NULL,
 		last_modified DATETIME NOT NULL,
 		status TEXT NOT NULL,
+		status_reason TEXT NOT NULL DEFAULT '',
 		error_message TEXT,
 		created_at DATETIME NOT NULL,
-		updated_at DATETIME NOT NULL
+		updated_at DATETIME NOT NULL,
+		verify_status TEXT NOT NULL DEFAULT '',
+		verify_message TEXT NOT NULL DEFAULT '',
+		verify_attempts INTEGER NOT NULL DEFAULT 0,
+		repair_attempts INTEGER NOT NULL DEFAULT 0,
+		drift_etag TEXT NOT NULL DEFAULT '',
+		retry_at DATETIME,
+		storage_class TEXT NOT NULL DEFAULT '',
+		stored_size INTEGER NOT NULL DEFAULT 0,
+		lease_owner TEXT NOT NULL DEFAULT '',
+		lease_expires DATETIME,
+		first_error_at DATETIME,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		sha256 TEXT NOT NULL DEFAULT ''
 	);
-	CREATE INDEX IF NOT EXISTS idx_project_path ON file_entries(project_name, path);
 	CREATE INDEX IF NOT EXISTS idx_status ON file_entries(status);
-	`
 
-	_, err := d.db.Exec(createTableSQL)
-	return err
+	CREATE TABLE IF NOT EXISTS listing_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		project_name TEXT NOT NULL,
+		source TEXT NOT NULL,
+		object_count INTEGER NOT NULL,
+		total_size INTEGER NOT NULL,
+		complete BOOLEAN NOT NULL,
+		started_at DATETIME NOT NULL,
+		finished_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_listing_runs_project ON listing_runs(project_name, finished_at);
+
+	CREATE TABLE IF NOT EXISTS destination_files (
+		project_name TEXT NOT NULL,
+		destination TEXT NOT NULL,
+		path TEXT NOT NULL,
+		etag TEXT NOT NULL,
+		status TEXT NOT NULL,
+		status_reason TEXT NOT NULL DEFAULT '',
+		error_message TEXT NOT NULL DEFAULT '',
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY (project_name, destination, path)
+	);
+
+	CREATE TABLE IF NOT EXISTS file_changes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		project_name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		old_etag TEXT NOT NULL,
+		old_size INTEGER NOT NULL,
+		new_etag TEXT NOT NULL,
+		new_size INTEGER NOT NULL,
+		policy TEXT NOT NULL,
+		superseded_path TEXT NOT NULL DEFAULT '',
+		detected_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_file_changes_path ON file_changes(project_name, path);
+
+	CREATE TABLE IF NOT EXISTS multipart_uploads (
+		project_name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		upload_id TEXT NOT NULL,
+		source_etag TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		part_size INTEGER NOT NULL,
+		started_at DATETIME NOT NULL,
+		PRIMARY KEY (project_name, path)
+	);
+
+	CREATE TABLE IF NOT EXISTS multipart_parts (
+		project_name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		part_number INTEGER NOT NULL,
+		etag TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		PRIMARY KEY (project_name, path, part_number)
+	);
+
+	CREATE TABLE IF NOT EXISTS bisync_state (
+		project_name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		source_etag TEXT NOT NULL,
+		source_size INTEGER NOT NULL,
+		dest_etag TEXT NOT NULL,
+		dest_size INTEGER NOT NULL,
+		synced_at DATETIME NOT NULL,
+		PRIMARY KEY (project_name, path)
+	);
+
+	CREATE TABLE IF NOT EXISTS destination_listing (
+		project_name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		etag TEXT NOT NULL,
+		PRIMARY KEY (project_name, path)
+	);
+
+	CREATE TABLE IF NOT EXISTS import_checkpoints (
+		project_name TEXT NOT NULL,
+		file_path TEXT NOT NULL,
+		file_size INTEGER NOT NULL,
+		file_mod_time DATETIME NOT NULL,
+		line INTEGER NOT NULL,
+		object_count INTEGER NOT NULL,
+		total_size INTEGER NOT NULL,
+		started_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY (project_name, file_path)
+	);
+
+	CREATE TABLE IF NOT EXISTS inventory_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		project_name TEXT NOT NULL,
+		baseline BOOLEAN NOT NULL,
+		complete BOOLEAN NOT NULL,
+		object_count INTEGER NOT NULL DEFAULT 0,
+		total_size INTEGER NOT NULL DEFAULT 0,
+		new INTEGER NOT NULL DEFAULT 0,
+		modified INTEGER NOT NULL DEFAULT 0,
+		deleted INTEGER NOT NULL DEFAULT 0,
+		started_at DATETIME NOT NULL,
+		finished_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS inventory_objects (
+		project_name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		etag TEXT NOT NULL,
+		last_modified DATETIME NOT NULL,
+		seen_run INTEGER NOT NULL,
+		PRIMARY KEY (project_name, path)
+	);
+
+	CREATE TABLE IF NOT EXISTS inventory_changes (
+		run_id INTEGER NOT NULL,
+		project_name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		change TEXT NOT NULL,
+		old_size INTEGER NOT NULL,
+		new_size INTEGER NOT NULL,
+		old_etag TEXT NOT NULL,
+		new_etag TEXT NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_inventory_changes_run ON inventory_changes(project_name, run_id);
+
+	CREATE TABLE IF NOT EXISTS stats_snapshots (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		project_name TEXT NOT NULL,
+		source TEXT NOT NULL,
+		taken_at DATETIME NOT NULL,
+		run_completed INTEGER NOT NULL,
+		run_failed INTEGER NOT NULL,
+		run_bytes INTEGER NOT NULL,
+		run_panics INTEGER NOT NULL DEFAULT 0,
+		pending_files INTEGER NOT NULL,
+		pending_bytes INTEGER NOT NULL,
+		failed_files INTEGER NOT NULL,
+		completed_files INTEGER NOT NULL,
+		completed_bytes INTEGER NOT NULL,
+		total_files INTEGER NOT NULL,
+		total_bytes INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_stats_snapshots_project ON stats_snapshots(project_name, taken_at);
+
+	CREATE TABLE IF NOT EXISTS endpoint_profiles (
+		project_name TEXT NOT NULL,
+		endpoint TEXT NOT NULL,
+		workers INTEGER NOT NULL,
+		runs INTEGER NOT NULL,
+		throughput REAL NOT NULL,
+		latency_p95_ms REAL NOT NULL,
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY (project_name, endpoint, workers)
+	);
+
+	CREATE TABLE IF NOT EXISTS packed_files (
+		project_name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		archive TEXT NOT NULL,
+		"offset" INTEGER NOT NULL,
+		size INTEGER NOT NULL,
+		packed_at DATETIME NOT NULL,
+		PRIMARY KEY (project_name, path)
+	);
+	CREATE INDEX IF NOT EXISTS idx_packed_files_archive ON packed_files(project_name, archive);
+
+	CREATE TABLE IF NOT EXISTS error_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		file_id INTEGER NOT NULL,
+		error_message TEXT NOT NULL,
+		occurred_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_error_history_file ON error_history(file_id, id);
+
+	CREATE TABLE IF NOT EXISTS partial_downloads (
+		project_name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		source_etag TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		written INTEGER NOT NULL,
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY (project_name, path)
+	);
+
+	CREATE TABLE IF NOT EXISTS tombstones (
+		project_name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		requested_by TEXT NOT NULL DEFAULT '',
+		requested_at DATETIME NOT NULL,
+		erased_at DATETIME,
+		PRIMARY KEY (project_name, path)
+	);
+
+	CREATE TABLE IF NOT EXISTS erasure_feed (
+		project_name TEXT PRIMARY KEY,
+		feed_cursor TEXT NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS pending_versions (
+		project_name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		superseded_path TEXT NOT NULL,
+		PRIMARY KEY (project_name, path)
+	);
+	`) + statsViews(d.db.dialect)
+
+	if _, err := d.db.Exec(createTableSQL); err != nil {
+		return err
+	}
+
+	if err := d.migrate(); err != nil {
+		return err
+	}
+
+	return d.setSchemaVersion(schemaVersion)
+}
+
+// storedSchemaVersion returns the schema version the database was last
+// initialized with, 0 for new databases
+func (d *Database) storedSchemaVersion() (int, error) {
+	var version int
+	if d.db.dialect.postgres() {
+		// PostgreSQL has no user_version, so the version is kept in a table
+		if _, err := d.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+			return 0, fmt.Errorf("failed to create schema version table: %w", err)
+		}
+		if err := d.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+			return 0, fmt.Errorf("failed to read schema version: %w", err)
+		}
+		return version, nil
+	}
+	if err := d.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+func (d *Database) setSchemaVersion(version int) error {
+	var err error
+	if d.db.dialect.postgres() {
+		_, err = d.db.Exec(fmt.Sprintf("DELETE FROM schema_version; INSERT INTO schema_version (version) VALUES (%d)", version))
+	} else {
+		_, err = d.db.Exec(fmt.Sprintf("PRAGMA user_version = %d", version))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+	return nil
+}
+
+// migrate adds columns introduced after the initial schema to existing databases
+func (d *Database) migrate() error {
+	version, err := d.storedSchemaVersion()
+	if err != nil {
+		return err
+	}
+	// A PostgreSQL state store never held unencoded paths
+	if version < 11 && !d.db.dialect.postgres() {
+		if err := d.encodeStoredPaths(); err != nil {
+			return err
+		}
+	}
+
+	columns := []struct {
+		name       string
+		definition string
+	}{
+		{"status_reason", "TEXT NOT NULL DEFAULT ''"},
+		{"verify_status", "TEXT NOT NULL DEFAULT ''"},
+		{"verify_message", "TEXT NOT NULL DEFAULT ''"},
+		{"verify_attempts", "INTEGER NOT NULL DEFAULT 0"},
+		{"repair_attempts", "INTEGER NOT NULL DEFAULT 0"},
+		{"drift_etag", "TEXT NOT NULL DEFAULT ''"},
+		{"retry_at", "DATETIME"},
+		{"storage_class", "TEXT NOT NULL DEFAULT ''"},
+		{"stored_size", "INTEGER NOT NULL DEFAULT 0"},
+		{"lease_owner", "TEXT NOT NULL DEFAULT ''"},
+		{"lease_expires", "DATETIME"},
+		{"first_error_at", "DATETIME"},
+		{"attempts", "INTEGER NOT NULL DEFAULT 0"},
+		{"sha256", "TEXT NOT NULL DEFAULT ''"},
+	}
+
+	for _, column := range columns {
+		if err := d.addColumnIfMissing("file_entries", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	if err := d.addColumnIfMissing("stats_snapshots", "run_panics", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	// Every database initialized since schema version 1 has the unique
+	// index, so only older and new ones are checked for duplicates
+	if version < 1 {
+		return d.ensureUniquePaths()
+	}
+	return nil
+}
+
+// encodeStoredPaths encodes the paths that databases from before version 11
+// stored as they are, see package keys. Longer paths are encoded first, so a
+// path is never encoded to one that is still waiting for its turn.
+func (d *Database) encodeStoredPaths() error {
+	columns := []struct {
+		table  string
+		column string
+	}{
+		{"file_entries", "path"},
+		{"destination_files", "path"},
+		{"file_changes", "path"},
+		{"file_changes", "superseded_path"},
+		{"multipart_uploads", "path"},
+		{"multipart_parts", "path"},
+		{"bisync_state", "path"},
+		{"destination_listing", "path"},
+		{"inventory_objects", "path"},
+		{"inventory_changes", "path"},
+		{"packed_files", "path"},
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, c := range columns {
+		// Only paths with "%", control characters or non-ASCII bytes can change
+		rows, err := tx.Query(fmt.Sprintf(`
+		SELECT rowid, %[2]s FROM %[1]s
+		WHERE %[2]s GLOB '*[^ -~]*' OR instr(%[2]s, '%%') > 0
+		ORDER BY length(CAST(%[2]s AS BLOB)) DESC`, c.table, c.column))
+		if err != nil {
+			return fmt.Errorf("failed to read paths of %s: %w", c.table, err)
+		}
+		type storedPath struct {
+			rowID int64
+			path  string
+		}
+		var changed []storedPath
+		for rows.Next() {
+			var p storedPath
+			if err := rows.Scan(&p.rowID, &p.path); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan path of %s: %w", c.table, err)
+			}
+			if encoded := keys.Encode(p.path); encoded != p.path {
+				changed = append(changed, storedPath{p.rowID, encoded})
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, p := range changed {
+			query := fmt.Sprintf("UPDATE %s SET %s = ? WHERE rowid = ?", c.table, c.column)
+			if _, err := tx.Exec(query, p.path, p.rowID); err != nil {
+				return fmt.Errorf("failed to encode path in %s: %w", c.table, err)
+			}
+		}
+		if len(changed) > 0 {
+			log.Printf("Encoded %d paths in %s", len(changed), c.table)
+		}
+	}
+	return tx.Commit()
+}
+
+// ensureUniquePaths enforces one entry per project and path. Databases from
+// before the constraint, which predates schema versions, may hold
+// duplicates created by concurrent writers; of those the latest finished
+// entry is kept.
+func (d *Database) ensureUniquePaths() error {
+	dedupe := `
+	DELETE FROM file_entries WHERE id IN (
+		SELECT id FROM (
+			SELECT id, ROW_NUMBER() OVER (
+				PARTITION BY project_name, path
+				ORDER BY status IN ('pending', 'error'), updated_at DESC, id DESC
+			) AS rank
+			FROM file_entries
+		) ranked WHERE rank > 1
+	)`
+	result, err := d.db.Exec(dedupe)
+	if err != nil {
+		return fmt.Errorf("failed to remove duplicate file entries: %w", err)
+	}
+	if removed, _ := result.RowsAffected(); removed > 0 {
+		log.Printf("Removed %d duplicate file entries", removed)
+	}
+
+	index := `
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_project_path_unique ON file_entries(project_name, path);
+	DROP INDEX IF EXISTS idx_project_path;`
+	if _, err := d.db.Exec(index); err != nil {
+		return fmt.Errorf("failed to create unique path index: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) addColumnIfMissing(table, column, definition string) error {
+	if d.db.dialect.postgres() {
+		query := d.db.dialect.schema(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, column, definition))
+		if _, err := d.db.Exec(query); err != nil {
+			return fmt.Errorf("failed to add column %s to %s: %w", column, table, err)
+		}
+		return nil
+	}
+
+	rows, err := d.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to read schema of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return fmt.Errorf("failed to scan schema of %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read schema of %s: %w", table, err)
+	}
+	rows.Close()
+
+	if _, err := d.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s to %s: %w", column, table, err)
+	}
+	return nil
 }
 
 func (d *Database) Close() error {
@@ -83,53 +707,210 @@ func (d *Database) Close() error {
 }
 
 func (d *Database) InsertFileEntry(entry *FileEntry) error {
+	return insertFileEntry(d.db, entry)
+}
+
+// insertFileEntry adds an entry or, when the path is already tracked,
+// updates it. The status of an existing entry is only replaced when its ETag
+// changed, so a concurrent writer can't reset a copied file to pending, or
+// when it was deleted by a mirror sync and is back in the source.
+func insertFileEntry(ex execer, entry *FileEntry) error {
 	query := `
 	INSERT INTO file_entries (
-		project_name, path, size, etag, last_modified, status, error_message, created_at, updated_at
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		project_name, path, size, etag, last_modified, status, status_reason, error_message, created_at, updated_at, storage_class
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (project_name, path) DO UPDATE SET
+		status = CASE WHEN file_entries.etag = excluded.etag AND file_entries.status != ? THEN file_entries.status ELSE excluded.status END,
+		status_reason = CASE WHEN file_entries.etag = excluded.etag AND file_entries.status != ? THEN file_entries.status_reason ELSE excluded.status_reason END,
+		error_message = CASE WHEN file_entries.etag = excluded.etag AND file_entries.status != ? THEN file_entries.error_message ELSE excluded.error_message END,
+		size = excluded.size,
+		etag = excluded.etag,
+		last_modified = excluded.last_modified,
+		updated_at = excluded.updated_at,
+		storage_class = CASE WHEN excluded.storage_class != '' THEN excluded.storage_class ELSE file_entries.storage_class END
+	RETURNING id, status, created_at`
 
 	now := time.Now()
 	entry.CreatedAt = now
 	entry.UpdatedAt = now
 
-	result, err := d.db.Exec(query,
+	err := ex.QueryRow(query,
 		entry.ProjectName,
-		entry.Path,
+		keys.Encode(entry.Path),
 		entry.Size,
 		entry.ETag,
 		entry.LastModified,
 		entry.Status,
+		entry.StatusReason,
 		entry.ErrorMessage,
 		entry.CreatedAt,
 		entry.UpdatedAt,
-	)
-	if err != nil {
+		entry.StorageClass,
+		StatusDeleted, StatusDeleted, StatusDeleted,
+	).Scan(&entry.ID, &entry.Status, &entry.CreatedAt)
+	return err
+}
+
+func (d *Database) UpdateFileStatus(id int64, status FileStatus, errorMessage string) error {
+	return updateFileStatus(d.db, id, status, errorMessage)
+}
+
+func updateFileStatus(ex execer, id int64, status FileStatus, errorMessage string) error {
+	query := `
+	UPDATE file_entries 
+	SET status = ?, status_reason = '', error_message = ?, updated_at = ?,
+		verify_status = '', verify_message = '', verify_attempts = 0, attempts = 0,
+		` + firstErrorAt + `
+	WHERE id = ?`
+
+	now := time.Now()
+	if _, err := ex.Exec(query, status, errorMessage, now, status == StatusError, now, id); err != nil {
 		return err
 	}
+	if errorMessage == "" {
+		return nil
+	}
+	return appendErrorHistory(ex, id, errorMessage, now)
+}
+
+// firstErrorAt keeps the time a file first failed while it keeps failing.
+// It takes whether the new status is an error and the current time.
+const firstErrorAt = `first_error_at = CASE WHEN ? THEN COALESCE(first_error_at, ?) ELSE NULL END`
+
+// RecordFailure records a failed copy of a file as its attempts-th failure.
+// The file is set to status, StatusError to be attempted again from retryAt
+// on or StatusFailed once no more attempts are left.
+func (d *Database) RecordFailure(id int64, status FileStatus, errorMessage string, attempts int, retryAt time.Time) error {
+	return recordFailure(d.db, id, status, errorMessage, attempts, retryAt)
+}
+
+func recordFailure(ex execer, id int64, status FileStatus, errorMessage string, attempts int, retryAt time.Time) error {
+	query := `
+	UPDATE file_entries
+	SET status = ?, status_reason = '', error_message = ?, attempts = ?, retry_at = ?, updated_at = ?,
+		verify_status = '', verify_message = '', verify_attempts = 0,
+		first_error_at = COALESCE(first_error_at, ?)
+	WHERE id = ?`
+
+	now := time.Now()
+	if _, err := ex.Exec(query, status, errorMessage, attempts, retryAt.UTC(), now, now, id); err != nil {
+		return fmt.Errorf("failed to record failure: %w", err)
+	}
+	return appendErrorHistory(ex, id, errorMessage, now)
+}
+
+// UpdateFileEntry stores the source metadata and status of an existing entry
+// in a single statement, e.g. after the source object changed
+func (d *Database) UpdateFileEntry(entry *FileEntry) error {
+	return updateFileEntry(d.db, entry)
+}
+
+func updateFileEntry(ex execer, entry *FileEntry) error {
+	query := `
+	UPDATE file_entries
+	SET size = ?, etag = ?, last_modified = ?, status = ?, status_reason = ?, error_message = ?, drift_etag = ?, updated_at = ?,
+		storage_class = ?, verify_status = '', verify_message = '', verify_attempts = 0, attempts = 0,
+		sha256 = CASE WHEN etag = ? THEN sha256 ELSE '' END
+	WHERE id = ?`
+
+	entry.UpdatedAt = time.Now()
+	_, err := ex.Exec(query,
+		entry.Size,
+		entry.ETag,
+		entry.LastModified,
+		entry.Status,
+		entry.StatusReason,
+		entry.ErrorMessage,
+		entry.DriftETag,
+		entry.UpdatedAt,
+		entry.StorageClass,
+		entry.ETag,
+		entry.ID,
+	)
+	return err
+}
 
-	id, err := result.LastInsertId()
+// UpdateFileStatusReason sets a status together with the reason it was chosen,
+// clearing any previous error message
+func (d *Database) UpdateFileStatusReason(id int64, status FileStatus, reason string) error {
+	return updateFileStatusReason(d.db, id, status, reason)
+}
+
+func updateFileStatusReason(ex execer, id int64, status FileStatus, reason string) error {
+	query := `
+	UPDATE file_entries
+	SET status = ?, status_reason = ?, error_message = '', updated_at = ?,
+		verify_status = '', verify_message = '', verify_attempts = 0, attempts = 0,
+		` + firstErrorAt + `
+	WHERE id = ?`
+
+	now := time.Now()
+	_, err := ex.Exec(query, status, reason, now, status == StatusError, now, id)
+	return err
+}
+
+// MarkDeleted records that the path of entry was deleted from the
+// destination, for the reason in its StatusReason. Paths that were never
+// tracked, such as objects only ever present at the destination, are added
+// with the metadata of entry.
+func (d *Database) MarkDeleted(entry *FileEntry) error {
+	query := `
+	INSERT INTO file_entries (
+		project_name, path, size, etag, last_modified, status, status_reason, error_message, created_at, updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, '', ?, ?)
+	ON CONFLICT (project_name, path) DO UPDATE SET
+		status = excluded.status,
+		status_reason = excluded.status_reason,
+		error_message = '',
+		verify_status = '',
+		verify_message = '',
+		verify_attempts = 0,
+		updated_at = excluded.updated_at`
+
+	now := time.Now()
+	_, err := d.db.Exec(query,
+		entry.ProjectName,
+		keys.Encode(entry.Path),
+		entry.Size,
+		entry.ETag,
+		entry.LastModified,
+		StatusDeleted,
+		entry.StatusReason,
+		now,
+		now,
+	)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to record deletion of %s: %w", entry.Path, err)
 	}
-	entry.ID = id
 	return nil
 }
 
-func (d *Database) UpdateFileStatus(id int64, status FileStatus, errorMessage string) error {
+// RequestRestore sets a file whose source object is archived to
+// restore_requested, to be copied again from retryAt on
+func (d *Database) RequestRestore(id int64, reason string, retryAt time.Time) error {
+	return requestRestore(d.db, id, reason, retryAt)
+}
+
+func requestRestore(ex execer, id int64, reason string, retryAt time.Time) error {
 	query := `
-	UPDATE file_entries 
-	SET status = ?, error_message = ?, updated_at = ?
+	UPDATE file_entries
+	SET status = ?, status_reason = ?, error_message = '', retry_at = ?, updated_at = ?
 	WHERE id = ?`
 
-	_, err := d.db.Exec(query, status, errorMessage, time.Now(), id)
-	return err
+	if _, err := ex.Exec(query, StatusRestoreRequested, reason, retryAt.UTC(), time.Now(), id); err != nil {
+		return fmt.Errorf("failed to record restore request: %w", err)
+	}
+	return nil
 }
 
+// GetPendingFiles returns the files waiting to be copied, leaving out those
+// another instance holds an unexpired lease on
 func (d *Database) GetPendingFiles(projectName string, limit int) ([]*FileEntry, error) {
 	query := `
-        SELECT id, project_name, path, size, etag, last_modified, status, error_message, created_at, updated_at
+        SELECT ` + fileEntryColumns + `
         FROM file_entries
-        WHERE project_name = ? AND status IN (?, ?)
+        WHERE project_name = ? AND (status = ? OR (status IN (?, ?) AND (retry_at IS NULL OR retry_at <= ?)))
+            AND (lease_owner = '' OR lease_expires < ?)
         ORDER BY created_at ASC`
 
 	if limit > 0 {
@@ -137,7 +918,8 @@ func (d *Database) GetPendingFiles(projectName string, limit int) ([]*FileEntry,
 	}
 
 	var args []interface{}
-	args = append(args, projectName, StatusPending, StatusError)
+	now := time.Now().UTC()
+	args = append(args, projectName, StatusPending, StatusError, StatusRestoreRequested, now, now)
 	if limit > 0 {
 		args = append(args, limit)
 	}
@@ -179,19 +961,7 @@ func (d *Database) GetPendingFiles(projectName string, limit int) ([]*FileEntry,
 
 	var entries []*FileEntry
 	for rows.Next() {
-		entry := &FileEntry{}
-		err := rows.Scan(
-			&entry.ID,
-			&entry.ProjectName,
-			&entry.Path,
-			&entry.Size,
-			&entry.ETag,
-			&entry.LastModified,
-			&entry.Status,
-			&entry.ErrorMessage,
-			&entry.CreatedAt,
-			&entry.UpdatedAt,
-		)
+		entry, err := scanFileEntry(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -203,24 +973,12 @@ func (d *Database) GetPendingFiles(projectName string, limit int) ([]*FileEntry,
 
 func (d *Database) GetFileByPath(projectName, path string) (*FileEntry, error) {
 	query := `
-	SELECT id, project_name, path, size, etag, last_modified, status, error_message, created_at, updated_at
+	SELECT ` + fileEntryColumns + `
 	FROM file_entries
 	WHERE project_name = ? AND path = ?
 	LIMIT 1`
 
-	entry := &FileEntry{}
-	err := d.db.QueryRow(query, projectName, path).Scan(
-		&entry.ID,
-		&entry.ProjectName,
-		&entry.Path,
-		&entry.Size,
-		&entry.ETag,
-		&entry.LastModified,
-		&entry.Status,
-		&entry.ErrorMessage,
-		&entry.CreatedAt,
-		&entry.UpdatedAt,
-	)
+	entry, err := scanFileEntry(d.db.QueryRow(query, projectName, keys.Encode(path)))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -258,15 +1016,45 @@ func (d *Database) GetStatusCounts(projectName string) ([]StatusCount, error) {
 	return counts, nil
 }
 
+// GetSkipReasonCounts returns file counts of the skipped and held back
+// statuses grouped by reason
+func (d *Database) GetSkipReasonCounts(projectName string) ([]StatusCount, error) {
+	query := `
+	SELECT status, status_reason, COUNT(*) as count, SUM(size) as total_size
+	FROM file_entries
+	WHERE project_name = ? AND status IN (?, ?, ?)
+	GROUP BY status, status_reason
+	ORDER BY status, status_reason`
+
+	rows, err := d.db.Query(query, projectName, StatusSkippedFiltered, StatusSkippedExisting, StatusDestNewer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get skip reason counts: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []StatusCount
+	for rows.Next() {
+		var count StatusCount
+		var status string
+		if err := rows.Scan(&status, &count.Reason, &count.Count, &count.Size); err != nil {
+			return nil, fmt.Errorf("failed to scan skip reason count: %w", err)
+		}
+		count.Status = FileStatus(status)
+		counts = append(counts, count)
+	}
+
+	return counts, nil
+}
+
 func (d *Database) GetRecentErrors(projectName string, limit int) ([]*FileEntry, error) {
 	query := `
-	SELECT id, project_name, path, size, etag, last_modified, status, error_message, created_at, updated_at
+	SELECT ` + fileEntryColumns + `
 	FROM file_entries
-	WHERE project_name = ? AND status = ? AND error_message IS NOT NULL
+	WHERE project_name = ? AND status IN (?, ?) AND error_message IS NOT NULL
 	ORDER BY updated_at DESC
 	LIMIT ?`
 
-	rows, err := d.db.Query(query, projectName, StatusError, limit)
+	rows, err := d.db.Query(query, projectName, StatusError, StatusFailed, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get recent errors: %w", err)
 	}
@@ -274,19 +1062,7 @@ func (d *Database) GetRecentErrors(projectName string, limit int) ([]*FileEntry,
 
 	var entries []*FileEntry
 	for rows.Next() {
-		entry := &FileEntry{}
-		err := rows.Scan(
-			&entry.ID,
-			&entry.ProjectName,
-			&entry.Path,
-			&entry.Size,
-			&entry.ETag,
-			&entry.LastModified,
-			&entry.Status,
-			&entry.ErrorMessage,
-			&entry.CreatedAt,
-			&entry.UpdatedAt,
-		)
+		entry, err := scanFileEntry(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan error entry: %w", err)
 		}
@@ -303,10 +1079,62 @@ func (d *Database) FileExistsWithETag(projectName, path, etag string) (bool, err
 	WHERE project_name = ? AND path = ? AND etag = ?`
 
 	var count int
-	err := d.db.QueryRow(query, projectName, path, etag).Scan(&count)
+	err := d.db.QueryRow(query, projectName, keys.Encode(path), etag).Scan(&count)
 	if err != nil {
 		return false, fmt.Errorf("failed to check file existence: %w", err)
 	}
 
 	return count > 0, nil
 }
+
+// CountPendingFiles counts the files still to be copied, including archived
+// source objects waiting for their restore
+func (d *Database) CountPendingFiles(projectName string) (int64, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM file_entries
+	WHERE project_name = ? AND status IN (?, ?, ?)`
+
+	var count int64
+	err := d.db.QueryRow(query, projectName, StatusPending, StatusError, StatusRestoreRequested).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pending files: %w", err)
+	}
+
+	return count, nil
+}
+
+// GetUnfinishedPaths returns the paths of the files directly inside folder
+// that are neither copied (completed or staged) nor skipped
+func (d *Database) GetUnfinishedPaths(projectName, folder string) ([]string, error) {
+	prefix := ""
+	if folder != "." && folder != "" {
+		prefix = folder + "/"
+	}
+
+	query := `
+	SELECT path
+	FROM file_entries
+	WHERE project_name = ? AND substr(path, 1, ?) = ? AND status NOT IN (?, ?, ?, ?, ?)`
+
+	encodedPrefix := keys.Encode(prefix)
+	rows, err := d.db.Query(query, projectName, utf8.RuneCountInString(encodedPrefix), encodedPrefix,
+		StatusCompleted, StatusStaged, StatusSkippedFiltered, StatusSkippedExisting, StatusDeleted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unfinished files: %w", err)
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("failed to scan unfinished file: %w", err)
+		}
+		path = keys.Decode(path)
+		if !strings.Contains(path[len(prefix):], "/") {
+			paths = append(paths, path)
+		}
+	}
+	return paths, rows.Err()
+}
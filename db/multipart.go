@@ -0,0 +1,129 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+)
+
+// MultipartUpload is an unfinished upload of a large object to the main
+// destination, keyed by its destination path
+type MultipartUpload struct {
+	ProjectName string
+	Path        string
+	UploadID    string
+	// SourceETag and Size identify the source version being uploaded
+	SourceETag string
+	Size       int64
+	PartSize   int64
+	StartedAt  time.Time
+	Parts      []UploadedPart
+}
+
+// UploadedPart is a part of a multipart upload that reached the destination
+type UploadedPart struct {
+	Number int
+	ETag   string
+	Size   int64
+}
+
+// GetMultipartUpload returns the unfinished upload of path with its uploaded
+// parts, or nil if there is none
+func (d *Database) GetMultipartUpload(projectName, path string) (*MultipartUpload, error) {
+	upload := &MultipartUpload{ProjectName: projectName, Path: path}
+	err := d.db.QueryRow(`
+	SELECT upload_id, source_etag, size, part_size, started_at
+	FROM multipart_uploads
+	WHERE project_name = ? AND path = ?`, projectName, keys.Encode(path)).Scan(
+		&upload.UploadID, &upload.SourceETag, &upload.Size, &upload.PartSize, &upload.StartedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get multipart upload: %w", err)
+	}
+
+	rows, err := d.db.Query(`
+	SELECT part_number, etag, size
+	FROM multipart_parts
+	WHERE project_name = ? AND path = ?
+	ORDER BY part_number`, projectName, keys.Encode(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get uploaded parts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var part UploadedPart
+		if err := rows.Scan(&part.Number, &part.ETag, &part.Size); err != nil {
+			return nil, fmt.Errorf("failed to scan uploaded part: %w", err)
+		}
+		upload.Parts = append(upload.Parts, part)
+	}
+	return upload, rows.Err()
+}
+
+// StartMultipartUpload records a new upload of path, replacing any previous one
+func (d *Database) StartMultipartUpload(upload *MultipartUpload) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM multipart_parts WHERE project_name = ? AND path = ?`, upload.ProjectName, keys.Encode(upload.Path)); err != nil {
+		return fmt.Errorf("failed to clear uploaded parts: %w", err)
+	}
+
+	upload.StartedAt = time.Now()
+	upload.Parts = nil
+	_, err = tx.Exec(`
+	INSERT INTO multipart_uploads (
+		project_name, path, upload_id, source_etag, size, part_size, started_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (project_name, path) DO UPDATE SET
+		upload_id = excluded.upload_id,
+		source_etag = excluded.source_etag,
+		size = excluded.size,
+		part_size = excluded.part_size,
+		started_at = excluded.started_at`,
+		upload.ProjectName, keys.Encode(upload.Path), upload.UploadID, upload.SourceETag, upload.Size, upload.PartSize, upload.StartedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record multipart upload: %w", err)
+	}
+	return tx.Commit()
+}
+
+// AddUploadedPart records a part that reached the destination
+func (d *Database) AddUploadedPart(projectName, path string, part UploadedPart) error {
+	_, err := d.db.Exec(`
+	INSERT INTO multipart_parts (project_name, path, part_number, etag, size)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT (project_name, path, part_number) DO UPDATE SET
+		etag = excluded.etag, size = excluded.size`, projectName, keys.Encode(path), part.Number, part.ETag, part.Size)
+	if err != nil {
+		return fmt.Errorf("failed to record uploaded part: %w", err)
+	}
+	return nil
+}
+
+// DeleteMultipartUpload forgets a completed or abandoned upload of path
+func (d *Database) DeleteMultipartUpload(projectName, path string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM multipart_parts WHERE project_name = ? AND path = ?`, projectName, keys.Encode(path)); err != nil {
+		return fmt.Errorf("failed to delete uploaded parts: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM multipart_uploads WHERE project_name = ? AND path = ?`, projectName, keys.Encode(path)); err != nil {
+		return fmt.Errorf("failed to delete multipart upload: %w", err)
+	}
+	return tx.Commit()
+}
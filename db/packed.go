@@ -0,0 +1,135 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+)
+
+// PackedFile is a file stored inside a tar archive at a local destination.
+// Offset is the position of its content in the archive.
+type PackedFile struct {
+	Path     string
+	Archive  string
+	Offset   int64
+	Size     int64
+	PackedAt time.Time
+}
+
+const packedFileColumns = `path, archive, "offset", size, packed_at`
+
+func scanPackedFile(row rowScanner) (*PackedFile, error) {
+	file := &PackedFile{}
+	if err := row.Scan(&file.Path, &file.Archive, &file.Offset, &file.Size, &file.PackedAt); err != nil {
+		return nil, err
+	}
+	file.Path = keys.Decode(file.Path)
+	return file, nil
+}
+
+// RecordPackedFile records where a file was packed, replacing an earlier
+// copy of the same path
+func (d *Database) RecordPackedFile(projectName string, file *PackedFile) error {
+	query := `
+	INSERT INTO packed_files (project_name, ` + packedFileColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT (project_name, path) DO UPDATE SET
+		archive = excluded.archive, "offset" = excluded."offset", size = excluded.size, packed_at = excluded.packed_at`
+
+	_, err := d.db.Exec(query, projectName, keys.Encode(file.Path), file.Archive, file.Offset, file.Size, file.PackedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record packed file: %w", err)
+	}
+	return nil
+}
+
+// GetPackedFile returns where a path is packed, or nil if it isn't
+func (d *Database) GetPackedFile(projectName, path string) (*PackedFile, error) {
+	query := `SELECT ` + packedFileColumns + ` FROM packed_files WHERE project_name = ? AND path = ?`
+	file, err := scanPackedFile(d.db.QueryRow(query, projectName, keys.Encode(path)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get packed file: %w", err)
+	}
+	return file, nil
+}
+
+// DeletePackedFile forgets a packed file. Its content stays in the archive.
+func (d *Database) DeletePackedFile(projectName, path string) error {
+	if _, err := d.db.Exec(`DELETE FROM packed_files WHERE project_name = ? AND path = ?`, projectName, keys.Encode(path)); err != nil {
+		return fmt.Errorf("failed to delete packed file: %w", err)
+	}
+	return nil
+}
+
+// MovePackedFile records a packed file under another path, replacing a file
+// packed there before
+func (d *Database) MovePackedFile(projectName, from, to string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM packed_files WHERE project_name = ? AND path = ?`, projectName, keys.Encode(to)); err != nil {
+		return fmt.Errorf("failed to move packed file: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE packed_files SET path = ? WHERE project_name = ? AND path = ?`, keys.Encode(to), projectName, keys.Encode(from)); err != nil {
+		return fmt.Errorf("failed to move packed file: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ForEachPackedFile calls fn with every packed file whose path starts with
+// prefix, ordered by path
+func (d *Database) ForEachPackedFile(projectName, prefix string, fn func(*PackedFile) error) error {
+	query := `
+	SELECT ` + packedFileColumns + ` FROM packed_files
+	WHERE project_name = ? AND substr(path, 1, ?) = ?
+	ORDER BY path`
+
+	prefix = keys.Encode(prefix)
+	rows, err := d.db.Query(query, projectName, utf8.RuneCountInString(prefix), prefix)
+	if err != nil {
+		return fmt.Errorf("failed to get packed files: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		file, err := scanPackedFile(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan packed file: %w", err)
+		}
+		if err := fn(file); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetLastPackArchive returns the latest archive of the project and the end
+// of the last file recorded in it, or an empty name if nothing was packed
+func (d *Database) GetLastPackArchive(projectName string) (string, int64, error) {
+	query := `
+	SELECT archive, MAX("offset" + size) FROM packed_files
+	WHERE project_name = ?
+	GROUP BY archive
+	ORDER BY archive DESC
+	LIMIT 1`
+
+	var archive string
+	var end int64
+	err := d.db.QueryRow(query, projectName).Scan(&archive, &end)
+	if err == sql.ErrNoRows {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get last pack archive: %w", err)
+	}
+	return archive, end, nil
+}
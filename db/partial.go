@@ -0,0 +1,91 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+)
+
+// PartialDownload is an interrupted download of a source object to the
+// local main destination, whose first Written bytes are kept in an
+// unfinished file
+type PartialDownload struct {
+	ProjectName string
+	Path        string
+	// SourceETag and Size identify the source version being downloaded
+	SourceETag string
+	Size       int64
+	Written    int64
+	UpdatedAt  time.Time
+}
+
+// GetPartialDownload returns the interrupted download of path, or nil if
+// there is none
+func (d *Database) GetPartialDownload(projectName, path string) (*PartialDownload, error) {
+	download := &PartialDownload{ProjectName: projectName, Path: path}
+	err := d.db.QueryRow(`
+	SELECT source_etag, size, written, updated_at
+	FROM partial_downloads
+	WHERE project_name = ? AND path = ?`, projectName, keys.Encode(path)).Scan(
+		&download.SourceETag, &download.Size, &download.Written, &download.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get partial download: %w", err)
+	}
+	return download, nil
+}
+
+// GetPartialDownloadPaths returns the paths of all interrupted downloads of
+// a project
+func (d *Database) GetPartialDownloadPaths(projectName string) ([]string, error) {
+	rows, err := d.db.Query(`SELECT path FROM partial_downloads WHERE project_name = ?`, projectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get partial downloads: %w", err)
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("failed to scan partial download: %w", err)
+		}
+		paths = append(paths, keys.Decode(path))
+	}
+	return paths, rows.Err()
+}
+
+// SavePartialDownload records how much of a download was written, replacing
+// any previous record of path
+func (d *Database) SavePartialDownload(download *PartialDownload) error {
+	download.UpdatedAt = time.Now()
+	_, err := d.db.Exec(`
+	INSERT INTO partial_downloads (
+		project_name, path, source_etag, size, written, updated_at
+	) VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT (project_name, path) DO UPDATE SET
+		source_etag = excluded.source_etag,
+		size = excluded.size,
+		written = excluded.written,
+		updated_at = excluded.updated_at`,
+		download.ProjectName, keys.Encode(download.Path), download.SourceETag, download.Size, download.Written, download.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record partial download: %w", err)
+	}
+	return nil
+}
+
+// DeletePartialDownload forgets a completed or abandoned download of path
+func (d *Database) DeletePartialDownload(projectName, path string) error {
+	_, err := d.db.Exec(`DELETE FROM partial_downloads WHERE project_name = ? AND path = ?`, projectName, keys.Encode(path))
+	if err != nil {
+		return fmt.Errorf("failed to delete partial download: %w", err)
+	}
+	return nil
+}
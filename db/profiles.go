@@ -0,0 +1,81 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// profileWeight is the weight of the latest run in the averages of an
+// endpoint profile, so the profile follows changes of the endpoint within a
+// few runs
+const profileWeight = 0.3
+
+// EndpointProfile is the performance observed at an endpoint by past runs
+// with a given number of workers
+type EndpointProfile struct {
+	Endpoint string
+	Workers  int
+	Runs     int64
+	// Throughput is the average rate of all workers together, in bytes per
+	// second, and LatencyP95 the average p95 latency of requests, zero if
+	// it was never measured
+	Throughput float64
+	LatencyP95 time.Duration
+	UpdatedAt  time.Time
+}
+
+// RecordEndpointProfile adds the observations of a run to the profile of
+// the endpoint and worker count
+func (d *Database) RecordEndpointProfile(projectName string, run *EndpointProfile) error {
+	query := `
+	INSERT INTO endpoint_profiles (
+		project_name, endpoint, workers, runs, throughput, latency_p95_ms, updated_at
+	) VALUES (?, ?, ?, 1, ?, ?, ?)
+	ON CONFLICT (project_name, endpoint, workers) DO UPDATE SET
+		runs = endpoint_profiles.runs + 1,
+		throughput = endpoint_profiles.throughput * ? + excluded.throughput * ?,
+		latency_p95_ms = CASE
+			WHEN excluded.latency_p95_ms = 0 THEN endpoint_profiles.latency_p95_ms
+			WHEN endpoint_profiles.latency_p95_ms = 0 THEN excluded.latency_p95_ms
+			ELSE endpoint_profiles.latency_p95_ms * ? + excluded.latency_p95_ms * ?
+		END,
+		updated_at = excluded.updated_at`
+
+	latency := float64(run.LatencyP95) / float64(time.Millisecond)
+	_, err := d.db.Exec(query,
+		projectName, run.Endpoint, run.Workers, run.Throughput, latency, time.Now(),
+		1-profileWeight, profileWeight, 1-profileWeight, profileWeight,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record endpoint profile: %w", err)
+	}
+	return nil
+}
+
+// GetEndpointProfiles returns the profiles of the project, fastest first for
+// every endpoint
+func (d *Database) GetEndpointProfiles(projectName string) ([]EndpointProfile, error) {
+	query := `
+	SELECT endpoint, workers, runs, throughput, latency_p95_ms, updated_at
+	FROM endpoint_profiles
+	WHERE project_name = ?
+	ORDER BY endpoint, throughput DESC`
+
+	rows, err := d.db.Query(query, projectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get endpoint profiles: %w", err)
+	}
+	defer rows.Close()
+
+	var profiles []EndpointProfile
+	for rows.Next() {
+		var profile EndpointProfile
+		var latency float64
+		if err := rows.Scan(&profile.Endpoint, &profile.Workers, &profile.Runs, &profile.Throughput, &latency, &profile.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan endpoint profile: %w", err)
+		}
+		profile.LatencyP95 = time.Duration(latency * float64(time.Millisecond))
+		profiles = append(profiles, profile)
+	}
+	return profiles, rows.Err()
+}
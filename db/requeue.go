@@ -0,0 +1,235 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+)
+
+// RequeueModified sets the completed files whose source was last modified
+// at or after after and before before back to pending with reason, so the
+// next sync copies them again. It returns how many files were requeued.
+func (d *Database) RequeueModified(projectName string, after, before time.Time, reason string) (int64, error) {
+	// Imported entries may carry their modification time in another zone
+	// than UTC
+	modified, bound := d.db.dialect.timeValue("last_modified"), d.db.dialect.timeValue("?")
+	query := `
+	UPDATE file_entries
+	SET status = ?, status_reason = ?, error_message = '', updated_at = ?,
+		verify_status = '', verify_message = '', verify_attempts = 0
+	WHERE project_name = ? AND status = ? AND ` + modified + ` >= ` + bound + ` AND ` + modified + ` < ` + bound
+
+	result, err := d.db.Exec(query,
+		StatusPending, reason, time.Now(),
+		projectName, StatusCompleted, after.UTC(), before.UTC(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue files: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// RetryFailed sets the files of a project that failed, with or without
+// attempts left, back to pending and clears their errors and attempts. Only
+// files whose path starts with prefix are retried, and if match isn't nil
+// only those whose error message it accepts. It returns how many files were
+// set back.
+func (d *Database) RetryFailed(projectName, prefix string, match func(errorMessage string) bool) (int64, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	prefix = keys.Encode(prefix)
+	rows, err := tx.Query(`
+	SELECT id, COALESCE(error_message, '') FROM file_entries
+	WHERE project_name = ? AND status IN (?, ?) AND substr(path, 1, ?) = ?`,
+		projectName, StatusError, StatusFailed, utf8.RuneCountInString(prefix), prefix,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get failed files: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var errorMessage string
+		if err := rows.Scan(&id, &errorMessage); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan failed file: %w", err)
+		}
+		if match == nil || match(errorMessage) {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to get failed files: %w", err)
+	}
+
+	query := `
+	UPDATE file_entries
+	SET status = ?, status_reason = '', error_message = '', attempts = 0, retry_at = NULL, first_error_at = NULL, updated_at = ?
+	WHERE id = ?`
+	now := time.Now()
+	for _, id := range ids {
+		if _, err := tx.Exec(query, StatusPending, now, id); err != nil {
+			return 0, fmt.Errorf("failed to retry file: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit retry: %w", err)
+	}
+	return int64(len(ids)), nil
+}
+
+// ResetFiles sets the files of a project back to pending with reason, so the
+// next sync copies them again. Only files with one of statuses are reset, or
+// all files not pending if statuses is empty, and only those last updated
+// before before unless it is zero. Their leases, checksums, drift and the
+// state of unfinished transfers are cleared too, see clearFileState. It
+// returns how many files were reset and their unfinished multipart uploads,
+// which are left for the caller to abort.
+func (d *Database) ResetFiles(projectName string, statuses []FileStatus, before time.Time, reason string) (int64, []*MultipartUpload, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	where, args := resetFilter(d.db.dialect, projectName, statuses, before)
+	if len(statuses) == 0 {
+		where += " AND status != ?"
+		args = append(args, StatusPending)
+	}
+	uploads, err := clearFileState(tx, projectName, where, args)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	query := `
+	UPDATE file_entries
+	SET status = ?, status_reason = ?, error_message = '', updated_at = ?,
+		verify_status = '', verify_message = '', verify_attempts = 0, repair_attempts = 0,
+		attempts = 0, retry_at = NULL, first_error_at = NULL,
+		lease_owner = '', lease_expires = NULL, sha256 = '', drift_etag = '', stored_size = 0
+	WHERE ` + where
+
+	result, err := tx.Exec(query, append([]any{StatusPending, reason, time.Now()}, args...)...)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to reset files: %w", err)
+	}
+	reset, err := result.RowsAffected()
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("failed to commit reset: %w", err)
+	}
+	return reset, uploads, nil
+}
+
+// DeleteFiles removes the files of a project from the database, together
+// with their state at additional destinations, their error history and the
+// state cleared by clearFileState, so the next update-list adds them as new
+// files. Only files with one of statuses are deleted, or all files if
+// statuses is empty, and before limits them like for ResetFiles. It returns
+// how many files were deleted and their unfinished multipart uploads, which
+// are left for the caller to abort.
+func (d *Database) DeleteFiles(projectName string, statuses []FileStatus, before time.Time) (int64, []*MultipartUpload, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	where, args := resetFilter(d.db.dialect, projectName, statuses, before)
+	_, err = tx.Exec(`
+	DELETE FROM error_history
+	WHERE file_id IN (SELECT id FROM file_entries WHERE `+where+`)`, args...)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to delete error history: %w", err)
+	}
+	_, err = tx.Exec(`
+	DELETE FROM destination_files
+	WHERE project_name = ? AND path IN (SELECT path FROM file_entries WHERE `+where+`)`,
+		append([]any{projectName}, args...)...)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to delete destination state: %w", err)
+	}
+	uploads, err := clearFileState(tx, projectName, where, args)
+	if err != nil {
+		return 0, nil, err
+	}
+	result, err := tx.Exec(`DELETE FROM file_entries WHERE `+where, args...)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to delete files: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("failed to commit deletion: %w", err)
+	}
+	return deleted, uploads, nil
+}
+
+// fileStateTables hold the state of unfinished transfers and of the last
+// bidirectional sync of files, by path
+var fileStateTables = []string{"multipart_parts", "multipart_uploads", "partial_downloads", "bisync_state"}
+
+// clearFileState deletes the rows of fileStateTables of the files selected
+// by where and returns their multipart uploads. Previous copies still to be
+// kept by the version policy are left, so a copy made later keeps them.
+func clearFileState(t *tx, projectName, where string, args []any) ([]*MultipartUpload, error) {
+	selected := `project_name = ? AND path IN (SELECT path FROM file_entries WHERE ` + where + `)`
+	selectedArgs := append([]any{projectName}, args...)
+
+	rows, err := t.Query(`SELECT path, upload_id FROM multipart_uploads WHERE `+selected, selectedArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get multipart uploads: %w", err)
+	}
+	var uploads []*MultipartUpload
+	for rows.Next() {
+		upload := &MultipartUpload{ProjectName: projectName}
+		if err := rows.Scan(&upload.Path, &upload.UploadID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan multipart upload: %w", err)
+		}
+		upload.Path = keys.Decode(upload.Path)
+		uploads = append(uploads, upload)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get multipart uploads: %w", err)
+	}
+
+	for _, table := range fileStateTables {
+		if _, err := t.Exec(`DELETE FROM `+table+` WHERE `+selected, selectedArgs...); err != nil {
+			return nil, fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+	}
+	return uploads, nil
+}
+
+// resetFilter returns the condition selecting the files of ResetFiles and
+// DeleteFiles, and its arguments
+func resetFilter(d dialect, projectName string, statuses []FileStatus, before time.Time) (string, []any) {
+	where := "project_name = ?"
+	args := []any{projectName}
+	if len(statuses) > 0 {
+		where += " AND status IN (?" + strings.Repeat(", ?", len(statuses)-1) + ")"
+		for _, status := range statuses {
+			args = append(args, status)
+		}
+	}
+	if !before.IsZero() {
+		where += " AND " + d.timeValue("updated_at") + " < " + d.timeValue("?")
+		args = append(args, before.UTC())
+	}
+	return where, args
+}
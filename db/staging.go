@@ -0,0 +1,55 @@
+package db
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+)
+
+// GetStagedFiles returns the files copied to the staging prefix that are not
+// committed yet
+func (d *Database) GetStagedFiles(projectName string) ([]*FileEntry, error) {
+	query := `
+	SELECT ` + fileEntryColumns + `
+	FROM file_entries
+	WHERE project_name = ? AND status = ?
+	ORDER BY path ASC`
+
+	rows, err := d.db.Query(query, projectName, StatusStaged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get staged files: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*FileEntry
+	for rows.Next() {
+		entry, err := scanFileEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// CountUnstagedFiles returns how many files below prefix still have to be
+// copied before their group can be committed
+func (d *Database) CountUnstagedFiles(projectName, prefix string) (int64, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM file_entries
+	WHERE project_name = ? AND substr(path, 1, ?) = ? AND status NOT IN (?, ?, ?, ?, ?)`
+
+	var count int64
+	prefix = keys.Encode(prefix)
+	err := d.db.QueryRow(query, projectName, utf8.RuneCountInString(prefix), prefix,
+		StatusCompleted, StatusStaged, StatusSkippedFiltered, StatusSkippedExisting, StatusDeleted,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count unstaged files: %w", err)
+	}
+
+	return count, nil
+}
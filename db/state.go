@@ -0,0 +1,61 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/lib/pq"
+)
+
+// OpenDatabase opens the state of a project in the database selected by
+// driver: the SQLite database at dbPath when driver is empty or sqlite, or
+// the server at dsn otherwise
+func OpenDatabase(driver, dsn, dbPath string) (*Database, error) {
+	switch driver {
+	case "", DriverSQLite:
+		return NewDatabase(dbPath)
+	case DriverPostgres:
+		return openPostgres(dsn)
+	}
+	return nil, fmt.Errorf("unknown state backend %q", driver)
+}
+
+// OpenReadOnlyDatabase opens the state of a project for commands that only
+// read it, like NewReadOnlyDatabase does for SQLite. A database server is
+// opened as usual, as it doesn't lock out a running sync.
+func OpenReadOnlyDatabase(driver, dsn, dbPath string) (*Database, error) {
+	switch driver {
+	case "", DriverSQLite:
+		return NewReadOnlyDatabase(dbPath)
+	case DriverPostgres:
+		database, err := openPostgres(dsn)
+		if err != nil {
+			return nil, err
+		}
+		version, err := database.storedSchemaVersion()
+		if err == nil && version < schemaVersion {
+			err = database.Initialize()
+		}
+		if err != nil {
+			database.Close()
+			return nil, err
+		}
+		return database, nil
+	}
+	return OpenDatabase(driver, dsn, dbPath)
+}
+
+func openPostgres(dsn string) (*Database, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("the postgres state backend requires a dsn")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to state backend: %w", err)
+	}
+	return &Database{db: &conn{DB: db, dialect: DriverPostgres}}, nil
+}
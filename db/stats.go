@@ -0,0 +1,149 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// StatsSnapshot records the outcome of a run together with the state of the
+// file list afterwards. Snapshots accumulate over time and back the stats_*
+// views, which chart tools such as Grafana can query directly.
+type StatsSnapshot struct {
+	ProjectName string
+	// Source is the command that took the snapshot, e.g. "sync"
+	Source string
+	// RunCompleted, RunFailed and RunBytes count the files copied, the files
+	// that failed and the bytes transferred by the run, RunPanics the failed
+	// files whose processing panicked
+	RunCompleted int64
+	RunFailed    int64
+	RunBytes     int64
+	RunPanics    int64
+	TakenAt      time.Time
+}
+
+// statsViews returns the statements creating the views over the stats
+// snapshots. Times are Unix seconds in "time" columns, which is what chart
+// tools expect.
+func statsViews(d dialect) string {
+	if d.postgres() {
+		return postgresStatsViewsSQL
+	}
+	return statsViewsSQL
+}
+
+const statsViewsSQL = `
+	CREATE VIEW IF NOT EXISTS stats_daily_copied AS
+	SELECT project_name,
+		date(taken_at) AS day,
+		CAST(strftime('%s', date(taken_at)) AS INTEGER) AS time,
+		SUM(run_completed) AS files,
+		SUM(run_bytes) AS bytes,
+		SUM(run_failed) AS failed
+	FROM stats_snapshots
+	GROUP BY project_name, date(taken_at);
+
+	CREATE VIEW IF NOT EXISTS stats_backlog AS
+	SELECT project_name,
+		source,
+		taken_at,
+		CAST(strftime('%s', taken_at) AS INTEGER) AS time,
+		pending_files,
+		pending_bytes,
+		failed_files,
+		completed_files,
+		completed_bytes,
+		total_files,
+		total_bytes
+	FROM stats_snapshots;
+
+	CREATE VIEW IF NOT EXISTS stats_daily_errors AS
+	SELECT project_name,
+		date(taken_at) AS day,
+		CAST(strftime('%s', date(taken_at)) AS INTEGER) AS time,
+		SUM(run_failed) AS failed,
+		MAX(failed_files) AS failed_backlog,
+		CASE WHEN SUM(run_completed + run_failed) > 0
+			THEN CAST(SUM(run_failed) AS REAL) / SUM(run_completed + run_failed)
+			ELSE 0 END AS error_rate
+	FROM stats_snapshots
+	GROUP BY project_name, date(taken_at);
+	`
+
+// postgresStatsViewsSQL are the stats views of statsViewsSQL for PostgreSQL,
+// with days in UTC
+const postgresStatsViewsSQL = `
+	CREATE OR REPLACE VIEW stats_daily_copied AS
+	SELECT project_name,
+		CAST(taken_at AT TIME ZONE 'UTC' AS DATE) AS day,
+		CAST(EXTRACT(EPOCH FROM date_trunc('day', taken_at AT TIME ZONE 'UTC')) AS BIGINT) AS time,
+		SUM(run_completed) AS files,
+		SUM(run_bytes) AS bytes,
+		SUM(run_failed) AS failed
+	FROM stats_snapshots
+	GROUP BY 1, 2, 3;
+
+	CREATE OR REPLACE VIEW stats_backlog AS
+	SELECT project_name,
+		source,
+		taken_at,
+		CAST(EXTRACT(EPOCH FROM taken_at) AS BIGINT) AS time,
+		pending_files,
+		pending_bytes,
+		failed_files,
+		completed_files,
+		completed_bytes,
+		total_files,
+		total_bytes
+	FROM stats_snapshots;
+
+	CREATE OR REPLACE VIEW stats_daily_errors AS
+	SELECT project_name,
+		CAST(taken_at AT TIME ZONE 'UTC' AS DATE) AS day,
+		CAST(EXTRACT(EPOCH FROM date_trunc('day', taken_at AT TIME ZONE 'UTC')) AS BIGINT) AS time,
+		SUM(run_failed) AS failed,
+		MAX(failed_files) AS failed_backlog,
+		CASE WHEN SUM(run_completed + run_failed) > 0
+			THEN CAST(SUM(run_failed) AS DOUBLE PRECISION) / SUM(run_completed + run_failed)
+			ELSE 0 END AS error_rate
+	FROM stats_snapshots
+	GROUP BY 1, 2, 3;
+	`
+
+// InsertStatsSnapshot records a snapshot, counting the file list of the
+// project as it is now
+func (d *Database) InsertStatsSnapshot(snapshot *StatsSnapshot) error {
+	snapshot.TakenAt = time.Now().UTC()
+	var pendingFiles, pendingBytes, failedFiles, completedFiles, completedBytes, totalFiles, totalBytes int64
+	err := d.db.QueryRow(`
+	SELECT COUNT(CASE WHEN status = ? THEN 1 END),
+		COALESCE(SUM(CASE WHEN status = ? THEN size END), 0),
+		COUNT(CASE WHEN status IN (?, ?) THEN 1 END),
+		COUNT(CASE WHEN status IN (?, ?) THEN 1 END),
+		COALESCE(SUM(CASE WHEN status IN (?, ?) THEN size END), 0),
+		COUNT(*),
+		COALESCE(SUM(size), 0)
+	FROM file_entries
+	WHERE project_name = ? AND status NOT IN (?, ?)`,
+		StatusPending, StatusPending, StatusError, StatusFailed,
+		StatusCompleted, StatusSkippedExisting, StatusCompleted, StatusSkippedExisting,
+		snapshot.ProjectName, StatusDeleted, StatusSkippedFiltered,
+	).Scan(&pendingFiles, &pendingBytes, &failedFiles, &completedFiles, &completedBytes, &totalFiles, &totalBytes)
+	if err != nil {
+		return fmt.Errorf("failed to count files for stats snapshot: %w", err)
+	}
+
+	_, err = d.db.Exec(`
+	INSERT INTO stats_snapshots (
+		project_name, source, taken_at, run_completed, run_failed, run_bytes, run_panics,
+		pending_files, pending_bytes, failed_files, completed_files, completed_bytes, total_files, total_bytes
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		snapshot.ProjectName, snapshot.Source, snapshot.TakenAt,
+		snapshot.RunCompleted, snapshot.RunFailed, snapshot.RunBytes, snapshot.RunPanics,
+		pendingFiles, pendingBytes, failedFiles, completedFiles, completedBytes, totalFiles, totalBytes,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record stats snapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// StorageClassCount is the number of tracked files in one storage class of
+// the source
+type StorageClassCount struct {
+	StorageClass string
+	Count        int64
+	Size         int64
+}
+
+func setStorageClass(ex execer, id int64, storageClass string) error {
+	_, err := ex.Exec(`UPDATE file_entries SET storage_class = ?, updated_at = ? WHERE id = ?`, storageClass, time.Now(), id)
+	return err
+}
+
+// GetStorageClassCounts returns the files still in the source per storage
+// class
+func (d *Database) GetStorageClassCounts(projectName string) ([]StorageClassCount, error) {
+	query := `
+	SELECT storage_class, COUNT(*), COALESCE(SUM(size), 0)
+	FROM file_entries
+	WHERE project_name = ? AND status != ?
+	GROUP BY storage_class
+	ORDER BY storage_class`
+
+	rows, err := d.db.Query(query, projectName, StatusDeleted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage class counts: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []StorageClassCount
+	for rows.Next() {
+		var count StorageClassCount
+		if err := rows.Scan(&count.StorageClass, &count.Count, &count.Size); err != nil {
+			return nil, fmt.Errorf("failed to scan storage class count: %w", err)
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// StoredSizeTotals compares the size of the files saved compressed to a
+// local destination with the size they take on disk
+type StoredSizeTotals struct {
+	Count      int64
+	Size       int64
+	StoredSize int64
+}
+
+// SetStoredSize records the size a copied file takes at the destination
+func (d *Database) SetStoredSize(id int64, storedSize int64) error {
+	return setStoredSize(d.db, id, storedSize)
+}
+
+func setStoredSize(ex execer, id int64, storedSize int64) error {
+	_, err := ex.Exec(`UPDATE file_entries SET stored_size = ?, updated_at = ? WHERE id = ?`, storedSize, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to set stored size: %w", err)
+	}
+	return nil
+}
+
+// GetStoredSizeTotals returns the original and stored size of the copied
+// files whose stored size is recorded
+func (d *Database) GetStoredSizeTotals(projectName string) (*StoredSizeTotals, error) {
+	query := `
+	SELECT COUNT(*), COALESCE(SUM(size), 0), COALESCE(SUM(stored_size), 0)
+	FROM file_entries
+	WHERE project_name = ? AND status = ? AND stored_size > 0`
+
+	var totals StoredSizeTotals
+	err := d.db.QueryRow(query, projectName, StatusCompleted).Scan(&totals.Count, &totals.Size, &totals.StoredSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stored size totals: %w", err)
+	}
+	return &totals, nil
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+)
+
+// Tombstone records a key whose copies were requested to be erased. The key
+// is never copied again; ErasedAt is zero while copies may still be left.
+type Tombstone struct {
+	ProjectName string
+	Path        string
+	Reason      string
+	// RequestedBy is the forget command or the ID of a feed request
+	RequestedBy string
+	RequestedAt time.Time
+	ErasedAt    time.Time
+}
+
+// AddTombstone records an erasure request for a path. A path requested
+// again keeps its first request, but is erased once more.
+func (d *Database) AddTombstone(tombstone *Tombstone) error {
+	tombstone.RequestedAt = time.Now()
+	_, err := d.db.Exec(`
+	INSERT INTO tombstones (
+		project_name, path, reason, requested_by, requested_at
+	) VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT (project_name, path) DO UPDATE SET
+		erased_at = NULL`,
+		tombstone.ProjectName, keys.Encode(tombstone.Path), tombstone.Reason, tombstone.RequestedBy, tombstone.RequestedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record tombstone of %s: %w", tombstone.Path, err)
+	}
+	return nil
+}
+
+// MarkTombstoneErased records that all copies of a path were erased
+func (d *Database) MarkTombstoneErased(projectName, path string) error {
+	_, err := d.db.Exec(`UPDATE tombstones SET erased_at = ? WHERE project_name = ? AND path = ?`,
+		time.Now(), projectName, keys.Encode(path))
+	if err != nil {
+		return fmt.Errorf("failed to update tombstone of %s: %w", path, err)
+	}
+	return nil
+}
+
+// GetUnerasedTombstones returns the erasure requests of a project whose
+// copies were not all erased yet, oldest first
+func (d *Database) GetUnerasedTombstones(projectName string) ([]*Tombstone, error) {
+	rows, err := d.db.Query(`
+	SELECT path, reason, requested_by, requested_at
+	FROM tombstones
+	WHERE project_name = ? AND erased_at IS NULL
+	ORDER BY requested_at ASC`, projectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tombstones: %w", err)
+	}
+	defer rows.Close()
+
+	var tombstones []*Tombstone
+	for rows.Next() {
+		tombstone := &Tombstone{ProjectName: projectName}
+		if err := rows.Scan(&tombstone.Path, &tombstone.Reason, &tombstone.RequestedBy, &tombstone.RequestedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tombstone: %w", err)
+		}
+		tombstone.Path = keys.Decode(tombstone.Path)
+		tombstones = append(tombstones, tombstone)
+	}
+	return tombstones, rows.Err()
+}
+
+// GetTombstonedPaths returns which of paths have a tombstone
+func (d *Database) GetTombstonedPaths(projectName string, paths []string) (map[string]bool, error) {
+	tombstoned := make(map[string]bool)
+	for start := 0; start < len(paths); start += maxBatchLookup {
+		chunk := paths[start:min(start+maxBatchLookup, len(paths))]
+
+		args := make([]any, 0, len(chunk)+1)
+		args = append(args, projectName)
+		for _, path := range chunk {
+			args = append(args, keys.Encode(path))
+		}
+
+		rows, err := d.db.Query(`
+		SELECT path FROM tombstones
+		WHERE project_name = ? AND path IN (?`+strings.Repeat(", ?", len(chunk)-1)+`)`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tombstones: %w", err)
+		}
+		for rows.Next() {
+			var path string
+			if err := rows.Scan(&path); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan tombstone: %w", err)
+			}
+			tombstoned[keys.Decode(path)] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return tombstoned, nil
+}
+
+// GetSupersededPaths returns where the version policy kept previous copies
+// of path
+func (d *Database) GetSupersededPaths(projectName, path string) ([]string, error) {
+	rows, err := d.db.Query(`
+	SELECT DISTINCT superseded_path FROM file_changes
+	WHERE project_name = ? AND path = ? AND superseded_path != ''`, projectName, keys.Encode(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get superseded copies: %w", err)
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var superseded string
+		if err := rows.Scan(&superseded); err != nil {
+			return nil, fmt.Errorf("failed to scan superseded copy: %w", err)
+		}
+		paths = append(paths, keys.Decode(superseded))
+	}
+	return paths, rows.Err()
+}
+
+// GetErasureFeedCursor returns where reading the erasure feed of a project
+// continues, empty to start at its beginning
+func (d *Database) GetErasureFeedCursor(projectName string) (string, error) {
+	var cursor string
+	err := d.db.QueryRow(`SELECT feed_cursor FROM erasure_feed WHERE project_name = ?`, projectName).Scan(&cursor)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get erasure feed cursor: %w", err)
+	}
+	return cursor, nil
+}
+
+// SaveErasureFeedCursor records how far the erasure feed of a project was read
+func (d *Database) SaveErasureFeedCursor(projectName, cursor string) error {
+	_, err := d.db.Exec(`
+	INSERT INTO erasure_feed (project_name, feed_cursor, updated_at) VALUES (?, ?, ?)
+	ON CONFLICT (project_name) DO UPDATE SET
+		feed_cursor = excluded.feed_cursor,
+		updated_at = excluded.updated_at`,
+		projectName, cursor, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save erasure feed cursor: %w", err)
+	}
+	return nil
+}
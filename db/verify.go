@@ -0,0 +1,160 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+type VerifyStatus string
+
+const (
+	VerifyNone    VerifyStatus = ""
+	VerifyPending VerifyStatus = "verify_pending"
+	Verified      VerifyStatus = "verified"
+	VerifyCorrupt VerifyStatus = "corrupt"
+	// VerifyFailed means the copy could not be checked within the allowed attempts
+	VerifyFailed VerifyStatus = "verify_failed"
+)
+
+type VerifyCount struct {
+	Status VerifyStatus
+	Count  int64
+	Size   int64
+}
+
+// QueueVerification marks completed files as verify_pending. Files that were
+// never verified (or could not be verified) are queued; with all set, every
+// completed file is queued again. With corrupt set, quarantined files are
+// queued as well. Files already queued stay queued so an interrupted
+// verification resumes where it stopped.
+func (d *Database) QueueVerification(projectName string, all, corrupt bool) (int64, error) {
+	query := `
+	UPDATE file_entries
+	SET verify_status = ?, verify_message = '', verify_attempts = 0
+	WHERE project_name = ? AND verify_status != ? AND (
+		(status = ? AND (? OR verify_status IN (?, ?))) OR
+		(status = ? AND ?)
+	)`
+
+	result, err := d.db.Exec(query,
+		VerifyPending, projectName, VerifyPending,
+		StatusCompleted, all, VerifyNone, VerifyFailed,
+		StatusCorrupt, all || corrupt,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to queue verification: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func (d *Database) GetVerifyPendingFiles(projectName string) ([]*FileEntry, error) {
+	query := `
+	SELECT ` + fileEntryColumns + `
+	FROM file_entries
+	WHERE project_name = ? AND status IN (?, ?) AND verify_status = ?
+	ORDER BY id ASC`
+
+	rows, err := d.db.Query(query, projectName, StatusCompleted, StatusCorrupt, VerifyPending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get files to verify: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*FileEntry
+	for rows.Next() {
+		entry, err := scanFileEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// UpdateVerifyStatus records the outcome of one verification attempt
+func (d *Database) UpdateVerifyStatus(id int64, status VerifyStatus, message string, attempts int) error {
+	query := `
+	UPDATE file_entries
+	SET verify_status = ?, verify_message = ?, verify_attempts = ?, updated_at = ?
+	WHERE id = ?`
+
+	_, err := d.db.Exec(query, status, message, attempts, time.Now(), id)
+	return err
+}
+
+// QuarantineFile marks a file whose copy failed verification as corrupt
+func (d *Database) QuarantineFile(id int64, message string, attempts int) error {
+	query := `
+	UPDATE file_entries
+	SET status = ?, verify_status = ?, verify_message = ?, verify_attempts = ?, updated_at = ?
+	WHERE id = ?`
+
+	_, err := d.db.Exec(query, StatusCorrupt, VerifyCorrupt, message, attempts, time.Now(), id)
+	return err
+}
+
+// RecordRepairAttempt counts an automatic re-copy of a corrupt file. The
+// counter is never reset so repairs stay bounded across runs.
+func (d *Database) RecordRepairAttempt(id int64) error {
+	query := `
+	UPDATE file_entries
+	SET repair_attempts = repair_attempts + 1, updated_at = ?
+	WHERE id = ?`
+
+	_, err := d.db.Exec(query, time.Now(), id)
+	return err
+}
+
+func (d *Database) GetCorruptFiles(projectName string) ([]*FileEntry, error) {
+	query := `
+	SELECT ` + fileEntryColumns + `
+	FROM file_entries
+	WHERE project_name = ? AND status = ?
+	ORDER BY path ASC`
+
+	rows, err := d.db.Query(query, projectName, StatusCorrupt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get corrupt files: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*FileEntry
+	for rows.Next() {
+		entry, err := scanFileEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (d *Database) GetVerifyCounts(projectName string) ([]VerifyCount, error) {
+	query := `
+	SELECT verify_status, COUNT(*) as count, SUM(size) as total_size
+	FROM file_entries
+	WHERE project_name = ? AND verify_status != ?
+	GROUP BY verify_status
+	ORDER BY verify_status`
+
+	rows, err := d.db.Query(query, projectName, VerifyNone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get verify counts: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []VerifyCount
+	for rows.Next() {
+		var count VerifyCount
+		var status string
+		if err := rows.Scan(&status, &count.Count, &count.Size); err != nil {
+			return nil, fmt.Errorf("failed to scan verify count: %w", err)
+		}
+		count.Status = VerifyStatus(status)
+		counts = append(counts, count)
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,88 @@
+// Package gcs connects to Google Cloud Storage destinations. Buckets are
+// accessed through the S3-compatible XML API with OAuth 2.0 access tokens of
+// a service account, so the transfers themselves are shared with MinIO
+// destinations.
+package gcs
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+	miniogo "github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const defaultEndpoint = "storage.googleapis.com"
+
+// credentialsEnv names the key file used when the project doesn't set one,
+// like the Google Cloud client libraries do
+const credentialsEnv = "GOOGLE_APPLICATION_CREDENTIALS"
+
+// NewClient creates a client for a Google Cloud Storage bucket
+func NewClient(cfg *config.GCSConfig) (*minio.MinioClient, error) {
+	credentialsFile := cfg.CredentialsFile
+	if credentialsFile == "" {
+		credentialsFile = os.Getenv(credentialsEnv)
+	}
+	if credentialsFile == "" {
+		return nil, fmt.Errorf("no service account credentials file configured and %s is not set", credentialsEnv)
+	}
+	tokens, err := newTokenSource(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// An http:// endpoint is accepted for emulators
+	endpoint, secure := cfg.Endpoint, true
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	} else if strings.HasPrefix(endpoint, "http://") {
+		endpoint, secure = strings.TrimPrefix(endpoint, "http://"), false
+	} else {
+		endpoint = strings.TrimPrefix(endpoint, "https://")
+	}
+
+	transport, err := miniogo.DefaultTransport(secure)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcs transport: %w", err)
+	}
+	latency := minio.NewLatencyTransport(&bearerTransport{tokens: tokens, base: transport})
+
+	// Requests are left unsigned and authorized with the access token instead
+	client, err := miniogo.New(endpoint, &miniogo.Options{
+		Creds:        credentials.NewStatic("", "", "", credentials.SignatureAnonymous),
+		Secure:       secure,
+		Region:       "auto",
+		BucketLookup: miniogo.BucketLookupPath,
+		Transport:    minio.NewRetryAfterTransport(latency),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcs client: %w", err)
+	}
+
+	wrapped := minio.WrapClient(client, latency, endpoint, cfg.BucketName, cfg.FolderPath)
+	// Cloud Storage encrypts objects by its own default encryption settings
+	wrapped.SkipBucketEncryption()
+	return wrapped, nil
+}
+
+// bearerTransport adds the current access token to every request
+type bearerTransport struct {
+	tokens *tokenSource
+	base   http.RoundTripper
+}
+
+func (b *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := b.tokens.Token()
+	if err != nil {
+		return nil, err
+	}
+	// RoundTrippers must not modify the request they were given
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return b.base.RoundTrip(req)
+}
@@ -0,0 +1,163 @@
+// Package keys encodes object keys that can't be stored as they are.
+//
+// Object keys may contain newlines, other control characters and bytes that
+// aren't valid UTF-8, which corrupt line based lists, terminals and file
+// names. Encode escapes those bytes as %XX, like URLs do; keys without them
+// are returned unchanged, so databases and local copies of ordinary keys look
+// the same as before. A literal "%" is only escaped when it is followed by
+// two hex digits that Decode would read as an escape, which keeps the
+// encoding reversible.
+package keys
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const hexDigits = "0123456789ABCDEF"
+
+// escaped reports whether Decode turns %XX with the value b into a byte
+func escaped(b byte) bool {
+	return b < 0x20 || b == 0x7f || b == '%' || b >= utf8.RuneSelf
+}
+
+// escapeAt reports whether s starts with %XX that Decode would unescape, and
+// returns its value
+func escapeAt(s string) (byte, bool) {
+	if len(s) < 3 || s[0] != '%' {
+		return 0, false
+	}
+	hi, ok1 := unhex(s[1])
+	lo, ok2 := unhex(s[2])
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	b := hi<<4 | lo
+	return b, escaped(b)
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// unsafeAt returns the length of the byte sequence at the start of s and
+// whether it must be escaped
+func unsafeAt(s string) (int, bool) {
+	c := s[0]
+	if c < utf8.RuneSelf {
+		if c == '%' {
+			_, ok := escapeAt(s)
+			return 1, ok
+		}
+		return 1, c < 0x20 || c == 0x7f
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	return size, r == utf8.RuneError && size == 1
+}
+
+// Encode returns key with control characters, DEL and bytes that aren't
+// part of valid UTF-8 escaped as %XX
+func Encode(key string) string {
+	for i := 0; i < len(key); {
+		size, unsafe := unsafeAt(key[i:])
+		if unsafe {
+			return encodeFrom(key, i)
+		}
+		i += size
+	}
+	return key
+}
+
+func encodeFrom(key string, start int) string {
+	var b strings.Builder
+	b.Grow(len(key) + 8)
+	b.WriteString(key[:start])
+	for i := start; i < len(key); {
+		size, unsafe := unsafeAt(key[i:])
+		if unsafe {
+			// Multi-byte sequences are never unsafe, size is 1
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[key[i]>>4])
+			b.WriteByte(hexDigits[key[i]&0x0f])
+		} else {
+			b.WriteString(key[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// Decode returns the key that Encode encoded as encoded
+func Decode(encoded string) string {
+	start := strings.IndexByte(encoded, '%')
+	if start < 0 {
+		return encoded
+	}
+	var b strings.Builder
+	b.Grow(len(encoded))
+	b.WriteString(encoded[:start])
+	for i := start; i < len(encoded); {
+		if c, ok := escapeAt(encoded[i:]); ok {
+			b.WriteByte(c)
+			i += 3
+			continue
+		}
+		b.WriteByte(encoded[i])
+		i++
+	}
+	return b.String()
+}
+
+// EncodePath encodes key for use as a relative file path, like Encode. It
+// also replaces the segments between slashes that would otherwise be
+// collapsed or leave the directory: empty ones, "." and "..".
+func EncodePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		switch segment {
+		case "":
+			segments[i] = "%"
+		case ".":
+			segments[i] = "%2E"
+		case "..":
+			segments[i] = "%2E%2E"
+		default:
+			segment = Encode(segment)
+			if segment == "%" || segment == "%2E" || segment == "%2E%2E" {
+				// Literal replacements keep their "%" escaped
+				segment = "%25" + segment[1:]
+			}
+			segments[i] = segment
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// DecodePath returns the key that EncodePath encoded as path
+func DecodePath(path string) string {
+	if !strings.Contains(path, "%") {
+		return path
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		switch segment {
+		case "%":
+			segments[i] = ""
+		case "%2E":
+			segments[i] = "."
+		case "%2E%2E":
+			segments[i] = ".."
+		default:
+			segments[i] = Decode(segment)
+		}
+	}
+	return strings.Join(segments, "/")
+}
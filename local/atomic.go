@@ -0,0 +1,108 @@
+package local
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// partSuffix is appended to the names of files while they are written. They
+// only get their own name once complete, so a crash never leaves a truncated
+// file that looks like a finished copy.
+const partSuffix = ".msc.part"
+
+// legacyPartialSuffix was used for compressed files by earlier versions
+const legacyPartialSuffix = ".partial"
+
+// orphanedPartAge is how long an unfinished file has not been written to
+// before it is removed as left behind by a crash. Younger ones may still be
+// written by another process using the same directory.
+const orphanedPartAge = time.Hour
+
+// commitFile makes the complete file written to target durable and moves it
+// to fullPath. The file is synced and closed, and the directory is synced
+// after the rename, so the new name survives a crash too. target is removed
+// if the file can't be committed.
+func commitFile(file *os.File, target, fullPath string) error {
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(target)
+		return fmt.Errorf("failed to sync file %s: %w", target, err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(target)
+		return fmt.Errorf("failed to write file %s: %w", fullPath, err)
+	}
+	if err := os.Rename(target, fullPath); err != nil {
+		os.Remove(target)
+		return fmt.Errorf("failed to rename %s to %s: %w", target, fullPath, err)
+	}
+	return syncDir(filepath.Dir(fullPath))
+}
+
+// syncDir makes the entries of a directory durable. Windows can't sync
+// directories, and renames are durable there once they returned.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory %s: %w", dir, err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// partPath is the unfinished file a source object is written to
+func (s *Storage) partPath(sourcePath string) string {
+	return s.storedPath(sourcePath) + partSuffix
+}
+
+// isPart reports whether a stored file is an unfinished one
+func (s *Storage) isPart(fullPath string) bool {
+	return strings.HasSuffix(fullPath, partSuffix) ||
+		(s.compression != nil && strings.HasSuffix(fullPath, legacyPartialSuffix))
+}
+
+// RemoveOrphanedParts removes the unfinished files left behind by crashed
+// runs, except those of the source paths in keep, which are resumed later.
+// It returns how many files it removed.
+func (s *Storage) RemoveOrphanedParts(keep []string) (int, error) {
+	kept := make(map[string]bool, len(keep))
+	for _, sourcePath := range keep {
+		kept[s.partPath(sourcePath)] = true
+	}
+	cutoff := time.Now().Add(-orphanedPartAge)
+	removed := 0
+	err := filepath.WalkDir(s.basePath, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && entry.Name() == PackDir && filepath.Dir(fullPath) == s.basePath {
+			return filepath.SkipDir
+		}
+		if !entry.Type().IsRegular() || !s.isPart(fullPath) || kept[fullPath] {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to remove unfinished file %s: %v", fullPath, err)
+			return nil
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,104 @@
+package local
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileAttributes are the permissions and owner given to saved files. Files
+// also get the modification time of their source object.
+type fileAttributes struct {
+	// mode is zero to keep the permissions files are created with
+	mode fs.FileMode
+	// uid and gid are -1 to keep the owner and group of the process
+	uid, gid int
+}
+
+// newFileAttributes parses the file mode, an octal number like "0640", and
+// the owner, "user:group" with names or numeric IDs where either part may
+// be left out
+func newFileAttributes(mode, owner string) (fileAttributes, error) {
+	attrs := fileAttributes{uid: -1, gid: -1}
+	if mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || perm > 0o777 {
+			return attrs, fmt.Errorf("invalid file mode %q, expected permissions like 0640", mode)
+		}
+		attrs.mode = fs.FileMode(perm)
+	}
+	if owner == "" {
+		return attrs, nil
+	}
+	if runtime.GOOS == "windows" {
+		return attrs, fmt.Errorf("the owner of saved files can't be set on Windows")
+	}
+
+	userName, groupName, _ := strings.Cut(owner, ":")
+	if userName != "" {
+		uid, err := lookupID(userName, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return attrs, fmt.Errorf("invalid owner %q: %w", owner, err)
+		}
+		attrs.uid = uid
+	}
+	if groupName != "" {
+		gid, err := lookupID(groupName, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return attrs, fmt.Errorf("invalid owner %q: %w", owner, err)
+		}
+		attrs.gid = gid
+	}
+	return attrs, nil
+}
+
+// lookupID returns a numeric ID as is and looks up names
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// apply gives the file written to target the configured permissions and
+// owner, and modTime as its modification time unless it is zero. It is
+// called once the file is completely written, before it is committed.
+func (a fileAttributes) apply(file *os.File, target string, modTime time.Time) error {
+	if a.mode != 0 {
+		if err := file.Chmod(a.mode); err != nil {
+			return fmt.Errorf("failed to set permissions of %s: %w", target, err)
+		}
+	}
+	if a.uid != -1 || a.gid != -1 {
+		if err := file.Chown(a.uid, a.gid); err != nil {
+			return fmt.Errorf("failed to set owner of %s: %w", target, err)
+		}
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(target, modTime, modTime); err != nil {
+			return fmt.Errorf("failed to set modification time of %s: %w", target, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package local
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PartFile is the unfinished file of a source object whose content is
+// written in chunks at their offsets, possibly concurrently
+type PartFile struct {
+	storage  *Storage
+	file     *os.File
+	target   string
+	fullPath string
+}
+
+// CreatePart creates the unfinished file of a source object of size bytes,
+// replacing any previous one. Only storage that keeps files as they are can
+// be written in chunks.
+func (s *Storage) CreatePart(sourcePath string, size int64) (*PartFile, error) {
+	if !s.Resumable() {
+		return nil, fmt.Errorf("encrypted or compressed files can't be written in chunks")
+	}
+	fullPath := s.storedPath(sourcePath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", filepath.Dir(fullPath), err)
+	}
+	target := s.partPath(sourcePath)
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file %s: %w", target, err)
+	}
+	// The file gets its final size up front, chunks fill it in any order
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		os.Remove(target)
+		return nil, fmt.Errorf("failed to allocate file %s: %w", target, err)
+	}
+	log.Printf("Debug: Saving file in chunks to: %s", fullPath)
+	return &PartFile{storage: s, file: file, target: target, fullPath: fullPath}, nil
+}
+
+// Writer returns a writer for the chunk starting at offset. Writers of
+// different chunks may be used concurrently.
+func (p *PartFile) Writer(offset int64) io.Writer {
+	return io.NewOffsetWriter(p.file, offset)
+}
+
+// Commit gives the complete file its name, with modTime as its modification
+// time unless it is zero
+func (p *PartFile) Commit(modTime time.Time) error {
+	if err := p.storage.attributes.apply(p.file, p.target, modTime); err != nil {
+		p.file.Close()
+		os.Remove(p.target)
+		return err
+	}
+	return commitFile(p.file, p.target, p.fullPath)
+}
+
+// Abort ends a failed save whose first written bytes are complete. They
+// are kept for ResumeFile and a PartialWriteError wrapping err is returned;
+// without any, the file is removed and err is returned as is.
+func (p *PartFile) Abort(written int64, err error) error {
+	if written > 0 {
+		if truncErr := p.file.Truncate(written); truncErr != nil {
+			written = 0
+		}
+	}
+	return p.storage.abortPart(p.file, p.target, written, err)
+}
@@ -0,0 +1,175 @@
+package local
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+)
+
+// ErrAppendCompressed is returned when appending to a compressed file
+var ErrAppendCompressed = errors.New("can't append to compressed files")
+
+// gzipSizeField identifies the subfield of the gzip header extra field
+// holding the size of the original content
+var gzipSizeField = [2]byte{'M', 'S'}
+
+// zstd files start with a skippable frame holding the size of the original
+// content, which decoders ignore. The frame content size of zstd itself is
+// left out for small files.
+const (
+	zstdSizeFrameMagic  = 0x184D2A5E
+	zstdSizeFrameLength = 16
+)
+
+// fileCompression compresses saved files in one format. The size of the
+// original content is kept at the start of every file, in a skippable frame
+// for zstd and an extra field for gzip, so existence checks don't have to
+// decompress them.
+type fileCompression struct {
+	format string
+	// extension is appended to the names of the saved files
+	extension string
+}
+
+// newFileCompression returns the compression of the format, or nil if the
+// files are stored as they are
+func newFileCompression(format string) (*fileCompression, error) {
+	switch format {
+	case "", config.CompressNone:
+		return nil, nil
+	case config.CompressGzip:
+		return &fileCompression{format: format, extension: ".gz"}, nil
+	case config.CompressZstd:
+		return &fileCompression{format: format, extension: ".zst"}, nil
+	default:
+		return nil, fmt.Errorf("invalid local compression %q, must be %s, %s or %s", format, config.CompressZstd, config.CompressGzip, config.CompressNone)
+	}
+}
+
+// compressReader returns the compressed content of reader, which must be
+// size bytes. Reading it fails if the content has a different size.
+func (c *fileCompression) compressReader(reader io.Reader, size int64, name string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(c.compress(pw, reader, size, name))
+	}()
+	return pr
+}
+
+func (c *fileCompression) compress(w io.Writer, reader io.Reader, size int64, name string) error {
+	var compressor io.WriteCloser
+	switch c.format {
+	case config.CompressGzip:
+		extra := make([]byte, 12)
+		copy(extra, gzipSizeField[:])
+		binary.LittleEndian.PutUint16(extra[2:], 8)
+		binary.LittleEndian.PutUint64(extra[4:], uint64(size))
+
+		writer := gzip.NewWriter(w)
+		writer.Header.Name = path.Base(name)
+		writer.Header.Extra = extra
+		compressor = writer
+	default:
+		frame := make([]byte, zstdSizeFrameLength)
+		binary.LittleEndian.PutUint32(frame, zstdSizeFrameMagic)
+		binary.LittleEndian.PutUint32(frame[4:], 8)
+		binary.LittleEndian.PutUint64(frame[8:], uint64(size))
+		if _, err := w.Write(frame); err != nil {
+			return err
+		}
+
+		writer, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return fmt.Errorf("failed to create compressor: %w", err)
+		}
+		writer.ResetContentSize(w, size)
+		compressor = writer
+	}
+
+	written, err := io.Copy(compressor, reader)
+	if err != nil {
+		compressor.Close()
+		return err
+	}
+	if written != size {
+		compressor.Close()
+		return fmt.Errorf("content is %d bytes, expected %d", written, size)
+	}
+	if err := compressor.Close(); err != nil {
+		return fmt.Errorf("failed to compress: %w", err)
+	}
+	return nil
+}
+
+// decompressReader returns the original content of the compressed file r
+func (c *fileCompression) decompressReader(r io.ReadCloser) (io.ReadCloser, error) {
+	switch c.format {
+	case config.CompressGzip:
+		reader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip header: %w", err)
+		}
+		return &decompressingReader{Reader: reader, closers: []io.Closer{reader, r}}, nil
+	default:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create decompressor: %w", err)
+		}
+		return &decompressingReader{Reader: decoder, closers: []io.Closer{decoder.IOReadCloser(), r}}, nil
+	}
+}
+
+type decompressingReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decompressingReader) Close() error {
+	var err error
+	for _, closer := range d.closers {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// originalSize returns the size of the original content recorded in the
+// header of the compressed file r
+func (c *fileCompression) originalSize(r io.Reader) (int64, error) {
+	switch c.format {
+	case config.CompressGzip:
+		reader, err := gzip.NewReader(r)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read gzip header: %w", err)
+		}
+		extra := reader.Header.Extra
+		for len(extra) >= 4 {
+			length := int(binary.LittleEndian.Uint16(extra[2:]))
+			if len(extra) < 4+length {
+				break
+			}
+			if [2]byte{extra[0], extra[1]} == gzipSizeField && length == 8 {
+				return int64(binary.LittleEndian.Uint64(extra[4:])), nil
+			}
+			extra = extra[4+length:]
+		}
+		return 0, fmt.Errorf("gzip header has no original size")
+	default:
+		frame := make([]byte, zstdSizeFrameLength)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return 0, fmt.Errorf("failed to read zstd size frame: %w", err)
+		}
+		if binary.LittleEndian.Uint32(frame) != zstdSizeFrameMagic || binary.LittleEndian.Uint32(frame[4:]) != 8 {
+			return 0, fmt.Errorf("zstd file has no size frame")
+		}
+		return int64(binary.LittleEndian.Uint64(frame[8:])), nil
+	}
+}
@@ -0,0 +1,321 @@
+package local
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+)
+
+const (
+	// SidecarSuffix is appended to the name of an encrypted file for the
+	// sidecar describing it
+	SidecarSuffix = ".msc-enc.json"
+
+	sidecarVersion  = 1
+	sidecarCipher   = "AES-256-GCM"
+	encryptionChunk = 64 << 10
+	// The nonce of a chunk is the random prefix of its file followed by the
+	// big-endian chunk number
+	noncePrefixSize = 8
+)
+
+// ErrAppendEncrypted is returned when appending to an encrypted file, whose
+// last chunk is already sealed
+var ErrAppendEncrypted = errors.New("can't append to encrypted files")
+
+// sidecar describes an encrypted file. Files are split into chunks of
+// ChunkSize bytes, each sealed with AES-256-GCM; the additional data of a
+// chunk is 1 for the last one and 0 for the others, so truncated files are
+// detected.
+type sidecar struct {
+	Version     int    `json:"version"`
+	Cipher      string `json:"cipher"`
+	ChunkSize   int    `json:"chunkSize"`
+	NoncePrefix []byte `json:"noncePrefix"`
+	// Size is the size of the decrypted content
+	Size int64 `json:"size"`
+	// KeyID identifies the key without revealing it: the start of its
+	// SHA-256 digest
+	KeyID string `json:"keyID"`
+}
+
+// fileEncryption seals the files of a storage with one key
+type fileEncryption struct {
+	aead  cipher.AEAD
+	keyID string
+}
+
+// newFileEncryption returns the encryption configured by cfg, or nil if cfg
+// is nil. It fails if the key is missing or invalid.
+func newFileEncryption(cfg *config.LocalEncryptionConfig) (*fileEncryption, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	encoded := cfg.Key
+	if cfg.KeyEnv != "" {
+		if encoded != "" {
+			return nil, fmt.Errorf("local encryption sets both key and keyEnv")
+		}
+		encoded = os.Getenv(cfg.KeyEnv)
+		if encoded == "" {
+			return nil, fmt.Errorf("environment variable %s with the local encryption key is not set", cfg.KeyEnv)
+		}
+	}
+	if encoded == "" {
+		return nil, fmt.Errorf("local encryption needs a key or keyEnv")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid local encryption key, must be base64 encoded: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid local encryption key, must be 32 bytes, not %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	digest := sha256.Sum256(key)
+	return &fileEncryption{aead: aead, keyID: hex.EncodeToString(digest[:8])}, nil
+}
+
+func (e *fileEncryption) nonce(prefix []byte, chunk uint32) []byte {
+	nonce := make([]byte, e.aead.NonceSize())
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], chunk)
+	return nonce
+}
+
+func additionalData(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encrypt writes the sealed content of reader to w and returns the sidecar
+// describing it
+func (e *fileEncryption) encrypt(w io.Writer, reader io.Reader) (*sidecar, error) {
+	info := &sidecar{
+		Version:     sidecarVersion,
+		Cipher:      sidecarCipher,
+		ChunkSize:   encryptionChunk,
+		NoncePrefix: make([]byte, noncePrefixSize),
+		KeyID:       e.keyID,
+	}
+	if _, err := rand.Read(info.NoncePrefix); err != nil {
+		return nil, fmt.Errorf("failed to create nonce: %w", err)
+	}
+
+	// A chunk is only sealed once the next one has been started, so the
+	// last one is known
+	buffered := bufio.NewReaderSize(reader, encryptionChunk)
+	plain := make([]byte, encryptionChunk)
+	sealed := make([]byte, 0, encryptionChunk+e.aead.Overhead())
+	for chunk := uint32(0); ; chunk++ {
+		n, err := io.ReadFull(buffered, plain)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		last := err != nil
+		if !last {
+			_, err := buffered.Peek(1)
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			last = err == io.EOF
+		}
+
+		sealed = e.aead.Seal(sealed[:0], e.nonce(info.NoncePrefix, chunk), plain[:n], additionalData(last))
+		if _, err := w.Write(sealed); err != nil {
+			return nil, err
+		}
+		info.Size += int64(n)
+		if last {
+			return info, nil
+		}
+		if chunk == ^uint32(0) {
+			return nil, fmt.Errorf("file too large to encrypt")
+		}
+	}
+}
+
+// decryptReader returns the decrypted content of the sealed file r
+func (e *fileEncryption) decryptReader(r io.ReadCloser, info *sidecar) (io.ReadCloser, error) {
+	if info.Version != sidecarVersion || info.Cipher != sidecarCipher || info.ChunkSize <= 0 || len(info.NoncePrefix) != noncePrefixSize {
+		return nil, fmt.Errorf("unsupported encryption %s version %d", info.Cipher, info.Version)
+	}
+	if info.KeyID != e.keyID {
+		return nil, fmt.Errorf("file was encrypted with key %s, not the configured key %s", info.KeyID, e.keyID)
+	}
+	return &decryptingReader{
+		enc:    e,
+		info:   info,
+		source: bufio.NewReaderSize(r, info.ChunkSize+e.aead.Overhead()),
+		closer: r,
+		sealed: make([]byte, info.ChunkSize+e.aead.Overhead()),
+	}, nil
+}
+
+type decryptingReader struct {
+	enc    *fileEncryption
+	info   *sidecar
+	source *bufio.Reader
+	closer io.Closer
+	sealed []byte
+	plain  []byte
+	chunk  uint32
+	done   bool
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk
+func (d *decryptingReader) next() error {
+	n, err := io.ReadFull(d.source, d.sealed)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return fmt.Errorf("encrypted file is truncated: %w", io.ErrUnexpectedEOF)
+		}
+		return err
+	}
+	last := err == io.ErrUnexpectedEOF
+	if !last {
+		if _, err := d.source.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+
+	plain, err := d.enc.aead.Open(d.sealed[:0], d.enc.nonce(d.info.NoncePrefix, d.chunk), d.sealed[:n], additionalData(last))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt chunk %d: %w", d.chunk, err)
+	}
+	d.plain, d.done = plain, last
+	d.chunk++
+	return nil
+}
+
+func (d *decryptingReader) Close() error {
+	return d.closer.Close()
+}
+
+// readSidecar returns the sidecar of the file at fullPath, or nil if there
+// is none
+func readSidecar(fullPath string) (*sidecar, error) {
+	data, err := os.ReadFile(fullPath + SidecarSuffix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption sidecar: %w", err)
+	}
+	var info sidecar
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse encryption sidecar %s: %w", fullPath+SidecarSuffix, err)
+	}
+	return &info, nil
+}
+
+func writeSidecar(fullPath string, info *sidecar) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode encryption sidecar: %w", err)
+	}
+	if err := os.WriteFile(fullPath+SidecarSuffix, data, 0644); err != nil {
+		return fmt.Errorf("failed to write encryption sidecar: %w", err)
+	}
+	return nil
+}
+
+// contentInfo reports the size of the original content of an encrypted or
+// compressed file
+type contentInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i contentInfo) Size() int64 { return i.size }
+
+// DecryptTo writes the decrypted content of the stored files whose source
+// path starts with prefix to outputDir, keeping their relative paths, and
+// returns how many it wrote
+func (s *Storage) DecryptTo(outputDir, prefix string) (int, error) {
+	if s.encryption == nil {
+		return 0, fmt.Errorf("files in %s are not encrypted", s.basePath)
+	}
+	absOutput, err := filepath.Abs(outputDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	if rel, err := filepath.Rel(s.basePath, absOutput); err == nil && !strings.HasPrefix(rel, "..") {
+		return 0, fmt.Errorf("output directory %s is inside the encrypted files in %s", absOutput, s.basePath)
+	}
+
+	files := 0
+	err = s.ListFiles(func(sourcePath string, size int64) error {
+		if !strings.HasPrefix(sourcePath, prefix) {
+			return nil
+		}
+		relative, err := filepath.Rel(s.basePath, s.destPath(sourcePath))
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(absOutput, relative)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(target), err)
+		}
+
+		reader, err := s.OpenFile(sourcePath)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		out, err := os.Create(target)
+		if err != nil {
+			return fmt.Errorf("failed to create file %s: %w", target, err)
+		}
+		if _, err := io.Copy(out, reader); err != nil {
+			out.Close()
+			os.Remove(target)
+			return fmt.Errorf("failed to decrypt %s: %w", sourcePath, err)
+		}
+		if err := out.Close(); err != nil {
+			return fmt.Errorf("failed to write file %s: %w", target, err)
+		}
+		files++
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,124 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// PartialWriteError is returned when saving a file failed after Written
+// bytes of its content were kept in the unfinished file, from which the
+// save can be resumed with ResumeFile
+type PartialWriteError struct {
+	Written int64
+	Err     error
+}
+
+func (e *PartialWriteError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PartialWriteError) Unwrap() error {
+	return e.Err
+}
+
+// PartialWritten returns how many bytes a failed save kept, or zero if
+// nothing can be resumed
+func PartialWritten(err error) int64 {
+	var partial *PartialWriteError
+	if errors.As(err, &partial) {
+		return partial.Written
+	}
+	return 0
+}
+
+// Resumable reports whether interrupted saves keep what they wrote. Files
+// are written as they are unless they are encrypted or compressed, which
+// are written as a whole.
+func (s *Storage) Resumable() bool {
+	return s.encryption == nil && s.compression == nil
+}
+
+// PartialSize returns the size of the unfinished file of a source object,
+// or zero if there is none
+func (s *Storage) PartialSize(sourcePath string) (int64, error) {
+	info, err := os.Stat(s.partPath(sourcePath))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// DeletePart removes the unfinished file of a source object, if any
+func (s *Storage) DeletePart(sourcePath string) error {
+	target := s.partPath(sourcePath)
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete unfinished file %s: %w", target, err)
+	}
+	return nil
+}
+
+// abortPart handles a failed write of the unfinished file target after
+// written bytes. Resumable storage keeps them for ResumeFile and returns
+// a PartialWriteError; otherwise the file is removed.
+func (s *Storage) abortPart(file *os.File, target string, written int64, err error) error {
+	if !s.Resumable() || written == 0 || file.Sync() != nil {
+		file.Close()
+		os.Remove(target)
+		return err
+	}
+	file.Close()
+	return &PartialWriteError{Written: written, Err: err}
+}
+
+// ResumeFile continues an interrupted save of a source object whose first
+// offset bytes are in its unfinished file, appending the rest of the
+// content from reader. Anything in the file after offset is discarded. The
+// completed file gets modTime as its modification time, unless it is zero.
+func (s *Storage) ResumeFile(ctx context.Context, sourcePath string, reader io.Reader, offset int64, modTime time.Time) error {
+	if !s.Resumable() {
+		return fmt.Errorf("saves of encrypted or compressed files can't be resumed")
+	}
+	fullPath := s.storedPath(sourcePath)
+	target := s.partPath(sourcePath)
+	log.Printf("Debug: Resuming file %s at offset %d", fullPath, offset)
+
+	file, err := os.OpenFile(target, os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", target, err)
+	}
+	defer file.Close()
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		os.Remove(target)
+		return fmt.Errorf("failed to truncate file %s: %w", target, err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		os.Remove(target)
+		return fmt.Errorf("failed to seek in file %s: %w", target, err)
+	}
+
+	written, err := io.Copy(file, reader)
+	if err != nil {
+		return s.abortPart(file, target, offset+written, fmt.Errorf("failed to write file %s: %w", fullPath, err))
+	}
+	if err := s.attributes.apply(file, target, modTime); err != nil {
+		file.Close()
+		os.Remove(target)
+		return err
+	}
+	if err := commitFile(file, target, fullPath); err != nil {
+		return err
+	}
+
+	log.Printf("Debug: Successfully appended %d bytes to %s at offset %d", written, fullPath, offset)
+	return nil
+}
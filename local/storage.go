@@ -2,19 +2,35 @@ package local
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
 )
 
+// PackDir holds the archives of packed files below the base path. It is
+// never listed as stored files.
+const PackDir = ".msc-packs"
+
 type Storage struct {
 	basePath   string
 	folderPath string // Source folder path from config
+	// encryption seals saved files, nil if they are stored as they are
+	encryption *fileEncryption
+	// compression compresses saved files before they are sealed, nil if
+	// they are stored as they are
+	compression *fileCompression
+	// attributes are the permissions and owner of saved files
+	attributes fileAttributes
 }
 
 func convertToWSLPath(windowsPath string) string {
@@ -35,6 +51,22 @@ func convertToWSLPath(windowsPath string) string {
 }
 
 func NewStorage(cfg *config.LocalConfig, sourceFolderPath string) (*Storage, error) {
+	encryption, err := newFileEncryption(cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	compression, err := newFileCompression(cfg.Compress)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Pack != nil && (encryption != nil || compression != nil) {
+		return nil, fmt.Errorf("packing small files can't be combined with local encryption or compression")
+	}
+	attributes, err := newFileAttributes(cfg.FileMode, cfg.Owner)
+	if err != nil {
+		return nil, err
+	}
+
 	// Convert relative path to absolute
 	absPath, err := filepath.Abs(cfg.Path)
 	if err != nil {
@@ -54,15 +86,62 @@ func NewStorage(cfg *config.LocalConfig, sourceFolderPath string) (*Storage, err
 		return nil, fmt.Errorf("failed to create base directory: %w", err)
 	}
 
+	if encryption != nil {
+		log.Printf("Files saved to %s are encrypted with key %s", absPath, encryption.keyID)
+	}
+	if compression != nil {
+		log.Printf("Files saved to %s are compressed with %s", absPath, compression.format)
+	}
+
 	return &Storage{
-		basePath:   absPath,
-		folderPath: sourceFolderPath,
+		basePath:    absPath,
+		folderPath:  sourceFolderPath,
+		encryption:  encryption,
+		compression: compression,
+		attributes:  attributes,
 	}, nil
 }
 
-// SaveFile saves a file to the local storage
-func (s *Storage) SaveFile(ctx context.Context, sourcePath string, reader io.Reader) error {
-	// The sourcePath now includes the full path including folder structure
+// Encrypted reports whether saved files are encrypted
+func (s *Storage) Encrypted() bool {
+	return s.encryption != nil
+}
+
+// Compressed reports whether saved files are compressed
+func (s *Storage) Compressed() bool {
+	return s.compression != nil
+}
+
+// PackPath returns the path of a pack archive
+func (s *Storage) PackPath(archive string) string {
+	return filepath.Join(s.basePath, PackDir, archive)
+}
+
+// WritePlainFile replaces the file at name, relative to the base path, with
+// data as is, without compression or encryption, e.g. for files that
+// describe the storage to other tools
+func (s *Storage) WritePlainFile(name string, data []byte) error {
+	fullPath := filepath.Join(s.basePath, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(fullPath), err)
+	}
+	// Readers never see a partly written file
+	tmpPath := fullPath + partSuffix
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", tmpPath, err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write file %s: %w", tmpPath, err)
+	}
+	return commitFile(file, tmpPath, fullPath)
+}
+
+// destPath maps a source object path to its location in the local storage
+func (s *Storage) destPath(sourcePath string) string {
+	// The sourcePath includes the full path including folder structure
 	// We need to maintain the same structure in the destination
 	relativePath := sourcePath
 	if s.folderPath != "" {
@@ -70,8 +149,25 @@ func (s *Storage) SaveFile(ctx context.Context, sourcePath string, reader io.Rea
 		relativePath = strings.TrimPrefix(sourcePath, s.folderPath+"/")
 	}
 
-	// Create the full destination path preserving folder structure
-	fullPath := filepath.Join(s.basePath, relativePath)
+	// Create the full destination path preserving folder structure. Keys
+	// with control characters, invalid UTF-8 or segments like ".." are
+	// encoded so they cannot corrupt or escape the path.
+	return filepath.Join(s.basePath, filepath.FromSlash(keys.EncodePath(relativePath)))
+}
+
+// storedPath is the file a source object is saved in, which has the
+// extension of the compression format if files are compressed
+func (s *Storage) storedPath(sourcePath string) string {
+	if s.compression != nil {
+		return s.destPath(sourcePath) + s.compression.extension
+	}
+	return s.destPath(sourcePath)
+}
+
+// SaveFile saves a file of size bytes to the local storage. The file gets
+// modTime as its modification time, unless it is zero.
+func (s *Storage) SaveFile(ctx context.Context, sourcePath string, reader io.Reader, size int64, modTime time.Time) error {
+	fullPath := s.storedPath(sourcePath)
 	log.Printf("Debug: Saving file to: %s", fullPath)
 
 	// Create all parent directories with full permissions first
@@ -81,19 +177,56 @@ func (s *Storage) SaveFile(ctx context.Context, sourcePath string, reader io.Rea
 		return fmt.Errorf("failed to create directory %s: %w", dir, err)
 	}
 
+	// Files are written under a temporary name and only get their own once
+	// complete, so a crash never leaves a truncated file behind
+	target := s.partPath(sourcePath)
+
 	// Create file with explicit permissions
-	file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
-		log.Printf("Debug: Failed to create file: %v (path: %s)", err, fullPath)
-		return fmt.Errorf("failed to create file %s: %w", fullPath, err)
+		log.Printf("Debug: Failed to create file: %v (path: %s)", err, target)
+		return fmt.Errorf("failed to create file %s: %w", target, err)
 	}
 	defer file.Close()
 
-	// Copy data
-	written, err := io.Copy(file, reader)
+	if s.compression != nil {
+		compressed := s.compression.compressReader(reader, size, sourcePath)
+		defer compressed.Close()
+		reader = compressed
+	}
+
+	// Encrypted files are followed by their sidecar; a file whose sidecar
+	// is missing or stale counts as not saved
+	var written int64
+	var sealed *sidecar
+	if s.encryption != nil {
+		if err := os.Remove(fullPath + SidecarSuffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove encryption sidecar of %s: %w", fullPath, err)
+		}
+		if sealed, err = s.encryption.encrypt(file, reader); err == nil {
+			written = sealed.Size
+		}
+	} else {
+		// Copy data
+		written, err = io.Copy(file, reader)
+	}
 	if err != nil {
 		log.Printf("Debug: Failed to write data: %v", err)
-		return fmt.Errorf("failed to write file %s: %w", fullPath, err)
+		return s.abortPart(file, target, written, fmt.Errorf("failed to write file %s: %w", fullPath, err))
+	}
+	if err := s.attributes.apply(file, target, modTime); err != nil {
+		file.Close()
+		os.Remove(target)
+		return err
+	}
+
+	if err := commitFile(file, target, fullPath); err != nil {
+		return err
+	}
+	if sealed != nil {
+		if err := writeSidecar(fullPath, sealed); err != nil {
+			return err
+		}
 	}
 
 	log.Printf("Debug: Successfully wrote %d bytes to %s", written, fullPath)
@@ -101,13 +234,279 @@ func (s *Storage) SaveFile(ctx context.Context, sourcePath string, reader io.Rea
 }
 
 func (s *Storage) FileExists(objectPath string) (bool, error) {
-	fullPath := filepath.Join(s.basePath, filepath.FromSlash(objectPath))
-	_, err := os.Stat(fullPath)
-	if err == nil {
-		return true, nil
+	info, err := s.StatFile(objectPath)
+	if err != nil {
+		return false, err
 	}
+	return info != nil, nil
+}
+
+// StatFile returns the file info of a stored source object, or nil if it
+// has not been saved
+func (s *Storage) StatFile(sourcePath string) (os.FileInfo, error) {
+	fullPath := s.storedPath(sourcePath)
+	info, err := os.Stat(fullPath)
 	if os.IsNotExist(err) {
-		return false, nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.contentInfo(fullPath, info)
+}
+
+// contentInfo returns info with the size of the original content of the
+// stored file at fullPath, or nil if the file is incomplete
+func (s *Storage) contentInfo(fullPath string, info os.FileInfo) (os.FileInfo, error) {
+	if s.encryption == nil && s.compression == nil {
+		return info, nil
 	}
-	return false, err
+
+	// Encrypted files have the size of their decrypted content
+	size := info.Size()
+	if s.encryption != nil {
+		encrypted, err := readSidecar(fullPath)
+		if err != nil || encrypted == nil {
+			return nil, err
+		}
+		size = encrypted.Size
+	}
+
+	// Compressed files have the size recorded in their header
+	if s.compression != nil {
+		stored, err := s.openStored(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		defer stored.Close()
+		if size, err = s.compression.originalSize(stored); err != nil {
+			return nil, fmt.Errorf("failed to read size of %s: %w", fullPath, err)
+		}
+	}
+	return contentInfo{FileInfo: info, size: size}, nil
+}
+
+// StoredSize returns the size a stored source object takes on disk
+func (s *Storage) StoredSize(sourcePath string) (int64, error) {
+	info, err := os.Stat(s.storedPath(sourcePath))
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// OpenFile opens a stored file for reading, decrypting and decompressing it
+// if needed
+func (s *Storage) OpenFile(sourcePath string) (io.ReadCloser, error) {
+	fullPath := s.storedPath(sourcePath)
+	stored, err := s.openStored(fullPath)
+	if err != nil || s.compression == nil {
+		return stored, err
+	}
+	reader, err := s.compression.decompressReader(stored)
+	if err != nil {
+		stored.Close()
+		return nil, fmt.Errorf("failed to decompress %s: %w", fullPath, err)
+	}
+	return reader, nil
+}
+
+// openStored opens the stored file at fullPath, decrypting it if needed
+func (s *Storage) openStored(fullPath string) (io.ReadCloser, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	if s.encryption == nil {
+		return file, nil
+	}
+
+	info, err := readSidecar(fullPath)
+	if err == nil && info == nil {
+		err = fmt.Errorf("encryption sidecar of %s is missing", fullPath)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	reader, err := s.encryption.decryptReader(file, info)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to decrypt %s: %w", fullPath, err)
+	}
+	return reader, nil
+}
+
+// MoveFile moves a saved file to the destination path of another source path
+func (s *Storage) MoveFile(fromSourcePath, toSourcePath string) error {
+	from := s.storedPath(fromSourcePath)
+	to := s.storedPath(toSourcePath)
+
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(to), err)
+	}
+	if err := os.Rename(from, to); err != nil {
+		return fmt.Errorf("failed to move file %s to %s: %w", from, to, err)
+	}
+	if s.encryption != nil {
+		if err := os.Rename(from+SidecarSuffix, to+SidecarSuffix); err != nil {
+			return fmt.Errorf("failed to move encryption sidecar of %s: %w", from, err)
+		}
+	}
+	return nil
+}
+
+// ReadRange returns length bytes of a stored file starting at offset
+func (s *Storage) ReadRange(sourcePath string, offset, length int64) ([]byte, error) {
+	if s.encryption != nil || s.compression != nil {
+		reader, err := s.OpenFile(sourcePath)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		buf := make([]byte, length)
+		if _, err := io.CopyN(io.Discard, reader, offset); err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		return buf, nil
+	}
+
+	file, err := os.Open(s.storedPath(sourcePath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	buf := make([]byte, length)
+	if _, err := file.ReadAt(buf, offset); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return buf, nil
+}
+
+// HashFile returns the hex encoded MD5 checksum of the content of a stored
+// file
+func (s *Storage) HashFile(sourcePath string) (string, error) {
+	file, err := s.OpenFile(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// AppendFile appends the content of reader to a stored file, which then
+// gets modTime as its modification time unless it is zero
+func (s *Storage) AppendFile(ctx context.Context, sourcePath string, reader io.Reader, modTime time.Time) error {
+	if s.encryption != nil {
+		return ErrAppendEncrypted
+	}
+	if s.compression != nil {
+		return ErrAppendCompressed
+	}
+	fullPath := s.destPath(sourcePath)
+	log.Printf("Debug: Appending to file: %s", fullPath)
+
+	file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", fullPath, err)
+	}
+	defer file.Close()
+
+	written, err := io.Copy(file, reader)
+	if err != nil {
+		return fmt.Errorf("failed to append to file %s: %w", fullPath, err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file %s: %w", fullPath, err)
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(fullPath, modTime, modTime); err != nil {
+			return fmt.Errorf("failed to set modification time of %s: %w", fullPath, err)
+		}
+	}
+
+	log.Printf("Debug: Successfully appended %d bytes to %s", written, fullPath)
+	return nil
+}
+
+// ListFiles calls fn with the source path and size of every stored file.
+// Encrypted and compressed files are listed with the size of their content;
+// encrypted files without a sidecar were not completely saved and are left
+// out, as are files without the extension of the compression format.
+func (s *Storage) ListFiles(fn func(sourcePath string, size int64) error) error {
+	return filepath.WalkDir(s.basePath, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && entry.Name() == PackDir && filepath.Dir(fullPath) == s.basePath {
+			return filepath.SkipDir
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		if s.encryption != nil && strings.HasSuffix(fullPath, SidecarSuffix) {
+			return nil
+		}
+		if s.isPart(fullPath) {
+			return nil
+		}
+		if s.compression != nil && !strings.HasSuffix(fullPath, s.compression.extension) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		info, err = s.contentInfo(fullPath, info)
+		if err != nil || info == nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(s.basePath, fullPath)
+		if err != nil {
+			return err
+		}
+		sourcePath := filepath.ToSlash(relativePath)
+		if s.compression != nil {
+			sourcePath = strings.TrimSuffix(sourcePath, s.compression.extension)
+		}
+		sourcePath = keys.DecodePath(sourcePath)
+		if s.folderPath != "" {
+			sourcePath = s.folderPath + "/" + sourcePath
+		}
+		return fn(sourcePath, info.Size())
+	})
+}
+
+// DeleteFile removes a stored file and the directories left empty by it
+func (s *Storage) DeleteFile(sourcePath string) error {
+	fullPath := s.storedPath(sourcePath)
+	log.Printf("Debug: Deleting file: %s", fullPath)
+
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file %s: %w", fullPath, err)
+	}
+	if s.encryption != nil {
+		if err := os.Remove(fullPath + SidecarSuffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete encryption sidecar of %s: %w", fullPath, err)
+		}
+	}
+
+	for dir := filepath.Dir(fullPath); dir != s.basePath && strings.HasPrefix(dir, s.basePath); dir = filepath.Dir(dir) {
+		// Fails once a directory is not empty
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+	return nil
 }
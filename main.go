@@ -2,20 +2,43 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/local"
 	"github.com/chmdznr/minio-simple-copier/v2/sync"
 )
 
 const projectsDir = "projects"
 
+// exitAlertAbort is the exit code of a sync aborted by alert thresholds
+const exitAlertAbort = 3
+
+// exitSyncErrors is the exit code of a sync that finished with failed files
+const exitSyncErrors = 1
+
+// patternList collects the values of a flag that can be given more than once
+type patternList []string
+
+func (l *patternList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *patternList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func formatSize(size int64) string {
 	const unit = 1024
 	if size < unit {
@@ -37,7 +60,7 @@ func printStatus(status *sync.SyncStatus) {
 	for _, count := range status.Counts {
 		totalFiles += count.Count
 		totalSize += count.Size
-		fmt.Printf("%-10s: %5d files (%s)\n",
+		fmt.Printf("%-16s: %5d files (%s)\n",
 			count.Status,
 			count.Count,
 			formatSize(count.Size),
@@ -46,6 +69,136 @@ func printStatus(status *sync.SyncStatus) {
 
 	fmt.Printf("\nTotal: %d files (%s)\n", totalFiles, formatSize(totalSize))
 
+	if accounting := status.Accounting; accounting.LastListing != nil {
+		fmt.Printf("Last listing (%s, %s): %d files (%s)\n",
+			accounting.LastListing.Source,
+			accounting.LastListing.FinishedAt.Format(time.RFC3339),
+			accounting.LastListing.ObjectCount,
+			formatSize(accounting.LastListing.TotalSize),
+		)
+		if problems := accounting.Problems(); len(problems) > 0 {
+			fmt.Println("\n!!! ACCOUNTING MISMATCH !!!")
+			for _, problem := range problems {
+				fmt.Printf("!!! %s\n", problem)
+			}
+		}
+	}
+
+	if len(status.Aging) > 0 {
+		fmt.Println("\nBacklog Age:")
+		fmt.Println("------------")
+		for _, count := range status.Aging {
+			fmt.Printf("%-16s %-7s: %5d files (%s)\n",
+				count.Status,
+				count.Bracket,
+				count.Count,
+				formatSize(count.Size),
+			)
+		}
+		fmt.Println("Pending files age from their last status change, failed files from their first failure")
+	}
+
+	if len(status.SkipReasons) > 0 {
+		fmt.Println("\nSkipped Files:")
+		fmt.Println("--------------")
+		for _, reason := range status.SkipReasons {
+			fmt.Printf("%-16s: %5d files (%s) - %s\n",
+				reason.Status,
+				reason.Count,
+				formatSize(reason.Size),
+				reason.Reason,
+			)
+		}
+	}
+
+	if len(status.VerifyCounts) > 0 {
+		fmt.Println("\nVerification:")
+		fmt.Println("-------------")
+		for _, count := range status.VerifyCounts {
+			fmt.Printf("%-16s: %5d files (%s)\n",
+				count.Status,
+				count.Count,
+				formatSize(count.Size),
+			)
+		}
+	}
+
+	if len(status.DestinationCounts) > 0 {
+		fmt.Println("\nAdditional Destinations:")
+		fmt.Println("------------------------")
+		for _, count := range status.DestinationCounts {
+			fmt.Printf("%-16s %-16s: %5d files (%s)\n",
+				count.Destination,
+				count.Status,
+				count.Count,
+				formatSize(count.Size),
+			)
+		}
+	}
+
+	if len(status.ChangeCounts) > 0 {
+		fmt.Println("\nSource Changes After Copy:")
+		fmt.Println("--------------------------")
+		for _, count := range status.ChangeCounts {
+			fmt.Printf("%-16s: %5d changes\n", count.Policy, count.Count)
+		}
+		if status.Drifted > 0 {
+			fmt.Printf("%d kept copies differ from their source (drift)\n", status.Drifted)
+		}
+	}
+
+	if len(status.StorageClasses) > 0 {
+		fmt.Println("\nSource Storage Classes:")
+		fmt.Println("-----------------------")
+		for _, count := range status.StorageClasses {
+			class := count.StorageClass
+			if class == "" {
+				class = "(not listed)"
+			}
+			fmt.Printf("%-16s: %5d files (%s)\n", class, count.Count, formatSize(count.Size))
+		}
+	}
+
+	if sizes := status.StoredSizes; sizes != nil && sizes.Count > 0 {
+		fmt.Println("\nCompressed Copies:")
+		fmt.Println("------------------")
+		fmt.Printf("%d files of %s stored in %s (%.1f%%)\n", sizes.Count, formatSize(sizes.Size), formatSize(sizes.StoredSize),
+			float64(sizes.StoredSize)*100/float64(max(sizes.Size, 1)))
+	}
+
+	if len(status.Profiles) > 0 {
+		fmt.Println("\nEndpoint Profiles:")
+		fmt.Println("------------------")
+		for _, profile := range status.Profiles {
+			latency := "-"
+			if profile.LatencyP95 > 0 {
+				latency = profile.LatencyP95.Round(time.Millisecond).String()
+			}
+			fmt.Printf("%-32s %3d workers: %s/s, p95 latency %s (%d runs, last %s)\n",
+				profile.Endpoint,
+				profile.Workers,
+				formatSize(int64(profile.Throughput)),
+				latency,
+				profile.Runs,
+				profile.UpdatedAt.Format(time.RFC3339),
+			)
+		}
+	}
+
+	if live := status.Live; live != nil {
+		elapsed := time.Since(live.StartedAt)
+		fmt.Println("\nRunning Sync:")
+		fmt.Println("-------------")
+		fmt.Printf("Process %d with %d workers, running for %s\n", live.PID, live.Workers, elapsed.Round(time.Second))
+		fmt.Printf("Processed %d of %d files (%d failed), %s transferred (%s/s)\n",
+			live.Completed+live.Failed,
+			live.Total,
+			live.Failed,
+			formatSize(live.Transferred),
+			formatSize(int64(float64(live.Transferred)/max(elapsed.Seconds(), 1))),
+		)
+	}
+
 	if len(status.RecentErrors) > 0 {
 		fmt.Println("\nRecent Errors:")
 		fmt.Println("--------------")
@@ -59,6 +212,265 @@ func printStatus(status *sync.SyncStatus) {
 	}
 }
 
+// shutdownSignals returns a channel closed on the first SIGINT or SIGTERM,
+// asking for a graceful stop, and a context cancelled on the second one
+func shutdownSignals() (<-chan struct{}, context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	stop := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, stopping after the running transfers (repeat to stop immediately)", sig)
+		close(stop)
+		sig = <-signals
+		log.Printf("Received %s, stopping immediately", sig)
+		cancel()
+	}()
+	return stop, ctx
+}
+
+func printSyncResult(result *sync.SyncResult) {
+	if result == nil {
+		return
+	}
+
+	fmt.Println("\nSync Result:")
+	fmt.Println("------------")
+	fmt.Printf("Files: %d (%d completed, %d skipped, %d failed, %d not dispatched)\n",
+		result.Total, result.Completed, result.Skipped, result.Failed, result.NotDispatched)
+	fmt.Printf("Transferred %s in %s (%s/s)\n",
+		formatSize(result.Transferred),
+		result.Duration.Round(time.Second),
+		formatSize(int64(float64(result.Transferred)/max(result.Duration.Seconds(), 1))),
+	)
+	if result.RestoreRequested > 0 {
+		fmt.Printf("%d archived source objects are waiting for a restore\n", result.RestoreRequested)
+	}
+	if result.DestNewer > 0 {
+		fmt.Printf("%d files were not copied because the destination is newer (status dest_newer)\n", result.DestNewer)
+	}
+	if result.ClaimedElsewhere > 0 {
+		fmt.Printf("%d files were left to other instances that claimed them first\n", result.ClaimedElsewhere)
+	}
+	if result.GaveUp > 0 {
+		fmt.Printf("%d files failed too often and are no longer attempted (status failed)\n", result.GaveUp)
+	}
+	if result.Panics > 0 {
+		fmt.Printf("%d files failed with a panic, their errors hold the stack trace\n", result.Panics)
+	}
+	if result.DestinationErrors > 0 {
+		fmt.Printf("%d additional destinations could not be brought up to date\n", result.DestinationErrors)
+	}
+	if mirror := result.Mirror; mirror != nil {
+		if mirror.DryRun {
+			fmt.Printf("Mirror (dry run): %d objects (%s) no longer in the source would be deleted\n",
+				mirror.Extra, formatSize(mirror.ExtraSize))
+		} else {
+			fmt.Printf("Mirror: %d objects (%s) no longer in the source, %d deleted, %d failed\n",
+				mirror.Extra, formatSize(mirror.ExtraSize), mirror.Deleted, mirror.Failed)
+			if mirror.Held > 0 {
+				fmt.Printf("Mirror: %d objects kept because they are under legal hold\n", mirror.Held)
+			}
+		}
+	}
+
+	if result.Failed == 0 {
+		return
+	}
+
+	fmt.Println("\nErrors by Class:")
+	fmt.Println("----------------")
+	for _, count := range result.ErrorCounts() {
+		fmt.Printf("%-16s: %5d files\n", count.Class, count.Count)
+	}
+
+	fmt.Printf("\nFailed Files (%d of %d):\n", len(result.FailedFiles), result.Failed)
+	fmt.Println("-------------")
+	for _, file := range result.FailedFiles {
+		fmt.Printf("File: %s\nClass: %s\nError: %s\n\n", file.Path, file.Class, file.Error)
+	}
+}
+
+func printCorruptReport(files []*db.FileEntry) {
+	fmt.Println("\nCorrupt Files:")
+	fmt.Println("--------------")
+
+	var totalSize int64
+	for _, file := range files {
+		totalSize += file.Size
+		fmt.Printf("File: %s\nSize: %s\nProblem: %s\nRepair attempts: %d\nTime: %s\n\n",
+			file.Path,
+			formatSize(file.Size),
+			file.VerifyMessage,
+			file.RepairAttempts,
+			file.UpdatedAt.Format(time.RFC3339),
+		)
+	}
+
+	fmt.Printf("Total: %d corrupt files (%s)\n", len(files), formatSize(totalSize))
+}
+
+func printFiles(files []sync.FileHistory, errorHistory bool) {
+	var totalSize int64
+	for _, file := range files {
+		totalSize += file.Size
+		fmt.Printf("%-16s %10s  %s\n", file.Status, formatSize(file.Size), file.Path)
+		if file.ErrorMessage != "" && !errorHistory {
+			fmt.Printf("    Error: %s\n", file.ErrorMessage)
+		}
+		for _, record := range file.Errors {
+			fmt.Printf("    %s  %s\n", record.OccurredAt.Format(time.RFC3339), record.Message)
+		}
+	}
+
+	fmt.Printf("\nTotal: %d files (%s)\n", len(files), formatSize(totalSize))
+}
+
+func printListPreview(preview *sync.ListPreview) {
+	fmt.Println("\nListing Preview:")
+	fmt.Println("----------------")
+	for _, entry := range preview.Prefixes {
+		name := entry.Prefix
+		if name == "" {
+			name = "(top level)"
+		}
+		fmt.Printf("%-40s %8d files %12s", name, entry.Listed, formatSize(entry.Size))
+		if entry.Included != entry.Listed {
+			fmt.Printf("  (%d files, %s to copy)", entry.Included, formatSize(entry.IncludedSize))
+		}
+		fmt.Println()
+	}
+	fmt.Printf("\nTotal: %d files (%s), %d files (%s) would be recorded for copying\n",
+		preview.Listed, formatSize(preview.Size), preview.Included, formatSize(preview.IncludedSize))
+	if preview.SampledOut > 0 {
+		fmt.Printf("Left out of the sample: %d files\n", preview.SampledOut)
+	}
+	if preview.Excluded > 0 {
+		fmt.Printf("Left out by the filters: %d files\n", preview.Excluded)
+	}
+	if preview.Replicated > 0 {
+		fmt.Printf("Left to the bucket replication of the source: %d files\n", preview.Replicated)
+	}
+	fmt.Println("Nothing was recorded (dry run)")
+}
+
+func printInventoryRuns(runs []*db.InventoryRun) {
+	fmt.Println("\nInventory Runs:")
+	fmt.Println("---------------")
+	for _, run := range runs {
+		note := ""
+		switch {
+		case run.FinishedAt.IsZero():
+			note = " (interrupted)"
+		case !run.Complete:
+			note = " (listing incomplete, deletions not detected)"
+		case run.Baseline:
+			note = " (baseline)"
+		}
+		fmt.Printf("Run %d at %s: %d objects (%s), %d new, %d modified, %d deleted%s\n",
+			run.ID, run.StartedAt.Format(time.RFC3339), run.ObjectCount, formatSize(run.TotalSize),
+			run.New, run.Modified, run.Deleted, note)
+	}
+}
+
+func printInventoryChanges(changes []*db.InventoryChange) {
+	fmt.Println("\nInventory Changes:")
+	fmt.Println("------------------")
+	counts := make(map[string]int)
+	for _, change := range changes {
+		counts[change.Change]++
+		switch change.Change {
+		case db.InventoryModified:
+			fmt.Printf("%-9s %s (%s -> %s)\n", change.Change, change.Path, formatSize(change.OldSize), formatSize(change.NewSize))
+		case db.InventoryDeleted:
+			fmt.Printf("%-9s %s (%s)\n", change.Change, change.Path, formatSize(change.OldSize))
+		default:
+			fmt.Printf("%-9s %s (%s)\n", change.Change, change.Path, formatSize(change.NewSize))
+		}
+	}
+	fmt.Printf("\nTotal: %d new, %d modified, %d deleted\n",
+		counts[db.InventoryNew], counts[db.InventoryModified], counts[db.InventoryDeleted])
+}
+
+// sharedProjects returns the other projects with a database that write to
+// the main destination of cfg
+func sharedProjects(fileConfig *config.FileConfig, cfg *config.ProjectConfig) []sync.SharedProject {
+	var shared []sync.SharedProject
+	for name := range fileConfig.Projects {
+		if name == cfg.ProjectName {
+			continue
+		}
+		other, err := fileConfig.GetProjectConfig(name)
+		if err != nil || other.DestinationID() != cfg.DestinationID() {
+			continue
+		}
+		databasePath := filepath.Join(projectsDir, name, "files.db")
+		if driver := other.StateBackend.Driver; driver == "" || driver == db.DriverSQLite {
+			if _, err := os.Stat(databasePath); err != nil {
+				continue
+			}
+		}
+		shared = append(shared, sync.SharedProject{Name: name, DatabasePath: databasePath, StateBackend: other.StateBackend})
+	}
+	return shared
+}
+
+func printExtras(report *sync.ExtrasReport, output string) {
+	fmt.Println("\nExtraneous Destination Objects:")
+	fmt.Println("-------------------------------")
+	for _, folder := range report.Folders {
+		name := folder.Folder
+		if name == "" {
+			name = "(top level)"
+		}
+		fmt.Printf("%-40s %8d files %12s\n", name, folder.Count, formatSize(folder.Size))
+	}
+	fmt.Printf("\nTotal: %d of %d destination objects (%s) are in neither the source nor the file list of any project\n",
+		len(report.Extras), report.Scanned, formatSize(report.Size))
+	fmt.Printf("Listed in %s\n", output)
+}
+
+func printDaemonStatus(status *sync.DaemonStatus) {
+	state := status.State
+	if status.Paused {
+		state += ", paused"
+	}
+	fmt.Printf("Daemon %d: %s\n", status.PID, state)
+	if status.Storm != "" {
+		fmt.Printf("Workers paused: %s\n", status.Storm)
+	}
+	if !status.NextCycle.IsZero() {
+		fmt.Printf("Next cycle at %s\n", status.NextCycle.Format(time.RFC3339))
+	}
+	if live := status.Progress; live != nil {
+		elapsed := time.Since(live.StartedAt)
+		fmt.Printf("Sync with %d workers, running for %s\n", live.Workers, elapsed.Round(time.Second))
+		fmt.Printf("Processed %d of %d files (%d failed), %s transferred (%s/s)\n",
+			live.Completed+live.Failed,
+			live.Total,
+			live.Failed,
+			formatSize(live.Transferred),
+			formatSize(int64(float64(live.Transferred)/max(elapsed.Seconds(), 1))),
+		)
+	}
+}
+
+func printPlan(plan *sync.Plan) {
+	fmt.Println("\nPlanned Actions:")
+	fmt.Println("----------------")
+	for _, action := range plan.Actions {
+		fmt.Printf("%-10s %s (%s): %s\n", action.Action, action.Path, formatSize(action.Size), action.Reason)
+	}
+
+	counts, sizes := plan.Counts()
+	fmt.Println()
+	for _, action := range []sync.PlanActionType{sync.PlanCopy, sync.PlanOverwrite, sync.PlanSkip, sync.PlanKeep} {
+		fmt.Printf("%-10s: %5d files (%s)\n", action, counts[action], formatSize(sizes[action]))
+	}
+}
+
 func printUsage() {
 	fmt.Println(`Minio Simple Copier - A high-performance file synchronization tool
 
@@ -71,7 +483,28 @@ Commands:
   update-list   Update source file list
   sync          Start file synchronization
   status        Show current sync status
-  import-list   Import file list from mc ls --recursive --json output
+  import-list   Import file list from mc ls --recursive --json output, a key list or CSV
+  run           Keep running update-list and sync periodically (daemon mode)
+  ctl           Pause, resume or query a daemon through its control socket
+  verify        Check completed copies at the destination (resumable)
+  corrupt-report
+                List files quarantined as corrupt by verify
+  requeue       Copy completed files again whose source was modified in a time range
+  retry         Set failed files back to pending, optionally by path prefix or error
+  reset         Set files back to pending by status or age, or delete them with -all
+  files         List tracked files by status or path prefix, with -show-error-history their last errors
+  plan          Save the actions a sync would perform for review
+  apply         Execute a plan saved by the plan command
+  catalog       Export an inventory of the source or destination as CSV
+  bisync        Propagate new and changed objects in both directions
+  inventory     Record new, modified and deleted source objects without copying
+  inventory-report
+                List inventory runs, or the changes of one with -inventory-run
+  find-extras   Report destination objects unknown to the source and projects
+  decrypt-local Write decrypted copies of an encrypted local destination
+  extract       Write files packed into archives at a local destination
+  serve-local   Serve a local destination read-only over the S3 API for restore tests
+  forget        Erase a key from the destinations and never copy it again
 
 Examples:
   1. Configure Minio-to-Minio sync:
@@ -90,18 +523,101 @@ Examples:
        -source-folder "documents/2024" \
        -dest-type local -local-path "/data/backup/2024-docs"
 
-  4. Update file list:
+  4. Configure Minio-to-AWS S3 sync:
+     minio-simple-copier -project to-aws -command config \
+       -source-endpoint minio:9000 -source-bucket mybucket \
+       -dest-type s3 -dest-region eu-west-1 -dest-bucket my-archive
+
+  5. Update file list:
      minio-simple-copier -project myproject -command update-list
 
-  5. Start sync with 10 workers:
+  6. Update file list with only the immediate objects of the source folder:
+     minio-simple-copier -project myproject -command update-list -recursive=false
+
+  7. Pilot a migration on a 1% sample of the source keys:
+     minio-simple-copier -project myproject -command update-list -sample 1%
+
+     Or migrate in stages, e.g. the objects last modified in 2023:
+     minio-simple-copier -project myproject -command update-list -modified-after 2023-01-01 -modified-before 2024-01-01
+
+     Preview what a listing would record per top-level prefix, without recording it:
+     minio-simple-copier -project myproject -command update-list -dry-run
+
+  8. Start sync with 10 workers:
      minio-simple-copier -project myproject -command sync -workers 10
 
-  6. Check sync status:
+  9. Check sync status:
      minio-simple-copier -project myproject -command status
 
-  7. Import file list:
+  10. Import file list:
      minio-simple-copier -project myproject -command import-list -import-list file_list.txt
 
+  11. Verify and automatically re-copy corrupt files (at most 3 times each):
+     minio-simple-copier -project myproject -command verify -repair -max-repairs 3
+
+  12. Sync within a 6 hour maintenance window:
+     minio-simple-copier -project myproject -command sync -workers 10 -max-duration 6h
+
+  13. Run as a one-shot migration job that exits once fully synced:
+     minio-simple-copier -project myproject -command run -interval 5m -exit-when-synced 30m
+
+  14. Review the changes of a sync before executing them:
+     minio-simple-copier -project myproject -command plan -plan-file plan.json
+     minio-simple-copier -project myproject -command apply -plan-file plan.json -workers 10
+
+  15. Export the destination inventory for the data catalog:
+     minio-simple-copier -project myproject -command catalog -catalog-side destination -catalog-output dest.csv
+
+  16. Keep two MinIO buckets in step, the newest version winning conflicts:
+     minio-simple-copier -project shared -command config ... -conflict newest-wins
+     minio-simple-copier -project shared -command bisync -workers 10
+
+  17. Mirror deletions, reviewing the report of a dry run first:
+     minio-simple-copier -project myproject -command sync -mirror-dry-run
+     minio-simple-copier -project myproject -command sync -workers 10 -mirror
+
+  18. Store tuning defaults for scheduled runs, then sync without flags:
+     minio-simple-copier -project nightly -command config ... -workers 20 -bandwidth-limit 50MB/s
+     minio-simple-copier -project nightly -command sync
+
+  19. Monitor a bucket without copying it, then review the changes of a run:
+     minio-simple-copier -project watch-only -command inventory
+     minio-simple-copier -project watch-only -command inventory-report
+     minio-simple-copier -project watch-only -command inventory-report -inventory-run 12
+
+  20. Configure Minio-to-Google Cloud Storage sync with a service account key:
+     minio-simple-copier -project to-gcs -command config \
+       -source-endpoint minio:9000 -source-bucket mybucket \
+       -dest-type gcs -gcs-credentials /etc/msc/sa.json -dest-bucket my-archive
+
+  21. Pause a running daemon during business hours and resume it later:
+     minio-simple-copier -project myproject -command ctl -ctl pause
+     minio-simple-copier -project myproject -command ctl -ctl resume
+
+  22. Copy only images, skipping everything below tmp/ and any logs folder:
+     minio-simple-copier -project myproject -command config ... \
+       -include "*.jpg" -include "*.png" -exclude "regex:^tmp/" -exclude "**/logs/**"
+
+  23. Only sync objects modified in the last 7 days, skipping those over 5GB:
+     minio-simple-copier -project myproject -command sync -newer-than 7d -max-size 5GB
+
+  24. Copy again the files whose source was modified during a corruption incident:
+     minio-simple-copier -project myproject -command requeue -modified-between 2024-05-01 2024-05-03
+     minio-simple-copier -project myproject -command sync
+
+  25. Retry the files that failed with access denied errors once the credentials were fixed:
+     minio-simple-copier -project myproject -command retry -error-match "(?i)access denied"
+
+  26. Copy every completed file again, or start a project over from scratch:
+     minio-simple-copier -project myproject -command reset -statuses completed,skipped_existing
+     minio-simple-copier -project myproject -command reset -all
+
+  27. Show the last errors of the files that keep failing:
+     minio-simple-copier -project myproject -command files -statuses error,failed -show-error-history
+
+  28. Erase a key from every copy after a right-to-erasure request:
+     minio-simple-copier -project myproject -command forget -key users/1234/profile.json -reason "ticket 5678"
+
 For more information, visit: https://github.com/chmdznr/minio-simple-copier`)
 }
 
@@ -115,39 +631,177 @@ func main() {
 		sourceBucket    = flag.String("source-bucket", "", "Source Minio bucket")
 		sourceFolder    = flag.String("source-folder", "", "Source folder path (e.g., naskah-keluar)")
 
-		destType      = flag.String("dest-type", "minio", "Destination type (minio or local)")
+		destType      = flag.String("dest-type", "minio", "Destination type (minio, s3, gcs or local)")
 		localDestPath = flag.String("local-path", "", "Local destination path (when dest-type is local)")
 
-		destEndpoint  = flag.String("dest-endpoint", "", "Destination Minio endpoint (when dest-type is minio)")
-		destAccessKey = flag.String("dest-access-key", "", "Destination Minio access key (when dest-type is minio)")
-		destSecretKey = flag.String("dest-secret-key", "", "Destination Minio secret key (when dest-type is minio)")
-		destBucket    = flag.String("dest-bucket", "", "Destination Minio bucket (when dest-type is minio)")
-		destUseSSL    = flag.Bool("dest-use-ssl", true, "Use SSL for destination Minio (when dest-type is minio)")
-		destFolder    = flag.String("dest-folder", "", "Destination folder path (when dest-type is minio)")
+		destEndpoint  = flag.String("dest-endpoint", "", "Destination endpoint (when dest-type is minio, s3 or gcs, default s3.amazonaws.com for s3 and storage.googleapis.com for gcs)")
+		destAccessKey = flag.String("dest-access-key", "", "Destination access key (when dest-type is minio or s3)")
+		destSecretKey = flag.String("dest-secret-key", "", "Destination secret key (when dest-type is minio or s3)")
+		destBucket    = flag.String("dest-bucket", "", "Destination bucket (when dest-type is minio, s3 or gcs)")
+		destUseSSL    = flag.Bool("dest-use-ssl", true, "Use SSL for the destination (when dest-type is minio or s3)")
+		destFolder    = flag.String("dest-folder", "", "Destination folder path (when dest-type is minio, s3 or gcs)")
+
+		destRegion       = flag.String("dest-region", "", "Destination region, e.g. eu-west-1 (when dest-type is s3)")
+		destAddressing   = flag.String("dest-addressing", "", "Bucket addressing: path or virtual-hosted, default depends on the endpoint (when dest-type is s3)")
+		destSessionToken = flag.String("dest-session-token", "", "Session token of temporary credentials (when dest-type is s3)")
+
+		gcsCredentials = flag.String("gcs-credentials", "", "Service account JSON key file, default $GOOGLE_APPLICATION_CREDENTIALS (when dest-type is gcs)")
+
+		workers = flag.Int("workers", 5, "Number of concurrent workers (saved by the config command as project default; without one, sync, run and apply use the fastest worker count of past runs)")
+		command = flag.String("command", "", "Command to execute (help, config, update-list, sync, status, import-list, run, ctl, verify, corrupt-report, requeue, retry, reset, files, plan, apply, catalog, bisync, inventory, inventory-report, find-extras, decrypt-local, extract, serve-local, forget)")
+
+		// Listing flags
+		recursive       = flag.Bool("recursive", true, "List the source folder recursively (update-list and run commands)")
+		depth           = flag.Int("depth", 0, "Number of folder levels to list below the source folder (0 = unlimited, implies -recursive=false)")
+		listingCacheTTL = flag.Duration("listing-cache-ttl", 0, "Read the listing from the bucket listing cache shared between projects if younger than this, refreshing it otherwise (update-list and run commands, 0 = disabled)")
+		sample          = flag.String("sample", "", "Only record a deterministic sample of source keys, e.g. 1% or 0.01 (update-list and run commands)")
+		listers         = flag.Int("listers", 1, "Number of shards listed concurrently in a recursive listing (update-list and run commands)")
+		shardDepth      = flag.Int("shard-depth", 1, "With -listers, split the keyspace into the prefixes this many levels below the source folder (update-list and run commands)")
+		shardDelimiter  = flag.String("shard-delimiter", "/", "With -listers, the delimiter separating the levels of -shard-depth (update-list and run commands)")
+		listBatchSize   = flag.Int("list-batch-size", 500, "Number of listed files written to the database per transaction (update-list, import-list and run commands)")
+		listFlushEvery  = flag.Duration("list-flush-interval", time.Second, "Write a partial batch of listed files after this long (update-list and run commands)")
+		modifiedAfter   = flag.String("modified-after", "", "Only record source objects last modified at or after this date (2024-01-31) or RFC 3339 time (update-list and run commands)")
+		modifiedBefore  = flag.String("modified-before", "", "Only record source objects last modified before this date (2024-01-31) or RFC 3339 time (update-list and run commands)")
+		listDryRun      = flag.Bool("dry-run", false, "Only print the number and size of the listed objects per top-level prefix, without recording them (update-list command)")
+		modifiedBetween = flag.String("modified-between", "", "Start of the time range, followed by its end as the next argument, e.g. -modified-between 2024-05-01 2024-05-03 (requeue command)")
+
+		retryPrefix = flag.String("retry-prefix", "", "Only retry failed files whose source path starts with this prefix (retry command)")
+		errorMatch  = flag.String("error-match", "", "Only retry failed files whose error message matches this regular expression (retry command)")
+
+		resetStatuses = flag.String("statuses", "", "Comma-separated statuses of the files to reset or list, e.g. completed,error (reset and files commands, default all)")
+		resetAll      = flag.Bool("all", false, "Delete the selected files from the database instead of setting them back to pending (reset command)")
+		resetBefore   = flag.String("before", "", "Only reset files last updated before this date (2024-01-31) or RFC 3339 time (reset command)")
+
+		pathPrefix   = flag.String("path-prefix", "", "Only list files whose source path starts with this prefix (files command)")
+		errorHistory = flag.Bool("show-error-history", false, "Show the last errors of every listed file, newest first (files command)")
+
+		maxDuration = flag.Duration("max-duration", 0, "Stop dispatching new files after this long and exit with a resumable state (sync command, 0 = no limit)")
+
+		skipExisting        = flag.Bool("skip-existing", false, "Also check the destination for files requeued by the requeue, reset and verify commands, and skip those that already exist (sync and run commands, saved by the config command as project default)")
+		force               = flag.Bool("force", false, "Copy every pending file without checking whether the destination already has it, whatever the overwrite policy (sync and run commands)")
+		existingFromListing = flag.Bool("skip-existing-by-listing", false, "With -skip-existing, list a MinIO or S3 destination once instead of checking every file (sync and run commands, saved by the config command as project default)")
+		delta               = flag.Bool("delta", false, "Fetch only the appended bytes of grown files at local destinations (sync, run and apply commands)")
+		stallTimeout        = flag.Duration("stall-timeout", 0, "Cancel and retry transfers when nothing was transferred for this long (sync, run and apply commands, 0 = disabled)")
+		stallDump           = flag.Bool("stall-dump", false, "Log a goroutine dump when a stall is detected (sync, run and apply commands)")
+		bandwidthLimit      = flag.String("bandwidth-limit", "", "Cap the rate all workers together read from the source, e.g. 50MB/s (saved by the config command as project default)")
+		paceLatency         = flag.Duration("pace-latency", 0, "Reduce the transfers in flight while the p95 latency of destination requests exceeds this, recovering gradually (saved by the config command as project default, 0 = disabled)")
+		nice                = flag.Int("nice", 0, "Lower the CPU priority like nice(1), 1 to 19; on Windows a lower priority class (saved by the config command as project default)")
+		ioPriority          = flag.String("ionice", "", "Disk I/O priority on Linux: idle or low (saved by the config command as project default)")
+		diskWriteLimit      = flag.String("disk-write-limit", "", "Cap the rate files are written to a local destination, e.g. 20MB/s (saved by the config command as project default)")
+		overwrite           = flag.String("overwrite", "", "Which existing destination objects a sync replaces: never, if-newer, if-different (default) or always (sync, run and plan commands, saved by the config command as project default)")
+		chunkSize           = flag.String("chunk-size", "", "Size of the byte ranges large objects are downloaded in with -chunk-concurrency, and of multipart upload parts, e.g. 64MB (saved by the config command as project default)")
+		chunkConcurrency    = flag.Int("chunk-concurrency", 0, "Download objects larger than -chunk-size to a local destination in this many concurrent byte ranges, and upload this many multipart parts at once (saved by the config command as project default, 0 or 1 = one stream)")
+		checksum            = flag.Bool("checksum", false, "Compare local copies with the source by their SHA256 checksums instead of size and modification time (sync, run, plan and verify commands, saved by the config command as project default)")
+		mirror              = flag.Bool("mirror", false, "Delete objects from the destination that no longer exist in the source, after reporting them (sync command)")
+		mirrorDryRun        = flag.Bool("mirror-dry-run", false, "Only report the objects -mirror would delete (sync command)")
+		serverSideCopy      = flag.Bool("server-side-copy", false, "Copy files on the server instead of downloading and uploading them; used automatically for a MinIO destination on the source server with the same credentials, set it to override that (sync, run, apply and verify commands)")
+
+		reverify   = flag.Bool("reverify", false, "Verify all completed files again, including already verified ones (verify command)")
+		repair     = flag.Bool("repair", false, "Re-copy corrupt files and verify them again (verify command)")
+		maxRepairs = flag.Int("max-repairs", 3, "Maximum number of automatic re-copies per corrupt file (verify command)")
+		deepVerify = flag.Bool("deep", false, "Compare copies with a fresh stat of the source and checksum local copies (verify command)")
+		requeue    = flag.Bool("requeue", false, "Set files with mismatching copies back to pending instead of quarantining them (verify command)")
+
+		// Alert flags (saved by the config command)
+		minThroughput  = flag.String("min-throughput", "", "Alert when throughput stays below this rate per second, e.g. 10MB (config command)")
+		maxErrorRate   = flag.Float64("max-error-rate", 0, "Alert when the fraction of failed files stays above this, e.g. 0.05 (config command)")
+		breachDuration = flag.Duration("alert-after", 0, "How long a threshold breach must persist before alerting, default 5m (config command)")
+		abortOnAlert   = flag.Bool("abort-on-alert", false, "Abort the sync with exit code 3 when an alert is emitted (config command)")
+		alertWebhook   = flag.String("alert-webhook", "", "URL receiving alerts as JSON POST requests (config command)")
+		maxPendingAge  = flag.Duration("max-pending-age", 0, "Alert after a sync when a file has been pending for longer than this, e.g. 24h (config command)")
 
-		workers = flag.Int("workers", 5, "Number of concurrent workers")
-		command = flag.String("command", "", "Command to execute (help, config, update-list, sync, status)")
+		// Atomic commit flags (saved by the config command)
+		atomicGroupDepth = flag.Int("atomic-group-depth", 0, "Stage files and commit each group of folders at this depth below the source folder at once (config command, 0 = off)")
+		stagingPrefix    = flag.String("staging-prefix", "", "Destination prefix for staged files, default .staging (config command)")
+
+		onChange      = flag.String("on-change", "", "What to do when a source object changes after it was copied: requeue (default), keep or version (config command)")
+		versionPrefix = flag.String("version-prefix", "", "Destination prefix for copies superseded by the version policy, default .versions (config command)")
+		conflict      = flag.String("conflict", "", "Which side wins objects changed on both sides: newest-wins (default), source-wins or skip (config command, used by bisync)")
+		clockSkew     = flag.Duration("clock-skew", 0, "Modification times closer than this are treated as simultaneous, for servers with unsynchronized clocks (config command)")
+		protectNewer  = flag.Bool("protect-newer", false, "Don't overwrite destination objects modified after the source object, mark them dest_newer for review (config command)")
+		preserveMeta  = flag.Bool("preserve-metadata", false, "Copy the content type, content encoding, user metadata, tags, storage class and ACL of source objects to object storage destinations (config command)")
+		runMarker     = flag.String("run-marker", "", "Write the statistics of every successful sync to this destination key, e.g. _sync/last_run.json (config command)")
+		runMarkerTags = flag.Bool("run-marker-tags", false, "Set the statistics of every successful sync as tags of the destination bucket (config command, MinIO and S3 destinations)")
+		replStatus    = flag.Bool("replication-status", false, "Only copy source objects whose MinIO bucket replication failed or is pending, or that aren't replicated (config command)")
+
+		// Request retry flags (saved by the config command as project default)
+		requestRetries       = flag.Int("request-retries", 0, "How often a failing MinIO or S3 request is attempted (0 = project default or 3)")
+		requestRetryInterval = flag.Duration("request-retry-interval", 0, "Pause between attempts of a failing request (0 = project default or 5s)")
+
+		// File retry flags (saved by the config command as project default)
+		maxRetries   = flag.Int("max-retries", 0, "How often a failing file is attempted before it is set to failed (0 = project default or 5)")
+		retryBackoff = flag.Duration("retry-backoff", 0, "Pause before a failed file is attempted again, doubled with every further failure up to 24h (0 = project default or 1m)")
+
+		// Daemon mode flags
+		interval       = flag.Duration("interval", 15*time.Minute, "Time between sync cycles (run command, overrides the project schedule)")
+		schedule       = flag.String("schedule", "", "When the run command re-syncs: a cron expression like \"*/15 * * * *\" or @every <duration> (config command)")
+		exitWhenSynced = flag.Duration("exit-when-synced", 0, "Exit once the backlog is empty and no changes are seen for this long (run command, 0 = never)")
+		watch          = flag.Bool("watch", false, "Sync changed objects as MinIO bucket notifications report them, listing the source in full only on startup (run command)")
+		ctlCommand     = flag.String("ctl", sync.ControlStatus, "Command sent to the daemon of the project: pause, resume or status (ctl command)")
+
+		// Catalog flags
+		catalogSide   = flag.String("catalog-side", "source", "Side to export: source or destination (catalog command)")
+		catalogOutput = flag.String("catalog-output", "", "CSV file for the catalog (default: projects/<project>/catalog-<side>.csv)")
+
+		inventoryRun  = flag.Int64("inventory-run", 0, "Inventory run whose changes are listed (inventory-report command, 0 = list the latest runs)")
+		inventoryRuns = flag.Int("inventory-runs", 20, "Number of inventory runs listed (inventory-report command)")
+
+		extrasOutput = flag.String("extras-output", "", "CSV file listing the extraneous objects (default: projects/<project>/extras.csv)")
+
+		// Erasure flags
+		forgetKey    = flag.String("key", "", "Source key whose copies are erased and never copied again (forget command)")
+		forgetReason = flag.String("reason", "", "Reason recorded with the erasure, e.g. a ticket number (forget command)")
+		forgetFeed   = flag.Bool("feed", false, "Read the erasure feed of the project and erase the requested keys (forget command)")
+
+		// Local encryption flags
+		decryptOutput = flag.String("decrypt-output", "", "Directory the decrypted files are written to (decrypt-local command)")
+		decryptPrefix = flag.String("decrypt-prefix", "", "Only decrypt files whose source path starts with this prefix (decrypt-local command)")
+		serveAddr     = flag.String("serve-addr", "127.0.0.1:9900", "Address the local copy is served on (serve-local command)")
+
+		// Pack flags
+		extractOutput = flag.String("extract-output", "", "Directory the packed files are extracted to (extract command)")
+		extractPrefix = flag.String("extract-prefix", "", "Only extract packed files whose source path starts with this prefix (extract command)")
+
+		// Plan/apply flags
+		planFile = flag.String("plan-file", "", "Plan file written by plan and executed by apply (default: projects/<project>/plan.json)")
 
 		// New flag for importing file list
-		importFile = flag.String("import-list", "", "Import file list from mc ls --recursive --json output")
+		importFile    = flag.String("import-list", "", "Import file list from mc ls --recursive --json output, a key list or CSV, optionally gzip-compressed")
+		restartImport = flag.Bool("restart-import", false, "Import the file from the start instead of resuming an interrupted import (import-list command)")
+		importFormat  = flag.String("format", sync.ImportFormatAuto, "Format of the import file: auto, json, text or csv (import-list command)")
+		urlDecodeKeys = flag.Bool("url-decode-keys", false, "URL-decode the keys of the import file (import-list command)")
+		stripPrefix   = flag.String("strip-prefix", "", "Prefix removed from the keys of the import file, e.g. alias/bucket/folder (import-list command)")
+		importCheck   = flag.Int("import-check-sample", 20, "Number of imported keys checked to exist in the source before importing, 0 to disable (import-list command)")
 	)
 
 	// Handle SSL flag separately
 	var sourceUseSSL bool
 	flag.BoolVar(&sourceUseSSL, "source-use-ssl", true, "Use SSL for source Minio")
 
+	// Filter flags (saved by the config command, override the project filters
+	// for a single run of the other commands)
+	var include, exclude patternList
+	flag.Var(&include, "include", "Only copy source files matching this glob or regex:<expression>, can be repeated")
+	flag.Var(&exclude, "exclude", "Skip source files matching this glob or regex:<expression>, can be repeated")
+	minSize := flag.String("min-size", "", "Only copy source files of at least this size, e.g. 1KB")
+	maxSize := flag.String("max-size", "", "Only copy source files of at most this size, e.g. 5GB")
+	newerThan := flag.String("newer-than", "", "Only copy source files modified within this age, e.g. 7d or 36h")
+	olderThan := flag.String("older-than", "", "Only copy source files last modified longer ago than this age, e.g. 30d")
+
 	flag.Parse()
 
-	// Debug: Print all arguments
-	log.Println("Debug: Command line arguments:")
-	for i, arg := range os.Args {
-		log.Printf("Arg[%d]: %q", i, arg)
+	// -modified-between takes the end of its range as the following
+	// argument, which stops flag parsing, so parse the flags after it too
+	var modifiedUntil string
+	if *modifiedBetween != "" && flag.NArg() > 0 {
+		modifiedUntil = flag.Arg(0)
+		flag.CommandLine.Parse(flag.Args()[1:])
 	}
 
-	// Debug: Print all flags
-	log.Println("Debug: Printing all flags:")
+	// Flags given on the command line override the project tuning defaults
+	setFlags := make(map[string]bool)
 	flag.Visit(func(f *flag.Flag) {
-		log.Printf("Flag: %s = %q", f.Name, f.Value.String())
+		setFlags[f.Name] = true
 	})
 
 	// Show help if no arguments or help command
@@ -188,8 +842,8 @@ func main() {
 		destTypeEnum := config.DestinationType(destTypeStr)
 		log.Printf("Debug: destTypeEnum after conversion: %q", destTypeEnum)
 
-		if destTypeEnum != config.DestinationMinio && destTypeEnum != config.DestinationLocal {
-			log.Fatalf("Invalid destination type: %s. Must be 'minio' or 'local'", destTypeStr)
+		if destTypeEnum != config.DestinationMinio && destTypeEnum != config.DestinationS3 && destTypeEnum != config.DestinationGCS && destTypeEnum != config.DestinationLocal {
+			log.Fatalf("Invalid destination type: %s. Must be 'minio', 's3', 'gcs' or 'local'", destTypeStr)
 		}
 
 		// Save new config
@@ -204,6 +858,97 @@ func main() {
 				FolderPath:      *sourceFolder,
 			},
 			DestType: destTypeEnum,
+			Alerts: config.AlertConfig{
+				MinThroughput:  *minThroughput,
+				MaxErrorRate:   *maxErrorRate,
+				BreachDuration: *breachDuration,
+				AbortOnBreach:  *abortOnAlert,
+				WebhookURL:     *alertWebhook,
+				MaxPendingAge:  *maxPendingAge,
+			},
+			AtomicCommit: config.AtomicCommitConfig{
+				GroupDepth:    *atomicGroupDepth,
+				StagingPrefix: *stagingPrefix,
+			},
+			OnChange: config.ChangeConfig{
+				Policy:        config.ChangePolicy(*onChange),
+				VersionPrefix: *versionPrefix,
+			},
+			Include:           include,
+			Exclude:           exclude,
+			MinSize:           *minSize,
+			MaxSize:           *maxSize,
+			NewerThan:         *newerThan,
+			OlderThan:         *olderThan,
+			Conflict:          config.ConflictPolicy(*conflict),
+			ProtectNewer:      *protectNewer,
+			PreserveMetadata:  *preserveMeta,
+			ReplicationStatus: *replStatus,
+			RunMarker: config.RunMarkerConfig{
+				Key:        *runMarker,
+				BucketTags: *runMarkerTags,
+			},
+			Schedule:  *schedule,
+			ClockSkew: *clockSkew,
+			Tuning: config.TuningConfig{
+				SkipExisting:          *skipExisting,
+				SkipExistingByListing: *existingFromListing,
+				BandwidthLimit:        *bandwidthLimit,
+				Retries:               *requestRetries,
+				RetryInterval:         *requestRetryInterval,
+				MaxRetries:            *maxRetries,
+				RetryBackoff:          *retryBackoff,
+				PaceLatency:           *paceLatency,
+				Nice:                  *nice,
+				IOPriority:            *ioPriority,
+				DiskWriteLimit:        *diskWriteLimit,
+				Checksum:              *checksum,
+				Overwrite:             config.OverwritePolicy(*overwrite),
+				ChunkSize:             *chunkSize,
+				ChunkConcurrency:      *chunkConcurrency,
+			},
+		}
+		if setFlags["workers"] {
+			cfg.Tuning.Workers = *workers
+		}
+		if _, err := config.ParseSize(*minThroughput); err != nil {
+			log.Fatalf("Invalid minimum throughput: %v", err)
+		}
+		if _, err := config.ParseRate(*bandwidthLimit); err != nil {
+			log.Fatalf("Invalid bandwidth limit: %v", err)
+		}
+		if _, err := config.ParseRate(*diskWriteLimit); err != nil {
+			log.Fatalf("Invalid disk write limit: %v", err)
+		}
+		switch config.OverwritePolicy(*overwrite) {
+		case "", config.OverwriteNever, config.OverwriteIfNewer, config.OverwriteIfDifferent, config.OverwriteAlways:
+		default:
+			log.Fatalf("Invalid overwrite policy %q, must be never, if-newer, if-different or always", *overwrite)
+		}
+		if size, err := config.ParseSize(*chunkSize); err != nil || (*chunkSize != "" && size <= 0) {
+			log.Fatalf("Invalid chunk size %q", *chunkSize)
+		}
+		if *chunkConcurrency < 0 {
+			log.Fatalf("Invalid chunk concurrency %d, can't be negative", *chunkConcurrency)
+		}
+		if *maxRetries < 0 || *retryBackoff < 0 {
+			log.Fatalf("Invalid retry policy, -max-retries and -retry-backoff can't be negative")
+		}
+		if *nice < -20 || *nice > 19 {
+			log.Fatalf("Invalid nice value %d, must be between -20 and 19", *nice)
+		}
+		if *ioPriority != "" && *ioPriority != sync.IOPriorityIdle && *ioPriority != sync.IOPriorityLow {
+			log.Fatalf("Invalid I/O priority %q, must be %s or %s", *ioPriority, sync.IOPriorityIdle, sync.IOPriorityLow)
+		}
+		for _, size := range []string{*minSize, *maxSize} {
+			if _, err := config.ParseSize(size); err != nil {
+				log.Fatalf("Invalid size filter: %v", err)
+			}
+		}
+		for _, age := range []string{*newerThan, *olderThan} {
+			if _, err := config.ParseAge(age); err != nil {
+				log.Fatalf("Invalid age filter: %v", err)
+			}
 		}
 
 		// Handle destination based on type
@@ -217,12 +962,62 @@ func main() {
 				BucketName:      *destBucket,
 				FolderPath:      *destFolder,
 			}
+		case config.DestinationS3:
+			cfg.DestS3 = config.S3Config{
+				Endpoint:        *destEndpoint,
+				Region:          *destRegion,
+				AccessKeyID:     *destAccessKey,
+				SecretAccessKey: *destSecretKey,
+				SessionToken:    *destSessionToken,
+				UseSSL:          *destUseSSL,
+				Addressing:      *destAddressing,
+				BucketName:      *destBucket,
+				FolderPath:      *destFolder,
+			}
+		case config.DestinationGCS:
+			cfg.DestGCS = config.GCSConfig{
+				Endpoint:        *destEndpoint,
+				CredentialsFile: *gcsCredentials,
+				BucketName:      *destBucket,
+				FolderPath:      *destFolder,
+			}
 		case config.DestinationLocal:
 			cfg.DestLocal = config.LocalConfig{
 				Path: *localDestPath,
 			}
 		}
 
+		// Keep settings that can only be edited in the config file
+		if existing, err := fileConfig.GetProjectConfig(*projectName); err == nil {
+			cfg.Destinations = existing.Destinations
+			cfg.Ordering = existing.Ordering
+			cfg.AddMetadata = existing.AddMetadata
+			cfg.Allowlist = existing.Allowlist
+			cfg.Erasure = existing.Erasure
+			cfg.LegalHold = existing.LegalHold
+			cfg.Prefixes = existing.Prefixes
+			cfg.Compression = existing.Compression
+			cfg.Multipart = existing.Multipart
+			cfg.Restore = existing.Restore
+			cfg.SourceMinio.TrustETag = existing.SourceMinio.TrustETag
+			cfg.DestMinio.TrustETag = existing.DestMinio.TrustETag
+			cfg.DestS3.TrustETag = existing.DestS3.TrustETag
+			cfg.DestGCS.TrustETag = existing.DestGCS.TrustETag
+			cfg.SourceMinio.Encryption = existing.SourceMinio.Encryption
+			cfg.DestMinio.Encryption = existing.DestMinio.Encryption
+			cfg.DestS3.Encryption = existing.DestS3.Encryption
+			cfg.DestLocal.Encryption = existing.DestLocal.Encryption
+			cfg.SourceMinio.Credentials = existing.SourceMinio.Credentials
+			cfg.DestMinio.Credentials = existing.DestMinio.Credentials
+			cfg.DestS3.Credentials = existing.DestS3.Credentials
+			cfg.DestLocal.Compress = existing.DestLocal.Compress
+			cfg.DestLocal.Pack = existing.DestLocal.Pack
+			cfg.DestLocal.FileMode = existing.DestLocal.FileMode
+			cfg.DestLocal.Owner = existing.DestLocal.Owner
+			cfg.StateBackend = existing.StateBackend
+			cfg.Distributed = existing.Distributed
+		}
+
 		fileConfig.SetProjectConfig(*projectName, *cfg)
 		if err := config.SaveConfig(projectsDir, fileConfig); err != nil {
 			log.Fatalf("Failed to save config: %v", err)
@@ -239,45 +1034,179 @@ func main() {
 
 	// Set database path
 	cfg.DatabasePath = filepath.Join(projectDir, "files.db")
+	if *planFile == "" {
+		*planFile = filepath.Join(projectDir, "plan.json")
+	}
+	cfg.ListingCachePath = filepath.Join(projectsDir, "listing-cache.db")
+	cfg.EndpointSlotsPath = filepath.Join(projectsDir, "endpoint-slots.db")
 
-	// Debug config
-	log.Printf("Debug: Project config: %+v", cfg)
-	log.Printf("Debug: Source Minio config: %+v", cfg.SourceMinio)
-	if cfg.DestType == config.DestinationMinio {
-		log.Printf("Debug: Destination Minio config: %+v", cfg.DestMinio)
-	} else {
-		log.Printf("Debug: Destination Local config: %+v", cfg.DestLocal)
+	// Apply the project tuning defaults
+	if !setFlags["workers"] && cfg.Tuning.Workers > 0 {
+		*workers = cfg.Tuning.Workers
+	}
+	// Without either, copying commands use the profiles of past runs
+	workersSet := setFlags["workers"] || cfg.Tuning.Workers > 0
+	if !setFlags["skip-existing"] {
+		*skipExisting = cfg.Tuning.SkipExisting
+	}
+	if !setFlags["skip-existing-by-listing"] {
+		*existingFromListing = cfg.Tuning.SkipExistingByListing
+	}
+	if setFlags["bandwidth-limit"] {
+		cfg.Tuning.BandwidthLimit = *bandwidthLimit
+	}
+	if setFlags["request-retries"] {
+		cfg.Tuning.Retries = *requestRetries
+	}
+	if setFlags["request-retry-interval"] {
+		cfg.Tuning.RetryInterval = *requestRetryInterval
+	}
+	if setFlags["max-retries"] {
+		cfg.Tuning.MaxRetries = *maxRetries
+	}
+	if setFlags["retry-backoff"] {
+		cfg.Tuning.RetryBackoff = *retryBackoff
+	}
+	if setFlags["pace-latency"] {
+		cfg.Tuning.PaceLatency = *paceLatency
+	}
+	if setFlags["nice"] {
+		cfg.Tuning.Nice = *nice
+	}
+	if setFlags["ionice"] {
+		cfg.Tuning.IOPriority = *ioPriority
+	}
+	if setFlags["disk-write-limit"] {
+		cfg.Tuning.DiskWriteLimit = *diskWriteLimit
+	}
+	if setFlags["checksum"] {
+		cfg.Tuning.Checksum = *checksum
+	}
+	if setFlags["overwrite"] {
+		cfg.Tuning.Overwrite = config.OverwritePolicy(*overwrite)
+	}
+	if setFlags["chunk-size"] {
+		cfg.Tuning.ChunkSize = *chunkSize
+	}
+	if setFlags["chunk-concurrency"] {
+		cfg.Tuning.ChunkConcurrency = *chunkConcurrency
+	}
+	// Backup jobs on shared hosts stay out of the way of other services
+	if err := sync.SetPriority(cfg.Tuning.Nice, cfg.Tuning.IOPriority); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	if setFlags["server-side-copy"] {
+		cfg.ServerSideCopy = serverSideCopy
+	}
+	if setFlags["include"] {
+		cfg.Include = include
+	}
+	if setFlags["exclude"] {
+		cfg.Exclude = exclude
+	}
+	if setFlags["min-size"] {
+		cfg.MinSize = *minSize
+	}
+	if setFlags["max-size"] {
+		cfg.MaxSize = *maxSize
+	}
+	if setFlags["newer-than"] {
+		cfg.NewerThan = *newerThan
+	}
+	if setFlags["older-than"] {
+		cfg.OlderThan = *olderThan
+	}
+
+	// Listing options shared by update-list and run
+	listOpts := sync.ListOptions{
+		Depth:          *depth,
+		CacheMaxAge:    *listingCacheTTL,
+		Listers:        *listers,
+		ShardDepth:     *shardDepth,
+		ShardDelimiter: *shardDelimiter,
+		BatchSize:      *listBatchSize,
+		FlushInterval:  *listFlushEvery,
+	}
+	if !*recursive && listOpts.Depth == 0 {
+		listOpts.Depth = 1
+	}
+	if listOpts.SampleRate, err = sync.ParseSampleRate(*sample); err != nil {
+		log.Fatalf("Invalid sample option: %v", err)
+	}
+	if listOpts.ModifiedAfter, err = sync.ParseListTime(*modifiedAfter); err != nil {
+		log.Fatalf("Invalid modified-after option: %v", err)
+	}
+	if listOpts.ModifiedBefore, err = sync.ParseListTime(*modifiedBefore); err != nil {
+		log.Fatalf("Invalid modified-before option: %v", err)
+	}
+	if !listOpts.ModifiedAfter.IsZero() && !listOpts.ModifiedBefore.IsZero() && !listOpts.ModifiedBefore.After(listOpts.ModifiedAfter) {
+		log.Fatalf("-modified-before must be after -modified-after")
 	}
 
 	// Execute command
 	switch *command {
 	case "update-list":
-		fmt.Println("Updating source file list...")
 		syncService, err := sync.NewService(cfg)
 		if err != nil {
 			log.Fatalf("Failed to create sync service: %v", err)
 		}
 		defer syncService.Close()
 
-		if err := syncService.UpdateSourceList(context.Background()); err != nil {
+		if *listDryRun {
+			preview, err := syncService.PreviewSourceList(context.Background(), listOpts)
+			if preview != nil {
+				printListPreview(preview)
+			}
+			if err != nil {
+				log.Fatalf("Failed to preview source file list: %v", err)
+			}
+			break
+		}
+		fmt.Println("Updating source file list...")
+		if err := syncService.UpdateSourceList(context.Background(), listOpts); err != nil {
 			log.Fatalf("Failed to update source file list: %v", err)
 		}
 		fmt.Println("Source file list updated successfully")
 
 	case "sync":
-		fmt.Printf("Starting sync with %d workers...\n", *workers)
 		syncService, err := sync.NewService(cfg)
 		if err != nil {
 			log.Fatalf("Failed to create sync service: %v", err)
 		}
 		defer syncService.Close()
 
-		if err := syncService.StartSync(context.Background(), *workers); err != nil {
+		if !workersSet {
+			*workers = syncService.SuggestedWorkers(*workers)
+		}
+		fmt.Printf("Starting sync with %d workers...\n", *workers)
+
+		opts := sync.SyncOptions{
+			Workers:             *workers,
+			MaxDuration:         *maxDuration,
+			SkipExisting:        *skipExisting,
+			Force:               *force,
+			ExistingFromListing: *existingFromListing,
+			Delta:               *delta,
+			StallTimeout:        *stallTimeout,
+			StallDump:           *stallDump,
+			Mirror:              *mirror,
+			MirrorDryRun:        *mirrorDryRun,
+		}
+		result, err := syncService.StartSync(context.Background(), opts)
+		printSyncResult(result)
+		if err != nil {
+			if errors.Is(err, sync.ErrAlertAbort) {
+				log.Printf("Failed to sync files: %v", err)
+				os.Exit(exitAlertAbort)
+			}
 			log.Fatalf("Failed to sync files: %v", err)
 		}
+		if result.Failed > 0 || result.DestinationErrors > 0 || (result.Mirror != nil && result.Mirror.Failed > 0) {
+			os.Exit(exitSyncErrors)
+		}
 
 	case "status":
-		syncService, err := sync.NewService(cfg)
+		syncService, err := sync.NewStatusService(cfg)
 		if err != nil {
 			log.Fatalf("Failed to create sync service: %v", err)
 		}
@@ -289,6 +1218,19 @@ func main() {
 		}
 		printStatus(status)
 
+	case "ctl":
+		syncService, err := sync.NewStatusService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		status, err := syncService.SendControl(*ctlCommand)
+		if err != nil {
+			log.Fatalf("Failed to control daemon: %v", err)
+		}
+		printDaemonStatus(status)
+
 	case "import-list":
 		if *importFile == "" {
 			log.Fatal("Import file path is required for import-list command")
@@ -314,10 +1256,403 @@ func main() {
 		defer syncService.Close()
 
 		// Import file list
-		if err := syncService.ImportFileList(context.Background(), []string{importPath}); err != nil {
+		importOpts := sync.ImportOptions{
+			BatchSize:   *listBatchSize,
+			Restart:     *restartImport,
+			Format:      *importFormat,
+			URLDecode:   *urlDecodeKeys,
+			StripPrefix: *stripPrefix,
+			CheckSample: *importCheck,
+		}
+		if err := syncService.ImportFileList(context.Background(), []string{importPath}, importOpts); err != nil {
 			log.Fatalf("Failed to import file list: %v", err)
 		}
 
+	case "run":
+		syncService, err := sync.NewService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		if !workersSet {
+			*workers = syncService.SuggestedWorkers(*workers)
+		}
+
+		stop, ctx := shutdownSignals()
+		opts := sync.RunOptions{
+			Workers:             *workers,
+			Interval:            *interval,
+			ExitWhenSynced:      *exitWhenSynced,
+			Watch:               *watch,
+			List:                listOpts,
+			SkipExisting:        *skipExisting,
+			Force:               *force,
+			ExistingFromListing: *existingFromListing,
+			Delta:               *delta,
+			StallTimeout:        *stallTimeout,
+			StallDump:           *stallDump,
+			Stop:                stop,
+		}
+		// An explicit -interval replaces the schedule of the project
+		if !setFlags["interval"] {
+			opts.Schedule = cfg.Schedule
+		}
+		if err := syncService.Run(ctx, opts); err != nil {
+			if errors.Is(err, sync.ErrAlertAbort) {
+				log.Printf("Failed to run daemon: %v", err)
+				os.Exit(exitAlertAbort)
+			}
+			log.Fatalf("Failed to run daemon: %v", err)
+		}
+
+	case "verify":
+		fmt.Printf("Verifying completed files with %d workers...\n", *workers)
+		syncService, err := sync.NewService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		opts := sync.VerifyOptions{
+			Workers:    *workers,
+			Reverify:   *reverify,
+			Repair:     *repair,
+			MaxRepairs: *maxRepairs,
+			Deep:       *deepVerify,
+			Requeue:    *requeue,
+		}
+		result, err := syncService.Verify(context.Background(), opts)
+		if err != nil {
+			log.Fatalf("Failed to verify files: %v", err)
+		}
+		fmt.Printf("Verified: %d (repaired: %d), Corrupt: %d, Requeued: %d, Failed: %d, Left for retry: %d\n",
+			result.Verified, result.Repaired, result.Corrupt, result.Requeued, result.Failed, result.Retry)
+		if result.Corrupt > 0 {
+			fmt.Println("Run the corrupt-report command to list the quarantined files")
+		}
+
+	case "corrupt-report":
+		syncService, err := sync.NewService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		files, err := syncService.GetCorruptFiles()
+		if err != nil {
+			log.Fatalf("Failed to get corrupt files: %v", err)
+		}
+		printCorruptReport(files)
+
+	case "requeue":
+		if *modifiedBetween == "" || modifiedUntil == "" {
+			log.Fatal("Time range is required for requeue command (-modified-between 2024-05-01 2024-05-03)")
+		}
+		from, until, err := sync.ParseTimeRange(*modifiedBetween, modifiedUntil)
+		if err != nil {
+			log.Fatalf("Invalid modified-between option: %v", err)
+		}
+		syncService, err := sync.NewService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		requeued, err := syncService.RequeueModified(from, until)
+		if err != nil {
+			log.Fatalf("Failed to requeue files: %v", err)
+		}
+		fmt.Printf("Requeued %d completed files last modified from %s until %s, run sync to copy them again\n",
+			requeued, from.Format(time.RFC3339), until.Format(time.RFC3339))
+
+	case "retry":
+		syncService, err := sync.NewService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		retried, err := syncService.RetryFailed(*retryPrefix, *errorMatch)
+		if err != nil {
+			log.Fatalf("Failed to retry files: %v", err)
+		}
+		fmt.Printf("Set %d failed files back to pending, run sync to copy them\n", retried)
+
+	case "reset":
+		if *resetStatuses == "" && !*resetAll && *resetBefore == "" {
+			log.Fatal("Select the files to reset with -statuses or -before, or all files with -all")
+		}
+		statuses, err := sync.ParseStatuses(*resetStatuses)
+		if err != nil {
+			log.Fatalf("Invalid statuses option: %v", err)
+		}
+		before, err := sync.ParseListTime(*resetBefore)
+		if err != nil {
+			log.Fatalf("Invalid before option: %v", err)
+		}
+		syncService, err := sync.NewService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		opts := sync.ResetOptions{Statuses: statuses, Before: before, All: *resetAll}
+		reset, err := syncService.Reset(context.Background(), opts)
+		if err != nil {
+			log.Fatalf("Failed to reset files: %v", err)
+		}
+		if opts.All {
+			fmt.Printf("Deleted %d files from the database, run update-list to list them again\n", reset)
+		} else {
+			fmt.Printf("Set %d files back to pending, run sync to copy them again\n", reset)
+		}
+
+	case "files":
+		statuses, err := sync.ParseStatuses(*resetStatuses)
+		if err != nil {
+			log.Fatalf("Invalid statuses option: %v", err)
+		}
+		syncService, err := sync.NewStatusService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		files, err := syncService.ListFiles(statuses, *pathPrefix, *errorHistory)
+		if err != nil {
+			log.Fatalf("Failed to list files: %v", err)
+		}
+		printFiles(files, *errorHistory)
+
+	case "plan":
+		syncService, err := sync.NewService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		plan, err := syncService.Plan(context.Background(), *workers)
+		if err != nil {
+			log.Fatalf("Failed to plan sync: %v", err)
+		}
+		if err := plan.Save(*planFile); err != nil {
+			log.Fatalf("Failed to save plan: %v", err)
+		}
+		printPlan(plan)
+		fmt.Printf("\nPlan saved to %s, run the apply command to execute it\n", *planFile)
+
+	case "apply":
+		plan, err := sync.LoadPlan(*planFile)
+		if err != nil {
+			log.Fatalf("Failed to load plan: %v", err)
+		}
+
+		syncService, err := sync.NewService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		if !workersSet {
+			*workers = syncService.SuggestedWorkers(*workers)
+		}
+		opts := sync.SyncOptions{
+			Workers:      *workers,
+			MaxDuration:  *maxDuration,
+			Delta:        *delta,
+			StallTimeout: *stallTimeout,
+			StallDump:    *stallDump,
+		}
+		result, err := syncService.ApplyPlan(context.Background(), plan, opts)
+		printSyncResult(result)
+		if err != nil {
+			if errors.Is(err, sync.ErrAlertAbort) {
+				log.Printf("Failed to apply plan: %v", err)
+				os.Exit(exitAlertAbort)
+			}
+			log.Fatalf("Failed to apply plan: %v", err)
+		}
+		if result.Failed > 0 {
+			os.Exit(exitSyncErrors)
+		}
+
+	case "catalog":
+		if *catalogOutput == "" {
+			*catalogOutput = filepath.Join(projectDir, "catalog-"+*catalogSide+".csv")
+		}
+
+		syncService, err := sync.NewService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		out, err := os.Create(*catalogOutput)
+		if err != nil {
+			log.Fatalf("Failed to create catalog file: %v", err)
+		}
+		defer out.Close()
+
+		count, err := syncService.ExportCatalog(context.Background(), *catalogSide, out)
+		if err != nil {
+			log.Fatalf("Failed to export catalog: %v", err)
+		}
+		fmt.Printf("Exported %d objects to %s\n", count, *catalogOutput)
+
+	case "bisync":
+		fmt.Printf("Starting bidirectional sync with %d workers...\n", *workers)
+		syncService, err := sync.NewService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		result, err := syncService.Bisync(context.Background(), sync.BisyncOptions{Workers: *workers})
+		if result != nil {
+			fmt.Printf("Copied to destination: %d, Copied to source: %d, Conflicts: %d (skipped: %d), Failed: %d\n",
+				result.ToDest, result.ToSource, result.Conflicts, result.Skipped, result.Failed)
+		}
+		if err != nil {
+			log.Fatalf("Failed to run bidirectional sync: %v", err)
+		}
+
+	case "inventory":
+		syncService, err := sync.NewService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		run, err := syncService.Inventory(context.Background(), listOpts)
+		if run != nil {
+			printInventoryRuns([]*db.InventoryRun{run})
+		}
+		if err != nil {
+			log.Fatalf("Failed to take inventory: %v", err)
+		}
+
+	case "inventory-report":
+		syncService, err := sync.NewStatusService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		if *inventoryRun == 0 {
+			runs, err := syncService.GetInventoryRuns(*inventoryRuns)
+			if err != nil {
+				log.Fatalf("Failed to get inventory runs: %v", err)
+			}
+			printInventoryRuns(runs)
+			break
+		}
+		changes, err := syncService.GetInventoryChanges(*inventoryRun)
+		if err != nil {
+			log.Fatalf("Failed to get inventory changes: %v", err)
+		}
+		printInventoryChanges(changes)
+
+	case "find-extras":
+		if *extrasOutput == "" {
+			*extrasOutput = filepath.Join(projectDir, "extras.csv")
+		}
+
+		syncService, err := sync.NewService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		report, err := syncService.FindExtras(context.Background(), sharedProjects(fileConfig, cfg))
+		if err != nil {
+			log.Fatalf("Failed to find extraneous objects: %v", err)
+		}
+		out, err := os.Create(*extrasOutput)
+		if err != nil {
+			log.Fatalf("Failed to create extras file: %v", err)
+		}
+		defer out.Close()
+		if err := report.WriteCSV(out); err != nil {
+			log.Fatalf("Failed to write extras file: %v", err)
+		}
+		printExtras(report, *extrasOutput)
+
+	case "decrypt-local":
+		if cfg.DestType != config.DestinationLocal {
+			log.Fatal("decrypt-local only works for projects with a local destination")
+		}
+		if *decryptOutput == "" {
+			log.Fatal("Output directory is required for decrypt-local command (-decrypt-output)")
+		}
+		storage, err := local.NewStorage(&cfg.DestLocal, cfg.SourceMinio.FolderPath)
+		if err != nil {
+			log.Fatalf("Failed to open local destination: %v", err)
+		}
+		files, err := storage.DecryptTo(*decryptOutput, *decryptPrefix)
+		if err != nil {
+			log.Fatalf("Failed to decrypt files: %v", err)
+		}
+		fmt.Printf("Decrypted %d files to %s\n", files, *decryptOutput)
+
+	case "extract":
+		if *extractOutput == "" {
+			log.Fatal("Output directory is required for extract command (-extract-output)")
+		}
+		syncService, err := sync.NewService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		files, err := syncService.Extract(context.Background(), *extractPrefix, *extractOutput)
+		if err != nil {
+			log.Fatalf("Failed to extract files: %v", err)
+		}
+		fmt.Printf("Extracted %d files to %s\n", files, *extractOutput)
+
+	case "serve-local":
+		syncService, err := sync.NewService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if err := syncService.ServeLocal(ctx, *serveAddr); err != nil {
+			log.Fatalf("Failed to serve local copy: %v", err)
+		}
+
+	case "forget":
+		if *forgetKey == "" && !*forgetFeed {
+			log.Fatal("Key is required for forget command (-key), or read the erasure feed with -feed")
+		}
+		syncService, err := sync.NewService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create sync service: %v", err)
+		}
+		defer syncService.Close()
+
+		ctx := context.Background()
+		if *forgetKey != "" {
+			if err := syncService.Forget(ctx, *forgetKey, *forgetReason); err != nil {
+				log.Fatalf("Failed to erase %s, the next sync attempts it again: %v", *forgetKey, err)
+			}
+			fmt.Printf("Erased %s, it won't be copied again\n", *forgetKey)
+		}
+		if *forgetFeed {
+			read, err := syncService.ReadErasureFeed(ctx)
+			if err != nil {
+				log.Fatalf("Failed to read erasure feed: %v", err)
+			}
+			erased, failed, err := syncService.EraseTombstones(ctx)
+			if err != nil {
+				log.Fatalf("Failed to erase keys: %v", err)
+			}
+			fmt.Printf("Read %d erasure requests, erased %d keys, %d left for the next sync\n", read, erased, failed)
+		}
+
 	default:
 		log.Fatalf("Unknown command: %s", *command)
 	}
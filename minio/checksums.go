@@ -0,0 +1,67 @@
+package minio
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// StatChecksums stats an object including the checksums of the whole object
+// stored by the server, keyed by algorithm (CRC32, CRC32C, SHA1, SHA256).
+// Objects uploaded without a checksum have none. Checksums of multipart
+// uploads are computed from the part checksums, which depend on the part
+// size, and are left out.
+func (m *MinioClient) StatChecksums(ctx context.Context, objectPath string) (*ObjectInfo, error) {
+	opts := m.readOptions()
+	opts.Checksum = true
+
+	var info minio.ObjectInfo
+	err := m.withRetry(ctx, "StatObject", func() error {
+		var err error
+		info, err = m.client.StatObject(ctx, m.bucketName, objectPath, opts)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checksums of %s: %w", objectPath, err)
+	}
+
+	result := &ObjectInfo{
+		Key:          objectPath,
+		Size:         info.Size,
+		ETag:         m.objectETag(info.ETag, info.Size, info.LastModified),
+		LastModified: info.LastModified,
+		Checksums:    make(map[string]string),
+	}
+	for algorithm, value := range map[string]string{
+		"CRC32":  info.ChecksumCRC32,
+		"CRC32C": info.ChecksumCRC32C,
+		"SHA1":   info.ChecksumSHA1,
+		"SHA256": info.ChecksumSHA256,
+	} {
+		if value != "" && !strings.Contains(value, "-") {
+			result.Checksums[algorithm] = value
+		}
+	}
+	return result, nil
+}
+
+// HashObject downloads an object and returns the hex encoded MD5 checksum of
+// its content
+func (m *MinioClient) HashObject(ctx context.Context, objectPath string) (string, error) {
+	reader, err := m.GetObject(ctx, objectPath)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", fmt.Errorf("failed to read object %s: %w", objectPath, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
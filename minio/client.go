@@ -1,29 +1,71 @@
 package minio
 
 import (
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"maps"
 	"net"
+	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chmdznr/minio-simple-copier/v2/config"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/minio/minio-go/v7/pkg/tags"
 )
 
+// Default retry policy of requests, see SetRetryPolicy
 const (
-	maxRetries    = 3
-	retryInterval = 5 * time.Second
+	defaultMaxRetries    = 3
+	defaultRetryInterval = 5 * time.Second
+)
+
+// Metadata recording the original object of a gzip-compressed or multipart
+// copy
+const (
+	metaOriginalSize = "Msc-Original-Size"
+	metaOriginalETag = "Msc-Original-Etag"
+
+	// gzipPartSize bounds the memory used per compressed upload, whose size
+	// is unknown in advance; it allows compressed objects up to 640 GiB
+	gzipPartSize = 64 << 20
 )
 
 type MinioClient struct {
 	client     *minio.Client
+	endpoint   string
 	bucketName string
 	folderPath string
+
+	maxRetries    int
+	retryInterval time.Duration
+	// etagMode is how ETags of the server are used, see DistrustETags
+	etagMode int
+	// latency measures the requests of the client, see ObserveLatency
+	latency *LatencyTransport
+	// aclUnsupported is set once an ACL request failed, see objectACL
+	aclUnsupported atomic.Bool
+
+	// encryption is the default encryption of the bucket, see bucketEncryption
+	encryptionOnce sync.Once
+	encryption     encrypt.ServerSide
+	// customerKey is the configured SSE-C key objects are read with, see
+	// UseEncryption
+	customerKey encrypt.ServerSide
+	// creds are loaded again when a request is denied, nil for static keys,
+	// see UseCredentials
+	creds *credentials.Credentials
 }
 
 type ObjectInfo struct {
@@ -31,61 +73,407 @@ type ObjectInfo struct {
 	Size         int64
 	ETag         string
 	LastModified time.Time
+	// Compressed is set for copies stored by PutObjectGzip; Size and ETag
+	// then describe the original object
+	Compressed bool
+	// StorageClass, Metadata and Tags are only filled by listings with metadata
+	StorageClass string
+	Metadata     map[string]string
+	Tags         map[string]string
+	// ReplicationStatus is the bucket replication status MinIO reports, e.g.
+	// COMPLETED or FAILED. It is filled by StatObject and by listings with
+	// metadata, and empty for objects that aren't replicated.
+	ReplicationStatus string
+	// Checksums is only filled by StatChecksums
+	Checksums map[string]string
+	// VersionID, IsLatest and IsDeleteMarker are only filled by ListVersions
+	VersionID      string
+	IsLatest       bool
+	IsDeleteMarker bool
 }
 
 func NewMinioClient(cfg *config.MinioConfig) (*MinioClient, error) {
+	transport, err := minio.DefaultTransport(cfg.UseSSL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create minio transport: %w", err)
+	}
+	latency := NewLatencyTransport(transport)
+
+	creds, err := NewCredentials(cfg.Credentials, cfg.AccessKeyID, cfg.SecretAccessKey, "", EndpointURL(cfg.Endpoint, cfg.UseSSL), "")
+	if err != nil {
+		return nil, err
+	}
+
 	// Initialize minio client
 	client, err := minio.New(cfg.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
-		Secure: cfg.UseSSL,
+		Creds:     creds,
+		Secure:    cfg.UseSSL,
+		Transport: NewRetryAfterTransport(latency),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create minio client: %w", err)
 	}
 
+	m := WrapClient(client, latency, cfg.Endpoint, cfg.BucketName, cfg.FolderPath)
+	if cfg.Credentials != nil {
+		m.UseCredentials(creds)
+	}
+	if err := m.UseEncryption(cfg.Encryption); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WrapClient returns a MinioClient using an already configured client, for
+// S3-compatible services that need options beyond MinioConfig. latency is
+// the transport of the client, or nil if its latency isn't measured.
+func WrapClient(client *minio.Client, latency *LatencyTransport, endpoint, bucketName, folderPath string) *MinioClient {
 	return &MinioClient{
-		client:     client,
-		bucketName: cfg.BucketName,
-		folderPath: cfg.FolderPath,
-	}, nil
+		client:        client,
+		endpoint:      endpoint,
+		bucketName:    bucketName,
+		folderPath:    folderPath,
+		maxRetries:    defaultMaxRetries,
+		retryInterval: defaultRetryInterval,
+		latency:       latency,
+	}
+}
+
+// SetRetryPolicy sets how often retryable requests are attempted and the
+// pause between attempts. Zero values keep the current setting.
+func (m *MinioClient) SetRetryPolicy(attempts int, interval time.Duration) {
+	if attempts > 0 {
+		m.maxRetries = attempts
+	}
+	if interval > 0 {
+		m.retryInterval = interval
+	}
 }
 
 func (m *MinioClient) GetFolderPath() string {
 	return m.folderPath
 }
 
-func (m *MinioClient) ListObjects(ctx context.Context) ([]ObjectInfo, error) {
-	log.Printf("Debug: Listing objects in bucket %s with prefix %s", m.bucketName, m.folderPath)
+func (m *MinioClient) GetEndpoint() string {
+	return m.endpoint
+}
+
+func (m *MinioClient) GetBucketName() string {
+	return m.bucketName
+}
+
+// ListingError reports a listing that failed permanently after retries.
+// Objects up to and including LastKey were already delivered.
+type ListingError struct {
+	Prefix  string
+	LastKey string
+	Err     error
+}
+
+func (e *ListingError) Error() string {
+	if e.LastKey == "" {
+		return fmt.Sprintf("prefix %q remains unlisted: %v", e.Prefix, e.Err)
+	}
+	return fmt.Sprintf("keys after %q under prefix %q remain unlisted: %v", e.LastKey, e.Prefix, e.Err)
+}
+
+func (e *ListingError) Unwrap() error {
+	return e.Err
+}
+
+// ListOptions controls how ListObjects walks the folder
+type ListOptions struct {
+	// Depth limits how many folder levels below the folder are listed using a
+	// delimiter. Zero lists recursively; 1 lists only the immediate objects.
+	Depth int
+	// Listers lists the shards of a recursive listing concurrently. fn is
+	// then called from several goroutines.
+	Listers int
+	// ShardDepth is the number of delimiter levels below the folder whose
+	// prefixes are the shards of a concurrent listing, default 1
+	ShardDepth int
+	// Delimiter separates the levels of the shards, default "/"
+	Delimiter string
+	// WithMetadata includes user metadata, tags and the replication status
+	WithMetadata bool
+}
+
+// UnlistedRanges returns every ListingError contained in err
+func UnlistedRanges(err error) []*ListingError {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var ranges []*ListingError
+		for _, e := range joined.Unwrap() {
+			ranges = append(ranges, UnlistedRanges(e)...)
+		}
+		return ranges
+	}
+
+	var listErr *ListingError
+	if errors.As(err, &listErr) {
+		return []*ListingError{listErr}
+	}
+	return nil
+}
+
+// ListObjects streams all objects under the configured folder to fn. When the
+// listing fails partway it is resumed after the last listed key, giving up
+// after m.maxRetries consecutive failures without progress.
+func (m *MinioClient) ListObjects(ctx context.Context, opts ListOptions, fn func(ObjectInfo) error) error {
+	log.Printf("Debug: Listing objects in bucket %s with prefix %s (depth: %d)", m.bucketName, m.folderPath, opts.Depth)
+
+	if opts.Depth <= 0 && opts.Listers <= 1 {
+		return m.listPrefix(ctx, m.folderPath, true, opts.WithMetadata, fn, nil)
+	}
+
+	root := m.folderPath
+	if root != "" && !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+	if opts.Depth <= 0 {
+		return m.listSharded(ctx, root, opts, fn)
+	}
+	return m.walkPrefix(ctx, root, opts.Depth, opts.WithMetadata, fn)
+}
+
+// ListPrefix lists every object below prefix, regardless of the configured
+// folder path. withMetadata includes user metadata and tags, which is a
+// MinIO extension of the listing API.
+func (m *MinioClient) ListPrefix(ctx context.Context, prefix string, withMetadata bool, fn func(ObjectInfo) error) error {
+	log.Printf("Debug: Listing objects in bucket %s with prefix %s", m.bucketName, prefix)
+	return m.listPrefix(ctx, prefix, true, withMetadata, fn, nil)
+}
+
+// ListBucket streams every object in the bucket to fn, ignoring the folder
+func (m *MinioClient) ListBucket(ctx context.Context, fn func(ObjectInfo) error) error {
+	log.Printf("Debug: Listing all objects in bucket %s", m.bucketName)
+	return m.listPrefix(ctx, "", true, false, fn, nil)
+}
+
+// ListVersions streams every version of the objects below prefix to fn,
+// including delete markers, newest first per key. User metadata and tags are
+// requested with the MinIO listing extension and are nil for servers that
+// don't list them. A listing that fails partway is listed again, skipping
+// the versions already listed, with the retry policy of listPrefix.
+func (m *MinioClient) ListVersions(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	log.Printf("Debug: Listing object versions in bucket %s with prefix %s", m.bucketName, prefix)
+
+	var lastKey, lastVersionID string
+	failures := 0
+	for {
+		progressed, err := m.listVersionsAfter(ctx, prefix, lastKey, lastVersionID, func(object minio.ObjectInfo) error {
+			lastKey, lastVersionID = object.Key, object.VersionID
+			if strings.HasSuffix(object.Key, "/") {
+				return nil
+			}
+
+			info := ObjectInfo{
+				Key:            object.Key,
+				Size:           object.Size,
+				LastModified:   object.LastModified,
+				StorageClass:   object.StorageClass,
+				Metadata:       object.UserMetadata,
+				Tags:           object.UserTags,
+				VersionID:      object.VersionID,
+				IsLatest:       object.IsLatest,
+				IsDeleteMarker: object.IsDeleteMarker,
+			}
+			// Delete markers have no content to describe
+			if !object.IsDeleteMarker {
+				info.ETag = m.objectETag(object.ETag, object.Size, object.LastModified)
+			}
+			return fn(info)
+		})
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+
+		var listErr *listObjectsError
+		if !errors.As(err, &listErr) {
+			return err
+		}
+
+		if progressed {
+			failures = 0
+		}
+		failures++
+		if failures >= m.maxRetries {
+			return &ListingError{Prefix: prefix, LastKey: lastKey, Err: listErr.err}
+		}
+
+		log.Printf("Retrying version listing of %s after %q (attempt %d/%d) after error: %v", prefix, lastKey, failures+1, m.maxRetries, listErr.err)
+		if sleep(ctx, m.retryInterval) != nil {
+			return err
+		}
+	}
+}
+
+// listVersionsAfter lists the versions below prefix that follow version
+// lastVersionID of lastKey. The versions API has no start marker in the
+// client, so the versions before it are listed again and skipped.
+func (m *MinioClient) listVersionsAfter(ctx context.Context, prefix, lastKey, lastVersionID string, fn func(minio.ObjectInfo) error) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// Create done channel to control the listing
 	objectCh := m.client.ListObjects(ctx, m.bucketName, minio.ListObjectsOptions{
-		Prefix:    m.folderPath,
-		Recursive: true,
+		Prefix:       prefix,
+		Recursive:    true,
+		WithVersions: true,
+		WithMetadata: true,
 	})
 
-	var objects []ObjectInfo
+	skipping := lastKey != ""
+	progressed := false
 	for object := range objectCh {
 		if object.Err != nil {
-			return nil, fmt.Errorf("error listing objects: %w", object.Err)
+			return progressed, &listObjectsError{err: object.Err}
 		}
+		if skipping {
+			switch {
+			case object.Key < lastKey:
+				continue
+			case object.Key == lastKey:
+				// Versions of a key are listed newest first, so the ones up
+				// to the last listed one were seen already
+				if object.VersionID == lastVersionID {
+					skipping = false
+				}
+				continue
+			}
+			skipping = false
+		}
+		progressed = true
 
-		// Skip folders
-		if strings.HasSuffix(object.Key, "/") {
-			continue
+		if err := fn(object); err != nil {
+			return progressed, err
+		}
+	}
+
+	return progressed, nil
+}
+
+// walkPrefix lists prefix with a delimiter and descends into sub-prefixes
+// until depth is exhausted. Prefixes that fail to list are reported together
+// without stopping the walk.
+func (m *MinioClient) walkPrefix(ctx context.Context, prefix string, depth int, withMetadata bool, fn func(ObjectInfo) error) error {
+	var subPrefixes []string
+	err := m.listPrefix(ctx, prefix, false, withMetadata, fn, func(subPrefix string) {
+		if depth > 1 {
+			subPrefixes = append(subPrefixes, subPrefix)
+		} else {
+			log.Printf("Debug: Skipping sub-prefix %s (depth limit reached)", subPrefix)
+		}
+	})
+	if err != nil && len(UnlistedRanges(err)) == 0 {
+		return err
+	}
+
+	errs := []error{err}
+	for _, subPrefix := range subPrefixes {
+		subErr := m.walkPrefix(ctx, subPrefix, depth-1, withMetadata, fn)
+		if subErr != nil && len(UnlistedRanges(subErr)) == 0 {
+			return subErr
 		}
+		errs = append(errs, subErr)
+	}
+	return errors.Join(errs...)
+}
+
+// listPrefix lists a single prefix with retries. Objects go to fn; when not
+// recursive, common prefixes go to onPrefix.
+func (m *MinioClient) listPrefix(ctx context.Context, prefix string, recursive, withMetadata bool, fn func(ObjectInfo) error, onPrefix func(string)) error {
+	var lastKey string
+	failures := 0
+	for {
+		progressed, err := m.listFrom(ctx, prefix, recursive, withMetadata, lastKey, func(object minio.ObjectInfo) error {
+			lastKey = object.Key
+
+			// Folders: either a common prefix or a folder marker object
+			if strings.HasSuffix(object.Key, "/") {
+				if !recursive && object.Key != prefix && onPrefix != nil {
+					onPrefix(object.Key)
+				}
+				return nil
+			}
 
-		// Keep the full path including folder structure
-		objects = append(objects, ObjectInfo{
-			Key:          object.Key,
-			Size:         object.Size,
-			ETag:         object.ETag,
-			LastModified: object.LastModified,
+			log.Printf("Debug: Found object: %s (size: %d, etag: %s)", object.Key, object.Size, object.ETag)
+
+			// Keep the full path including folder structure
+			return fn(ObjectInfo{
+				Key:          object.Key,
+				Size:         object.Size,
+				ETag:         m.objectETag(object.ETag, object.Size, object.LastModified),
+				LastModified: object.LastModified,
+				StorageClass: object.StorageClass,
+				Metadata:     object.UserMetadata,
+				Tags:         object.UserTags,
+				// MinIO lists the replication status as metadata
+				ReplicationStatus: replicationStatus(object),
+			})
 		})
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+
+		var listErr *listObjectsError
+		if !errors.As(err, &listErr) {
+			// Error returned by the callback, not by the listing itself
+			return err
+		}
+
+		if progressed {
+			failures = 0
+		}
+		failures++
+		if failures >= m.maxRetries {
+			return &ListingError{Prefix: prefix, LastKey: lastKey, Err: listErr.err}
+		}
+
+		log.Printf("Retrying listing of %s after %q (attempt %d/%d) after error: %v", prefix, lastKey, failures+1, m.maxRetries, listErr.err)
+		if sleep(ctx, m.retryInterval) != nil {
+			return err
+		}
+	}
+}
+
+// listObjectsError marks errors reported by the listing API
+type listObjectsError struct {
+	err error
+}
+
+func (e *listObjectsError) Error() string {
+	return fmt.Sprintf("error listing objects: %v", e.err)
+}
+
+func (m *MinioClient) listFrom(ctx context.Context, prefix string, recursive, withMetadata bool, startAfter string, fn func(minio.ObjectInfo) error) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objectCh := m.client.ListObjects(ctx, m.bucketName, minio.ListObjectsOptions{
+		Prefix:       prefix,
+		Recursive:    recursive,
+		StartAfter:   startAfter,
+		WithMetadata: withMetadata,
+	})
+
+	progressed := false
+	for object := range objectCh {
+		if object.Err != nil {
+			return progressed, &listObjectsError{err: object.Err}
+		}
+		progressed = true
 
-		log.Printf("Debug: Found object: %s (size: %d, etag: %s)", object.Key, object.Size, object.ETag)
+		if err := fn(object); err != nil {
+			return progressed, err
+		}
 	}
 
-	return objects, nil
+	return progressed, nil
 }
 
 func (m *MinioClient) GetObject(ctx context.Context, objectPath string) (io.ReadCloser, error) {
@@ -93,9 +481,9 @@ func (m *MinioClient) GetObject(ctx context.Context, objectPath string) (io.Read
 	log.Printf("Debug: Getting object: %s", objectPath)
 
 	var obj *minio.Object
-	err := m.withRetry("GetObject", func() error {
+	err := m.withRetry(ctx, "GetObject", func() error {
 		var err error
-		obj, err = m.client.GetObject(ctx, m.bucketName, objectPath, minio.GetObjectOptions{})
+		obj, err = m.client.GetObject(ctx, m.bucketName, objectPath, m.readOptions())
 		return err
 	})
 
@@ -106,13 +494,40 @@ func (m *MinioClient) GetObject(ctx context.Context, objectPath string) (io.Read
 	return obj, nil
 }
 
-func (m *MinioClient) PutObject(ctx context.Context, objectPath string, reader io.Reader, size int64) error {
+// GetObjectRange returns length bytes of an object starting at offset
+func (m *MinioClient) GetObjectRange(ctx context.Context, objectPath string, offset, length int64) (io.ReadCloser, error) {
+	log.Printf("Debug: Getting object range: %s (offset: %d, length: %d)", objectPath, offset, length)
+
+	opts := m.readOptions()
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, fmt.Errorf("invalid range for object %s: %w", objectPath, err)
+	}
+
+	var obj *minio.Object
+	err := m.withRetry(ctx, "GetObject", func() error {
+		var err error
+		obj, err = m.client.GetObject(ctx, m.bucketName, objectPath, opts)
+		return err
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %s: %w", objectPath, err)
+	}
+
+	return obj, nil
+}
+
+// PutObject stores the content of reader at objectPath, with the given
+// metadata if it isn't nil
+func (m *MinioClient) PutObject(ctx context.Context, objectPath string, reader io.Reader, size int64, metadata *ObjectMetadata) error {
 	// The objectPath should already include the full path
 	log.Printf("Debug: Putting object: %s (size: %d)", objectPath, size)
 
 	// Put object with retry
-	err := m.withRetry("PutObject", func() error {
-		_, err := m.client.PutObject(ctx, m.bucketName, objectPath, reader, size, minio.PutObjectOptions{})
+	opts := minio.PutObjectOptions{ServerSideEncryption: m.bucketEncryption(ctx)}
+	metadata.apply(&opts)
+	err := m.withRetry(ctx, "PutObject", func() error {
+		_, err := m.client.PutObject(ctx, m.bucketName, objectPath, reader, size, opts)
 		return err
 	})
 
@@ -123,13 +538,156 @@ func (m *MinioClient) PutObject(ctx context.Context, objectPath string, reader i
 	return nil
 }
 
+// PutObjectGzip stores the gzip-compressed content of reader with
+// Content-Encoding: gzip, keeping the original size and ETag as metadata.
+// The stream cannot be replayed, so the upload is not retried.
+func (m *MinioClient) PutObjectGzip(ctx context.Context, objectPath string, reader io.Reader, size int64, etag string, metadata *ObjectMetadata) error {
+	log.Printf("Debug: Putting compressed object: %s (size: %d)", objectPath, size)
+
+	sse := m.bucketEncryption(ctx)
+	pr, pw := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, reader)
+		if err == nil {
+			err = gz.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	opts := minio.PutObjectOptions{
+		ContentEncoding:      "gzip",
+		PartSize:             gzipPartSize,
+		ServerSideEncryption: sse,
+		UserMetadata: map[string]string{
+			metaOriginalSize: strconv.FormatInt(size, 10),
+			metaOriginalETag: etag,
+		},
+	}
+	metadata.apply(&opts)
+	_, err := m.client.PutObject(ctx, m.bucketName, objectPath, pr, -1, opts)
+	// Unblock the compressor if the upload stopped reading
+	pr.CloseWithError(err)
+
+	if err != nil {
+		return fmt.Errorf("failed to put compressed object: %w", err)
+	}
+
+	return nil
+}
+
+// CopyObject copies an object to another key of the same bucket on the
+// server. Objects larger than 5 GiB are copied in parts.
+func (m *MinioClient) CopyObject(ctx context.Context, srcPath, dstPath string) error {
+	log.Printf("Debug: Copying object: %s -> %s", srcPath, dstPath)
+
+	dst := minio.CopyDestOptions{Bucket: m.bucketName, Object: dstPath, Encryption: m.bucketEncryption(ctx)}
+	err := m.withRetry(ctx, "ComposeObject", func() error {
+		_, err := m.client.ComposeObject(ctx, dst,
+			minio.CopySrcOptions{Bucket: m.bucketName, Object: srcPath, Encryption: m.customerKey},
+		)
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to copy object %s to %s: %w", srcPath, dstPath, err)
+	}
+
+	return nil
+}
+
+// CopyObjectFrom copies an object of another bucket on the same server to
+// dstPath, without its content passing through the copier. The credentials
+// of this client must be allowed to read the source bucket. Objects larger
+// than 5 GiB are copied in parts. Copies keep the user metadata of the
+// source, or get metadata instead if it isn't nil.
+func (m *MinioClient) CopyObjectFrom(ctx context.Context, source *MinioClient, srcPath, dstPath string, metadata *ObjectMetadata) error {
+	log.Printf("Debug: Copying object on the server: %s/%s -> %s/%s", source.bucketName, srcPath, m.bucketName, dstPath)
+
+	dst := minio.CopyDestOptions{Bucket: m.bucketName, Object: dstPath, Encryption: m.bucketEncryption(ctx)}
+	if metadata != nil {
+		dst.ReplaceMetadata, dst.UserMetadata = true, metadata.headers()
+		dst.ReplaceTags, dst.UserTags = true, metadata.Tags
+	}
+	err := m.withRetry(ctx, "ComposeObject", func() error {
+		_, err := m.client.ComposeObject(ctx, dst,
+			minio.CopySrcOptions{Bucket: source.bucketName, Object: srcPath, Encryption: source.customerKey},
+		)
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to copy object %s/%s to %s: %w", source.bucketName, srcPath, dstPath, err)
+	}
+
+	return nil
+}
+
+func (m *MinioClient) RemoveObject(ctx context.Context, objectPath string) error {
+	log.Printf("Debug: Removing object: %s", objectPath)
+
+	err := m.withRetry(ctx, "RemoveObject", func() error {
+		return m.client.RemoveObject(ctx, m.bucketName, objectPath, minio.RemoveObjectOptions{})
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to remove object %s: %w", objectPath, err)
+	}
+
+	return nil
+}
+
+// RestoreObject requests a temporary copy of an archived object that stays
+// readable for days, retrieved with the given tier (Standard, Bulk or
+// Expedited)
+func (m *MinioClient) RestoreObject(ctx context.Context, objectPath string, days int, tier string) error {
+	log.Printf("Debug: Requesting restore of object: %s (%d days, tier %s)", objectPath, days, tier)
+
+	var req minio.RestoreRequest
+	req.SetDays(days)
+	req.SetGlacierJobParameters(minio.GlacierJobParameters{Tier: minio.TierType(tier)})
+
+	err := m.withRetry(ctx, "RestoreObject", func() error {
+		return m.client.RestoreObject(ctx, m.bucketName, objectPath, "", req)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore object %s: %w", objectPath, err)
+	}
+	return nil
+}
+
+// SetBucketTags sets tags on the bucket, keeping the tags it has otherwise
+func (m *MinioClient) SetBucketTags(ctx context.Context, values map[string]string) error {
+	merged := make(map[string]string)
+	current, err := m.client.GetBucketTagging(ctx, m.bucketName)
+	switch {
+	case err == nil:
+		maps.Copy(merged, current.ToMap())
+	case ErrorCode(err) != "NoSuchTagSet":
+		return fmt.Errorf("failed to get tags of bucket %s: %w", m.bucketName, err)
+	}
+	maps.Copy(merged, values)
+
+	bucketTags, err := tags.NewTags(merged, false)
+	if err != nil {
+		return fmt.Errorf("invalid bucket tags: %w", err)
+	}
+	err = m.withRetry(ctx, "SetBucketTagging", func() error {
+		return m.client.SetBucketTagging(ctx, m.bucketName, bucketTags)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set tags of bucket %s: %w", m.bucketName, err)
+	}
+	return nil
+}
+
 func (m *MinioClient) StatObject(ctx context.Context, objectPath string) (*ObjectInfo, error) {
 	log.Printf("Debug: Getting object info: %s", objectPath)
 
 	var info minio.ObjectInfo
-	err := m.withRetry("StatObject", func() error {
+	err := m.withRetry(ctx, "StatObject", func() error {
 		var err error
-		info, err = m.client.StatObject(ctx, m.bucketName, objectPath, minio.StatObjectOptions{})
+		info, err = m.client.StatObject(ctx, m.bucketName, objectPath, m.readOptions())
 		return err
 	})
 
@@ -137,24 +695,118 @@ func (m *MinioClient) StatObject(ctx context.Context, objectPath string) (*Objec
 		return nil, fmt.Errorf("failed to get object info %s: %w", objectPath, err)
 	}
 
-	return &ObjectInfo{
-		Key:          info.Key,
-		Size:         info.Size,
-		ETag:         info.ETag,
-		LastModified: info.LastModified,
-	}, nil
+	result := &ObjectInfo{
+		Key:               info.Key,
+		Size:              info.Size,
+		ETag:              m.objectETag(info.ETag, info.Size, info.LastModified),
+		LastModified:      info.LastModified,
+		ReplicationStatus: info.ReplicationStatus,
+	}
+	if originalETag, ok := info.UserMetadata[metaOriginalETag]; ok {
+		result.ETag = originalETag
+	}
+	if originalSize, ok := info.UserMetadata[metaOriginalSize]; ok {
+		if size, err := strconv.ParseInt(originalSize, 10, 64); err == nil {
+			result.Size = size
+			result.Compressed = true
+		}
+	}
+	return result, nil
+}
+
+// IsNotFound reports whether err means the object does not exist, or the
+// file at a local destination
+func IsNotFound(err error) bool {
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) {
+		return resp.Code == "NoSuchKey" || resp.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// IsArchived reports whether a read failed because the object is in an
+// archive storage class and has to be restored first
+func IsArchived(err error) bool {
+	return ErrorCode(err) == "InvalidObjectState"
+}
+
+// IsRestoreInProgress reports whether a restore request failed because the
+// object is already being restored
+func IsRestoreInProgress(err error) bool {
+	return ErrorCode(err) == "RestoreAlreadyInProgress"
+}
+
+// IsAccessDenied reports whether a request was refused because of its
+// credentials or permissions, e.g. after they expired
+func IsAccessDenied(err error) bool {
+	var resp minio.ErrorResponse
+	if !errors.As(err, &resp) {
+		return false
+	}
+	switch resp.Code {
+	case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken", "InvalidToken", "AllAccessDisabled":
+		return true
+	}
+	return resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden
 }
 
-func (m *MinioClient) withRetry(operation string, fn func() error) error {
+// IsThrottled reports whether the server asked to slow down
+func IsThrottled(err error) bool {
+	var resp minio.ErrorResponse
+	if !errors.As(err, &resp) {
+		return false
+	}
+	switch resp.Code {
+	case "SlowDown", "ServiceUnavailable", "RequestLimitExceeded", "TooManyRequests":
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+}
+
+// sleep waits for d, or returns the error of ctx once it is done
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// ErrorCode returns the S3 error code of a failed request, e.g.
+// "AccessDenied", or an empty string if err is not an S3 error response
+func ErrorCode(err error) string {
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) {
+		return resp.Code
+	}
+	return ""
+}
+
+func (m *MinioClient) withRetry(ctx context.Context, operation string, fn func() error) error {
 	var lastErr error
-	for attempt := 0; attempt < maxRetries; attempt++ {
+	reloaded := false
+	for attempt := 0; attempt < m.maxRetries; attempt++ {
 		if attempt > 0 {
-			log.Printf("Retrying %s (attempt %d/%d) after error: %v", operation, attempt+1, maxRetries, lastErr)
-			time.Sleep(retryInterval)
+			log.Printf("Retrying %s (attempt %d/%d) after error: %v", operation, attempt+1, m.maxRetries, lastErr)
+			if err := sleep(ctx, m.retryInterval); err != nil {
+				return err
+			}
 		}
 
 		if err := fn(); err != nil {
 			lastErr = err
+			// Denied requests are tried once more with reloaded credentials
+			if !reloaded && m.reloadCredentials(err) {
+				reloaded = true
+				if err = fn(); err == nil {
+					return nil
+				}
+				lastErr = err
+			}
 			if !isRetryableError(err) {
 				return err
 			}
@@ -162,7 +814,7 @@ func (m *MinioClient) withRetry(operation string, fn func() error) error {
 		}
 		return nil
 	}
-	return fmt.Errorf("failed after %d retries: %w", maxRetries, lastErr)
+	return fmt.Errorf("failed after %d retries: %w", m.maxRetries, lastErr)
 }
 
 func isRetryableError(err error) bool {
@@ -0,0 +1,136 @@
+package minio
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// NewCredentials returns the credentials of an endpoint: the static keys, or
+// those loaded as configured by cfg. endpoint is the URL of the endpoint,
+// the default STS endpoint.
+func NewCredentials(cfg *config.CredentialsConfig, accessKey, secretKey, sessionToken, endpoint, region string) (*credentials.Credentials, error) {
+	if cfg == nil {
+		return credentials.NewStaticV4(accessKey, secretKey, sessionToken), nil
+	}
+
+	provider := &reloadingProvider{interval: cfg.RefreshInterval}
+	switch cfg.Source {
+	case config.CredentialsFile:
+		file := &credentials.FileAWSCredentials{Filename: cfg.File, Profile: cfg.Profile}
+		// The file provider reports keys without expiry as expired, so
+		// they are only loaded again when the file changes
+		provider.provider = file
+		provider.file = func() string { return file.Filename }
+		provider.name = "credentials file"
+	case config.CredentialsEnv:
+		provider.provider = &credentials.Chain{Providers: []credentials.Provider{&credentials.EnvAWS{}, &credentials.EnvMinio{}}}
+		provider.name = "environment"
+	case config.CredentialsSTS:
+		if accessKey == "" || secretKey == "" {
+			return nil, fmt.Errorf("STS credentials need the access key and secret key of the endpoint")
+		}
+		stsEndpoint := cfg.STSEndpoint
+		if stsEndpoint == "" {
+			stsEndpoint = endpoint
+		}
+		provider.provider = &credentials.STSAssumeRole{
+			Client:      &http.Client{Transport: http.DefaultTransport},
+			STSEndpoint: stsEndpoint,
+			Options: credentials.STSAssumeRoleOptions{
+				AccessKey:       accessKey,
+				SecretKey:       secretKey,
+				Location:        region,
+				DurationSeconds: int(cfg.Duration.Seconds()),
+				RoleARN:         cfg.RoleARN,
+				RoleSessionName: "minio-simple-copier",
+			},
+		}
+		provider.name = "STS " + stsEndpoint
+	default:
+		return nil, fmt.Errorf("invalid credentials source %q, must be %s, %s or %s", cfg.Source, config.CredentialsFile, config.CredentialsEnv, config.CredentialsSTS)
+	}
+	return credentials.New(provider), nil
+}
+
+// reloadingProvider loads the keys of provider again once they expire, at
+// the refresh interval and when the file they were read from changes. Keys
+// read from a file never expire otherwise.
+type reloadingProvider struct {
+	provider credentials.Provider
+	name     string
+	interval time.Duration
+	// file returns the file the keys are read from, nil for other sources
+	file func() string
+
+	mu       sync.Mutex
+	loadedAt time.Time
+	modTime  time.Time
+}
+
+func (p *reloadingProvider) Retrieve() (credentials.Value, error) {
+	value, err := p.provider.Retrieve()
+	if err != nil {
+		return value, fmt.Errorf("failed to load credentials from %s: %w", p.name, err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loadedAt = time.Now()
+	p.modTime = p.fileModTime()
+	log.Printf("Loaded credentials from %s", p.name)
+	return value, nil
+}
+
+func (p *reloadingProvider) IsExpired() bool {
+	if p.file == nil && p.provider.IsExpired() {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.interval > 0 && time.Since(p.loadedAt) >= p.interval {
+		return true
+	}
+	return p.file != nil && !p.fileModTime().Equal(p.modTime)
+}
+
+func (p *reloadingProvider) fileModTime() time.Time {
+	if p.file == nil {
+		return time.Time{}
+	}
+	info, err := os.Stat(p.file())
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// EndpointURL returns the URL of an endpoint given as host and port
+func EndpointURL(endpoint string, useSSL bool) string {
+	if useSSL {
+		return "https://" + endpoint
+	}
+	return "http://" + endpoint
+}
+
+// UseCredentials lets the client load creds again when a request is
+// denied, in case the keys were rotated since they were loaded
+func (m *MinioClient) UseCredentials(creds *credentials.Credentials) {
+	m.creds = creds
+}
+
+// reloadCredentials reports whether err may be fixed by loading the keys
+// again, and expires them if so
+func (m *MinioClient) reloadCredentials(err error) bool {
+	if m.creds == nil || !IsAccessDenied(err) {
+		return false
+	}
+	log.Printf("Access denied by %s, loading credentials again: %v", m.endpoint, err)
+	m.creds.Expire()
+	return true
+}
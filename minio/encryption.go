@@ -0,0 +1,136 @@
+package minio
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// UseEncryption reads and writes objects with the configured encryption
+// instead of following the default encryption of the bucket. It fails if
+// the configuration lacks the key its type needs; a nil configuration keeps
+// the default.
+func (m *MinioClient) UseEncryption(cfg *config.EncryptionConfig) error {
+	if cfg == nil {
+		return nil
+	}
+
+	kind := cfg.Type
+	if kind == "" {
+		switch {
+		case cfg.Key != "" && cfg.KMSKeyID != "":
+			return fmt.Errorf("encryption of bucket %s sets both key and kmsKeyID, set type to pick one", m.bucketName)
+		case cfg.Key != "":
+			kind = config.EncryptionSSEC
+		case cfg.KMSKeyID != "":
+			kind = config.EncryptionSSEKMS
+		default:
+			return fmt.Errorf("encryption of bucket %s needs a type, key or kmsKeyID", m.bucketName)
+		}
+	}
+
+	var sse encrypt.ServerSide
+	switch kind {
+	case config.EncryptionSSEC:
+		if cfg.Key == "" {
+			return fmt.Errorf("encryption of bucket %s is %s but no key is set", m.bucketName, kind)
+		}
+		if m.client.EndpointURL().Scheme != "https" {
+			return fmt.Errorf("SSE-C keys of bucket %s are only sent over SSL, enable usessl", m.bucketName)
+		}
+		key, err := base64.StdEncoding.DecodeString(cfg.Key)
+		if err != nil {
+			return fmt.Errorf("invalid SSE-C key of bucket %s, must be base64 encoded: %w", m.bucketName, err)
+		}
+		if sse, err = encrypt.NewSSEC(key); err != nil {
+			return fmt.Errorf("invalid SSE-C key of bucket %s, must be 32 bytes: %w", m.bucketName, err)
+		}
+		m.customerKey = sse
+		log.Printf("Objects of bucket %s are read and written with an SSE-C key", m.bucketName)
+	case config.EncryptionSSEKMS:
+		if cfg.KMSKeyID == "" {
+			return fmt.Errorf("encryption of bucket %s is %s but no kmsKeyID is set", m.bucketName, kind)
+		}
+		var err error
+		if sse, err = encrypt.NewSSEKMS(cfg.KMSKeyID, nil); err != nil {
+			return fmt.Errorf("invalid KMS key of bucket %s: %w", m.bucketName, err)
+		}
+		log.Printf("Uploads to bucket %s are encrypted with KMS key %s", m.bucketName, cfg.KMSKeyID)
+	case config.EncryptionSSES3:
+		sse = encrypt.NewSSE()
+		log.Printf("Uploads to bucket %s are encrypted with SSE-S3", m.bucketName)
+	default:
+		return fmt.Errorf("invalid encryption type %q of bucket %s, must be %q, %q or %q",
+			kind, m.bucketName, config.EncryptionSSEC, config.EncryptionSSEKMS, config.EncryptionSSES3)
+	}
+
+	m.encryptionOnce.Do(func() { m.encryption = sse })
+	return nil
+}
+
+// readOptions returns the options objects are read with, carrying the SSE-C
+// key if one is configured
+func (m *MinioClient) readOptions() minio.GetObjectOptions {
+	return minio.GetObjectOptions{ServerSideEncryption: m.customerKey}
+}
+
+// IsEncryptionKeyMissing reports whether err is a server refusing to read
+// an object encrypted with SSE-C because its key wasn't sent, or another one
+func IsEncryptionKeyMissing(err error) bool {
+	var resp minio.ErrorResponse
+	if !errors.As(err, &resp) {
+		return false
+	}
+	return resp.Code == "InvalidRequest" && strings.Contains(resp.Message, "Server Side Encryption") ||
+		resp.Code == "AccessDenied" && strings.Contains(resp.Message, "SSE-C")
+}
+
+// bucketEncryption returns the server-side encryption uploads to the bucket
+// have to request, following the default encryption of the bucket. Buckets
+// enforcing SSE-KMS through a policy reject uploads that don't name the key,
+// so the headers are sent explicitly. The configuration is read once, on the
+// first upload.
+func (m *MinioClient) bucketEncryption(ctx context.Context) encrypt.ServerSide {
+	m.encryptionOnce.Do(func() {
+		cfg, err := m.client.GetBucketEncryption(ctx, m.bucketName)
+		if err != nil {
+			if ErrorCode(err) != "ServerSideEncryptionConfigurationNotFoundError" {
+				log.Printf("Warning: Failed to get default encryption of bucket %s, uploading without encryption headers: %v", m.bucketName, err)
+			}
+			return
+		}
+
+		for _, rule := range cfg.Rules {
+			switch rule.Apply.SSEAlgorithm {
+			case "aws:kms":
+				sse, err := encrypt.NewSSEKMS(rule.Apply.KmsMasterKeyID, nil)
+				if err != nil {
+					log.Printf("Warning: Invalid KMS key of bucket %s: %v", m.bucketName, err)
+					return
+				}
+				log.Printf("Bucket %s encrypts with KMS key %s, uploads request it explicitly", m.bucketName, rule.Apply.KmsMasterKeyID)
+				m.encryption = sse
+				return
+			case "AES256":
+				log.Printf("Bucket %s encrypts with SSE-S3, uploads request it explicitly", m.bucketName)
+				m.encryption = encrypt.NewSSE()
+				return
+			}
+		}
+	})
+	return m.encryption
+}
+
+// SkipBucketEncryption uploads without encryption headers and without
+// reading the default encryption of the bucket, for services that don't
+// support the S3 bucket encryption API
+func (m *MinioClient) SkipBucketEncryption() {
+	m.encryptionOnce.Do(func() {})
+}
@@ -0,0 +1,54 @@
+package minio
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// How the ETags reported by the server are used, see DistrustETags
+const (
+	etagTrusted = iota
+	etagSynthesized
+	etagDropped
+)
+
+// DistrustETags stops using the ETags reported by the server, for gateways
+// that return random ETags. With synthesize, objects get an ETag made of
+// their size and modification time instead, so that changes of a source are
+// still detected; otherwise they get none and copies are compared by size and
+// modification time like local files. ETags of compressed and multipart
+// copies, which are kept as metadata, are still used.
+func (m *MinioClient) DistrustETags(synthesize bool) {
+	m.etagMode = etagDropped
+	if synthesize {
+		m.etagMode = etagSynthesized
+	}
+}
+
+// TrustsETags reports whether the ETags of the server are used
+func (m *MinioClient) TrustsETags() bool {
+	return m.etagMode == etagTrusted
+}
+
+// IsSynthesizedETag reports whether etag was made by a client that distrusts
+// the ETags of its server
+func IsSynthesizedETag(etag string) bool {
+	return strings.HasPrefix(etag, synthesizedETagPrefix)
+}
+
+const synthesizedETagPrefix = "size"
+
+// objectETag returns the ETag an object reported by the server gets. The
+// modification time is truncated to seconds, which is the precision of stat
+// results. Synthesized ETags contain a "-", so like multipart ETags they are
+// never taken for an MD5 checksum.
+func (m *MinioClient) objectETag(etag string, size int64, modified time.Time) string {
+	switch m.etagMode {
+	case etagSynthesized:
+		return fmt.Sprintf("%s%d-mtime%d", synthesizedETagPrefix, size, modified.Unix())
+	case etagDropped:
+		return ""
+	}
+	return etag
+}
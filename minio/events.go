@@ -0,0 +1,59 @@
+package minio
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/notification"
+)
+
+// ObjectEvent is a change of an object reported by a bucket notification
+type ObjectEvent struct {
+	// Removed is set for deleted objects; Object then only has a Key
+	Removed bool
+	Object  ObjectInfo
+}
+
+// ListenObjectEvents subscribes to the notifications of objects created or
+// removed below the folder path and calls fn for each of them. It returns
+// when ctx is cancelled, fn fails or the subscription fails, e.g. because the
+// server is not MinIO. Events happening while it doesn't run are lost.
+func (m *MinioClient) ListenObjectEvents(ctx context.Context, fn func(ObjectEvent) error) error {
+	prefix := m.folderPath
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	events := []string{string(notification.ObjectCreatedAll), string(notification.ObjectRemovedAll)}
+
+	for info := range m.client.ListenBucketNotification(ctx, m.bucketName, prefix, "", events) {
+		if info.Err != nil {
+			return fmt.Errorf("bucket notifications failed: %w", info.Err)
+		}
+		for _, record := range info.Records {
+			// Keys are URL-encoded in notifications
+			key, err := url.QueryUnescape(record.S3.Object.Key)
+			if err != nil {
+				key = record.S3.Object.Key
+			}
+			event := ObjectEvent{
+				Removed: strings.HasPrefix(record.EventName, "s3:ObjectRemoved:"),
+				Object: ObjectInfo{
+					Key:  key,
+					Size: record.S3.Object.Size,
+					ETag: strings.Trim(record.S3.Object.ETag, `"`),
+				},
+			}
+			if modified, err := time.Parse(time.RFC3339, record.EventTime); err == nil {
+				event.Object.LastModified = modified
+			}
+			event.Object.ETag = m.objectETag(event.Object.ETag, event.Object.Size, event.Object.LastModified)
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,73 @@
+package minio
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyTransport measures how long the server takes to answer requests: the
+// time from the end of the request body to the response headers. Uploads are
+// measured without the time their body takes to send, so their latency is
+// comparable to that of small requests.
+type LatencyTransport struct {
+	base     http.RoundTripper
+	observer atomic.Pointer[func(time.Duration)]
+}
+
+// NewLatencyTransport wraps base, which defaults to http.DefaultTransport
+func NewLatencyTransport(base http.RoundTripper) *LatencyTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &LatencyTransport{base: base}
+}
+
+func (t *LatencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	observe := t.observer.Load()
+	if observe == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	var sent atomic.Int64
+	if req.Body != nil && req.Body != http.NoBody {
+		// RoundTrippers must not modify the request they were given
+		req = req.Clone(req.Context())
+		req.Body = &sentBody{ReadCloser: req.Body, sent: &sent}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	from := start
+	if sentAt := sent.Load(); sentAt > 0 {
+		from = time.Unix(0, sentAt)
+	}
+	(*observe)(time.Since(from))
+	return resp, nil
+}
+
+// sentBody records when a request body was read to the end
+type sentBody struct {
+	io.ReadCloser
+	sent *atomic.Int64
+}
+
+func (b *sentBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.sent.CompareAndSwap(0, time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// ObserveLatency calls fn with the latency of every request of the client,
+// see LatencyTransport. Clients created without one are not observed.
+func (m *MinioClient) ObserveLatency(fn func(time.Duration)) {
+	if m.latency != nil {
+		m.latency.observer.Store(&fn)
+	}
+}
@@ -0,0 +1,243 @@
+package minio
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ObjectMetadata holds the properties of an object that copies can keep
+type ObjectMetadata struct {
+	ContentType     string
+	ContentEncoding string
+	// UserMetadata are the X-Amz-Meta- headers, without the prefix
+	UserMetadata map[string]string
+	Tags         map[string]string
+	StorageClass string
+	// ACL holds the X-Amz-Acl or X-Amz-Grant-* headers granting access that
+	// differs from the private default
+	ACL map[string]string
+}
+
+// GetObjectMetadata returns the content type, content encoding, user
+// metadata, tags, storage class and ACL of an object. Tags are only requested
+// for objects that have any, and ACLs until the server turns out not to
+// support them.
+func (m *MinioClient) GetObjectMetadata(ctx context.Context, objectPath string) (*ObjectMetadata, error) {
+	log.Printf("Debug: Getting object metadata: %s", objectPath)
+
+	var info minio.ObjectInfo
+	err := m.withRetry(ctx, "StatObject", func() error {
+		var err error
+		info, err = m.client.StatObject(ctx, m.bucketName, objectPath, m.readOptions())
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object metadata %s: %w", objectPath, err)
+	}
+
+	metadata := &ObjectMetadata{
+		ContentType:     info.ContentType,
+		ContentEncoding: info.Metadata.Get("Content-Encoding"),
+		UserMetadata:    make(map[string]string, len(info.UserMetadata)),
+		StorageClass:    info.StorageClass,
+	}
+	for key, value := range info.UserMetadata {
+		// Bookkeeping of copies made by this tool describes their source
+		if key != metaOriginalSize && key != metaOriginalETag {
+			metadata.UserMetadata[key] = value
+		}
+	}
+
+	if info.UserTagCount > 0 {
+		err = m.withRetry(ctx, "GetObjectTagging", func() error {
+			tags, err := m.client.GetObjectTagging(ctx, m.bucketName, objectPath, minio.GetObjectTaggingOptions{})
+			if err != nil {
+				return err
+			}
+			metadata.Tags = tags.ToMap()
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tags of object %s: %w", objectPath, err)
+		}
+	}
+
+	if metadata.ACL, err = m.objectACL(ctx, objectPath); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// GetObjectTags returns the tags of an object
+func (m *MinioClient) GetObjectTags(ctx context.Context, objectPath string) (map[string]string, error) {
+	log.Printf("Debug: Getting object tags: %s", objectPath)
+
+	var tags map[string]string
+	err := m.withRetry(ctx, "GetObjectTagging", func() error {
+		objectTags, err := m.client.GetObjectTagging(ctx, m.bucketName, objectPath, minio.GetObjectTaggingOptions{})
+		if err != nil {
+			return err
+		}
+		tags = objectTags.ToMap()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags of object %s: %w", objectPath, err)
+	}
+	return tags, nil
+}
+
+// GetVersionMetadata returns the user metadata and tags of a version of an
+// object for servers whose listings don't include them. Metadata is keyed as
+// MinIO lists it, by header with the content type, so that both can be
+// compared. An empty versionID is the current version.
+func (m *MinioClient) GetVersionMetadata(ctx context.Context, objectPath, versionID string) (metadata, tags map[string]string, err error) {
+	log.Printf("Debug: Getting metadata of object version: %s (version: %s)", objectPath, versionID)
+
+	opts := m.readOptions()
+	opts.VersionID = versionID
+	var info minio.ObjectInfo
+	err = m.withRetry(ctx, "StatObject", func() error {
+		var err error
+		info, err = m.client.StatObject(ctx, m.bucketName, objectPath, opts)
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get object metadata %s: %w", objectPath, err)
+	}
+
+	metadata = map[string]string{"content-type": info.ContentType}
+	for key, value := range info.UserMetadata {
+		metadata["X-Amz-Meta-"+key] = value
+	}
+
+	if info.UserTagCount > 0 {
+		err = m.withRetry(ctx, "GetObjectTagging", func() error {
+			objectTags, err := m.client.GetObjectTagging(ctx, m.bucketName, objectPath, minio.GetObjectTaggingOptions{VersionID: versionID})
+			if err != nil {
+				return err
+			}
+			tags = objectTags.ToMap()
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get tags of object %s: %w", objectPath, err)
+		}
+	}
+	return metadata, tags, nil
+}
+
+// objectACL returns the headers that recreate the ACL of an object, or nil
+// for the private default. ACLs are best effort: the first failure, e.g. of a
+// server without ACL support or a key that may not read ACLs, stops
+// requesting them.
+func (m *MinioClient) objectACL(ctx context.Context, objectPath string) (map[string]string, error) {
+	if m.aclUnsupported.Load() {
+		return nil, nil
+	}
+
+	var info *minio.ObjectInfo
+	err := m.withRetry(ctx, "GetObjectACL", func() error {
+		var err error
+		info, err = m.client.GetObjectACL(ctx, m.bucketName, objectPath)
+		return err
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !m.aclUnsupported.Swap(true) {
+			log.Printf("Warning: Failed to get the ACL of %s, ACLs of %s are not preserved: %v", objectPath, m.endpoint, err)
+		}
+		return nil, nil
+	}
+
+	if canned := info.Metadata.Get("X-Amz-Acl"); canned != "" {
+		if canned == "private" {
+			return nil, nil
+		}
+		return map[string]string{"X-Amz-Acl": canned}, nil
+	}
+	acl := make(map[string]string)
+	for header, values := range info.Metadata {
+		if strings.HasPrefix(header, "X-Amz-Grant-") {
+			acl[header] = strings.Join(values, ", ")
+		}
+	}
+	return acl, nil
+}
+
+// headers returns the metadata as the headers of a server-side copy, which
+// replace those of the source object
+func (md *ObjectMetadata) headers() map[string]string {
+	headers := make(map[string]string, len(md.UserMetadata)+2)
+	for key, value := range md.UserMetadata {
+		headers[key] = value
+	}
+	if md.ContentType != "" {
+		headers["Content-Type"] = md.ContentType
+	}
+	if md.ContentEncoding != "" {
+		headers["Content-Encoding"] = md.ContentEncoding
+	}
+	if md.StorageClass != "" {
+		headers["X-Amz-Storage-Class"] = md.StorageClass
+	}
+	for header, value := range md.ACL {
+		headers[header] = value
+	}
+	return headers
+}
+
+// apply adds the metadata to the options of an upload. Metadata the options
+// already set, such as the encoding of compressed copies, takes precedence.
+func (md *ObjectMetadata) apply(opts *minio.PutObjectOptions) {
+	if md == nil {
+		return
+	}
+	if opts.ContentType == "" {
+		opts.ContentType = md.ContentType
+	}
+	if opts.ContentEncoding == "" {
+		opts.ContentEncoding = md.ContentEncoding
+	}
+	if opts.StorageClass == "" {
+		opts.StorageClass = md.StorageClass
+	}
+	for key, value := range md.UserMetadata {
+		if opts.UserMetadata == nil {
+			opts.UserMetadata = make(map[string]string, len(md.UserMetadata))
+		}
+		if _, ok := opts.UserMetadata[key]; !ok {
+			opts.UserMetadata[key] = value
+		}
+	}
+	// X-Amz- headers in the user metadata are sent as they are
+	for header, value := range md.ACL {
+		if opts.UserMetadata == nil {
+			opts.UserMetadata = make(map[string]string, len(md.ACL))
+		}
+		opts.UserMetadata[header] = value
+	}
+	if len(md.Tags) > 0 && opts.UserTags == nil {
+		opts.UserTags = md.Tags
+	}
+}
+
+// replicationStatus returns the bucket replication status of a listed
+// object, which MinIO includes in the metadata of listings with metadata
+func replicationStatus(object minio.ObjectInfo) string {
+	if object.ReplicationStatus != "" {
+		return object.ReplicationStatus
+	}
+	for key, value := range object.UserMetadata {
+		if strings.EqualFold(key, "X-Amz-Replication-Status") {
+			return value
+		}
+	}
+	return ""
+}
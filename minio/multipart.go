@@ -0,0 +1,95 @@
+package minio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Part is an uploaded part of a multipart upload
+type Part struct {
+	Number int
+	ETag   string
+	Size   int64
+}
+
+// NewMultipartUpload starts a multipart upload of objectPath. The ETag of the
+// source is kept as metadata, because the ETag of the assembled object
+// depends on the part size and can't be compared with the source. The object
+// gets metadata if it isn't nil.
+func (m *MinioClient) NewMultipartUpload(ctx context.Context, objectPath, sourceETag string, metadata *ObjectMetadata) (string, error) {
+	log.Printf("Debug: Starting multipart upload: %s", objectPath)
+
+	core := minio.Core{Client: m.client}
+	opts := minio.PutObjectOptions{
+		UserMetadata:         map[string]string{metaOriginalETag: sourceETag},
+		ServerSideEncryption: m.bucketEncryption(ctx),
+	}
+	metadata.apply(&opts)
+	var uploadID string
+	err := m.withRetry(ctx, "NewMultipartUpload", func() error {
+		var err error
+		uploadID, err = core.NewMultipartUpload(ctx, m.bucketName, objectPath, opts)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	return uploadID, nil
+}
+
+// PutPart uploads a single part. The reader can't be replayed, so the part is
+// not retried here; it is uploaded again when the transfer resumes.
+func (m *MinioClient) PutPart(ctx context.Context, objectPath, uploadID string, number int, reader io.Reader, size int64) (Part, error) {
+	log.Printf("Debug: Putting part %d of %s (size: %d)", number, objectPath, size)
+
+	core := minio.Core{Client: m.client}
+	part, err := core.PutObjectPart(ctx, m.bucketName, objectPath, uploadID, number, reader, size, minio.PutObjectPartOptions{SSE: m.customerKey})
+	if err != nil {
+		return Part{}, fmt.Errorf("failed to put part %d: %w", number, err)
+	}
+	return Part{Number: part.PartNumber, ETag: part.ETag, Size: part.Size}, nil
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into the object
+func (m *MinioClient) CompleteMultipartUpload(ctx context.Context, objectPath, uploadID string, parts []Part) error {
+	log.Printf("Debug: Completing multipart upload: %s (%d parts)", objectPath, len(parts))
+
+	completed := make([]minio.CompletePart, len(parts))
+	for i, part := range parts {
+		completed[i] = minio.CompletePart{PartNumber: part.Number, ETag: part.ETag}
+	}
+
+	core := minio.Core{Client: m.client}
+	err := m.withRetry(ctx, "CompleteMultipartUpload", func() error {
+		_, err := core.CompleteMultipartUpload(ctx, m.bucketName, objectPath, uploadID, completed, minio.PutObjectOptions{ServerSideEncryption: m.customerKey})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+// AbortMultipartUpload discards an upload and its parts
+func (m *MinioClient) AbortMultipartUpload(ctx context.Context, objectPath, uploadID string) error {
+	core := minio.Core{Client: m.client}
+	if err := core.AbortMultipartUpload(ctx, m.bucketName, objectPath, uploadID); err != nil && !IsNoSuchUpload(err) {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	return nil
+}
+
+// IsNoSuchUpload reports whether err means the multipart upload no longer
+// exists, e.g. because it expired or was aborted
+func IsNoSuchUpload(err error) bool {
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) {
+		return resp.Code == "NoSuchUpload"
+	}
+	return false
+}
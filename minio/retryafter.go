@@ -0,0 +1,128 @@
+package minio
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRetryAfter caps the wait a server can ask for, so a misconfigured
+// gateway can't stall a sync for days
+const maxRetryAfter = time.Hour
+
+// RetryAfterTransport honors the Retry-After and rate limit headers of
+// throttled responses (429 and 503). Once a server asks to wait, every
+// request of the process to the same host waits until then, including the
+// retries of the client, instead of following the backoff of the client.
+type RetryAfterTransport struct {
+	base http.RoundTripper
+}
+
+// NewRetryAfterTransport wraps base, which defaults to http.DefaultTransport
+func NewRetryAfterTransport(base http.RoundTripper) *RetryAfterTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RetryAfterTransport{base: base}
+}
+
+// hostWaits holds the time until which requests to a host wait, shared by
+// the clients of all projects
+var hostWaits = struct {
+	sync.Mutex
+	until map[string]time.Time
+}{until: make(map[string]time.Time)}
+
+func (t *RetryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if err := waitForHost(req.Context(), host); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if delay, ok := retryAfter(resp.Header, time.Now()); ok {
+			delayHost(host, delay)
+		}
+	}
+	return resp, nil
+}
+
+// waitForHost blocks until requests to host may be sent again
+func waitForHost(ctx context.Context, host string) error {
+	hostWaits.Lock()
+	until := hostWaits.until[host]
+	hostWaits.Unlock()
+
+	delay := time.Until(until)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// delayHost holds back the requests to host for delay, unless they already
+// wait longer
+func delayHost(host string, delay time.Duration) {
+	until := time.Now().Add(delay)
+
+	hostWaits.Lock()
+	defer hostWaits.Unlock()
+	if until.After(hostWaits.until[host]) {
+		hostWaits.until[host] = until
+		log.Printf("Warning: %s is throttling requests, waiting %s as it asked", host, delay.Round(time.Millisecond))
+	}
+}
+
+// retryAfter returns how long a throttled response asks to wait, from the
+// Retry-After header in seconds or as an HTTP date, or the reset time of the
+// rate limit headers used by many gateways
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	var delay time.Duration
+	if value := strings.TrimSpace(header.Get("Retry-After")); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			delay = time.Duration(seconds * float64(time.Second))
+		} else if at, err := http.ParseTime(value); err == nil {
+			delay = at.Sub(now)
+		}
+	} else if value := rateLimitReset(header); value != "" {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, false
+		}
+		// Reset times are either seconds to wait or a Unix time
+		if seconds > 1e9 {
+			delay = time.Unix(int64(seconds), 0).Sub(now)
+		} else {
+			delay = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	if delay <= 0 {
+		return 0, false
+	}
+	return min(delay, maxRetryAfter), true
+}
+
+// rateLimitReset returns the first rate limit reset header of a response
+func rateLimitReset(header http.Header) string {
+	for _, name := range []string{"X-RateLimit-Reset-After", "X-RateLimit-Reset", "RateLimit-Reset"} {
+		if value := strings.TrimSpace(header.Get(name)); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,123 @@
+package minio
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// defaultDelimiter separates the levels of the keyspace unless configured
+const defaultDelimiter = "/"
+
+// listSharded splits the keyspace below root into shards, the prefixes
+// shardDepth delimiter levels down, and lists them recursively with up to
+// opts.Listers listings running at once. The levels above the shards are
+// listed with the delimiter, also concurrently, passing their objects to fn.
+// Prefixes that fail to list are reported together like in walkPrefix.
+func (m *MinioClient) listSharded(ctx context.Context, root string, opts ListOptions, fn func(ObjectInfo) error) error {
+	delimiter := opts.Delimiter
+	if delimiter == "" {
+		delimiter = defaultDelimiter
+	}
+	shardDepth := max(opts.ShardDepth, 1)
+
+	var errs []error
+	var mu sync.Mutex
+	shards := []string{root}
+	for level := 0; level < shardDepth && len(shards) > 0; level++ {
+		var next []string
+		err := m.forEachPrefix(shards, opts.Listers, func(prefix string) error {
+			return m.listDelimited(ctx, prefix, delimiter, opts.WithMetadata, fn, func(subPrefix string) {
+				mu.Lock()
+				next = append(next, subPrefix)
+				mu.Unlock()
+			})
+		})
+		if err != nil && len(UnlistedRanges(err)) == 0 {
+			return err
+		}
+		errs = append(errs, err)
+		shards = next
+	}
+	log.Printf("Debug: Listing %d shards of %s with %d listers", len(shards), root, opts.Listers)
+
+	err := m.forEachPrefix(shards, opts.Listers, func(shard string) error {
+		return m.listPrefix(ctx, shard, true, opts.WithMetadata, fn, nil)
+	})
+	return errors.Join(append(errs, err)...)
+}
+
+// forEachPrefix calls list for every prefix with up to listers calls running
+// at once and returns the joined errors
+func (m *MinioClient) forEachPrefix(prefixes []string, listers int, list func(string) error) error {
+	errs := make([]error, len(prefixes))
+	sem := make(chan struct{}, max(listers, 1))
+	var wg sync.WaitGroup
+	for i, prefix := range prefixes {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = list(prefix)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// listDelimited lists the objects directly below prefix, passing the common
+// prefixes up to the next delimiter to onPrefix. The listing API of
+// minio-go only supports "/", other delimiters are listed page by page.
+func (m *MinioClient) listDelimited(ctx context.Context, prefix, delimiter string, withMetadata bool, fn func(ObjectInfo) error, onPrefix func(string)) error {
+	if delimiter == defaultDelimiter {
+		return m.listPrefix(ctx, prefix, false, withMetadata, fn, onPrefix)
+	}
+
+	core := minio.Core{Client: m.client}
+	var token, lastKey string
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var result minio.ListBucketV2Result
+		err := m.withRetry(ctx, "ListObjectsV2", func() error {
+			var err error
+			result, err = core.ListObjectsV2(m.bucketName, prefix, "", token, delimiter, 0)
+			return err
+		})
+		if err != nil {
+			return &ListingError{Prefix: prefix, LastKey: lastKey, Err: err}
+		}
+
+		for _, object := range result.Contents {
+			lastKey = object.Key
+			if strings.HasSuffix(object.Key, "/") {
+				continue
+			}
+			err := fn(ObjectInfo{
+				Key:          object.Key,
+				Size:         object.Size,
+				ETag:         m.objectETag(object.ETag, object.Size, object.LastModified),
+				LastModified: object.LastModified,
+				StorageClass: object.StorageClass,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, common := range result.CommonPrefixes {
+			if common.Prefix != prefix {
+				onPrefix(common.Prefix)
+			}
+		}
+		if !result.IsTruncated {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
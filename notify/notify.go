@@ -0,0 +1,77 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// Event is a notification about something an operator should look at
+type Event struct {
+	Project string                 `json:"project"`
+	Type    string                 `json:"type"`
+	Message string                 `json:"message"`
+	Time    time.Time              `json:"time"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Notifier delivers events to the log and, when configured, to a webhook
+type Notifier struct {
+	project    string
+	webhookURL string
+	client     *http.Client
+}
+
+func NewNotifier(project, webhookURL string) *Notifier {
+	return &Notifier{
+		project:    project,
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// Notify logs the event and posts it to the webhook. Delivery failures are
+// returned but the event is always logged.
+func (n *Notifier) Notify(ctx context.Context, eventType, message string, details map[string]interface{}) error {
+	event := Event{
+		Project: n.project,
+		Type:    eventType,
+		Message: message,
+		Time:    time.Now(),
+		Details: details,
+	}
+
+	log.Printf("ALERT [%s] %s: %s", n.project, eventType, message)
+
+	if n.webhookURL == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
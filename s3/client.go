@@ -0,0 +1,82 @@
+// Package s3 connects to S3-compatible destinations such as AWS S3. The
+// transfers themselves are shared with MinIO destinations.
+package s3
+
+import (
+	"fmt"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+	miniogo "github.com/minio/minio-go/v7"
+)
+
+const defaultEndpoint = "s3.amazonaws.com"
+
+// Bucket addressing styles of S3Config.Addressing
+const (
+	AddressingPath          = "path"
+	AddressingVirtualHosted = "virtual-hosted"
+)
+
+// NewClient creates a client for an S3-compatible bucket
+func NewClient(cfg *config.S3Config) (*minio.MinioClient, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+
+	var lookup miniogo.BucketLookupType
+	switch cfg.Addressing {
+	case "":
+		lookup = miniogo.BucketLookupAuto
+	case AddressingPath:
+		lookup = miniogo.BucketLookupPath
+	case AddressingVirtualHosted:
+		lookup = miniogo.BucketLookupDNS
+	default:
+		return nil, fmt.Errorf("invalid addressing %q, must be %q or %q", cfg.Addressing, AddressingPath, AddressingVirtualHosted)
+	}
+
+	transport, err := miniogo.DefaultTransport(cfg.UseSSL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 transport: %w", err)
+	}
+	latency := minio.NewLatencyTransport(transport)
+
+	creds, err := minio.NewCredentials(cfg.Credentials, cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken, stsEndpoint(cfg, endpoint), cfg.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := miniogo.New(endpoint, &miniogo.Options{
+		Creds:        creds,
+		Secure:       cfg.UseSSL,
+		Region:       cfg.Region,
+		BucketLookup: lookup,
+		Transport:    minio.NewRetryAfterTransport(latency),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+
+	m := minio.WrapClient(client, latency, endpoint, cfg.BucketName, cfg.FolderPath)
+	if cfg.Credentials != nil {
+		m.UseCredentials(creds)
+	}
+	if err := m.UseEncryption(cfg.Encryption); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// stsEndpoint returns the default STS endpoint of a bucket: AWS STS for AWS
+// endpoints and the endpoint itself for other services
+func stsEndpoint(cfg *config.S3Config, endpoint string) string {
+	if endpoint != defaultEndpoint {
+		return minio.EndpointURL(endpoint, cfg.UseSSL)
+	}
+	if cfg.Region == "" {
+		return "https://sts.amazonaws.com"
+	}
+	return "https://sts." + cfg.Region + ".amazonaws.com"
+}
@@ -0,0 +1,80 @@
+package sync
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+// AccountingReport checks that the file entries across all statuses add up
+// to the last complete source listing
+type AccountingReport struct {
+	*db.Accounting
+}
+
+// Problems returns a description of every broken invariant
+func (r *AccountingReport) Problems() []string {
+	var problems []string
+	if r.LastListing != nil && r.TrackedCount != r.LastListing.ObjectCount {
+		problems = append(problems, fmt.Sprintf(
+			"last %s listed %d source objects but %d files are tracked (difference: %+d)",
+			r.LastListing.Source,
+			r.LastListing.ObjectCount,
+			r.TrackedCount,
+			r.TrackedCount-r.LastListing.ObjectCount,
+		))
+	}
+	if r.Duplicates > 0 {
+		problems = append(problems, fmt.Sprintf("%d duplicate file entries for the same path", r.Duplicates))
+	}
+	return problems
+}
+
+func (s *Service) CheckAccounting() (*AccountingReport, error) {
+	accounting, err := s.database.GetAccounting(s.projectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get accounting totals: %w", err)
+	}
+	return &AccountingReport{Accounting: accounting}, nil
+}
+
+// logAccounting runs the accounting check and flags any discrepancy
+func (s *Service) logAccounting() {
+	report, err := s.CheckAccounting()
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+
+	if report.LastListing == nil {
+		log.Printf("Accounting: no complete source listing recorded yet, skipping check")
+		return
+	}
+
+	problems := report.Problems()
+	if len(problems) == 0 {
+		log.Printf("Accounting: OK (%d source objects, %d tracked files)", report.LastListing.ObjectCount, report.TrackedCount)
+		return
+	}
+
+	log.Printf("!!! ACCOUNTING MISMATCH for project %s !!!", s.projectName)
+	for _, problem := range problems {
+		log.Printf("!!! %s", problem)
+	}
+}
+
+// recordStats takes a stats snapshot after a run of command, which a nil
+// result marks as a run that copies nothing, e.g. a listing
+func (s *Service) recordStats(command string, result *SyncResult) {
+	snapshot := &db.StatsSnapshot{ProjectName: s.projectName, Source: command}
+	if result != nil {
+		snapshot.RunCompleted = int64(result.Completed)
+		snapshot.RunFailed = int64(result.Failed)
+		snapshot.RunPanics = int64(result.Panics)
+		snapshot.RunBytes = result.Transferred
+	}
+	if err := s.database.InsertStatsSnapshot(snapshot); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
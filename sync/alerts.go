@@ -0,0 +1,172 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// ErrAlertAbort is returned by StartSync when a run was aborted because an
+// alert threshold was breached
+var ErrAlertAbort = errors.New("sync aborted after alert threshold breach")
+
+const (
+	alertCheckInterval    = 30 * time.Second
+	defaultBreachDuration = 5 * time.Minute
+)
+
+// alertThresholds holds the parsed alert configuration
+type alertThresholds struct {
+	minThroughput  int64 // bytes per second
+	maxErrorRate   float64
+	breachDuration time.Duration
+	abortOnBreach  bool
+	maxPendingAge  time.Duration
+}
+
+func (t alertThresholds) enabled() bool {
+	return t.minThroughput > 0 || t.maxErrorRate > 0
+}
+
+// runStats counts the progress of a sync run for alerting
+type runStats struct {
+	completed atomic.Int64
+	failed    atomic.Int64
+	skipped   atomic.Int64
+	// restoring counts the archived files set aside for a restore
+	restoring atomic.Int64
+	// destNewer counts the files held back because the destination is newer
+	destNewer atomic.Int64
+	// claimedElsewhere counts the files another instance claimed first
+	claimedElsewhere atomic.Int64
+	// gaveUp counts the failed files without attempts left
+	gaveUp atomic.Int64
+	// panics counts the files whose processing panicked
+	panics atomic.Int64
+}
+
+// countingReader adds the bytes read through it to a shared counter
+type countingReader struct {
+	reader  io.Reader
+	counter *atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.counter.Add(int64(n))
+	return n, err
+}
+
+// monitorAlerts samples throughput and error rate until ctx is done. Once a
+// breach has persisted for the configured duration an alert is sent, and
+// abort is called if the run should stop.
+func (s *Service) monitorAlerts(ctx context.Context, stats *runStats, abort func()) {
+	if !s.alerts.enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(alertCheckInterval)
+	defer ticker.Stop()
+
+	lastBytes := s.transferred.Load()
+	lastCompleted, lastFailed := stats.completed.Load(), stats.failed.Load()
+	lastCheck := time.Now()
+	var breachSince time.Time
+	alerted := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			bytes := s.transferred.Load()
+			completed, failed := stats.completed.Load(), stats.failed.Load()
+
+			elapsed := now.Sub(lastCheck).Seconds()
+			throughput := float64(bytes-lastBytes) / elapsed
+			finished := (completed - lastCompleted) + (failed - lastFailed)
+			errorRate := 0.0
+			if finished > 0 {
+				errorRate = float64(failed-lastFailed) / float64(finished)
+			}
+			lastBytes, lastCompleted, lastFailed, lastCheck = bytes, completed, failed, now
+
+			var problems []string
+			if s.alerts.minThroughput > 0 && throughput < float64(s.alerts.minThroughput) {
+				problems = append(problems, fmt.Sprintf("throughput %.0f B/s below minimum %d B/s", throughput, s.alerts.minThroughput))
+			}
+			if s.alerts.maxErrorRate > 0 && errorRate > s.alerts.maxErrorRate {
+				problems = append(problems, fmt.Sprintf("error rate %.1f%% above maximum %.1f%%", errorRate*100, s.alerts.maxErrorRate*100))
+			}
+
+			if len(problems) == 0 {
+				if alerted {
+					log.Printf("Alert thresholds recovered")
+				}
+				breachSince, alerted = time.Time{}, false
+				continue
+			}
+
+			if breachSince.IsZero() {
+				breachSince = now
+				log.Printf("Warning: Alert threshold breached: %v", problems)
+			}
+			if alerted || now.Sub(breachSince) < s.alerts.breachDuration {
+				continue
+			}
+
+			alerted = true
+			message := fmt.Sprintf("thresholds breached for %s: %v", now.Sub(breachSince).Round(time.Second), problems)
+			details := map[string]interface{}{
+				"throughput_bytes_per_second": throughput,
+				"error_rate":                  errorRate,
+				"completed":                   completed,
+				"failed":                      failed,
+			}
+			if err := s.notifier.Notify(ctx, "threshold_breach", message, details); err != nil {
+				log.Printf("Warning: Failed to send alert: %v", err)
+			}
+
+			if s.alerts.abortOnBreach {
+				log.Printf("Aborting sync because alert thresholds were breached")
+				abort()
+				return
+			}
+		}
+	}
+}
+
+// checkPendingAge alerts when the oldest pending or failed file has waited
+// longer than the configured maximum age, so a backlog that doesn't shrink is
+// noticed
+func (s *Service) checkPendingAge(ctx context.Context) {
+	if s.alerts.maxPendingAge <= 0 {
+		return
+	}
+	pending, since, err := s.database.GetOldestPending(s.projectName)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	age := time.Since(since)
+	if pending == 0 || age <= s.alerts.maxPendingAge {
+		return
+	}
+
+	message := fmt.Sprintf("%d files pending or failed, the oldest for %s (maximum %s)",
+		pending, age.Round(time.Minute), s.alerts.maxPendingAge)
+	log.Printf("Warning: %s", message)
+	details := map[string]interface{}{
+		"pending":              pending,
+		"oldest_pending_age":   age.Seconds(),
+		"max_pending_age":      s.alerts.maxPendingAge.Seconds(),
+		"oldest_pending_since": since.UTC().Format(time.RFC3339),
+	}
+	if err := s.notifier.Notify(ctx, "stale_backlog", message, details); err != nil {
+		log.Printf("Warning: Failed to send alert: %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+const (
+	defaultAllowlistCacheTTL = 10 * time.Minute
+	defaultAllowlistTimeout  = 30 * time.Second
+	// allowlistBatchSize is the number of keys asked about per request
+	allowlistBatchSize = 1000
+	// allowlistCacheSize bounds the cached answers; once reached, expired
+	// answers are dropped, and all of them if none has expired
+	allowlistCacheSize = 1000000
+)
+
+// allowlist asks the configured service which files may be copied, see
+// config.AllowlistConfig. Answers are cached for the configured time.
+type allowlist struct {
+	url      string
+	token    string
+	project  string
+	bucket   string
+	ttl      time.Duration
+	failOpen bool
+	client   *http.Client
+
+	mu    sync.Mutex
+	cache map[string]allowlistDecision
+}
+
+type allowlistDecision struct {
+	allow   bool
+	reason  string
+	expires time.Time
+}
+
+// allowlistRequest is posted to the service
+type allowlistRequest struct {
+	Project string   `json:"project"`
+	Bucket  string   `json:"bucket"`
+	Keys    []string `json:"keys"`
+}
+
+// allowlistResponse is the answer of the service. Keys it leaves out are
+// undecided.
+type allowlistResponse struct {
+	Decisions []struct {
+		Key    string `json:"key"`
+		Allow  bool   `json:"allow"`
+		Reason string `json:"reason,omitempty"`
+	} `json:"decisions"`
+}
+
+// newAllowlist returns the allowlist of the project, or nil if every file
+// may be copied
+func newAllowlist(cfg *config.ProjectConfig) (*allowlist, error) {
+	if cfg.Allowlist.URL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.Allowlist.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid allowlist URL %q", cfg.Allowlist.URL)
+	}
+
+	a := &allowlist{
+		url:      cfg.Allowlist.URL,
+		token:    cfg.Allowlist.Token,
+		project:  cfg.ProjectName,
+		bucket:   cfg.SourceMinio.BucketName,
+		ttl:      cfg.Allowlist.CacheTTL,
+		failOpen: cfg.Allowlist.FailOpen,
+		client:   &http.Client{Timeout: cfg.Allowlist.Timeout},
+		cache:    make(map[string]allowlistDecision),
+	}
+	if a.ttl <= 0 {
+		a.ttl = defaultAllowlistCacheTTL
+	}
+	if a.client.Timeout <= 0 {
+		a.client.Timeout = defaultAllowlistTimeout
+	}
+	log.Printf("Files to copy are checked with the allowlist service at %s", u.Host)
+	return a, nil
+}
+
+// lookup asks the service about the keys without a cached answer
+func (a *allowlist) lookup(ctx context.Context, keys []string) error {
+	if a == nil {
+		return nil
+	}
+	now := time.Now()
+	var missing []string
+	a.mu.Lock()
+	for _, key := range keys {
+		if decision, ok := a.cache[key]; !ok || now.After(decision.expires) {
+			missing = append(missing, key)
+		}
+	}
+	a.mu.Unlock()
+
+	for start := 0; start < len(missing); start += allowlistBatchSize {
+		if err := a.query(ctx, missing[start:min(start+allowlistBatchSize, len(missing))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// query posts keys to the service and caches its decisions
+func (a *allowlist) query(ctx context.Context, keys []string) error {
+	body, err := json.Marshal(allowlistRequest{Project: a.project, Bucket: a.bucket, Keys: keys})
+	if err != nil {
+		return fmt.Errorf("failed to marshal allowlist request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create allowlist request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to query allowlist service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("allowlist service returned status %s", resp.Status)
+	}
+	var answer allowlistResponse
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return fmt.Errorf("failed to decode allowlist response: %w", err)
+	}
+
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.cache)+len(answer.Decisions) > allowlistCacheSize {
+		for key, decision := range a.cache {
+			if now.After(decision.expires) {
+				delete(a.cache, key)
+			}
+		}
+		if len(a.cache)+len(answer.Decisions) > allowlistCacheSize {
+			clear(a.cache)
+		}
+	}
+	expires := now.Add(a.ttl)
+	for _, d := range answer.Decisions {
+		a.cache[d.Key] = allowlistDecision{allow: d.Allow, reason: d.Reason, expires: expires}
+	}
+	return nil
+}
+
+// reason returns why the service denies a key, or an empty string if it
+// allows it. decided is false if the service gave no answer for the key.
+func (a *allowlist) reason(key string) (reason string, decided bool) {
+	if a == nil {
+		return "", true
+	}
+	a.mu.Lock()
+	decision, ok := a.cache[key]
+	a.mu.Unlock()
+	switch {
+	case !ok:
+		return "", false
+	case decision.allow:
+		return "", true
+	case decision.reason != "":
+		return "denied by the allowlist service: " + decision.reason, true
+	default:
+		return "denied by the allowlist service", true
+	}
+}
+
+// dropDenied asks the allowlist service about the pending files of a run,
+// marks those it denies as skipped_filtered and returns the others. Files
+// without an answer are held back for a later run unless the allowlist
+// fails open.
+func (s *Service) dropDenied(ctx context.Context, files []*db.FileEntry) []*db.FileEntry {
+	if s.allowlist == nil {
+		return files
+	}
+	kept := files[:0]
+	denied, undecided := 0, 0
+	for start := 0; start < len(files); start += allowlistBatchSize {
+		chunk := files[start:min(start+allowlistBatchSize, len(files))]
+		keys := make([]string, len(chunk))
+		for i, file := range chunk {
+			keys[i] = file.Path
+		}
+		if err := s.allowlist.lookup(ctx, keys); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+
+		for _, file := range chunk {
+			reason, decided := s.allowlist.reason(file.Path)
+			switch {
+			case !decided && !s.allowlist.failOpen:
+				undecided++
+			case reason != "":
+				if err := s.database.UpdateFileStatusReason(file.ID, db.StatusSkippedFiltered, reason); err != nil {
+					log.Printf("Warning: Failed to update status of %s: %v", file.Path, err)
+				}
+				denied++
+			default:
+				kept = append(kept, file)
+			}
+		}
+	}
+	if denied > 0 {
+		log.Printf("Skipping %d pending files denied by the allowlist service (status %s)", denied, db.StatusSkippedFiltered)
+	}
+	if undecided > 0 {
+		log.Printf("Warning: Holding back %d pending files the allowlist service gave no answer for, they stay pending", undecided)
+	}
+	return kept
+}
@@ -0,0 +1,119 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+const defaultStagingPrefix = ".staging"
+
+// atomicCommit groups files by folder and stages them until their group is
+// complete
+type atomicCommit struct {
+	groupDepth    int
+	stagingPrefix string
+	sourceFolder  string
+}
+
+func newAtomicCommit(cfg config.AtomicCommitConfig, sourceFolder string) atomicCommit {
+	prefix := strings.Trim(cfg.StagingPrefix, "/")
+	if prefix == "" {
+		prefix = defaultStagingPrefix
+	}
+	return atomicCommit{
+		groupDepth:    cfg.GroupDepth,
+		stagingPrefix: prefix,
+		sourceFolder:  strings.Trim(sourceFolder, "/"),
+	}
+}
+
+// groupOf returns the folder identifying the group of key, or an empty string
+// if key is not part of a group
+func (a atomicCommit) groupOf(key string) string {
+	if a.groupDepth <= 0 {
+		return ""
+	}
+	rel := key
+	if a.sourceFolder != "" {
+		rel = strings.TrimPrefix(key, a.sourceFolder+"/")
+	}
+	parts := strings.Split(rel, "/")
+	if len(parts) <= a.groupDepth {
+		return ""
+	}
+	return path.Join(a.sourceFolder, strings.Join(parts[:a.groupDepth], "/"))
+}
+
+// stagingPath returns where key is uploaded before its group is committed
+func (a atomicCommit) stagingPath(key string) string {
+	return path.Join(a.stagingPrefix, key)
+}
+
+// commitGroups moves the staged files of every complete group to their final
+// keys. Groups with files left to copy stay staged for a later run.
+func (s *Service) commitGroups(ctx context.Context) error {
+	if s.atomic.groupDepth <= 0 {
+		return nil
+	}
+
+	staged, err := s.database.GetStagedFiles(s.projectName)
+	if err != nil {
+		return err
+	}
+
+	var groups []string
+	filesByGroup := make(map[string][]*db.FileEntry)
+	for _, file := range staged {
+		group := s.atomic.groupOf(file.Path)
+		if _, ok := filesByGroup[group]; !ok {
+			groups = append(groups, group)
+		}
+		filesByGroup[group] = append(filesByGroup[group], file)
+	}
+
+	var failed int
+	for _, group := range groups {
+		remaining, err := s.database.CountUnstagedFiles(s.projectName, group+"/")
+		if err != nil {
+			return err
+		}
+		if remaining > 0 {
+			log.Printf("Group %s: %d files staged, waiting for %d more before commit",
+				group, len(filesByGroup[group]), remaining)
+			continue
+		}
+
+		log.Printf("Committing group %s (%d files)", group, len(filesByGroup[group]))
+		for _, file := range filesByGroup[group] {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := s.commitFile(ctx, file); err != nil {
+				log.Printf("Warning: Failed to commit %s: %v", file.Path, err)
+				failed++
+				continue
+			}
+			if err := s.database.UpdateFileStatus(file.ID, db.StatusCompleted, ""); err != nil {
+				log.Printf("Warning: Failed to update file status for %s: %v", file.Path, err)
+				failed++
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to commit %d staged files", failed)
+	}
+	return nil
+}
+
+// commitFile moves a staged file to its final key at the main destination
+func (s *Service) commitFile(ctx context.Context, file *db.FileEntry) error {
+	s.destStats.invalidate(file.Path)
+	return s.dest.Move(ctx, s.atomic.stagingPath(file.Path), file.Path)
+}
@@ -0,0 +1,153 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/local"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+// StorageBackend is a storage files are copied from or to. Keys are source
+// object paths, which every backend maps to its own layout. Missing keys are
+// reported with errors recognized by minio.IsNotFound.
+//
+// Features that only some storages support, such as resumable multipart
+// uploads, server-side copies or appending to local files, use the
+// underlying client directly.
+type StorageBackend interface {
+	// List calls fn with every object whose key starts with prefix
+	List(ctx context.Context, prefix string, fn func(minio.ObjectInfo) error) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Put stores the content of reader, which is opts.Size bytes, at key
+	Put(ctx context.Context, key string, reader io.Reader, opts PutOptions) error
+	// Stat returns the size and modification time of a stored object, and
+	// its ETag if the backend has one
+	Stat(ctx context.Context, key string) (*minio.ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	// Move renames a stored object, e.g. to commit a staged copy
+	Move(ctx context.Context, from, to string) error
+	// Hash returns the hex encoded MD5 checksum of the content of an object
+	Hash(ctx context.Context, key string) (string, error)
+}
+
+// PutOptions describes the object a backend stores
+type PutOptions struct {
+	Size int64
+	// SourceETag identifies the source object
+	SourceETag string
+	// Compress gzips the content where the backend supports it
+	Compress bool
+	// Metadata is kept with the object where the backend supports it
+	Metadata *minio.ObjectMetadata
+	// LastModified of the source object is kept as the modification time
+	// of local files
+	LastModified time.Time
+}
+
+// newBackend returns the backend of a destination, which has a client for
+// object stores and local storage otherwise
+func newBackend(client *minio.MinioClient, storage *local.Storage) StorageBackend {
+	if storage != nil {
+		return &localBackend{storage: storage}
+	}
+	return &objectBackend{client: client}
+}
+
+// objectBackend stores files in a MinIO, S3 or Cloud Storage bucket
+type objectBackend struct {
+	client *minio.MinioClient
+}
+
+func (b *objectBackend) List(ctx context.Context, prefix string, fn func(minio.ObjectInfo) error) error {
+	return b.client.ListPrefix(ctx, prefix, false, fn)
+}
+
+func (b *objectBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return b.client.GetObject(ctx, key)
+}
+
+func (b *objectBackend) Put(ctx context.Context, key string, reader io.Reader, opts PutOptions) error {
+	if opts.Compress {
+		return b.client.PutObjectGzip(ctx, key, reader, opts.Size, opts.SourceETag, opts.Metadata)
+	}
+	return b.client.PutObject(ctx, key, reader, opts.Size, opts.Metadata)
+}
+
+func (b *objectBackend) Stat(ctx context.Context, key string) (*minio.ObjectInfo, error) {
+	return b.client.StatObject(ctx, key)
+}
+
+func (b *objectBackend) Delete(ctx context.Context, key string) error {
+	return b.client.RemoveObject(ctx, key)
+}
+
+// Move copies the object on the server and removes the original, which is
+// left behind with a warning if it can't be removed
+func (b *objectBackend) Move(ctx context.Context, from, to string) error {
+	if err := b.client.CopyObject(ctx, from, to); err != nil {
+		return err
+	}
+	if err := b.client.RemoveObject(ctx, from); err != nil {
+		log.Printf("Warning: Failed to remove %s after copying it to %s: %v", from, to, err)
+	}
+	return nil
+}
+
+func (b *objectBackend) Hash(ctx context.Context, key string) (string, error) {
+	return b.client.HashObject(ctx, key)
+}
+
+// localBackend stores files in a local directory. Files have no ETag, so
+// copies are compared by size unless they are checksummed.
+type localBackend struct {
+	storage *local.Storage
+}
+
+func (b *localBackend) List(ctx context.Context, prefix string, fn func(minio.ObjectInfo) error) error {
+	return b.storage.ListFiles(func(sourcePath string, size int64) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(sourcePath, prefix) {
+			return nil
+		}
+		return fn(minio.ObjectInfo{Key: sourcePath, Size: size})
+	})
+}
+
+func (b *localBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return b.storage.OpenFile(key)
+}
+
+func (b *localBackend) Put(ctx context.Context, key string, reader io.Reader, opts PutOptions) error {
+	return b.storage.SaveFile(ctx, key, reader, opts.Size, opts.LastModified)
+}
+
+func (b *localBackend) Stat(ctx context.Context, key string) (*minio.ObjectInfo, error) {
+	info, err := b.storage.StatFile(key)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, fmt.Errorf("file %s: %w", key, fs.ErrNotExist)
+	}
+	return &minio.ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
+
+func (b *localBackend) Delete(ctx context.Context, key string) error {
+	return b.storage.DeleteFile(key)
+}
+
+func (b *localBackend) Move(ctx context.Context, from, to string) error {
+	return b.storage.MoveFile(from, to)
+}
+
+func (b *localBackend) Hash(ctx context.Context, key string) (string, error) {
+	return b.storage.HashFile(key)
+}
@@ -0,0 +1,367 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+	"golang.org/x/sync/errgroup"
+)
+
+// BisyncOptions controls a bidirectional sync run
+type BisyncOptions struct {
+	Workers int
+}
+
+// BisyncResult summarizes a bidirectional sync run
+type BisyncResult struct {
+	ToDest    int
+	ToSource  int
+	Conflicts int
+	// Skipped counts the conflicts left alone by the skip policy
+	Skipped int
+	Failed  int
+}
+
+// bisyncDirection is the way an object is copied by a bidirectional sync
+type bisyncDirection int
+
+const (
+	bisyncNone bisyncDirection = iota
+	bisyncToDest
+	bisyncToSource
+)
+
+// bisyncAction is the work planned for one path
+type bisyncAction struct {
+	key       string
+	direction bisyncDirection
+	conflict  bool
+	source    *minio.ObjectInfo
+	dest      *minio.ObjectInfo
+}
+
+func newConflictPolicy(policy config.ConflictPolicy) (config.ConflictPolicy, error) {
+	switch policy {
+	case "":
+		return config.ConflictNewestWins, nil
+	case config.ConflictNewestWins, config.ConflictSourceWins, config.ConflictSkip:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid conflict policy %q (must be %s, %s or %s)",
+			policy, config.ConflictNewestWins, config.ConflictSourceWins, config.ConflictSkip)
+	}
+}
+
+// Bisync propagates new and changed objects between the source folder and
+// the same keys at a MinIO or S3 destination in both directions. What both
+// sides looked like after the previous run is kept in the database, so a side
+// whose ETag differs from it has changed. Objects changed on both sides are
+// resolved by the conflict policy of the project. Deletions are not
+// propagated: an object missing on one side is copied from the other.
+func (s *Service) Bisync(ctx context.Context, opts BisyncOptions) (*BisyncResult, error) {
+	if !s.destType.IsObjectStore() {
+		return nil, fmt.Errorf("bidirectional sync needs a MinIO or S3 destination, not %s", s.destType)
+	}
+	if !s.sourceClient.TrustsETags() || !s.destClient.TrustsETags() {
+		return nil, fmt.Errorf("bidirectional sync detects changes by ETag and can't be used with trustETag: false")
+	}
+	if len(s.compression.patterns) > 0 {
+		return nil, fmt.Errorf("bidirectional sync can't be used with compression")
+	}
+
+	prefix := strings.Trim(s.sourceClient.GetFolderPath(), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	log.Printf("Listing source and destination prefix %q...", prefix)
+	sources, err := s.listTree(ctx, s.sourceClient, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source: %w", err)
+	}
+	dests, err := s.listTree(ctx, s.destClient, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list destination: %w", err)
+	}
+
+	states, err := s.database.GetBisyncStates(s.projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	actions, err := s.planBisync(sources, dests, states)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Bidirectional sync: %d source objects, %d destination objects, %d to process",
+		len(sources), len(dests), len(actions))
+
+	var (
+		result             BisyncResult
+		toDest, toSource   atomic.Int64
+		conflicts, skipped atomic.Int64
+		failed             atomic.Int64
+	)
+
+	g, groupCtx := errgroup.WithContext(ctx)
+	g.SetLimit(max(opts.Workers, 1))
+	for _, action := range actions {
+		if groupCtx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			if action.conflict {
+				conflicts.Add(1)
+			}
+			if err := s.bisyncPath(groupCtx, action); err != nil {
+				failed.Add(1)
+				log.Printf("Failed to sync %s: %v", action.key, err)
+				return nil
+			}
+			switch action.direction {
+			case bisyncToDest:
+				toDest.Add(1)
+			case bisyncToSource:
+				toSource.Add(1)
+			default:
+				if action.conflict {
+					skipped.Add(1)
+				}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	result.ToDest = int(toDest.Load())
+	result.ToSource = int(toSource.Load())
+	result.Conflicts = int(conflicts.Load())
+	result.Skipped = int(skipped.Load())
+	result.Failed = int(failed.Load())
+	log.Printf("Bidirectional sync finished: %d copied to destination, %d copied to source, %d conflicts (%d skipped), %d failed",
+		result.ToDest, result.ToSource, result.Conflicts, result.Skipped, result.Failed)
+
+	if err := ctx.Err(); err != nil {
+		return &result, fmt.Errorf("bidirectional sync cancelled: %w", err)
+	}
+	if result.Failed > 0 {
+		return &result, fmt.Errorf("bidirectional sync completed with %d errors", result.Failed)
+	}
+	return &result, nil
+}
+
+// listTree lists prefix at one side of an object store sync, leaving out the
+// copies the tool keeps below the version and staging prefixes
+func (s *Service) listTree(ctx context.Context, client *minio.MinioClient, prefix string) (map[string]minio.ObjectInfo, error) {
+	objects := make(map[string]minio.ObjectInfo)
+	err := client.ListPrefix(ctx, prefix, false, func(obj minio.ObjectInfo) error {
+		if strings.HasSuffix(obj.Key, "/") || s.isBookkeepingKey(obj.Key) {
+			return nil
+		}
+		objects[obj.Key] = obj
+		return nil
+	})
+	return objects, err
+}
+
+// planBisync decides for every path present on either side which way it is
+// copied. Paths that are in sync need no action, unless their state has to
+// be recorded.
+func (s *Service) planBisync(sources, dests map[string]minio.ObjectInfo, states map[string]*db.BisyncState) ([]bisyncAction, error) {
+	keys := make([]string, 0, len(sources)+len(dests))
+	for key := range sources {
+		keys = append(keys, key)
+	}
+	for key := range dests {
+		if _, ok := sources[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	// Objects present on both sides without a recorded state were either
+	// copied by sync, which is tracked in file_entries, or are a conflict
+	var unknown []string
+	for _, key := range keys {
+		_, inSource := sources[key]
+		_, inDest := dests[key]
+		if _, ok := states[key]; !ok && inSource && inDest {
+			unknown = append(unknown, key)
+		}
+	}
+	copied, err := s.database.GetFilesByPaths(s.projectName, unknown)
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []bisyncAction
+	for _, key := range keys {
+		action := bisyncAction{key: key}
+		source, inSource := sources[key]
+		dest, inDest := dests[key]
+		if inSource {
+			action.source = &source
+		}
+		if inDest {
+			action.dest = &dest
+		}
+
+		state, known := states[key]
+		switch {
+		case !inDest:
+			action.direction = bisyncToDest
+		case !inSource:
+			action.direction = bisyncToSource
+		case known:
+			sourceChanged := source.ETag != state.SourceETag || source.Size != state.SourceSize
+			destChanged := dest.ETag != state.DestETag || dest.Size != state.DestSize
+			switch {
+			case sourceChanged && destChanged:
+				action.conflict = true
+			case sourceChanged:
+				action.direction = bisyncToDest
+			case destChanged:
+				action.direction = bisyncToSource
+			default:
+				continue
+			}
+		default:
+			entry := copied[key]
+			inSync := source.ETag == dest.ETag && source.Size == dest.Size
+			syncedBefore := entry != nil && entry.ETag == source.ETag &&
+				(entry.Status == db.StatusCompleted || entry.Status == db.StatusSkippedExisting)
+			if !inSync && !syncedBefore {
+				action.conflict = true
+			}
+			// Otherwise only the state is recorded
+		}
+
+		if action.conflict {
+			action.direction = s.resolveConflict(source, dest)
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+// resolveConflict applies the conflict policy to an object changed on both
+// sides
+func (s *Service) resolveConflict(source, dest minio.ObjectInfo) bisyncDirection {
+	switch s.conflict {
+	case config.ConflictSourceWins:
+		return bisyncToDest
+	case config.ConflictSkip:
+		return bisyncNone
+	default:
+		// Which side is newer can't be told within the clock skew
+		// tolerance, so such conflicts are left alone like with skip
+		switch compareModified(dest.LastModified, source.LastModified, s.clockSkew) {
+		case 1:
+			return bisyncToSource
+		case 0:
+			if s.clockSkew > 0 {
+				log.Printf("Conflict: %s changed on both sides within the clock skew of %s", source.Key, s.clockSkew)
+				return bisyncNone
+			}
+		}
+		return bisyncToDest
+	}
+}
+
+// bisyncPath carries out the action planned for one path and records the
+// resulting state of both sides
+func (s *Service) bisyncPath(ctx context.Context, action bisyncAction) error {
+	source, dest := action.source, action.dest
+	var err error
+
+	switch action.direction {
+	case bisyncNone:
+		if action.conflict {
+			log.Printf("Conflict: %s changed on both sides, skipped", action.key)
+			return nil
+		}
+	case bisyncToDest:
+		log.Printf("Copying %s to destination", action.key)
+		if dest, err = s.copyBetween(ctx, s.sourceClient, s.destClient, action.key, source.Size); err != nil {
+			return err
+		}
+		s.destStats.invalidate(action.key)
+	case bisyncToSource:
+		log.Printf("Copying %s to source", action.key)
+		if source, err = s.copyBetween(ctx, s.destClient, s.sourceClient, action.key, dest.Size); err != nil {
+			return err
+		}
+	}
+
+	if err := s.database.SaveBisyncState(s.projectName, &db.BisyncState{
+		Path:       action.key,
+		SourceETag: source.ETag,
+		SourceSize: source.Size,
+		DestETag:   dest.ETag,
+		DestSize:   dest.Size,
+	}); err != nil {
+		return err
+	}
+
+	// The source object is now copied, keep the file list in step
+	entry := &db.FileEntry{
+		ProjectName:  s.projectName,
+		Path:         action.key,
+		Size:         source.Size,
+		ETag:         source.ETag,
+		LastModified: source.LastModified,
+		Status:       db.StatusPending,
+	}
+	if err := s.database.InsertFileEntry(entry); err != nil {
+		return fmt.Errorf("failed to track %s: %w", action.key, err)
+	}
+	if entry.Status != db.StatusCompleted {
+		return s.database.UpdateFileStatus(entry.ID, db.StatusCompleted, "")
+	}
+	return nil
+}
+
+// copyBetween copies key from one side to the other and returns the new
+// object as listed at the target
+func (s *Service) copyBetween(ctx context.Context, from, to *minio.MinioClient, key string, size int64) (*minio.ObjectInfo, error) {
+	release, err := s.budget.acquire(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	reader, err := from.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	counted := s.meter(ctx, reader)
+	if err := to.PutObject(ctx, key, counted, size, nil); err != nil {
+		return nil, err
+	}
+
+	var copied *minio.ObjectInfo
+	err = to.ListPrefix(ctx, key, false, func(obj minio.ObjectInfo) error {
+		if obj.Key == key {
+			copied = &obj
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list copy of %s: %w", key, err)
+	}
+	if copied == nil {
+		return nil, fmt.Errorf("copy of %s is missing after upload", key)
+	}
+	return copied, nil
+}
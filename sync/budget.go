@@ -0,0 +1,178 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+const (
+	// slotLease is how long a transfer slot stays taken without renewal
+	slotLease         = time.Minute
+	slotRenewInterval = 20 * time.Second
+	slotPollInterval  = 500 * time.Millisecond
+)
+
+// endpointBudget limits the concurrent transfers of all running projects to
+// the endpoints they share. Every transfer takes a slot of the source and
+// the destination endpoint first.
+type endpointBudget struct {
+	slots  *db.EndpointSlots
+	limits map[string]int
+	holder string
+	stop   context.CancelFunc
+}
+
+// heldSlot is a transfer slot taken by this process
+type heldSlot struct {
+	endpoint string
+	slot     int
+}
+
+// newEndpointBudget opens the shared slot database if any of the given
+// clients connects to an endpoint with a limit. It returns nil otherwise.
+func newEndpointBudget(cfg *config.ProjectConfig, clients []*minio.MinioClient) (*endpointBudget, error) {
+	limits := make(map[string]int)
+	for _, client := range clients {
+		if client == nil {
+			continue
+		}
+		endpoint := client.GetEndpoint()
+		if limit := cfg.Endpoints[endpoint].MaxTransfers; limit > 0 {
+			limits[endpoint] = limit
+		}
+	}
+	if len(limits) == 0 {
+		return nil, nil
+	}
+	if cfg.EndpointSlotsPath == "" {
+		return nil, fmt.Errorf("endpoint limits configured without a slot database")
+	}
+
+	slots, err := db.NewEndpointSlots(cfg.EndpointSlotsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open endpoint slots: %w", err)
+	}
+	for endpoint, limit := range limits {
+		log.Printf("Transfers to and from %s are limited to %d across all projects", endpoint, limit)
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	b := &endpointBudget{
+		slots:  slots,
+		limits: limits,
+		holder: fmt.Sprintf("%s/%d/%d", cfg.ProjectName, os.Getpid(), time.Now().UnixNano()),
+		stop:   stop,
+	}
+	go b.renew(ctx)
+	return b, nil
+}
+
+// renew keeps the leases of the held slots from expiring
+func (b *endpointBudget) renew(ctx context.Context) {
+	ticker := time.NewTicker(slotRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.slots.Renew(b.holder, slotLease); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
+	}
+}
+
+// acquire waits until a slot of every limited endpoint among clients is
+// free and returns the function giving them back
+func (b *endpointBudget) acquire(ctx context.Context, clients ...*minio.MinioClient) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+
+	// Slots are taken in a fixed order, so processes waiting for several
+	// endpoints can't deadlock each other
+	var endpoints []string
+	for _, client := range clients {
+		if client == nil {
+			continue
+		}
+		endpoint := client.GetEndpoint()
+		if b.limits[endpoint] > 0 && !slices.Contains(endpoints, endpoint) {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	slices.Sort(endpoints)
+
+	var held []heldSlot
+	release := func() {
+		for _, h := range held {
+			if err := b.slots.Release(h.endpoint, h.slot, b.holder); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
+	}
+	for _, endpoint := range endpoints {
+		slot, err := b.waitForSlot(ctx, endpoint)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		held = append(held, heldSlot{endpoint: endpoint, slot: slot})
+	}
+	return release, nil
+}
+
+func (b *endpointBudget) waitForSlot(ctx context.Context, endpoint string) (int, error) {
+	limit := b.limits[endpoint]
+	logged := false
+	for {
+		slot, err := b.slots.TryAcquire(endpoint, limit, b.holder, slotLease)
+		if err != nil {
+			return -1, err
+		}
+		if slot >= 0 {
+			return slot, nil
+		}
+		if !logged {
+			log.Printf("Debug: Waiting for one of the %d transfer slots of %s", limit, endpoint)
+			logged = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		case <-time.After(slotPollInterval):
+		}
+	}
+}
+
+// withinBudget runs fn once the source and dest have a free transfer slot
+func (s *Service) withinBudget(ctx context.Context, dest *minio.MinioClient, fn func() error) error {
+	release, err := s.budget.acquire(ctx, s.sourceClient, dest)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn()
+}
+
+// close gives back the slots still held and closes the slot database
+func (b *endpointBudget) close() error {
+	if b == nil {
+		return nil
+	}
+	b.stop()
+	if err := b.slots.ReleaseAll(b.holder); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return b.slots.Close()
+}
@@ -0,0 +1,113 @@
+package sync
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+// Sides of a project a catalog can be exported for
+const (
+	CatalogSource      = "source"
+	CatalogDestination = "destination"
+)
+
+var catalogHeader = []string{
+	"key", "version_id", "is_latest", "delete_marker", "size", "etag", "last_modified", "storage_class", "metadata", "tags",
+}
+
+// ExportCatalog writes an inventory of every version of the objects below
+// the source folder on one side of the project as CSV, including delete
+// markers. Metadata and tags are JSON objects; servers whose listings don't
+// include them are asked for them per version.
+func (s *Service) ExportCatalog(ctx context.Context, side string, w io.Writer) (int64, error) {
+	var client *minio.MinioClient
+	switch side {
+	case CatalogSource:
+		client = s.sourceClient
+	case CatalogDestination:
+		if !s.destType.IsObjectStore() {
+			return 0, fmt.Errorf("catalog export is only supported for MinIO and S3 destinations")
+		}
+		client = s.destClient
+	default:
+		return 0, fmt.Errorf("invalid catalog side %q (must be %s or %s)", side, CatalogSource, CatalogDestination)
+	}
+
+	prefix := strings.Trim(s.sourceClient.GetFolderPath(), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(catalogHeader); err != nil {
+		return 0, fmt.Errorf("failed to write catalog: %w", err)
+	}
+
+	log.Printf("Exporting %s catalog of %s/%s", side, client.GetBucketName(), prefix)
+	var count int64
+	err := client.ListVersions(ctx, prefix, func(obj minio.ObjectInfo) error {
+		// Only MinIO lists metadata, which always holds the content type
+		if obj.Metadata == nil && !obj.IsDeleteMarker {
+			var err error
+			obj.Metadata, obj.Tags, err = client.GetVersionMetadata(ctx, obj.Key, obj.VersionID)
+			if err != nil {
+				return err
+			}
+		}
+		metadata, err := catalogJSON(obj.Metadata)
+		if err != nil {
+			return err
+		}
+		tags, err := catalogJSON(obj.Tags)
+		if err != nil {
+			return err
+		}
+
+		record := []string{
+			obj.Key,
+			obj.VersionID,
+			strconv.FormatBool(obj.IsLatest),
+			strconv.FormatBool(obj.IsDeleteMarker),
+			strconv.FormatInt(obj.Size, 10),
+			obj.ETag,
+			obj.LastModified.UTC().Format(time.RFC3339),
+			obj.StorageClass,
+			metadata,
+			tags,
+		}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("failed to write catalog: %w", err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return count, fmt.Errorf("failed to write catalog: %w", err)
+	}
+	return count, nil
+}
+
+func catalogJSON(values map[string]string) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode catalog entry: %w", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,157 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+const defaultVersionPrefix = ".versions"
+
+// changePolicy handles source objects that change after they were copied
+type changePolicy struct {
+	policy        config.ChangePolicy
+	versionPrefix string
+}
+
+func newChangePolicy(cfg config.ChangeConfig) (changePolicy, error) {
+	policy := cfg.Policy
+	switch policy {
+	case "":
+		policy = config.ChangeRequeue
+	case config.ChangeRequeue, config.ChangeKeep, config.ChangeVersion:
+	default:
+		return changePolicy{}, fmt.Errorf("invalid change policy %q (must be %s, %s or %s)",
+			cfg.Policy, config.ChangeRequeue, config.ChangeKeep, config.ChangeVersion)
+	}
+
+	prefix := strings.Trim(cfg.VersionPrefix, "/")
+	if prefix == "" {
+		prefix = defaultVersionPrefix
+	}
+	return changePolicy{policy: policy, versionPrefix: prefix}, nil
+}
+
+// versionPath returns where the copy of file is kept once superseded
+func (c changePolicy) versionPath(file *db.FileEntry) string {
+	return path.Join(c.versionPrefix, file.Path) + "." + file.LastModified.UTC().Format("20060102T150405Z")
+}
+
+// entryWriter is implemented by *db.Database and *db.Batch
+type entryWriter interface {
+	UpdateFileEntry(entry *db.FileEntry) error
+	SetDriftETag(id int64, etag string) error
+	InsertFileChange(change *db.FileChange) error
+	SetPendingVersion(projectName, path, supersededPath string) error
+}
+
+// handleChange applies the change policy to a tracked file whose source
+// object now has a different ETag. Files that were not copied yet are always
+// queued again. It reports whether the file was queued.
+func (s *Service) handleChange(w entryWriter, exists *db.FileEntry, obj minio.ObjectInfo) (bool, error) {
+	copied := exists.Status == db.StatusCompleted || exists.Status == db.StatusSkippedExisting
+	if !copied {
+		return true, s.requeueChanged(w, exists, obj)
+	}
+
+	change := &db.FileChange{
+		ProjectName: s.projectName,
+		Path:        exists.Path,
+		OldETag:     exists.ETag,
+		OldSize:     exists.Size,
+		NewETag:     obj.ETag,
+		NewSize:     obj.Size,
+		Policy:      string(s.changes.policy),
+	}
+
+	switch s.changes.policy {
+	case config.ChangeKeep:
+		if exists.DriftETag == obj.ETag {
+			return false, nil
+		}
+		log.Printf("Warning: Source of %s changed after it was copied, keeping the first copy (ETag %s -> %s)",
+			exists.Path, exists.ETag, obj.ETag)
+		if err := w.SetDriftETag(exists.ID, obj.ETag); err != nil {
+			return false, fmt.Errorf("failed to flag drift: %w", err)
+		}
+		return false, w.InsertFileChange(change)
+
+	case config.ChangeVersion:
+		// The previous copy is kept when the file is copied again, see
+		// keepPendingVersion, so no request is made while listing
+		change.SupersededPath = s.changes.versionPath(exists)
+		if err := w.SetPendingVersion(s.projectName, exists.Path, change.SupersededPath); err != nil {
+			return false, err
+		}
+	}
+
+	if err := w.InsertFileChange(change); err != nil {
+		return false, err
+	}
+	return true, s.requeueChanged(w, exists, obj)
+}
+
+// requeueChanged stores the new source metadata and queues the file again
+func (s *Service) requeueChanged(w entryWriter, exists *db.FileEntry, obj minio.ObjectInfo) error {
+	log.Printf("Debug: Updating file %s (ETag changed: %s -> %s)", exists.Path, exists.ETag, obj.ETag)
+	exists.Size = obj.Size
+	exists.ETag = obj.ETag
+	exists.LastModified = obj.LastModified
+	exists.Status = db.StatusPending
+	exists.StatusReason = ""
+	exists.ErrorMessage = ""
+	exists.DriftETag = ""
+	if err := w.UpdateFileEntry(exists); err != nil {
+		return fmt.Errorf("failed to update file entry: %w", err)
+	}
+	return nil
+}
+
+// keepPendingVersion keeps the copy of file at the main destination that
+// the version policy recorded to be kept before it is replaced. A copy that
+// is gone by now has nothing to keep.
+func (s *Service) keepPendingVersion(ctx context.Context, file *db.FileEntry) error {
+	if s.changes.policy != config.ChangeVersion {
+		return nil
+	}
+	versionPath, err := s.database.GetPendingVersion(s.projectName, file.Path)
+	if err != nil || versionPath == "" {
+		return err
+	}
+
+	err = s.keepVersion(ctx, file.Path, versionPath)
+	switch {
+	case minio.IsNotFound(err):
+		log.Printf("Warning: Previous copy of %s is gone and can't be kept as %s", file.Path, versionPath)
+	case err != nil:
+		return fmt.Errorf("failed to keep previous copy of %s: %w", file.Path, err)
+	default:
+		log.Printf("Debug: Kept previous copy of %s as %s", file.Path, versionPath)
+	}
+	return s.database.DeletePendingVersion(s.projectName, file.Path)
+}
+
+// keepVersion preserves the copy of key at the main destination under
+// versionPath before it is replaced
+func (s *Service) keepVersion(ctx context.Context, key, versionPath string) error {
+	if s.destType == config.DestinationLocal {
+		return s.dest.Move(ctx, key, versionPath)
+	}
+	return s.destClient.CopyObject(ctx, key, versionPath)
+}
+
+// sameVersion reports whether a listed object is the recorded version of a
+// file although only one of their ETags was synthesized, because trustETag
+// was changed since the file was recorded
+func sameVersion(file *db.FileEntry, obj minio.ObjectInfo) bool {
+	if minio.IsSynthesizedETag(file.ETag) == minio.IsSynthesizedETag(obj.ETag) {
+		return false
+	}
+	return file.Size == obj.Size && file.LastModified.Unix() == obj.LastModified.Unix()
+}
@@ -0,0 +1,105 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+// checksumWriter hashes what is written to the main destination when it is
+// local storage, where copies have no ETag to compare with the source
+type checksumWriter struct {
+	hash hash.Hash
+}
+
+// newChecksumWriter returns a writer for copies of file to dest, or nil if
+// they are not checksummed
+func (s *Service) newChecksumWriter(dest StorageBackend) *checksumWriter {
+	if dest != s.dest || s.localDest == nil {
+		return nil
+	}
+	return &checksumWriter{hash: sha256.New()}
+}
+
+// wrap tees reader into the checksum
+func (w *checksumWriter) wrap(reader io.Reader) io.Reader {
+	if w == nil {
+		return reader
+	}
+	return io.TeeReader(reader, w.hash)
+}
+
+// record stores the checksum of a completed copy of file
+func (w *checksumWriter) record(s *Service, file *db.FileEntry) {
+	if w == nil {
+		return
+	}
+	s.recordSHA256(file, hex.EncodeToString(w.hash.Sum(nil)))
+}
+
+// recordSHA256 stores the checksum of the local copy of a file, which is
+// kept until the source object changes
+func (s *Service) recordSHA256(file *db.FileEntry, checksum string) {
+	if err := s.writer.setSHA256(file.ID, checksum); err != nil {
+		log.Printf("Warning: Failed to record checksum of %s: %v", file.Path, err)
+		return
+	}
+	file.SHA256 = checksum
+}
+
+// hashSHA256 reads an object and returns the hex encoded SHA256 checksum of
+// its content
+func hashSHA256(ctx context.Context, backend StorageBackend, key string) (string, error) {
+	reader, err := backend.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sourceSHA256 returns the SHA256 checksum of the content of a source object.
+// The checksum recorded when it was copied or one stored by the server is
+// used where available; otherwise the object is read.
+func (s *Service) sourceSHA256(ctx context.Context, file *db.FileEntry) (string, error) {
+	if file.SHA256 != "" {
+		return file.SHA256, nil
+	}
+	info, err := s.sourceClient.StatChecksums(ctx, file.Path)
+	if err != nil {
+		return "", err
+	}
+	if sum, err := base64.StdEncoding.DecodeString(info.Checksums["SHA256"]); err == nil && len(sum) == sha256.Size {
+		return hex.EncodeToString(sum), nil
+	}
+	return hashSHA256(ctx, s.source, file.Path)
+}
+
+// sameChecksum compares the local copy of a file with the source by their
+// SHA256 checksums and returns the reason it differs, or an empty string
+func (s *Service) sameChecksum(ctx context.Context, file *db.FileEntry) (string, error) {
+	sourceSum, err := s.sourceSHA256(ctx, file)
+	if err != nil {
+		return "", err
+	}
+	destSum, err := hashSHA256(ctx, s.dest, file.Path)
+	if err != nil {
+		return "", err
+	}
+	if sourceSum != destSum {
+		return fmt.Sprintf("SHA256 checksum mismatch: source %s, destination %s", sourceSum, destSum), nil
+	}
+	return "", nil
+}
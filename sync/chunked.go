@@ -0,0 +1,154 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+const defaultChunkSize = 64 << 20
+
+// chunkedDownloads splits the downloads of large objects to a local
+// destination into byte ranges fetched over concurrent connections
+type chunkedDownloads struct {
+	size int64
+	// concurrency is how many chunks of an object are in flight, 1 to
+	// download objects in one stream
+	concurrency int
+}
+
+func newChunkedDownloads(cfg config.TuningConfig) (chunkedDownloads, error) {
+	chunks := chunkedDownloads{size: defaultChunkSize, concurrency: 1}
+	if cfg.ChunkSize != "" {
+		size, err := config.ParseSize(cfg.ChunkSize)
+		if err != nil || size <= 0 {
+			return chunkedDownloads{}, fmt.Errorf("invalid chunk size %q", cfg.ChunkSize)
+		}
+		chunks.size = size
+	}
+	if cfg.ChunkConcurrency < 0 {
+		return chunkedDownloads{}, fmt.Errorf("invalid chunk concurrency: %d", cfg.ChunkConcurrency)
+	}
+	if cfg.ChunkConcurrency > 0 {
+		chunks.concurrency = cfg.ChunkConcurrency
+	}
+	return chunks, nil
+}
+
+// applies reports whether an object of size bytes is downloaded in chunks
+func (c chunkedDownloads) applies(size int64) bool {
+	return c.concurrency > 1 && size > c.size
+}
+
+// downloadChunked downloads file to the local main destination in chunks,
+// up to the configured number at once, each written at its offset. After
+// the first failure no further chunk is started; the complete chunks at the
+// start of the file are kept to resume the download.
+func (s *Service) downloadChunked(ctx context.Context, file *db.FileEntry) error {
+	if err := protectHeld(ctx, s.dest, file.Path); err != nil {
+		return err
+	}
+	part, err := s.localDest.CreatePart(file.Path, file.Size)
+	if err != nil {
+		return fmt.Errorf("failed to save file %s: %w", file.Path, err)
+	}
+
+	chunkCount := int((file.Size + s.chunks.size - 1) / s.chunks.size)
+	log.Printf("Debug: Downloading %s in %d chunks of %d bytes over %d connections",
+		file.Path, chunkCount, s.chunks.size, min(s.chunks.concurrency, chunkCount))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	done := make([]bool, chunkCount)
+	indexes := make(chan int)
+	for i := 0; i < min(s.chunks.concurrency, chunkCount); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				offset := int64(index) * s.chunks.size
+				length := min(s.chunks.size, file.Size-offset)
+				err := func() (err error) {
+					defer recoverTransfer(file.Path, &err)
+					return s.downloadChunk(ctx, file.Path, part.Writer(offset), offset, length)
+				}()
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					cancel()
+				} else {
+					done[index] = true
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for index := range chunkCount {
+		select {
+		case indexes <- index:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		complete := 0
+		for complete < chunkCount && done[complete] {
+			complete++
+		}
+		err := part.Abort(min(int64(complete)*s.chunks.size, file.Size), fmt.Errorf("failed to save file %s: %w", file.Path, firstErr))
+		s.recordPartialDownload(file, s.dest, err)
+		return err
+	}
+	if err := part.Commit(file.LastModified); err != nil {
+		return fmt.Errorf("failed to save file %s: %w", file.Path, err)
+	}
+
+	// The checksum covers the whole copy, so it is read once more
+	checksum, err := hashSHA256(ctx, s.dest, file.Path)
+	if err != nil {
+		log.Printf("Warning: Failed to checksum %s: %v", file.Path, err)
+		return nil
+	}
+	s.recordSHA256(file, checksum)
+	return nil
+}
+
+// downloadChunk copies a byte range of the source object to w
+func (s *Service) downloadChunk(ctx context.Context, sourcePath string, w io.Writer, offset, length int64) error {
+	reader, err := s.sourceClient.GetObjectRange(ctx, sourcePath, offset, length)
+	if err != nil {
+		return fmt.Errorf("failed to get range at %d of %s: %w", offset, sourcePath, err)
+	}
+	defer reader.Close()
+
+	written, err := io.Copy(w, s.toDisk(ctx, s.meter(ctx, reader)))
+	if err != nil {
+		return fmt.Errorf("failed to write range at %d: %w", offset, err)
+	}
+	if written != length {
+		return fmt.Errorf("range at %d of %s ended after %d of %d bytes", offset, sourcePath, written, length)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package sync
+
+import "time"
+
+// compareModified orders two modification times that may come from servers
+// with different clocks. It returns 1 if a is later than b, -1 if it is
+// earlier, and 0 if they are closer than the clock skew tolerance and can't
+// be ordered reliably.
+func compareModified(a, b time.Time, skew time.Duration) int {
+	switch diff := a.Sub(b); {
+	case diff > skew:
+		return 1
+	case diff < -skew:
+		return -1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,42 @@
+package sync
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+// compressionRules selects the files that are gzipped on their way to MinIO
+// destinations
+type compressionRules struct {
+	patterns []string
+	minSize  int64
+}
+
+func newCompressionRules(cfg config.CompressionConfig) (compressionRules, error) {
+	for _, pattern := range cfg.Patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return compressionRules{}, fmt.Errorf("invalid compression pattern %q: %w", pattern, err)
+		}
+	}
+	minSize, err := config.ParseSize(cfg.MinSize)
+	if err != nil {
+		return compressionRules{}, fmt.Errorf("invalid compression minimum size: %w", err)
+	}
+	return compressionRules{patterns: cfg.Patterns, minSize: minSize}, nil
+}
+
+// applies reports whether file is compressed when copied to MinIO
+func (c compressionRules) applies(file *db.FileEntry) bool {
+	if file.Size < c.minSize {
+		return false
+	}
+	for _, pattern := range c.patterns {
+		if matchPattern(pattern, file.Path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,251 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// controlSocketFile is the Unix socket a daemon accepts control commands on,
+// next to the project database. Unlike the progress port it can be reached
+// from other containers sharing the directory, and access is limited by the
+// permissions of the socket instead of a TCP port.
+const controlSocketFile = "control.sock"
+
+// Commands accepted by the control socket
+const (
+	ControlPause  = "pause"
+	ControlResume = "resume"
+	ControlStatus = "status"
+)
+
+// States of a daemon reported by the control socket
+const (
+	daemonListing = "listing"
+	daemonSyncing = "syncing"
+	daemonWaiting = "waiting"
+)
+
+// DaemonStatus is the state of a daemon as reported on its control socket
+type DaemonStatus struct {
+	PID    int  `json:"pid"`
+	Paused bool `json:"paused"`
+	// State is listing, syncing or waiting for the next cycle
+	State     string    `json:"state"`
+	NextCycle time.Time `json:"nextCycle,omitempty"`
+	// Progress is set while a sync is running
+	Progress *RunProgress `json:"progress,omitempty"`
+	// Storm describes why the workers are paused by the failure rate
+	Storm string `json:"storm,omitempty"`
+}
+
+// daemonControl is the state a daemon shares with its control socket. A
+// paused daemon lets transfers in flight finish but starts no new ones and
+// no new cycles until it is resumed.
+type daemonControl struct {
+	mu       sync.Mutex
+	paused   bool
+	state    string
+	next     time.Time
+	progress func() RunProgress
+	// resumed is closed and replaced when the daemon is resumed
+	resumed chan struct{}
+}
+
+func newDaemonControl() *daemonControl {
+	return &daemonControl{state: daemonListing, resumed: make(chan struct{})}
+}
+
+func (c *daemonControl) pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		log.Printf("Daemon paused, transfers in flight are finished but no new ones are started")
+		c.paused = true
+	}
+}
+
+func (c *daemonControl) resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		log.Printf("Daemon resumed")
+		c.paused = false
+		close(c.resumed)
+		c.resumed = make(chan struct{})
+	}
+}
+
+// isPaused reports whether the daemon is paused, false without a daemon
+func (c *daemonControl) isPaused() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// wait returns once the daemon isn't paused, or with an error when ctx is
+// done or stop is closed first
+func (c *daemonControl) wait(ctx context.Context, stop <-chan struct{}) error {
+	if c == nil {
+		return nil
+	}
+	for {
+		c.mu.Lock()
+		paused, resumed := c.paused, c.resumed
+		c.mu.Unlock()
+		if !paused {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-stop:
+			return errStopRequested
+		case <-resumed:
+		}
+	}
+}
+
+// setState records what the daemon is doing and, while waiting, when the
+// next cycle starts
+func (c *daemonControl) setState(state string, next time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state, c.next = state, next
+}
+
+// setProgress sets the progress of the running sync, nil once it ended
+func (c *daemonControl) setProgress(progress func() RunProgress) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.progress = progress
+}
+
+func (c *daemonControl) status() DaemonStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := DaemonStatus{
+		PID:       os.Getpid(),
+		Paused:    c.paused,
+		State:     c.state,
+		NextCycle: c.next,
+	}
+	if c.progress != nil {
+		progress := c.progress()
+		status.Progress = &progress
+	}
+	return status
+}
+
+// serveControl accepts control commands for the daemon on the control
+// socket of the project. The returned function stops it again.
+func (s *Service) serveControl(control *daemonControl) func() {
+	// A socket left behind by a killed daemon refuses connections and is
+	// replaced; one that answers belongs to another running daemon
+	if conn, err := net.DialTimeout("unix", s.controlPath, progressTimeout); err == nil {
+		conn.Close()
+		log.Printf("Warning: Another daemon is listening on %s, not accepting control commands", s.controlPath)
+		return func() {}
+	}
+	os.Remove(s.controlPath)
+
+	listener, err := net.Listen("unix", s.controlPath)
+	if err != nil {
+		log.Printf("Warning: Failed to open control socket: %v", err)
+		return func() {}
+	}
+	if err := os.Chmod(s.controlPath, 0600); err != nil {
+		log.Printf("Warning: Failed to restrict control socket: %v", err)
+	}
+	log.Printf("Accepting control commands on %s", s.controlPath)
+
+	reply := func(w http.ResponseWriter) {
+		status := control.status()
+		status.Storm = s.storm.paused()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.Printf("Warning: Failed to send daemon status: %v", err)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /"+ControlStatus, func(w http.ResponseWriter, r *http.Request) {
+		reply(w)
+	})
+	mux.HandleFunc("POST /"+ControlPause, func(w http.ResponseWriter, r *http.Request) {
+		control.pause()
+		reply(w)
+	})
+	mux.HandleFunc("POST /"+ControlResume, func(w http.ResponseWriter, r *http.Request) {
+		// Resuming also ends a pause after a storm of failures, e.g. once
+		// the credentials were fixed
+		control.resume()
+		s.storm.resume()
+		reply(w)
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+
+	// Closing the listener removes the socket
+	return func() { server.Close() }
+}
+
+// SendControl sends a control command to the daemon of the project and
+// returns its state afterwards
+func (s *Service) SendControl(command string) (*DaemonStatus, error) {
+	method := http.MethodPost
+	switch command {
+	case ControlStatus:
+		method = http.MethodGet
+	case ControlPause, ControlResume:
+	default:
+		return nil, fmt.Errorf("invalid control command %q, must be %s, %s or %s", command, ControlPause, ControlResume, ControlStatus)
+	}
+
+	if _, err := os.Stat(s.controlPath); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no daemon is running for project %s (%s not found)", s.projectName, s.controlPath)
+	}
+	client := &http.Client{
+		Timeout: progressTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", s.controlPath)
+			},
+		},
+	}
+	req, err := http.NewRequest(method, "http://daemon/"+command, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create control request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach daemon on %s: %w", s.controlPath, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("daemon refused %s: %s", command, resp.Status)
+	}
+
+	var status DaemonStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to decode daemon status: %w", err)
+	}
+	return &status, nil
+}
@@ -0,0 +1,186 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// maxListingFailures is how many source listings in a row may fail before a
+// daemon that exits when synced gives up, as it can't tell it is synced
+const maxListingFailures = 3
+
+// RunOptions controls the daemon loop started by Run
+type RunOptions struct {
+	Workers  int
+	Interval time.Duration
+	// Schedule, if set, replaces Interval with the times of a cron
+	// expression or @every <duration>, see parseSchedule
+	Schedule string
+	List     ListOptions
+	// SkipExisting, Force, ExistingFromListing and Delta are passed on to
+	// every sync run
+	SkipExisting        bool
+	Force               bool
+	ExistingFromListing bool
+	Delta               bool
+	// StallTimeout and StallDump configure the watchdog of every sync run
+	StallTimeout time.Duration
+	StallDump    bool
+	// ExitWhenSynced makes Run return once the backlog has been empty and no
+	// new changes were found for at least this long, and fail once the source
+	// can't be listed several times in a row. Zero keeps running forever.
+	ExitWhenSynced time.Duration
+	// Watch replaces the scheduled listings with the bucket notifications
+	// of a MinIO source, see watch
+	Watch bool
+	// Stop ends the daemon gracefully once closed: a running sync stops
+	// dispatching, lets its in-flight transfers finish and Run returns.
+	// Cancelling the context stops everything at once.
+	Stop <-chan struct{}
+}
+
+// Run keeps the source list and destination in sync by running update-list
+// followed by sync right away and then on the schedule, until the context is
+// cancelled or Stop is closed
+func (s *Service) Run(ctx context.Context, opts RunOptions) error {
+	s.control = newDaemonControl()
+	defer s.serveControl(s.control)()
+
+	if opts.Watch {
+		if opts.ExitWhenSynced > 0 {
+			return fmt.Errorf("exit-when-synced can't be used when watching bucket notifications")
+		}
+		return s.watch(ctx, opts)
+	}
+
+	var sched schedule
+	if opts.Schedule != "" {
+		var err error
+		if sched, err = parseSchedule(opts.Schedule); err != nil {
+			return err
+		}
+		log.Printf("Starting daemon mode (schedule: %s, workers: %d)", opts.Schedule, opts.Workers)
+	} else {
+		if opts.Interval <= 0 {
+			return fmt.Errorf("interval must be greater than zero")
+		}
+		sched = intervalSchedule(opts.Interval)
+		log.Printf("Starting daemon mode (interval: %s, workers: %d)", opts.Interval, opts.Workers)
+	}
+	if opts.ExitWhenSynced > 0 {
+		log.Printf("Daemon will exit once synced and idle for %s", opts.ExitWhenSynced)
+	}
+
+	idleSince := time.Now()
+	listingFailures := 0
+	for {
+		if !s.waitResumed(ctx, opts) {
+			return nil
+		}
+		s.control.setState(daemonListing, time.Time{})
+		if err := s.UpdateSourceList(ctx, opts.List); err != nil {
+			log.Printf("Warning: Failed to update source file list: %v", err)
+			// Changes the listing missed may be pending, so the backlog
+			// isn't known to be empty
+			idleSince = time.Now()
+			listingFailures++
+			if opts.ExitWhenSynced > 0 && listingFailures >= maxListingFailures {
+				return fmt.Errorf("source listing failed %d times in a row: %w", listingFailures, err)
+			}
+		} else {
+			listingFailures = 0
+		}
+
+		synced, err := s.syncPending(ctx, opts)
+		if err != nil {
+			return err
+		}
+		if synced {
+			idleSince = time.Now()
+		}
+
+		if opts.ExitWhenSynced > 0 {
+			remaining, err := s.database.CountPendingFiles(s.projectName)
+			if err != nil {
+				log.Printf("Warning: Failed to count pending files: %v", err)
+			} else if remaining > 0 {
+				idleSince = time.Now()
+			} else if idle := time.Since(idleSince); idle >= opts.ExitWhenSynced {
+				log.Printf("Backlog is empty and no changes seen for %s, exiting", idle.Round(time.Second))
+				return nil
+			}
+		}
+
+		next := sched.next(time.Now())
+		log.Printf("Next cycle at %s", next.Format(time.RFC3339))
+		s.control.setState(daemonWaiting, next)
+		select {
+		case <-ctx.Done():
+			log.Printf("Daemon stopped: %v", ctx.Err())
+			return nil
+		case <-opts.Stop:
+			log.Printf("Daemon stopped gracefully")
+			return nil
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// waitResumed waits while the daemon is paused and reports whether it may
+// continue, false once it was stopped
+func (s *Service) waitResumed(ctx context.Context, opts RunOptions) bool {
+	err := s.control.wait(ctx, opts.Stop)
+	switch {
+	case errors.Is(err, errStopRequested):
+		log.Printf("Daemon stopped gracefully")
+		return false
+	case err != nil:
+		log.Printf("Daemon stopped: %v", err)
+		return false
+	}
+	return true
+}
+
+// syncPending runs a sync if files are pending and the daemon isn't paused,
+// and reports whether it did. Failures of the run are logged; only an alert
+// abort is returned.
+func (s *Service) syncPending(ctx context.Context, opts RunOptions) (bool, error) {
+	if s.control.isPaused() {
+		return false, nil
+	}
+	pending, err := s.database.CountPendingFiles(s.projectName)
+	if err != nil {
+		log.Printf("Warning: Failed to count pending files: %v", err)
+		return false, nil
+	}
+	if pending == 0 {
+		return false, nil
+	}
+
+	s.control.setState(daemonSyncing, time.Time{})
+	defer s.control.setState(daemonWaiting, time.Time{})
+	result, err := s.StartSync(ctx, SyncOptions{
+		Workers:             opts.Workers,
+		SkipExisting:        opts.SkipExisting,
+		Force:               opts.Force,
+		ExistingFromListing: opts.ExistingFromListing,
+		Delta:               opts.Delta,
+		StallTimeout:        opts.StallTimeout,
+		StallDump:           opts.StallDump,
+		Stop:                opts.Stop,
+	})
+	if err != nil {
+		if errors.Is(err, ErrAlertAbort) {
+			return true, err
+		}
+		log.Printf("Warning: Sync run failed: %v", err)
+	}
+	if result.Failed > 0 || result.DestinationErrors > 0 {
+		log.Printf("Warning: Sync run finished with %d failed files and errors on %d additional destinations",
+			result.Failed, result.DestinationErrors)
+	}
+	return true, nil
+}
@@ -0,0 +1,149 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+// deltaProbeSize is how many bytes at the start and at the end of an existing
+// local copy are compared with the source before it is extended in place
+const deltaProbeSize = 64 << 10
+
+// deltaCopy brings an existing local copy of a grown source object up to date
+// by fetching only the appended bytes. S3 cannot checksum ranges on the
+// server, so a changed range anywhere else can only be found by downloading
+// it; deltaCopy therefore handles appends only. The probes at the start and
+// end of the copy rule out most other changes cheaply, and the extended copy
+// is checked against the checksum of the whole source object, so a change in
+// between is caught too. deltaCopy returns false when the file has to be
+// copied in full, including sources without a checksum to check with.
+func (s *Service) deltaCopy(ctx context.Context, file *db.FileEntry) (bool, error) {
+	// Encrypted and compressed copies are written as a whole and always
+	// copied in full
+	if s.localDest.Encrypted() || s.localDest.Compressed() {
+		return false, nil
+	}
+	info, err := s.localDest.StatFile(file.Path)
+	if err != nil || info == nil {
+		return false, nil
+	}
+	existing := info.Size()
+	if existing == 0 || existing >= file.Size {
+		return false, nil
+	}
+	if err := protectHeld(ctx, s.dest, file.Path); err != nil {
+		return false, err
+	}
+	sourceSHA256, sourceMD5, err := s.sourceDigest(ctx, file)
+	if err != nil {
+		return false, err
+	}
+	if sourceSHA256 == "" && sourceMD5 == "" {
+		log.Printf("Debug: Source of %s has no checksum to check an extended copy with, copying it in full", file.Path)
+		return false, nil
+	}
+
+	probe := min(int64(deltaProbeSize), existing)
+	for _, offset := range []int64{0, existing - probe} {
+		same, err := s.sameRange(ctx, file.Path, offset, probe)
+		if err != nil {
+			return false, err
+		}
+		if !same {
+			log.Printf("Debug: Local copy of %s differs at offset %d, copying in full", file.Path, offset)
+			return false, nil
+		}
+	}
+
+	reader, err := s.sourceClient.GetObjectRange(ctx, file.Path, existing, file.Size-existing)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	counted := s.toDisk(ctx, s.meter(ctx, reader))
+	if err := s.localDest.AppendFile(ctx, file.Path, counted, file.LastModified); err != nil {
+		return false, err
+	}
+
+	log.Printf("Delta: appended %d bytes to %s, reused %d bytes of the local copy",
+		file.Size-existing, file.Path, existing)
+
+	localSHA256, localMD5, err := hashLocal(ctx, s.dest, file.Path)
+	if err != nil {
+		return false, err
+	}
+	if sourceSHA256 != "" && localSHA256 != sourceSHA256 || sourceSHA256 == "" && localMD5 != sourceMD5 {
+		log.Printf("Delta: extended copy of %s differs from the source, copying it in full", file.Path)
+		return false, nil
+	}
+	s.recordSHA256(file, localSHA256)
+	return true, nil
+}
+
+// sourceDigest returns the hex encoded SHA256 checksum the server stores for
+// a source object, or else the MD5 checksum its ETag is for objects uploaded
+// in one part. Both are empty if neither is known.
+func (s *Service) sourceDigest(ctx context.Context, file *db.FileEntry) (sha256Sum, md5Sum string, err error) {
+	info, err := s.sourceClient.StatChecksums(ctx, file.Path)
+	if err != nil {
+		return "", "", err
+	}
+	// A source changed since it was listed is copied in full
+	if info.ETag != file.ETag {
+		return "", "", nil
+	}
+	if sum, err := base64.StdEncoding.DecodeString(info.Checksums["SHA256"]); err == nil && len(sum) == sha256.Size {
+		return hex.EncodeToString(sum), "", nil
+	}
+	// Multipart and synthesized ETags contain a "-" and are no checksum
+	if _, err := hex.DecodeString(file.ETag); err == nil && len(file.ETag) == 2*md5.Size {
+		return "", file.ETag, nil
+	}
+	return "", "", nil
+}
+
+// hashLocal reads a copy once and returns its hex encoded SHA256 and MD5
+// checksums
+func hashLocal(ctx context.Context, backend StorageBackend, key string) (sha256Sum, md5Sum string, err error) {
+	reader, err := backend.Get(ctx, key)
+	if err != nil {
+		return "", "", err
+	}
+	defer reader.Close()
+
+	sha256Hash, md5Hash := sha256.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(sha256Hash, md5Hash), reader); err != nil {
+		return "", "", fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return hex.EncodeToString(sha256Hash.Sum(nil)), hex.EncodeToString(md5Hash.Sum(nil)), nil
+}
+
+// sameRange compares a range of the source object with the local copy
+func (s *Service) sameRange(ctx context.Context, key string, offset, length int64) (bool, error) {
+	local, err := s.localDest.ReadRange(key, offset, length)
+	if err != nil {
+		return false, err
+	}
+
+	reader, err := s.sourceClient.GetObjectRange(ctx, key, offset, length)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	remote, err := io.ReadAll(s.meter(ctx, reader))
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(local, remote), nil
+}
@@ -0,0 +1,271 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/gcs"
+	"github.com/chmdznr/minio-simple-copier/v2/local"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+	"github.com/chmdznr/minio-simple-copier/v2/s3"
+)
+
+// extraDestination is an additional destination of a multi-destination
+// project. Its state is tracked separately from the main destination.
+type extraDestination struct {
+	name    string
+	backend StorageBackend
+	// client is nil for local destinations
+	client  *minio.MinioClient
+	include []string
+	exclude []string
+	// rangeOffset and rangeLength select the part of files copied, all of
+	// them if rangeLength is zero
+	rangeOffset int64
+	rangeLength int64
+}
+
+func newExtraDestination(cfg config.DestinationConfig, sourceFolderPath string) (*extraDestination, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("destination name is required")
+	}
+
+	for _, pattern := range append(append([]string{}, cfg.Include...), cfg.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q for destination %s: %w", pattern, cfg.Name, err)
+		}
+	}
+
+	dest := &extraDestination{
+		name:    cfg.Name,
+		include: cfg.Include,
+		exclude: cfg.Exclude,
+	}
+
+	if cfg.Range != nil {
+		var err error
+		if dest.rangeOffset, err = config.ParseSize(cfg.Range.Offset); err != nil {
+			return nil, fmt.Errorf("invalid range offset for destination %s: %w", cfg.Name, err)
+		}
+		if dest.rangeLength, err = config.ParseSize(cfg.Range.Length); err != nil {
+			return nil, fmt.Errorf("invalid range length for destination %s: %w", cfg.Name, err)
+		}
+		if dest.rangeLength <= 0 {
+			return nil, fmt.Errorf("the range of destination %s requires a length", cfg.Name)
+		}
+	}
+
+	var (
+		storage *local.Storage
+		err     error
+	)
+	switch cfg.Type {
+	case config.DestinationMinio:
+		if cfg.Dest == nil {
+			return nil, fmt.Errorf("destination %s has no dest section", cfg.Name)
+		}
+		dest.client, err = minio.NewMinioClient(cfg.Dest)
+	case config.DestinationS3:
+		if cfg.S3 == nil {
+			return nil, fmt.Errorf("destination %s has no s3 section", cfg.Name)
+		}
+		dest.client, err = s3.NewClient(cfg.S3)
+	case config.DestinationGCS:
+		if cfg.GCS == nil {
+			return nil, fmt.Errorf("destination %s has no gcs section", cfg.Name)
+		}
+		dest.client, err = gcs.NewClient(cfg.GCS)
+	case config.DestinationLocal:
+		if cfg.Local == nil {
+			return nil, fmt.Errorf("destination %s has no local section", cfg.Name)
+		}
+		if cfg.Local.Pack != nil {
+			return nil, fmt.Errorf("destination %s: packing small files is only supported at the main destination", cfg.Name)
+		}
+		if storage, err = local.NewStorage(cfg.Local, sourceFolderPath); err == nil {
+			removeOrphanedParts(storage, nil)
+		}
+	default:
+		return nil, fmt.Errorf("invalid type %q for destination %s", cfg.Type, cfg.Name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create destination %s: %w", cfg.Name, err)
+	}
+	dest.backend = newBackend(dest.client, storage)
+	return dest, nil
+}
+
+// filterReason returns why key is not copied to this destination, or an
+// empty string if it is
+func (d *extraDestination) filterReason(key string) string {
+	for _, pattern := range d.exclude {
+		if matchPattern(pattern, key) {
+			return fmt.Sprintf("excluded by %q", pattern)
+		}
+	}
+	if len(d.include) == 0 {
+		return ""
+	}
+	for _, pattern := range d.include {
+		if matchPattern(pattern, key) {
+			return ""
+		}
+	}
+	return "not matched by any include pattern"
+}
+
+// rangeReason returns why file is not copied to this destination because it
+// ends before the range starts, or an empty string if it is
+func (d *extraDestination) rangeReason(file *db.FileEntry) string {
+	if d.rangeLength > 0 && file.Size <= d.rangeOffset {
+		return fmt.Sprintf("smaller than range offset %d", d.rangeOffset)
+	}
+	return ""
+}
+
+// matchPattern matches a glob against the full key, or against the file name
+// when the pattern has no "/"
+func matchPattern(pattern, key string) bool {
+	if !strings.Contains(pattern, "/") {
+		key = path.Base(key)
+	}
+	matched, _ := path.Match(pattern, key)
+	return matched
+}
+
+// syncExtraDestination copies the tracked files matching the filters of an
+// additional destination
+func (s *Service) syncExtraDestination(ctx context.Context, dest *extraDestination, workers int) error {
+	files, err := s.database.GetDestinationWork(s.projectName, dest.name)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Destination %s: %d files to evaluate", dest.name, len(files))
+	if len(files) == 0 {
+		return nil
+	}
+
+	var (
+		wg, inFlight              sync.WaitGroup
+		mu                        sync.Mutex
+		copied, filtered, errored int
+		kept                      int
+	)
+	filesChan := make(chan *db.FileEntry, workers)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			for file := range filesChan {
+				status, reason, errorMessage := db.StatusCompleted, "", ""
+				if reason = dest.filterReason(file.Path); reason == "" {
+					reason = dest.rangeReason(file)
+				}
+				if reason != "" {
+					status = db.StatusSkippedFiltered
+				} else if err := s.withinBudget(ctx, dest.client, func() error {
+					if dest.rangeLength > 0 {
+						return s.copyRangeTo(ctx, file, dest)
+					}
+					return s.copyFileTo(ctx, file, file.Path, dest.backend)
+				}); errors.Is(err, ErrLegalHold) {
+					log.Printf("Worker %d: Not overwriting %s at %s (%s)", workerID, file.Path, dest.name, legalHoldReason)
+					status, reason = db.StatusSkippedExisting, legalHoldReason
+				} else if err != nil {
+					log.Printf("Worker %d: Failed to copy file %s to %s: %v", workerID, file.Path, dest.name, err)
+					status, errorMessage = db.StatusError, err.Error()
+				} else {
+					log.Printf("Worker %d: Copied file %s to %s", workerID, file.Path, dest.name)
+				}
+
+				if err := s.writer.setDestinationStatus(s.projectName, dest.name, file, status, reason, errorMessage); err != nil {
+					log.Printf("Worker %d: Failed to update status of %s for %s: %v", workerID, file.Path, dest.name, err)
+				}
+
+				mu.Lock()
+				switch status {
+				case db.StatusCompleted:
+					copied++
+				case db.StatusSkippedFiltered:
+					filtered++
+				case db.StatusSkippedExisting:
+					kept++
+				default:
+					errored++
+				}
+				mu.Unlock()
+				inFlight.Done()
+			}
+		}(i)
+	}
+
+	// Files held back by ordering rules go last, once their dependencies
+	// reached this destination
+	files, held := s.ordering.split(files)
+	dispatch := func(files []*db.FileEntry) {
+		for _, file := range files {
+			if ctx.Err() != nil {
+				break
+			}
+			inFlight.Add(1)
+			filesChan <- file
+		}
+	}
+	dispatch(files)
+	if len(held) > 0 {
+		inFlight.Wait()
+		dispatch(s.releaseHeld(held, func(folder string) ([]string, error) {
+			return s.database.GetUnfinishedDestinationPaths(s.projectName, dest.name, folder)
+		}))
+	}
+	close(filesChan)
+	wg.Wait()
+
+	log.Printf("Destination %s: %d copied, %d filtered, %d errors", dest.name, copied, filtered, errored)
+	if kept > 0 {
+		log.Printf("Destination %s: %d files not overwritten, their copies are under legal hold", dest.name, kept)
+	}
+	if errored > 0 {
+		return fmt.Errorf("destination %s completed with %d errors", dest.name, errored)
+	}
+	return nil
+}
+
+// copyRangeTo copies the configured byte range of a file to an additional
+// destination, with a ranged request to the source
+func (s *Service) copyRangeTo(ctx context.Context, file *db.FileEntry, dest *extraDestination) error {
+	length := min(dest.rangeLength, file.Size-dest.rangeOffset)
+	reader, err := s.sourceClient.GetObjectRange(ctx, file.Path, dest.rangeOffset, length)
+	if err != nil {
+		return fmt.Errorf("failed to get range of file %s: %w", file.Path, err)
+	}
+	defer reader.Close()
+	counted := s.meter(ctx, reader)
+	if _, ok := unheld(dest.backend).(*localBackend); ok {
+		counted = s.toDisk(ctx, counted)
+	}
+
+	var metadata *minio.ObjectMetadata
+	if _, ok := unheld(dest.backend).(*objectBackend); ok && s.addMetadata.applies(file) {
+		metadata = s.addMetadata.add(file, s.runID, nil)
+	}
+	err = dest.backend.Put(ctx, file.Path, counted, PutOptions{
+		Size:         length,
+		SourceETag:   file.ETag,
+		Metadata:     metadata,
+		LastModified: file.LastModified,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save range of file %s: %w", file.Path, err)
+	}
+	return nil
+}
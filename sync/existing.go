@@ -0,0 +1,38 @@
+package sync
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+// skipListedFiles lists the main destination once and marks the pending files
+// found there with the same size and ETag as skipped_existing. It returns the
+// pending files that exist with different content; only those still need a
+// per-file check, e.g. because they were stored compressed.
+func (s *Service) skipListedFiles(ctx context.Context) (map[string]bool, error) {
+	prefix := strings.Trim(s.sourceClient.GetFolderPath(), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	log.Printf("Listing destination prefix %q to find existing files...", prefix)
+	var listed int64
+	skipped, present, err := s.database.MarkListedFiles(s.projectName, "destination object listed with same size and ETag",
+		func(add func(db.ListedObject) error) error {
+			return s.destClient.ListPrefix(ctx, prefix, false, func(obj minio.ObjectInfo) error {
+				listed++
+				return add(db.ListedObject{Path: obj.Key, Size: obj.Size, ETag: obj.ETag})
+			})
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Destination listing: %d objects, %d pending files already present, %d present with different content",
+		listed, skipped, len(present))
+	return present, nil
+}
@@ -0,0 +1,144 @@
+package sync
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+// SharedProject is another project writing to the same destination, whose
+// recorded files are not extraneous
+type SharedProject struct {
+	Name         string
+	DatabasePath string
+	StateBackend config.StateBackendConfig
+}
+
+// ExtrasReport lists the destination objects that neither the source nor any
+// project writing to the destination knows about
+type ExtrasReport struct {
+	// Scanned counts the destination objects below the source folder
+	Scanned int
+	Extras  []minio.ObjectInfo
+	Size    int64
+	// Folders totals the extras per top-level folder below the prefix
+	Folders []ExtrasFolder
+}
+
+// ExtrasFolder is the number and total size of the extras in one folder
+type ExtrasFolder struct {
+	Folder string
+	Count  int
+	Size   int64
+}
+
+var extrasHeader = []string{"key", "size", "etag", "last_modified"}
+
+// FindExtras reports the objects below the source folder at the main
+// destination that are in neither a fresh listing of the source, the file
+// list or inventory of this project, nor the file lists of the shared
+// projects. Nothing is deleted; the report tells what -mirror would have to
+// consider before it is enabled.
+func (s *Service) FindExtras(ctx context.Context, shared []SharedProject) (*ExtrasReport, error) {
+	prefix := strings.Trim(s.sourceClient.GetFolderPath(), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	log.Printf("Finding extras: listing source and destination prefix %q...", prefix)
+	dests, err := s.listDestinationTree(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list destination: %w", err)
+	}
+	sources, err := s.listTree(ctx, s.sourceClient, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source: %w", err)
+	}
+
+	scanned := len(dests)
+
+	// Keys known anywhere are removed, the rest are extras
+	forget := func(key string) { delete(dests, key) }
+	for key := range sources {
+		forget(key)
+	}
+	if err := s.database.ForEachKnownPath(s.projectName, prefix, forget); err != nil {
+		return nil, err
+	}
+	for _, project := range shared {
+		if err := forgetProjectPaths(project, prefix, forget); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &ExtrasReport{Scanned: scanned}
+	folders := make(map[string]*ExtrasFolder)
+	for _, obj := range dests {
+		report.Extras = append(report.Extras, obj)
+		report.Size += obj.Size
+
+		folder, _, found := strings.Cut(strings.TrimPrefix(obj.Key, prefix), "/")
+		if !found {
+			folder = ""
+		}
+		folder = path.Join(prefix, folder)
+		if folders[folder] == nil {
+			folders[folder] = &ExtrasFolder{Folder: folder}
+		}
+		folders[folder].Count++
+		folders[folder].Size += obj.Size
+	}
+	sort.Slice(report.Extras, func(i, j int) bool { return report.Extras[i].Key < report.Extras[j].Key })
+	for _, folder := range folders {
+		report.Folders = append(report.Folders, *folder)
+	}
+	sort.Slice(report.Folders, func(i, j int) bool { return report.Folders[i].Size > report.Folders[j].Size })
+
+	log.Printf("Found %d extraneous destination objects (%d bytes)", len(report.Extras), report.Size)
+	return report, nil
+}
+
+// forgetProjectPaths calls forget with the paths recorded by another
+// project, read from its database without locking it
+func forgetProjectPaths(project SharedProject, prefix string, forget func(string)) error {
+	database, err := db.OpenReadOnlyDatabase(project.StateBackend.Driver, project.StateBackend.DSN, project.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to open database of project %s: %w", project.Name, err)
+	}
+	defer database.Close()
+
+	log.Printf("Keeping the files of project %s, which writes to the same destination", project.Name)
+	return database.ForEachKnownPath(project.Name, prefix, forget)
+}
+
+// WriteCSV writes the extras with their size, ETag and modification time
+func (r *ExtrasReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(extrasHeader); err != nil {
+		return fmt.Errorf("failed to write extras: %w", err)
+	}
+	for _, obj := range r.Extras {
+		record := []string{
+			obj.Key,
+			strconv.FormatInt(obj.Size, 10),
+			obj.ETag,
+			obj.LastModified.UTC().Format(time.RFC3339),
+		}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("failed to write extras: %w", err)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,33 @@
+package sync
+
+import (
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+// FileHistory is a tracked file with the last errors it failed with
+type FileHistory struct {
+	*db.FileEntry
+	Errors []db.ErrorRecord
+}
+
+// ListFiles returns the files of the project whose path starts with prefix,
+// limited to statuses unless it is empty. With errorHistory, the last errors
+// of every file are included, newest first.
+func (s *Service) ListFiles(statuses []db.FileStatus, prefix string, errorHistory bool) ([]FileHistory, error) {
+	files, err := s.database.GetFiles(s.projectName, statuses, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]FileHistory, 0, len(files))
+	for _, file := range files {
+		entry := FileHistory{FileEntry: file}
+		if errorHistory {
+			if entry.Errors, err = s.database.GetErrorHistory(file.ID); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
@@ -0,0 +1,213 @@
+package sync
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+// regexPrefix marks include and exclude patterns that are regular
+// expressions rather than globs
+const regexPrefix = "regex:"
+
+// objectFilter selects the source files a project copies by key, size and
+// age. Globs are matched against the full key, or against the file name
+// when they have no "/"; "**" also matches across folders, so "logs/**"
+// selects everything below logs/. Patterns starting with "regex:" are
+// regular expressions matched against the full key.
+type objectFilter struct {
+	include []keyPattern
+	exclude []keyPattern
+	// minSize, maxSize, newerThan and olderThan are zero when not set;
+	// the configured values are kept for the reasons
+	minSize, maxSize     int64
+	newerThan, olderThan time.Duration
+	cfg                  *config.ProjectConfig
+}
+
+type keyPattern struct {
+	pattern string
+	// re is set for regular expressions and globs with "**", the other globs
+	// are matched with path.Match like the patterns of other settings
+	re *regexp.Regexp
+}
+
+// newObjectFilter returns the filter configured for the project, or nil if
+// all files are copied
+func newObjectFilter(cfg *config.ProjectConfig) (*objectFilter, error) {
+	f := &objectFilter{cfg: cfg}
+	for _, pattern := range cfg.Include {
+		p, err := compileKeyPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+		f.include = append(f.include, p)
+	}
+	for _, pattern := range cfg.Exclude {
+		p, err := compileKeyPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+		f.exclude = append(f.exclude, p)
+	}
+
+	var err error
+	if f.minSize, err = config.ParseSize(cfg.MinSize); err != nil {
+		return nil, fmt.Errorf("invalid minimum size: %w", err)
+	}
+	if f.maxSize, err = config.ParseSize(cfg.MaxSize); err != nil {
+		return nil, fmt.Errorf("invalid maximum size: %w", err)
+	}
+	if f.maxSize > 0 && f.minSize > f.maxSize {
+		return nil, fmt.Errorf("minimum size %s is above maximum size %s", cfg.MinSize, cfg.MaxSize)
+	}
+	if f.newerThan, err = config.ParseAge(cfg.NewerThan); err != nil {
+		return nil, fmt.Errorf("invalid newer-than age: %w", err)
+	}
+	if f.olderThan, err = config.ParseAge(cfg.OlderThan); err != nil {
+		return nil, fmt.Errorf("invalid older-than age: %w", err)
+	}
+	if f.newerThan > 0 && f.olderThan >= f.newerThan {
+		return nil, fmt.Errorf("no file can be older than %s and newer than %s", cfg.OlderThan, cfg.NewerThan)
+	}
+
+	if len(f.include) == 0 && len(f.exclude) == 0 && f.minSize == 0 && f.maxSize == 0 && f.newerThan == 0 && f.olderThan == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func compileKeyPattern(pattern string) (keyPattern, error) {
+	if expr, ok := strings.CutPrefix(pattern, regexPrefix); ok {
+		re, err := regexp.Compile(expr)
+		return keyPattern{pattern: pattern, re: re}, err
+	}
+	if strings.Contains(pattern, "**") {
+		re, err := globRegexp(pattern)
+		return keyPattern{pattern: pattern, re: re}, err
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return keyPattern{}, err
+	}
+	return keyPattern{pattern: pattern}, nil
+}
+
+// globRegexp translates a glob with "**" into a regular expression. "**/"
+// matches any number of folders including none.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			switch {
+			case strings.HasPrefix(pattern[i:], "**/"):
+				expr.WriteString("(?:.*/)?")
+				i += 2
+			case strings.HasPrefix(pattern[i:], "**"):
+				expr.WriteString(".*")
+				i++
+			default:
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, path.ErrBadPattern
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "^") {
+				class = "^/" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+func (p keyPattern) matches(key string) bool {
+	if p.re != nil {
+		return p.re.MatchString(key)
+	}
+	return matchPattern(p.pattern, key)
+}
+
+// reason returns why a file is not copied, or an empty string if it is.
+// Sizes and modification times that are unknown, as for imported key
+// lists, select the file.
+func (f *objectFilter) reason(key string, size int64, modified time.Time) string {
+	if f == nil {
+		return ""
+	}
+	for _, p := range f.exclude {
+		if p.matches(key) {
+			return fmt.Sprintf("excluded by %q", p.pattern)
+		}
+	}
+	if len(f.include) > 0 && !slices.ContainsFunc(f.include, func(p keyPattern) bool { return p.matches(key) }) {
+		return "not matched by any include pattern"
+	}
+
+	if size >= 0 {
+		if f.minSize > 0 && size < f.minSize {
+			return fmt.Sprintf("smaller than %s", f.cfg.MinSize)
+		}
+		if f.maxSize > 0 && size > f.maxSize {
+			return fmt.Sprintf("larger than %s", f.cfg.MaxSize)
+		}
+	}
+	if !modified.IsZero() {
+		age := time.Since(modified)
+		if f.newerThan > 0 && age > f.newerThan {
+			return fmt.Sprintf("not modified within %s", f.cfg.NewerThan)
+		}
+		if f.olderThan > 0 && age < f.olderThan {
+			return fmt.Sprintf("modified within %s", f.cfg.OlderThan)
+		}
+	}
+	return ""
+}
+
+// dropExcluded marks the pending files the filter no longer selects as
+// skipped_filtered, e.g. after a pattern was added since the last listing or
+// as they aged, and returns the others
+func (s *Service) dropExcluded(files []*db.FileEntry) []*db.FileEntry {
+	if s.filter == nil {
+		return files
+	}
+	kept := files[:0]
+	excluded := 0
+	for _, file := range files {
+		reason := s.filter.reason(file.Path, file.Size, file.LastModified)
+		if reason == "" {
+			kept = append(kept, file)
+			continue
+		}
+		if err := s.database.UpdateFileStatusReason(file.ID, db.StatusSkippedFiltered, reason); err != nil {
+			log.Printf("Warning: Failed to update status of %s: %v", file.Path, err)
+		}
+		excluded++
+	}
+	if excluded > 0 {
+		log.Printf("Skipping %d pending files left out by the filters (status %s)", excluded, db.StatusSkippedFiltered)
+	}
+	return kept
+}
@@ -0,0 +1,325 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+const (
+	// erasedReason is the status reason of keys erased on request
+	erasedReason = "erased on request"
+	// forgetCommand is recorded as the requester of erasures by the forget
+	// command
+	forgetCommand         = "forget command"
+	defaultErasureTimeout = 30 * time.Second
+	// maxErasureFeedPages bounds the pages of the feed read at once, the
+	// rest is read by the next sync
+	maxErasureFeedPages = 100
+)
+
+// erasureFeed reads the erasure requests of the configured service, see
+// config.ErasureConfig
+type erasureFeed struct {
+	url     string
+	token   string
+	project string
+	bucket  string
+	client  *http.Client
+}
+
+// erasureFeedResponse is a page of the feed. Requests are returned after
+// the cursor of the previous page; an empty page ends the feed.
+type erasureFeedResponse struct {
+	Requests []struct {
+		ID     string `json:"id"`
+		Key    string `json:"key"`
+		Reason string `json:"reason,omitempty"`
+	} `json:"requests"`
+	Cursor string `json:"cursor"`
+}
+
+// newErasureFeed returns the erasure feed of the project, or nil if there
+// is none
+func newErasureFeed(cfg *config.ProjectConfig) (*erasureFeed, error) {
+	if cfg.Erasure.FeedURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.Erasure.FeedURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid erasure feed URL %q", cfg.Erasure.FeedURL)
+	}
+	timeout := cfg.Erasure.Timeout
+	if timeout <= 0 {
+		timeout = defaultErasureTimeout
+	}
+	return &erasureFeed{
+		url:     cfg.Erasure.FeedURL,
+		token:   cfg.Erasure.Token,
+		project: cfg.ProjectName,
+		bucket:  cfg.SourceMinio.BucketName,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// page fetches the requests after cursor
+func (f *erasureFeed) page(ctx context.Context, cursor string) (*erasureFeedResponse, error) {
+	u, err := url.Parse(f.url)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	query.Set("project", f.project)
+	query.Set("bucket", f.bucket)
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create erasure feed request: %w", err)
+	}
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read erasure feed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("erasure feed returned status %s", resp.Status)
+	}
+	var page erasureFeedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("failed to decode erasure feed: %w", err)
+	}
+	return &page, nil
+}
+
+// ReadErasureFeed records the new requests of the erasure feed as
+// tombstones and returns how many were read. The position in the feed is
+// saved after every page.
+func (s *Service) ReadErasureFeed(ctx context.Context) (int, error) {
+	if s.erasureFeed == nil {
+		return 0, fmt.Errorf("no erasure feed is configured for project %s", s.projectName)
+	}
+	cursor, err := s.database.GetErasureFeedCursor(s.projectName)
+	if err != nil {
+		return 0, err
+	}
+
+	read := 0
+	for range maxErasureFeedPages {
+		page, err := s.erasureFeed.page(ctx, cursor)
+		if err != nil {
+			return read, err
+		}
+		for _, request := range page.Requests {
+			if request.Key == "" {
+				continue
+			}
+			err := s.database.AddTombstone(&db.Tombstone{
+				ProjectName: s.projectName,
+				Path:        request.Key,
+				Reason:      request.Reason,
+				RequestedBy: "feed request " + request.ID,
+			})
+			if err != nil {
+				return read, err
+			}
+			read++
+		}
+		if len(page.Requests) == 0 || page.Cursor == "" || page.Cursor == cursor {
+			break
+		}
+		cursor = page.Cursor
+		if err := s.database.SaveErasureFeedCursor(s.projectName, cursor); err != nil {
+			return read, err
+		}
+	}
+	if read > 0 {
+		log.Printf("Read %d erasure requests from the erasure feed", read)
+	}
+	return read, nil
+}
+
+// Forget erases all copies of key and records a tombstone, so that it is
+// never copied again
+func (s *Service) Forget(ctx context.Context, key, reason string) error {
+	tombstone := &db.Tombstone{
+		ProjectName: s.projectName,
+		Path:        key,
+		Reason:      reason,
+		RequestedBy: forgetCommand,
+	}
+	if err := s.database.AddTombstone(tombstone); err != nil {
+		return err
+	}
+	return s.erase(ctx, tombstone)
+}
+
+// EraseTombstones erases the copies of every key whose erasure was
+// requested but didn't finish, e.g. because a destination was unreachable.
+// It returns how many keys were erased and how many are left.
+func (s *Service) EraseTombstones(ctx context.Context) (erased, failed int, err error) {
+	tombstones, err := s.database.GetUnerasedTombstones(s.projectName)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, tombstone := range tombstones {
+		if err := ctx.Err(); err != nil {
+			return erased, failed, err
+		}
+		if err := s.erase(ctx, tombstone); err != nil {
+			log.Printf("Warning: %v", err)
+			failed++
+			continue
+		}
+		erased++
+	}
+	return erased, failed, nil
+}
+
+// applyErasures reads the erasure feed and erases the requested keys before
+// a sync, so that none of them is copied again
+func (s *Service) applyErasures(ctx context.Context) {
+	if s.erasureFeed != nil {
+		if _, err := s.ReadErasureFeed(ctx); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	erased, failed, err := s.EraseTombstones(ctx)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	if erased > 0 {
+		log.Printf("Erased %d keys on request", erased)
+	}
+	if failed > 0 {
+		log.Printf("Warning: %d requested erasures are unfinished and are attempted again by the next sync", failed)
+	}
+}
+
+// erase removes every copy of the key of tombstone: the file at the main
+// destination with its staged and superseded copies, its unfinished
+// uploads and downloads, and its files at the additional destinations. The
+// file is set to deleted first, so it is no longer copied even if some copy
+// can't be removed; the tombstone stays unerased until all of them are,
+// except copies under legal hold, which are kept.
+func (s *Service) erase(ctx context.Context, tombstone *db.Tombstone) error {
+	key := tombstone.Path
+	reason := erasedReason
+	if tombstone.Reason != "" {
+		reason += ": " + tombstone.Reason
+	}
+	if err := s.database.MarkDeleted(&db.FileEntry{ProjectName: s.projectName, Path: key, StatusReason: reason}); err != nil {
+		return err
+	}
+
+	var (
+		errs []error
+		// held are the copies kept because they are under legal hold
+		held []string
+	)
+	superseded, err := s.database.GetSupersededPaths(s.projectName, key)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	destPaths := []string{key}
+	if s.atomic.groupDepth > 0 {
+		destPaths = append(destPaths, s.atomic.stagingPath(key))
+	}
+	for _, destPath := range append(destPaths, superseded...) {
+		err := s.eraseFromDestination(ctx, destPath)
+		switch {
+		case errors.Is(err, ErrLegalHold):
+			held = append(held, destPath)
+		case err != nil:
+			errs = append(errs, err)
+		}
+	}
+	for _, dest := range s.extraDests {
+		err := dest.backend.Delete(ctx, key)
+		switch {
+		case errors.Is(err, ErrLegalHold):
+			held = append(held, fmt.Sprintf("%s at destination %s", key, dest.name))
+			continue
+		case err != nil && !minio.IsNotFound(err):
+			errs = append(errs, fmt.Errorf("failed to delete from destination %s: %w", dest.name, err))
+			continue
+		}
+		entry := &db.FileEntry{Path: key}
+		if err := s.database.SetDestinationStatus(s.projectName, dest.name, entry, db.StatusDeleted, reason, ""); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to erase %s: %w", key, err)
+	}
+
+	// Held copies are kept for good, the erasure isn't attempted again
+	if err := s.database.MarkTombstoneErased(s.projectName, key); err != nil {
+		return err
+	}
+	for _, kept := range held {
+		log.Printf("Warning: Kept %s, it is under legal hold and has to be erased by hand once the hold is lifted", kept)
+	}
+	log.Printf("Erased %s and %d superseded copies", key, len(superseded))
+	return nil
+}
+
+// eraseFromDestination removes destPath from the main destination,
+// including unfinished uploads or downloads of it and its content in pack
+// archives. Copies under legal hold are refused with ErrLegalHold.
+func (s *Service) eraseFromDestination(ctx context.Context, destPath string) error {
+	defer s.destStats.invalidate(destPath)
+
+	// Nothing of a copy under legal hold is touched, not even its content
+	// in a pack archive
+	if err := protectHeld(ctx, s.dest, destPath); err != nil {
+		return err
+	}
+
+	if s.destClient != nil {
+		upload, err := s.database.GetMultipartUpload(s.projectName, destPath)
+		if err != nil {
+			return err
+		}
+		if upload != nil {
+			if err := s.destClient.AbortMultipartUpload(ctx, destPath, upload.UploadID); err != nil {
+				return fmt.Errorf("failed to abort upload of %s: %w", destPath, err)
+			}
+			if err := s.database.DeleteMultipartUpload(s.projectName, destPath); err != nil {
+				return err
+			}
+		}
+	}
+	if s.localDest != nil {
+		if err := s.localDest.DeletePart(destPath); err != nil {
+			return err
+		}
+		if err := s.database.DeletePartialDownload(s.projectName, destPath); err != nil {
+			return err
+		}
+	}
+	if s.packer != nil {
+		if err := s.packer.scrub(destPath); err != nil {
+			return err
+		}
+	}
+	if err := s.dest.Delete(ctx, destPath); err != nil && !minio.IsNotFound(err) {
+		return fmt.Errorf("failed to delete %s from destination: %w", destPath, err)
+	}
+	return nil
+}
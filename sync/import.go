@@ -0,0 +1,382 @@
+package sync
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+	"golang.org/x/sync/errgroup"
+)
+
+// importProgressInterval is how often import-list reports its progress
+const importProgressInterval = 10 * time.Second
+
+// maxImportLine bounds a single line of the import file
+const maxImportLine = 1 << 20
+
+type MCListEntry struct {
+	Status       string    `json:"status"`
+	Type         string    `json:"type"`
+	LastModified time.Time `json:"lastModified"`
+	Size         int64     `json:"size"`
+	Key          string    `json:"key"`
+	ETag         string    `json:"etag"`
+	StorageClass string    `json:"storageClass"`
+}
+
+// ImportOptions controls ImportFileList
+type ImportOptions struct {
+	// BatchSize is the number of imported files written per transaction
+	BatchSize int
+	// Restart ignores the checkpoint of an interrupted import of the file
+	Restart bool
+	// Format is the format of the import file: ImportFormatJSON,
+	// ImportFormatText, ImportFormatCSV or ImportFormatAuto (the default)
+	Format string
+	// URLDecode decodes URL-encoded keys, as listed with encoding-type url
+	URLDecode bool
+	// StripPrefix is removed from the start of keys, e.g. the alias, bucket
+	// and folder path some mc commands print in front of every key
+	StripPrefix string
+	// CheckSample is the number of keys of the first batch checked to exist
+	// in the source before anything is imported; 0 disables the check
+	CheckSample int
+}
+
+// ImportFileList imports a listing of the source folder, optionally
+// gzip-compressed, into the database: the output of mc ls --recursive --json,
+// a list of keys or CSV records of key,size,etag,modified. Objects whose size
+// or ETag is missing are looked up in the source. The file is streamed and
+// written in batches; after every batch the number of lines done is saved, so
+// an interrupted import continues where it stopped when it is run again with
+// the same, unchanged file.
+func (s *Service) ImportFileList(ctx context.Context, paths []string, opts ImportOptions) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("no file paths provided")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+
+	// Read JSON entries from the file
+	file, err := os.Open(paths[0]) // paths[0] is the import file path
+	if err != nil {
+		return fmt.Errorf("failed to open import file: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat import file: %w", err)
+	}
+
+	checkpoint, err := s.importCheckpoint(paths[0], info, opts.Restart)
+	if err != nil {
+		return err
+	}
+
+	var read atomic.Int64
+	reader, err := openImportFile(&countingReader{reader: file, counter: &read})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var (
+		tally      listCounts
+		line       int64
+		lines      atomic.Int64
+		imported   atomic.Int64
+		batch      = make([]minio.ObjectInfo, 0, opts.BatchSize)
+		folderPath = s.sourceClient.GetFolderPath()
+		// An import resumed after the first batch has passed the check
+		checked = checkpoint.Line > 0 || opts.CheckSample <= 0
+	)
+	imported.Store(checkpoint.ObjectCount)
+
+	// The batch and the checkpoint after its last line are committed together
+	flush := func() error {
+		if !checked {
+			checked = true
+			if err := s.checkImportSample(ctx, batch, opts.CheckSample); err != nil {
+				return err
+			}
+		}
+		var err error
+		if batch, err = s.statImported(ctx, batch); err != nil {
+			return err
+		}
+		cp := *checkpoint
+		cp.Line = line
+		cp.ObjectCount += int64(len(batch))
+		for _, obj := range batch {
+			cp.TotalSize += obj.Size
+		}
+		if err := s.recordBatch(ctx, batch, ListOptions{}, &tally, &cp); err != nil {
+			return fmt.Errorf("failed to record %d imported files: %w", len(batch), err)
+		}
+		*checkpoint = cp
+		imported.Store(cp.ObjectCount)
+		batch = batch[:0]
+		return nil
+	}
+
+	stopProgress := make(chan struct{})
+	defer close(stopProgress)
+	go func() {
+		ticker := time.NewTicker(importProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopProgress:
+				return
+			case <-ticker.C:
+				log.Printf("Import progress: %d lines, %d files imported, %d of %d bytes read (%.1f%%)",
+					lines.Load(), imported.Load(), read.Load(), info.Size(),
+					float64(read.Load())*100/float64(max(info.Size(), 1)))
+			}
+		}
+	}()
+
+	entries, format, err := newImportReader(reader, opts.Format)
+	if err != nil {
+		return err
+	}
+	log.Printf("Debug: Importing %s as %s", paths[0], format)
+
+	for {
+		entry, n, err := entries.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading import file at line %d: %w", line+1, err)
+		}
+		line = n
+		lines.Store(line)
+		if entry == nil || line <= checkpoint.Line {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		key, err := normalizeImportKey(entry.Key, opts)
+		if err != nil {
+			log.Printf("Warning: Skipping key %q in line %d: %v", entry.Key, line, err)
+			continue
+		}
+
+		// Add folder prefix to path if set
+		filePath := key
+		if folderPath != "" {
+			filePath = path.Join(folderPath, key)
+		}
+		batch = append(batch, minio.ObjectInfo{
+			Key:          filePath,
+			Size:         entry.Size,
+			ETag:         entry.ETag,
+			LastModified: entry.LastModified,
+			StorageClass: entry.StorageClass,
+		})
+		if len(batch) >= opts.BatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	log.Printf("Imported %d files (%d lines): added/updated %d, skipped %d",
+		checkpoint.ObjectCount, line, tally.added, tally.skipped)
+	if tally.excluded > 0 {
+		log.Printf("%d imported files left out by the filters (status %s)", tally.excluded, db.StatusSkippedFiltered)
+	}
+
+	// Print status distribution
+	counts, err := s.database.GetStatusCounts(s.projectName)
+	if err != nil {
+		log.Printf("Warning: Failed to get status counts: %v", err)
+	} else {
+		log.Printf("Debug: Status distribution after import:")
+		for _, count := range counts {
+			log.Printf("  - Status %s: %d files (%d bytes)", count.Status, count.Count, count.Size)
+		}
+	}
+
+	run := &db.ListingRun{
+		ProjectName: s.projectName,
+		Source:      "import-list",
+		ObjectCount: checkpoint.ObjectCount,
+		TotalSize:   checkpoint.TotalSize,
+		Complete:    true,
+		StartedAt:   checkpoint.StartedAt,
+		FinishedAt:  time.Now(),
+	}
+	if err := s.database.InsertListingRun(run); err != nil {
+		log.Printf("Warning: Failed to record listing run: %v", err)
+	}
+	if err := s.database.DeleteImportCheckpoint(s.projectName, paths[0]); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	s.recordStats("import-list", nil)
+
+	s.logAccounting()
+	return nil
+}
+
+// normalizeImportKey returns the key relative to the source folder of a key
+// read from an import file
+func normalizeImportKey(key string, opts ImportOptions) (string, error) {
+	if opts.URLDecode {
+		decoded, err := url.QueryUnescape(key)
+		if err != nil {
+			return "", fmt.Errorf("invalid URL encoding: %w", err)
+		}
+		key = decoded
+	}
+	if prefix := strings.TrimSuffix(opts.StripPrefix, "/"); prefix != "" {
+		if !strings.HasPrefix(key, prefix+"/") {
+			return "", fmt.Errorf("key does not start with %s/", prefix)
+		}
+		key = strings.TrimPrefix(key, prefix+"/")
+	}
+	if key == "" {
+		return "", fmt.Errorf("empty key")
+	}
+	return key, nil
+}
+
+// checkImportSample looks up an evenly spread sample of up to n objects of
+// the first batch in the source and fails if more than half of them don't
+// exist, which usually means the keys need -strip-prefix or -url-decode-keys
+// rather than that the source lost most of its objects
+func (s *Service) checkImportSample(ctx context.Context, batch []minio.ObjectInfo, n int) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	n = min(n, len(batch))
+
+	var missing atomic.Int64
+	g, groupCtx := errgroup.WithContext(ctx)
+	g.SetLimit(importStatWorkers)
+	for i := 0; i < n; i++ {
+		key := batch[i*len(batch)/n].Key
+		g.Go(func() error {
+			_, err := s.sourceClient.StatObject(groupCtx, key)
+			if minio.IsNotFound(err) {
+				log.Printf("Warning: Sampled key %s not found in the source", key)
+				missing.Add(1)
+				return nil
+			}
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("failed to check imported keys: %w", err)
+	}
+
+	if missing.Load()*2 > int64(n) {
+		return fmt.Errorf("%d of %d sampled keys (e.g. %s) don't exist in %s/%s; check the key format or use -strip-prefix/-url-decode-keys",
+			missing.Load(), n, batch[0].Key, s.sourceClient.GetBucketName(), s.sourceClient.GetFolderPath())
+	}
+	log.Printf("Checked %d sampled keys in the source, %d missing", n, missing.Load())
+	return nil
+}
+
+// importStatWorkers bounds the concurrent lookups of imported objects
+const importStatWorkers = 16
+
+// statImported looks up the size and ETag of imported objects that lack
+// them, since they are needed to copy and to detect changes. Objects that
+// don't exist in the source are dropped.
+func (s *Service) statImported(ctx context.Context, batch []minio.ObjectInfo) ([]minio.ObjectInfo, error) {
+	missing := make([]bool, len(batch))
+	g, groupCtx := errgroup.WithContext(ctx)
+	g.SetLimit(importStatWorkers)
+	for i := range batch {
+		if batch[i].Size >= 0 && batch[i].ETag != "" {
+			continue
+		}
+		g.Go(func() error {
+			info, err := s.sourceClient.StatObject(groupCtx, batch[i].Key)
+			if minio.IsNotFound(err) {
+				log.Printf("Warning: Imported object %s not found in the source, skipping", batch[i].Key)
+				missing[i] = true
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			batch[i].Size, batch[i].ETag = info.Size, info.ETag
+			if batch[i].LastModified.IsZero() {
+				batch[i].LastModified = info.LastModified
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to look up imported objects: %w", err)
+	}
+
+	found := batch[:0]
+	for i, obj := range batch {
+		if !missing[i] {
+			found = append(found, obj)
+		}
+	}
+	return found, nil
+}
+
+// importCheckpoint returns the checkpoint to continue an import of the file
+// from, or a new one starting at the first line
+func (s *Service) importCheckpoint(filePath string, info os.FileInfo, restart bool) (*db.ImportCheckpoint, error) {
+	fresh := &db.ImportCheckpoint{
+		ProjectName: s.projectName,
+		FilePath:    filePath,
+		FileSize:    info.Size(),
+		FileModTime: info.ModTime(),
+		StartedAt:   time.Now(),
+	}
+	if restart {
+		return fresh, nil
+	}
+
+	checkpoint, err := s.database.GetImportCheckpoint(s.projectName, filePath)
+	if err != nil || checkpoint == nil {
+		return fresh, err
+	}
+	if checkpoint.FileSize != info.Size() || !checkpoint.FileModTime.Equal(info.ModTime()) {
+		log.Printf("Import file changed since the interrupted import of %s, starting over", checkpoint.UpdatedAt.Format(time.RFC3339))
+		return fresh, nil
+	}
+	log.Printf("Resuming interrupted import after line %d (%d files imported)", checkpoint.Line, checkpoint.ObjectCount)
+	return checkpoint, nil
+}
+
+// openImportFile returns a reader of the import file content, decompressing
+// it if it is gzip-compressed
+func openImportFile(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open compressed import file: %w", err)
+		}
+		return gz, nil
+	}
+	return io.NopCloser(buffered), nil
+}
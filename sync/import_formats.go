@@ -0,0 +1,234 @@
+package sync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of import files
+const (
+	ImportFormatAuto = "auto"
+	ImportFormatJSON = "json"
+	ImportFormatText = "text"
+	ImportFormatCSV  = "csv"
+)
+
+// unknownSize marks imported objects whose size was not in the import file
+const unknownSize = -1
+
+// importReader reads the objects of an import file one at a time
+type importReader interface {
+	// next returns the next object and the line it ends on, or a nil entry
+	// for lines without an object. It returns io.EOF at the end of the file.
+	next() (*MCListEntry, int64, error)
+}
+
+// newImportReader returns a reader for the given format, detecting it from
+// the first line for ImportFormatAuto
+func newImportReader(r io.Reader, format string) (importReader, string, error) {
+	buffered := bufio.NewReaderSize(r, maxImportLine)
+	if format == "" || format == ImportFormatAuto {
+		format = detectImportFormat(buffered)
+	}
+
+	switch format {
+	case ImportFormatJSON, ImportFormatText:
+		scanner := bufio.NewScanner(buffered)
+		scanner.Buffer(make([]byte, 64*1024), maxImportLine)
+		if format == ImportFormatJSON {
+			return &jsonImportReader{scanner: scanner}, format, nil
+		}
+		return &textImportReader{scanner: scanner}, format, nil
+	case ImportFormatCSV:
+		reader := csv.NewReader(buffered)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		reader.ReuseRecord = true
+		return &csvImportReader{reader: reader}, format, nil
+	default:
+		return nil, "", fmt.Errorf("invalid import format %q (must be %s, %s, %s or %s)",
+			format, ImportFormatAuto, ImportFormatJSON, ImportFormatText, ImportFormatCSV)
+	}
+}
+
+// detectImportFormat guesses the format from the first non-empty line: mc
+// JSON starts with a brace, CSV has commas, anything else is a key list
+func detectImportFormat(r *bufio.Reader) string {
+	head, _ := r.Peek(r.Size())
+	for _, line := range bytes.Split(head, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		switch {
+		case len(line) == 0:
+			continue
+		case line[0] == '{':
+			return ImportFormatJSON
+		case bytes.ContainsRune(line, ','):
+			return ImportFormatCSV
+		default:
+			return ImportFormatText
+		}
+	}
+	return ImportFormatJSON
+}
+
+// jsonImportReader reads the output of mc ls --recursive --json
+type jsonImportReader struct {
+	scanner *bufio.Scanner
+	line    int64
+}
+
+func (r *jsonImportReader) next() (*MCListEntry, int64, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return nil, r.line, err
+		}
+		return nil, r.line, io.EOF
+	}
+	r.line++
+
+	data := bytes.TrimSpace(r.scanner.Bytes())
+	if len(data) == 0 {
+		return nil, r.line, nil
+	}
+	var entry MCListEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		log.Printf("Warning: Failed to parse JSON line %d: %v", r.line, err)
+		return nil, r.line, nil
+	}
+	// Skip non-file entries
+	if entry.Type != "file" {
+		return nil, r.line, nil
+	}
+	return &entry, r.line, nil
+}
+
+// textImportReader reads a list of keys, one per line
+type textImportReader struct {
+	scanner *bufio.Scanner
+	line    int64
+}
+
+func (r *textImportReader) next() (*MCListEntry, int64, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return nil, r.line, err
+		}
+		return nil, r.line, io.EOF
+	}
+	r.line++
+
+	key := strings.TrimSpace(r.scanner.Text())
+	if key == "" || strings.HasSuffix(key, "/") {
+		return nil, r.line, nil
+	}
+	return &MCListEntry{Key: key, Size: unknownSize}, r.line, nil
+}
+
+// csvImportReader reads key,size,etag,modified records. A header row naming
+// the columns is detected and allows any order and additional columns.
+type csvImportReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+	started bool
+	line    int64
+}
+
+// csvColumnNames maps the accepted header names to the columns they name
+var csvColumnNames = map[string]string{
+	"key": "key", "path": "key", "name": "key", "object": "key",
+	"size": "size", "bytes": "size",
+	"etag": "etag", "md5": "etag",
+	"modified": "modified", "lastmodified": "modified", "last_modified": "modified", "mtime": "modified",
+}
+
+func (r *csvImportReader) next() (*MCListEntry, int64, error) {
+	record, err := r.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			log.Printf("Warning: Failed to parse CSV line %d: %v", parseErr.Line, parseErr.Err)
+			return nil, int64(parseErr.Line), nil
+		}
+		return nil, r.line, err
+	}
+	line, _ := r.reader.FieldPos(0)
+	r.line = int64(line)
+
+	if !r.started {
+		r.started = true
+		r.columns = map[string]int{"key": 0, "size": 1, "etag": 2, "modified": 3}
+		if header := csvHeader(record); header != nil {
+			r.columns = header
+			return nil, int64(line), nil
+		}
+	}
+
+	field := func(name string) string {
+		if i, ok := r.columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	entry := &MCListEntry{Key: field("key"), Size: unknownSize, ETag: strings.Trim(field("etag"), `"`)}
+	if entry.Key == "" || strings.HasSuffix(entry.Key, "/") {
+		return nil, int64(line), nil
+	}
+	if size := field("size"); size != "" {
+		if entry.Size, err = strconv.ParseInt(size, 10, 64); err != nil || entry.Size < 0 {
+			log.Printf("Warning: Invalid size %q of %s in CSV line %d", size, entry.Key, line)
+			return nil, int64(line), nil
+		}
+	}
+	if modified := field("modified"); modified != "" {
+		if entry.LastModified, err = parseImportTime(modified); err != nil {
+			log.Printf("Warning: Invalid modification time %q of %s in CSV line %d", modified, entry.Key, line)
+		}
+	}
+	return entry, int64(line), nil
+}
+
+// csvHeader returns the columns named by a header row, or nil if record is
+// not a header: its size column is not a number
+func csvHeader(record []string) map[string]int {
+	columns := make(map[string]int)
+	for i, name := range record {
+		if column, ok := csvColumnNames[strings.ToLower(strings.TrimSpace(name))]; ok {
+			if _, seen := columns[column]; !seen {
+				columns[column] = i
+			}
+		}
+	}
+	if _, ok := columns["key"]; !ok {
+		return nil
+	}
+	if len(record) > 1 {
+		if _, err := strconv.ParseInt(strings.TrimSpace(record[1]), 10, 64); err == nil {
+			return nil
+		}
+	}
+	return columns
+}
+
+// parseImportTime parses the modification times found in exported listings:
+// RFC 3339, SQL timestamps and Unix seconds
+func parseImportTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05Z07:00", "2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown time format")
+}
@@ -0,0 +1,114 @@
+package sync
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+// metadataRules add metadata and tags to the objects copied to object
+// storage destinations, see config.MetadataRule
+type metadataRules struct {
+	rules []config.MetadataRule
+	// project, endpoint and bucket fill the placeholders of the source
+	project  string
+	endpoint string
+	bucket   string
+}
+
+func newMetadataRules(cfg *config.ProjectConfig) (metadataRules, error) {
+	for _, rule := range cfg.AddMetadata {
+		if rule.Files != "" {
+			if _, err := path.Match(rule.Files, ""); err != nil {
+				return metadataRules{}, fmt.Errorf("invalid metadata rule pattern %q: %w", rule.Files, err)
+			}
+		}
+		if len(rule.Metadata) == 0 && len(rule.Tags) == 0 {
+			return metadataRules{}, fmt.Errorf("metadata rule without metadata or tags")
+		}
+	}
+	return metadataRules{
+		rules:    cfg.AddMetadata,
+		project:  cfg.ProjectName,
+		endpoint: cfg.SourceMinio.Endpoint,
+		bucket:   cfg.SourceMinio.BucketName,
+	}, nil
+}
+
+// applies reports whether a rule matches file
+func (r metadataRules) applies(file *db.FileEntry) bool {
+	for _, rule := range r.rules {
+		if rule.Files == "" || matchPattern(rule.Files, file.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// add merges the metadata and tags of the rules matching file into
+// metadata, which may be nil, and returns the result. Later rules override
+// earlier ones, and both override the metadata of the source.
+func (r metadataRules) add(file *db.FileEntry, runID string, metadata *minio.ObjectMetadata) *minio.ObjectMetadata {
+	values := strings.NewReplacer(
+		"{project}", r.project,
+		"{source_endpoint}", r.endpoint,
+		"{source_bucket}", r.bucket,
+		"{path}", file.Path,
+		"{etag}", file.ETag,
+		"{run_id}", runID,
+	)
+
+	for _, rule := range r.rules {
+		if rule.Files != "" && !matchPattern(rule.Files, file.Path) {
+			continue
+		}
+		if metadata == nil {
+			metadata = &minio.ObjectMetadata{}
+		}
+		if len(rule.Metadata) > 0 {
+			userMetadata := make(map[string]string, len(metadata.UserMetadata)+len(rule.Metadata))
+			for key, value := range metadata.UserMetadata {
+				userMetadata[key] = value
+			}
+			for key, value := range rule.Metadata {
+				userMetadata[metadataKey(key)] = values.Replace(value)
+			}
+			metadata.UserMetadata = userMetadata
+		}
+		if len(rule.Tags) > 0 {
+			tags := make(map[string]string, len(metadata.Tags)+len(rule.Tags))
+			for key, value := range metadata.Tags {
+				tags[key] = value
+			}
+			for key, value := range rule.Tags {
+				tags[key] = values.Replace(value)
+			}
+			metadata.Tags = tags
+		}
+	}
+	return metadata
+}
+
+// metadataKey strips the X-Amz-Meta- prefix from a configured key, which
+// the client adds again when it sends the metadata
+func metadataKey(key string) string {
+	const prefix = "x-amz-meta-"
+	if len(key) > len(prefix) && strings.EqualFold(key[:len(prefix)], prefix) {
+		return key[len(prefix):]
+	}
+	return key
+}
+
+// newRunID returns a random identifier of a run, published by the run
+// marker and available to metadata rules as {run_id}
+func newRunID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,83 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+// Inventory lists the source folder and records which objects are new,
+// modified or deleted since the previous inventory run, without copying
+// anything. Inventory state is kept apart from the tracked files, so it
+// doesn't queue files for a sync of the same project.
+func (s *Service) Inventory(ctx context.Context, opts ListOptions) (*db.InventoryRun, error) {
+	opts = opts.withDefaults()
+	run, err := s.database.StartInventoryRun(s.projectName)
+	if err != nil {
+		return nil, err
+	}
+	if run.Baseline {
+		log.Printf("First inventory of project %s, recording the baseline", s.projectName)
+	}
+
+	// Concurrent listers call back concurrently
+	var mu sync.Mutex
+	var recordErr error
+	batch := make([]db.InventoryObject, 0, opts.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.database.RecordInventoryObjects(run, batch); err != nil {
+			recordErr = err
+			return err
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	listErr := s.sourceClient.ListObjects(ctx, opts.sourceListOptions(false), func(obj minio.ObjectInfo) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batch = append(batch, db.InventoryObject{
+			Path:         obj.Key,
+			Size:         obj.Size,
+			ETag:         obj.ETag,
+			LastModified: obj.LastModified,
+		})
+		if len(batch) >= opts.BatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if listErr == nil {
+		listErr = flush()
+	}
+	if listErr != nil && recordErr == nil {
+		log.Printf("Warning: Listing incomplete, deleted objects are not detected in this run")
+	}
+
+	if err := s.database.FinishInventoryRun(run, listErr == nil); err != nil {
+		return run, err
+	}
+	log.Printf("Inventory run %d: %d objects (%d bytes), %d new, %d modified, %d deleted",
+		run.ID, run.ObjectCount, run.TotalSize, run.New, run.Modified, run.Deleted)
+	if listErr != nil {
+		return run, fmt.Errorf("failed to list objects: %w", listErr)
+	}
+	return run, nil
+}
+
+// GetInventoryRuns returns the latest inventory runs, newest first
+func (s *Service) GetInventoryRuns(limit int) ([]*db.InventoryRun, error) {
+	return s.database.GetInventoryRuns(s.projectName, limit)
+}
+
+// GetInventoryChanges returns the changes found by an inventory run
+func (s *Service) GetInventoryChanges(runID int64) ([]*db.InventoryChange, error) {
+	return s.database.GetInventoryChanges(s.projectName, runID)
+}
@@ -0,0 +1,90 @@
+package sync
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+// defaultLease is how long a claim on a file lasts unless it is renewed
+const defaultLease = 5 * time.Minute
+
+// leases claims the files this instance copies when several instances sync
+// one project, see config.DistributedConfig
+type leases struct {
+	// owner identifies this instance, empty when files aren't claimed
+	owner    string
+	duration time.Duration
+}
+
+func newLeases(cfg config.DistributedConfig) leases {
+	if !cfg.Enabled {
+		return leases{}
+	}
+	l := leases{owner: cfg.InstanceID, duration: cfg.Lease}
+	if l.owner == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "copier"
+		}
+		l.owner = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if l.duration <= 0 {
+		l.duration = defaultLease
+	}
+	return l
+}
+
+// claim leases file to this instance. It reports false if another instance
+// is copying the file or already copied it.
+func (s *Service) claim(file *db.FileEntry) (bool, error) {
+	if s.leases.owner == "" {
+		return true, nil
+	}
+	var claimed bool
+	err := s.writer.write(func(b *db.Batch) error {
+		var err error
+		claimed, err = b.ClaimFile(file.ID, s.leases.owner, time.Now().Add(s.leases.duration))
+		return err
+	})
+	return claimed, err
+}
+
+// keepLeases renews the leases of this instance until the returned function
+// is called, which releases them
+func (s *Service) keepLeases() func() {
+	if s.leases.owner == "" {
+		return func() {}
+	}
+	log.Printf("Claiming files as %s", s.leases.owner)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(s.leases.duration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.database.RenewLeases(s.projectName, s.leases.owner, time.Now().Add(s.leases.duration)); err != nil {
+					log.Printf("Warning: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		if err := s.database.ReleaseLeases(s.projectName, s.leases.owner); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+}
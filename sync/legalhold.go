@@ -0,0 +1,169 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+// ErrLegalHold is returned for deletions and overwrites of destination
+// objects under legal hold
+var ErrLegalHold = errors.New("destination object is under legal hold")
+
+// legalHoldReason is the status reason of files whose copy was kept
+// because it is under legal hold
+const legalHoldReason = "destination object is under legal hold"
+
+// legalHold decides which destination objects are never deleted or
+// overwritten, see config.LegalHoldConfig
+type legalHold struct {
+	keys     map[string]bool
+	prefixes []string
+	tags     map[string]string
+}
+
+// newLegalHold returns the legal hold of a project, or nil if nothing is
+// held
+func newLegalHold(cfg config.LegalHoldConfig) (*legalHold, error) {
+	if len(cfg.Keys) == 0 && len(cfg.Tags) == 0 {
+		return nil, nil
+	}
+	h := &legalHold{keys: make(map[string]bool), tags: cfg.Tags}
+	for _, key := range cfg.Keys {
+		key = strings.TrimPrefix(key, "/")
+		switch {
+		case key == "":
+			return nil, fmt.Errorf("legal hold keys can't be empty")
+		case strings.HasSuffix(key, "/"):
+			h.prefixes = append(h.prefixes, key)
+		default:
+			h.keys[key] = true
+		}
+	}
+	log.Printf("Legal hold: %d keys, %d prefixes and %d tags are protected from deletion and overwrites",
+		len(h.keys), len(h.prefixes), len(h.tags))
+	return h, nil
+}
+
+// listed reports whether key is held by the configured keys and prefixes
+func (h *legalHold) listed(key string) bool {
+	if h.keys[key] {
+		return true
+	}
+	for _, prefix := range h.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// tagged reports whether tags contain one of the holding tags
+func (h *legalHold) tagged(tags map[string]string) bool {
+	for name, value := range h.tags {
+		if tags[name] == value {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap enforces the legal hold at a destination. client is nil for local
+// destinations, which have no tags.
+func (h *legalHold) wrap(backend StorageBackend, client *minio.MinioClient) StorageBackend {
+	if h == nil {
+		return backend
+	}
+	if client == nil && len(h.tags) > 0 && len(h.keys) == 0 && len(h.prefixes) == 0 {
+		log.Printf("Warning: Local destinations have no tags, the legal hold tags don't protect any of their files")
+	}
+	return &heldBackend{StorageBackend: backend, hold: h, client: client}
+}
+
+// heldBackend refuses to delete or overwrite objects under legal hold.
+// Objects that don't exist yet may be created at held keys.
+type heldBackend struct {
+	StorageBackend
+	hold   *legalHold
+	client *minio.MinioClient
+}
+
+// protect returns an error wrapping ErrLegalHold if key exists and is under
+// legal hold
+func (b *heldBackend) protect(ctx context.Context, key string) error {
+	listed := b.hold.listed(key)
+	if !listed && (b.client == nil || len(b.hold.tags) == 0) {
+		return nil
+	}
+
+	_, err := b.StorageBackend.Stat(ctx, key)
+	if minio.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check legal hold of %s: %w", key, err)
+	}
+	if !listed {
+		// Only the tags are read, not the rest of the metadata
+		tags, err := b.client.GetObjectTags(ctx, key)
+		if minio.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to check legal hold of %s: %w", key, err)
+		}
+		if !b.hold.tagged(tags) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %w", key, ErrLegalHold)
+}
+
+func (b *heldBackend) Put(ctx context.Context, key string, reader io.Reader, opts PutOptions) error {
+	if err := b.protect(ctx, key); err != nil {
+		return err
+	}
+	return b.StorageBackend.Put(ctx, key, reader, opts)
+}
+
+func (b *heldBackend) Delete(ctx context.Context, key string) error {
+	if err := b.protect(ctx, key); err != nil {
+		return err
+	}
+	return b.StorageBackend.Delete(ctx, key)
+}
+
+// Move refuses to remove a held object, or to replace one
+func (b *heldBackend) Move(ctx context.Context, from, to string) error {
+	if err := b.protect(ctx, from); err != nil {
+		return err
+	}
+	if err := b.protect(ctx, to); err != nil {
+		return err
+	}
+	return b.StorageBackend.Move(ctx, from, to)
+}
+
+// unheld returns the backend a destination stores its objects with,
+// without the legal hold
+func unheld(backend StorageBackend) StorageBackend {
+	if held, ok := backend.(*heldBackend); ok {
+		return held.StorageBackend
+	}
+	return backend
+}
+
+// protectHeld checks the legal hold of key at dest before a copy that
+// writes to the destination without its Put
+func protectHeld(ctx context.Context, dest StorageBackend, key string) error {
+	if held, ok := dest.(*heldBackend); ok {
+		return held.protect(ctx, key)
+	}
+	return nil
+}
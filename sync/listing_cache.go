@@ -0,0 +1,81 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+// listFromCache passes the source objects of the project to fn from the
+// shared bucket listing cache, refreshing the cache with one full bucket
+// listing when it is older than opts.CacheMaxAge
+func (s *Service) listFromCache(ctx context.Context, opts ListOptions, fn func(minio.ObjectInfo) error) error {
+	if s.listingCachePath == "" {
+		return fmt.Errorf("no listing cache configured")
+	}
+
+	cache, err := db.NewListingCache(s.listingCachePath)
+	if err != nil {
+		return fmt.Errorf("failed to open listing cache: %w", err)
+	}
+	defer cache.Close()
+
+	endpoint, bucket := s.sourceClient.GetEndpoint(), s.sourceClient.GetBucketName()
+	listedAt, err := cache.ListedAt(endpoint, bucket)
+	if err != nil {
+		return err
+	}
+
+	if age := time.Since(listedAt); listedAt.IsZero() || age > opts.CacheMaxAge {
+		log.Printf("Refreshing listing cache for bucket %s on %s...", bucket, endpoint)
+		count, err := cache.Refresh(endpoint, bucket, func(add func(db.CachedObject) error) error {
+			return s.sourceClient.ListBucket(ctx, func(obj minio.ObjectInfo) error {
+				return add(db.CachedObject{
+					Key:          obj.Key,
+					Size:         obj.Size,
+					ETag:         obj.ETag,
+					LastModified: obj.LastModified,
+				})
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to refresh listing cache: %w", err)
+		}
+		log.Printf("Cached %d objects of bucket %s", count, bucket)
+	} else {
+		log.Printf("Using listing cache of bucket %s from %s ago", bucket, age.Round(time.Second))
+	}
+
+	folder := s.sourceClient.GetFolderPath()
+	return cache.Objects(endpoint, bucket, folder, opts.ModifiedAfter, opts.ModifiedBefore, func(obj db.CachedObject) error {
+		if !withinDepth(obj.Key, folder, opts.Depth) {
+			return nil
+		}
+		return fn(minio.ObjectInfo{
+			Key:          obj.Key,
+			Size:         obj.Size,
+			ETag:         obj.ETag,
+			LastModified: obj.LastModified,
+		})
+	})
+}
+
+// withinDepth applies the delimiter-based depth limit of a direct listing to
+// a key from a recursive listing
+func withinDepth(key, folder string, depth int) bool {
+	if depth <= 0 {
+		return true
+	}
+	if folder != "" && !strings.HasSuffix(folder, "/") {
+		folder += "/"
+	}
+	if !strings.HasPrefix(key, folder) {
+		return false
+	}
+	return strings.Count(strings.TrimPrefix(key, folder), "/") < depth
+}
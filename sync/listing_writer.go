@@ -0,0 +1,151 @@
+package sync
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+// listProgressInterval is how often update-list reports its queue depths
+const listProgressInterval = 10 * time.Second
+
+// listingWriter records listed objects in the database from a single
+// goroutine. Listers add objects to a bounded queue and block once it is
+// full, so a listing that outpaces the database holds at most a few batches
+// in memory; the writer drains the queue in batches of opts.BatchSize, one
+// transaction each, and writes partial batches after opts.FlushInterval.
+type listingWriter struct {
+	s     *Service
+	opts  ListOptions
+	queue chan minio.ObjectInfo
+	done  chan struct{}
+	tally listCounts
+	stats listingWriterStats
+}
+
+// listingWriterStats are the metrics of a listingWriter
+type listingWriterStats struct {
+	// queued counts the objects added, written those recorded in batches
+	queued  atomic.Int64
+	written atomic.Int64
+	batches atomic.Int64
+	// blocked is how long listers waited for room in the full queue
+	blocked atomic.Int64
+	// flushTime is the time spent writing batches, maxFlush the longest
+	flushTime atomic.Int64
+	maxFlush  atomic.Int64
+	lastFlush atomic.Int64
+	// peakQueue is the most objects waiting in the queue
+	peakQueue atomic.Int64
+}
+
+// newListingWriter starts a writer recording the objects added to it until
+// close is called
+func (s *Service) newListingWriter(ctx context.Context, opts ListOptions) *listingWriter {
+	w := &listingWriter{
+		s:     s,
+		opts:  opts,
+		queue: make(chan minio.ObjectInfo, opts.BatchSize*4),
+		done:  make(chan struct{}),
+	}
+	go w.run(ctx)
+	return w
+}
+
+// add queues a listed object, waiting while the queue is full. It may be
+// called by several listers at once.
+func (w *listingWriter) add(ctx context.Context, obj minio.ObjectInfo) error {
+	select {
+	case w.queue <- obj:
+	default:
+		started := time.Now()
+		select {
+		case w.queue <- obj:
+			w.stats.blocked.Add(int64(time.Since(started)))
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	w.stats.queued.Add(1)
+	if depth := int64(len(w.queue)); depth > w.stats.peakQueue.Load() {
+		w.stats.peakQueue.Store(depth)
+	}
+	return nil
+}
+
+// close ends the listing and waits until every queued object is recorded.
+// It returns the outcome of the recorded objects.
+func (w *listingWriter) close() listCounts {
+	close(w.queue)
+	<-w.done
+	return w.tally
+}
+
+func (w *listingWriter) run(ctx context.Context) {
+	defer close(w.done)
+
+	batch := make([]minio.ObjectInfo, 0, w.opts.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		started := time.Now()
+		if err := w.s.recordBatch(ctx, batch, w.opts, &w.tally, nil); err != nil {
+			log.Printf("Warning: Failed to record %d listed files: %v", len(batch), err)
+		}
+		took := int64(time.Since(started))
+		w.stats.flushTime.Add(took)
+		w.stats.lastFlush.Store(took)
+		if took > w.stats.maxFlush.Load() {
+			w.stats.maxFlush.Store(took)
+		}
+		w.stats.written.Add(int64(len(batch)))
+		w.stats.batches.Add(1)
+		batch = batch[:0]
+	}
+
+	flushTicker := time.NewTicker(w.opts.FlushInterval)
+	defer flushTicker.Stop()
+	progressTicker := time.NewTicker(listProgressInterval)
+	defer progressTicker.Stop()
+	for {
+		select {
+		case obj, ok := <-w.queue:
+			if !ok {
+				flush()
+				return
+			}
+			w.tally.found++
+			w.tally.foundSize += obj.Size
+			batch = append(batch, obj)
+			if len(batch) >= w.opts.BatchSize {
+				flush()
+			}
+		case <-flushTicker.C:
+			flush()
+		case <-progressTicker.C:
+			log.Printf("Listing progress: %d listed, queue %d/%d, batch %d/%d, %d written in %d batches (last batch %v), listers blocked %v",
+				w.stats.queued.Load(), len(w.queue), cap(w.queue), len(batch), w.opts.BatchSize,
+				w.stats.written.Load(), w.stats.batches.Load(),
+				time.Duration(w.stats.lastFlush.Load()).Round(time.Millisecond),
+				time.Duration(w.stats.blocked.Load()).Round(time.Millisecond))
+		}
+	}
+}
+
+// logStats logs the metrics of a finished listing
+func (w *listingWriter) logStats() {
+	batches := w.stats.batches.Load()
+	var average time.Duration
+	if batches > 0 {
+		average = time.Duration(w.stats.flushTime.Load() / batches)
+	}
+	log.Printf("Listing writer: %d objects in %d batches, batch time avg %v max %v, peak queue %d/%d, listers blocked %v",
+		w.stats.written.Load(), batches,
+		average.Round(time.Millisecond), time.Duration(w.stats.maxFlush.Load()).Round(time.Millisecond),
+		w.stats.peakQueue.Load(), cap(w.queue),
+		time.Duration(w.stats.blocked.Load()).Round(time.Millisecond))
+}
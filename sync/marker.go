@@ -0,0 +1,123 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+// runMarkerTagPrefix starts the keys of the bucket tags set by the run marker
+const runMarkerTagPrefix = "sync:"
+
+// runMarker publishes the statistics of every successful sync at the main
+// destination, as a JSON object and/or as bucket tags
+type runMarker struct {
+	key        string
+	bucketTags bool
+}
+
+// newRunMarker returns the marker configured for the project, or nil if
+// none is written
+func newRunMarker(cfg config.RunMarkerConfig, destType config.DestinationType) (*runMarker, error) {
+	key := strings.TrimPrefix(cfg.Key, "/")
+	if key == "" && !cfg.BucketTags {
+		return nil, nil
+	}
+	if cfg.BucketTags && destType != config.DestinationMinio && destType != config.DestinationS3 {
+		return nil, fmt.Errorf("run marker bucket tags need a MinIO or S3 destination")
+	}
+	return &runMarker{key: key, bucketTags: cfg.BucketTags}, nil
+}
+
+// runRecord is the content of the run marker
+type runRecord struct {
+	Project       string    `json:"project"`
+	RunID         string    `json:"runId"`
+	StartedAt     time.Time `json:"startedAt"`
+	FinishedAt    time.Time `json:"finishedAt"`
+	Completed     int       `json:"completed"`
+	Skipped       int       `json:"skipped"`
+	Failed        int       `json:"failed"`
+	NotDispatched int       `json:"notDispatched"`
+	Transferred   int64     `json:"transferredBytes"`
+	// PendingFiles and FailedFiles are the backlog left for the next run
+	PendingFiles int64 `json:"pendingFiles"`
+	FailedFiles  int64 `json:"failedFiles"`
+}
+
+// tags returns the record as bucket tags
+func (r *runRecord) tags() map[string]string {
+	return map[string]string{
+		runMarkerTagPrefix + "project":           r.Project,
+		runMarkerTagPrefix + "run-id":            r.RunID,
+		runMarkerTagPrefix + "finished-at":       r.FinishedAt.Format(time.RFC3339),
+		runMarkerTagPrefix + "completed":         strconv.Itoa(r.Completed),
+		runMarkerTagPrefix + "failed":            strconv.Itoa(r.Failed),
+		runMarkerTagPrefix + "transferred-bytes": strconv.FormatInt(r.Transferred, 10),
+		runMarkerTagPrefix + "pending-files":     strconv.FormatInt(r.PendingFiles, 10),
+	}
+}
+
+// writeRunMarker publishes the statistics of a sync that started at started.
+// Failures are only logged, as the files were copied all the same.
+func (s *Service) writeRunMarker(ctx context.Context, started time.Time, transferred int64, result *SyncResult) {
+	if s.runMarker == nil {
+		return
+	}
+
+	record := &runRecord{
+		Project:       s.projectName,
+		RunID:         s.runID,
+		StartedAt:     started.UTC(),
+		FinishedAt:    time.Now().UTC(),
+		Completed:     result.Completed,
+		Skipped:       result.Skipped,
+		Failed:        result.Failed,
+		NotDispatched: result.NotDispatched,
+		Transferred:   transferred,
+	}
+	counts, err := s.database.GetStatusCounts(s.projectName)
+	if err != nil {
+		log.Printf("Warning: Failed to count the backlog for the run marker: %v", err)
+	}
+	for _, count := range counts {
+		switch count.Status {
+		case db.StatusPending:
+			record.PendingFiles += count.Count
+		case db.StatusError, db.StatusFailed:
+			record.FailedFiles += count.Count
+		}
+	}
+
+	if s.runMarker.key != "" {
+		data, err := json.MarshalIndent(record, "", "  ")
+		switch {
+		case err != nil:
+		case s.localDest != nil:
+			// Written as is, neither packed, compressed nor encrypted
+			err = s.localDest.WritePlainFile(s.runMarker.key, data)
+		default:
+			err = s.dest.Put(ctx, s.runMarker.key, bytes.NewReader(data), PutOptions{Size: int64(len(data))})
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to write run marker %s: %v", s.runMarker.key, err)
+		} else {
+			log.Printf("Wrote run marker %s (run %s)", s.runMarker.key, record.RunID)
+		}
+	}
+	if s.runMarker.bucketTags {
+		if err := s.destClient.SetBucketTags(ctx, record.tags()); err != nil {
+			log.Printf("Warning: Failed to tag destination bucket with the run statistics: %v", err)
+		} else {
+			log.Printf("Tagged destination bucket with the statistics of run %s", record.RunID)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+	"golang.org/x/sync/errgroup"
+)
+
+// mirrorDeleteReason is the status reason of paths deleted by a mirror sync
+const mirrorDeleteReason = "no longer in source"
+
+// MirrorResult summarizes the deletions of a mirror sync
+type MirrorResult struct {
+	// Extra counts the destination objects that no longer exist in the
+	// source, ExtraSize their total size
+	Extra     int
+	ExtraSize int64
+	Deleted   int
+	Failed    int
+	// Held counts the objects kept because they are under legal hold
+	Held int
+	// DryRun is set when the objects were only reported
+	DryRun bool
+}
+
+// mirror deletes the objects below the source folder at the main destination
+// that no longer exist in the source and records them with status deleted.
+// Every object is reported before anything is deleted; a dry run stops
+// after the report.
+func (s *Service) mirror(ctx context.Context, opts SyncOptions) (*MirrorResult, error) {
+	prefix := strings.Trim(s.sourceClient.GetFolderPath(), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	log.Printf("Mirror: listing source and destination prefix %q...", prefix)
+	sources, err := s.listTree(ctx, s.sourceClient, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source: %w", err)
+	}
+	dests, err := s.listDestinationTree(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list destination: %w", err)
+	}
+
+	var extra []minio.ObjectInfo
+	for key, obj := range dests {
+		if s.runMarker != nil && key == s.runMarker.key {
+			continue
+		}
+		if _, ok := sources[key]; !ok {
+			extra = append(extra, obj)
+		}
+	}
+	sort.Slice(extra, func(i, j int) bool { return extra[i].Key < extra[j].Key })
+
+	result := &MirrorResult{Extra: len(extra), DryRun: opts.MirrorDryRun}
+	for _, obj := range extra {
+		result.ExtraSize += obj.Size
+	}
+	log.Printf("Mirror report: %d of %d destination objects (%d bytes) no longer exist in the source",
+		result.Extra, len(dests), result.ExtraSize)
+	for _, obj := range extra {
+		log.Printf("  - %s (%d bytes)", obj.Key, obj.Size)
+	}
+
+	if len(extra) == 0 {
+		return result, nil
+	}
+	if opts.MirrorDryRun {
+		log.Printf("Mirror: dry run, nothing deleted")
+		return result, nil
+	}
+	// An empty listing is far more likely a wrong folder or a broken source
+	// than a deliberate wipe
+	if len(sources) == 0 {
+		return result, fmt.Errorf("source listing of %q is empty, refusing to delete all %d destination objects", prefix, len(extra))
+	}
+
+	var deleted, failed, held atomic.Int64
+	g, groupCtx := errgroup.WithContext(ctx)
+	g.SetLimit(max(opts.Workers, 1))
+	for _, obj := range extra {
+		if groupCtx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			err := s.deleteFromDestination(groupCtx, obj)
+			if errors.Is(err, ErrLegalHold) {
+				held.Add(1)
+				log.Printf("Mirror: keeping %s, it is under legal hold", obj.Key)
+				return nil
+			}
+			if err != nil {
+				failed.Add(1)
+				log.Printf("Failed to delete %s: %v", obj.Key, err)
+				return nil
+			}
+			deleted.Add(1)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	result.Deleted = int(deleted.Load())
+	result.Failed = int(failed.Load())
+	result.Held = int(held.Load())
+	log.Printf("Mirror finished: %d deleted, %d kept under legal hold, %d failed", result.Deleted, result.Held, result.Failed)
+
+	if err := ctx.Err(); err != nil {
+		return result, fmt.Errorf("mirror cancelled: %w", err)
+	}
+	return result, nil
+}
+
+// listDestinationTree lists prefix at the main destination by source path
+func (s *Service) listDestinationTree(ctx context.Context, prefix string) (map[string]minio.ObjectInfo, error) {
+	objects := make(map[string]minio.ObjectInfo)
+	err := s.dest.List(ctx, prefix, func(obj minio.ObjectInfo) error {
+		// Staged and versioned copies are stored below their own prefix,
+		// which local destinations list relative to the destination folder
+		relative := strings.TrimPrefix(obj.Key, prefix)
+		if strings.HasSuffix(obj.Key, "/") ||
+			s.isBookkeepingKey(obj.Key) || s.isBookkeepingKey(relative) {
+			return nil
+		}
+		objects[obj.Key] = obj
+		return nil
+	})
+	return objects, err
+}
+
+// isBookkeepingKey reports whether key is a staged or versioned copy
+func (s *Service) isBookkeepingKey(key string) bool {
+	return strings.HasPrefix(key, s.changes.versionPrefix+"/") ||
+		strings.HasPrefix(key, s.atomic.stagingPrefix+"/")
+}
+
+// deleteFromDestination removes one object from the main destination and
+// records the deletion
+func (s *Service) deleteFromDestination(ctx context.Context, obj minio.ObjectInfo) error {
+	if err := s.dest.Delete(ctx, obj.Key); err != nil {
+		return err
+	}
+	s.destStats.invalidate(obj.Key)
+	log.Printf("Deleted %s from destination", obj.Key)
+
+	return s.database.MarkDeleted(&db.FileEntry{
+		ProjectName:  s.projectName,
+		Path:         obj.Key,
+		Size:         obj.Size,
+		ETag:         obj.ETag,
+		LastModified: obj.LastModified,
+		StatusReason: mirrorDeleteReason,
+	})
+}
@@ -0,0 +1,227 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+const (
+	defaultMultipartThreshold = 256 << 20
+	defaultPartSize           = 64 << 20
+	// S3 rejects parts below 5MiB, except for the last one, and uploads of
+	// more than 10000 parts
+	minPartSize  = 5 << 20
+	maxPartCount = 10000
+)
+
+// multipartRules selects the files that are uploaded to the main destination
+// in parts that survive an interrupted transfer
+type multipartRules struct {
+	threshold int64
+	partSize  int64
+	// streams is how many parts of an object are transferred at once
+	streams int
+}
+
+func newMultipartRules(cfg config.MultipartConfig) (multipartRules, error) {
+	rules := multipartRules{threshold: defaultMultipartThreshold, partSize: defaultPartSize, streams: 1}
+	if cfg.Threshold != "" {
+		threshold, err := config.ParseSize(cfg.Threshold)
+		if err != nil {
+			return multipartRules{}, fmt.Errorf("invalid multipart threshold: %w", err)
+		}
+		rules.threshold = threshold
+	}
+	if cfg.PartSize != "" {
+		partSize, err := config.ParseSize(cfg.PartSize)
+		if err != nil {
+			return multipartRules{}, fmt.Errorf("invalid multipart part size: %w", err)
+		}
+		rules.partSize = max(partSize, minPartSize)
+	}
+	if cfg.Streams < 0 {
+		return multipartRules{}, fmt.Errorf("invalid multipart streams: %d", cfg.Streams)
+	}
+	if cfg.Streams > 0 {
+		rules.streams = cfg.Streams
+	}
+	return rules, nil
+}
+
+// applies reports whether file is uploaded in resumable parts
+func (m multipartRules) applies(file *db.FileEntry) bool {
+	return file.Size > minPartSize && file.Size >= m.threshold
+}
+
+// partSizeFor returns the part size used for an object of the given size
+func (m multipartRules) partSizeFor(size int64) int64 {
+	partSize := m.partSize
+	if size > partSize*maxPartCount {
+		partSize = (size + maxPartCount - 1) / maxPartCount
+	}
+	return partSize
+}
+
+// putMultipart uploads file to destPath at the main destination part by part,
+// recording every finished part so that a later run resumes where this one
+// stopped. A new upload gets metadata if it isn't nil.
+func (s *Service) putMultipart(ctx context.Context, file *db.FileEntry, destPath string, metadata *minio.ObjectMetadata) error {
+	upload, err := s.database.GetMultipartUpload(s.projectName, destPath)
+	if err != nil {
+		return err
+	}
+	if upload != nil && (upload.SourceETag != file.ETag || upload.Size != file.Size) {
+		// The source changed since the upload started, its parts are stale
+		log.Printf("Debug: Discarding upload of %s, the source changed", destPath)
+		if err := s.destClient.AbortMultipartUpload(ctx, destPath, upload.UploadID); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		if err := s.database.DeleteMultipartUpload(s.projectName, destPath); err != nil {
+			return err
+		}
+		upload = nil
+	}
+
+	if upload == nil {
+		uploadID, err := s.destClient.NewMultipartUpload(ctx, destPath, file.ETag, metadata)
+		if err != nil {
+			return err
+		}
+		upload = &db.MultipartUpload{
+			ProjectName: s.projectName,
+			Path:        destPath,
+			UploadID:    uploadID,
+			SourceETag:  file.ETag,
+			Size:        file.Size,
+			PartSize:    s.multipart.partSizeFor(file.Size),
+		}
+		if err := s.database.StartMultipartUpload(upload); err != nil {
+			return err
+		}
+	}
+
+	partCount := int((upload.Size + upload.PartSize - 1) / upload.PartSize)
+	done := make(map[int]db.UploadedPart, len(upload.Parts))
+	for _, part := range upload.Parts {
+		done[part.Number] = part
+	}
+	if len(done) > 0 {
+		log.Printf("Resuming upload of %s at part %d/%d", destPath, len(done)+1, partCount)
+	}
+
+	var missing []int
+	for number := 1; number <= partCount; number++ {
+		if _, ok := done[number]; !ok {
+			missing = append(missing, number)
+		}
+	}
+	if err := s.putParts(ctx, file.Path, upload, missing, done); err != nil {
+		if minio.IsNoSuchUpload(err) {
+			// The destination expired or aborted the upload, start over on
+			// the next attempt
+			if err := s.database.DeleteMultipartUpload(s.projectName, destPath); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
+		return err
+	}
+
+	parts := make([]minio.Part, 0, len(done))
+	for _, part := range done {
+		parts = append(parts, minio.Part{Number: part.Number, ETag: part.ETag, Size: part.Size})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+
+	if err := s.destClient.CompleteMultipartUpload(ctx, destPath, upload.UploadID, parts); err != nil {
+		if minio.IsNoSuchUpload(err) {
+			if err := s.database.DeleteMultipartUpload(s.projectName, destPath); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
+		return err
+	}
+	return s.database.DeleteMultipartUpload(s.projectName, destPath)
+}
+
+// putParts uploads the parts numbered in missing over up to streams
+// concurrent connections, each reading its own byte range of the source, and
+// records every finished part in the database and in done. After the first
+// failure no further part is started; the parts finished until then are kept
+// for the next attempt.
+func (s *Service) putParts(ctx context.Context, sourcePath string, upload *db.MultipartUpload, missing []int, done map[int]db.UploadedPart) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	numbers := make(chan int)
+	for i := 0; i < min(s.multipart.streams, len(missing)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range numbers {
+				offset := int64(number-1) * upload.PartSize
+				length := min(upload.PartSize, upload.Size-offset)
+				var uploaded db.UploadedPart
+				err := func() (err error) {
+					defer recoverTransfer(sourcePath, &err)
+					part, err := s.putPart(ctx, sourcePath, upload, number, offset, length)
+					if err != nil {
+						return err
+					}
+					uploaded = db.UploadedPart{Number: part.Number, ETag: part.ETag, Size: part.Size}
+					return s.database.AddUploadedPart(s.projectName, upload.Path, uploaded)
+				}()
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					cancel()
+				} else {
+					done[number] = uploaded
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, number := range missing {
+		select {
+		case numbers <- number:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(numbers)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// putPart copies a byte range of the source object as one part of upload
+func (s *Service) putPart(ctx context.Context, sourcePath string, upload *db.MultipartUpload, number int, offset, length int64) (minio.Part, error) {
+	reader, err := s.sourceClient.GetObjectRange(ctx, sourcePath, offset, length)
+	if err != nil {
+		return minio.Part{}, fmt.Errorf("failed to get part %d of %s: %w", number, sourcePath, err)
+	}
+	defer reader.Close()
+
+	counted := s.meter(ctx, reader)
+	return s.destClient.PutPart(ctx, upload.Path, upload.UploadID, number, counted, length)
+}
@@ -0,0 +1,104 @@
+package sync
+
+import (
+	"fmt"
+	"log"
+	"path"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+// orderingRules holds files back until the files they depend on are copied
+type orderingRules []config.OrderingRule
+
+func newOrderingRules(rules []config.OrderingRule) (orderingRules, error) {
+	for _, rule := range rules {
+		if rule.Files == "" {
+			return nil, fmt.Errorf("ordering rule without files pattern")
+		}
+		for _, pattern := range append([]string{rule.Files}, rule.After...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid ordering pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return orderingRules(rules), nil
+}
+
+// ruleFor returns the rule holding key back, or nil if it can be copied at
+// any time
+func (r orderingRules) ruleFor(key string) *config.OrderingRule {
+	for i := range r {
+		if matchPattern(r[i].Files, key) {
+			return &r[i]
+		}
+	}
+	return nil
+}
+
+// split separates the files that can be copied right away from the ones
+// that have to wait for other files
+func (r orderingRules) split(files []*db.FileEntry) (ready, held []*db.FileEntry) {
+	if len(r) == 0 {
+		return files, nil
+	}
+	for _, file := range files {
+		if r.ruleFor(file.Path) != nil {
+			held = append(held, file)
+		} else {
+			ready = append(ready, file)
+		}
+	}
+	return ready, held
+}
+
+// releaseHeld returns the held files whose dependencies are all completed,
+// looking up the unfinished files of a folder with unfinishedIn. The others
+// stay pending and are picked up by a later sync run.
+func (s *Service) releaseHeld(held []*db.FileEntry, unfinishedIn func(folder string) ([]string, error)) []*db.FileEntry {
+	unfinished := make(map[string][]string)
+	var released []*db.FileEntry
+	for _, file := range held {
+		folder := path.Dir(file.Path)
+		paths, ok := unfinished[folder]
+		if !ok {
+			var err error
+			paths, err = unfinishedIn(folder)
+			if err != nil {
+				log.Printf("Warning: Failed to check dependencies of %s: %v", file.Path, err)
+				continue
+			}
+			unfinished[folder] = paths
+		}
+
+		if blocker := blockingPath(s.ordering.ruleFor(file.Path), file.Path, paths); blocker != "" {
+			log.Printf("Holding back %s until %s is completed", file.Path, blocker)
+			continue
+		}
+		released = append(released, file)
+	}
+	return released
+}
+
+// blockingPath returns an unfinished file that key has to wait for according
+// to rule, or an empty string if there is none
+func blockingPath(rule *config.OrderingRule, key string, unfinished []string) string {
+	for _, p := range unfinished {
+		if p == key {
+			continue
+		}
+		if len(rule.After) == 0 {
+			if !matchPattern(rule.Files, p) {
+				return p
+			}
+			continue
+		}
+		for _, pattern := range rule.After {
+			if matchPattern(pattern, p) {
+				return p
+			}
+		}
+	}
+	return ""
+}
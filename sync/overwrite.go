@@ -0,0 +1,39 @@
+package sync
+
+import (
+	"fmt"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+func newOverwritePolicy(policy config.OverwritePolicy) (config.OverwritePolicy, error) {
+	switch policy {
+	case "":
+		return config.OverwriteIfDifferent, nil
+	case config.OverwriteNever, config.OverwriteIfNewer, config.OverwriteIfDifferent, config.OverwriteAlways:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid overwrite policy %q (must be %s, %s, %s or %s)", policy,
+			config.OverwriteNever, config.OverwriteIfNewer, config.OverwriteIfDifferent, config.OverwriteAlways)
+	}
+}
+
+// overwriteAction applies the overwrite policy to a file whose destination
+// object exists. It returns an empty action under the if-different policy,
+// which compares the content of both sides.
+func (s *Service) overwriteAction(file *db.FileEntry, dest *minio.ObjectInfo) (PlanActionType, string) {
+	switch s.overwrite {
+	case config.OverwriteNever:
+		return PlanSkip, "destination object exists and the overwrite policy is never"
+	case config.OverwriteAlways:
+		return PlanOverwrite, "overwrite policy is always"
+	case config.OverwriteIfNewer:
+		if compareModified(dest.LastModified, file.LastModified, s.clockSkew) < 0 {
+			return PlanOverwrite, "source modified after the destination object"
+		}
+		return PlanSkip, "destination object is not older than the source"
+	}
+	return "", ""
+}
@@ -0,0 +1,133 @@
+package sync
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// paceInterval is how often the latency of the destination is evaluated
+	paceInterval = 5 * time.Second
+	// paceMinSamples is the fewest requests a p95 is computed from
+	paceMinSamples = 20
+	// paceMaxSamples bounds the requests kept per interval
+	paceMaxSamples = 4096
+)
+
+// adaptivePacer limits the transfers in flight by the latency of the
+// destination. When the p95 latency of its requests rises above the
+// threshold, the limit is cut by a quarter; while it stays below, the limit
+// grows back by one transfer per interval up to the number of workers.
+type adaptivePacer struct {
+	threshold time.Duration
+
+	mu       sync.Mutex
+	limit    int
+	max      int
+	inFlight int
+	samples  []time.Duration
+	since    time.Time
+	// changed is closed and replaced whenever waiting transfers may start
+	changed chan struct{}
+}
+
+// newAdaptivePacer returns a pacer for threshold, or nil for no pacing
+func newAdaptivePacer(threshold time.Duration) *adaptivePacer {
+	if threshold <= 0 {
+		return nil
+	}
+	log.Printf("Transfers in flight are reduced while the p95 latency of the destination exceeds %v", threshold)
+	return &adaptivePacer{
+		threshold: threshold,
+		since:     time.Now(),
+		changed:   make(chan struct{}),
+	}
+}
+
+// start begins a run with the given number of workers, which all may
+// transfer until the destination slows down
+func (p *adaptivePacer) start(workers int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limit, p.max = workers, workers
+	p.samples = p.samples[:0]
+	p.since = time.Now()
+	p.wake()
+}
+
+// acquire waits until a transfer may start. Every successful call must be
+// followed by a call to release.
+func (p *adaptivePacer) acquire(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	for {
+		p.mu.Lock()
+		if p.inFlight < p.limit || p.limit == 0 {
+			p.inFlight++
+			p.mu.Unlock()
+			return nil
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// release ends a transfer started by acquire
+func (p *adaptivePacer) release() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight--
+	p.wake()
+}
+
+// observe records the latency of a request to the destination and adjusts
+// the limit once per interval
+func (p *adaptivePacer) observe(latency time.Duration) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.samples) < paceMaxSamples {
+		p.samples = append(p.samples, latency)
+	}
+	if time.Since(p.since) < paceInterval || len(p.samples) < paceMinSamples || p.max == 0 {
+		return
+	}
+
+	p95 := percentile95(p.samples)
+	p.samples = p.samples[:0]
+	p.since = time.Now()
+
+	switch {
+	case p95 > p.threshold && p.limit > 1:
+		p.limit = max(1, p.limit*3/4)
+		log.Printf("Warning: p95 latency of the destination is %v, reducing transfers in flight to %d", p95.Round(time.Millisecond), p.limit)
+	case p95 <= p.threshold && p.limit < p.max:
+		p.limit++
+		p.wake()
+		log.Printf("Debug: p95 latency of the destination is %v, raising transfers in flight to %d", p95.Round(time.Millisecond), p.limit)
+	}
+}
+
+// wake lets waiting transfers check the limit again. It must be called with
+// the mutex held.
+func (p *adaptivePacer) wake() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
@@ -0,0 +1,416 @@
+package sync
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/keys"
+	"github.com/chmdznr/minio-simple-copier/v2/local"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+const (
+	defaultPackArchiveSize = 1 << 30
+	packArchivePattern     = "pack-%06d.tar"
+	tarBlockSize           = 512
+)
+
+// packer appends small files to rolling tar archives in the pack directory
+// of a local destination. Archives are only written by one file at a time;
+// an archive left unfinished by an earlier run is continued after the last
+// file recorded in it, dropping anything written after that.
+type packer struct {
+	storage     *local.Storage
+	database    *db.Database
+	projectName string
+	maxFileSize int64
+	archiveSize int64
+
+	mu      sync.Mutex
+	archive string
+	file    *os.File
+	tw      *tar.Writer
+	// pos is the end of the data written to the archive
+	pos int64
+}
+
+// newPacker returns the packer configured by cfg, or nil if files aren't
+// packed
+func newPacker(cfg *config.PackConfig, storage *local.Storage, database *db.Database, projectName string) (*packer, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	maxFileSize, err := config.ParseSize(cfg.MaxFileSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pack maximum file size: %w", err)
+	}
+	if maxFileSize <= 0 {
+		return nil, fmt.Errorf("packing needs a maxFileSize")
+	}
+	archiveSize, err := config.ParseSize(cfg.ArchiveSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pack archive size: %w", err)
+	}
+	if archiveSize == 0 {
+		archiveSize = defaultPackArchiveSize
+	}
+	log.Printf("Files up to %d bytes are packed into archives of %d bytes", maxFileSize, archiveSize)
+	return &packer{
+		storage:     storage,
+		database:    database,
+		projectName: projectName,
+		maxFileSize: maxFileSize,
+		archiveSize: archiveSize,
+	}, nil
+}
+
+// applies reports whether a file of size bytes is packed
+func (p *packer) applies(size int64) bool {
+	return size <= p.maxFileSize
+}
+
+// add appends the content of reader, which must be size bytes, to the
+// current archive and records it under key
+func (p *packer) add(key string, reader io.Reader, size int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.open(); err != nil {
+		return err
+	}
+	start := p.pos
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     key,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  time.Now(),
+	}
+	err := p.tw.WriteHeader(header)
+	offset := p.pos
+	if err == nil {
+		_, err = io.Copy(p.tw, reader)
+	}
+	if err == nil {
+		err = p.tw.Flush()
+	}
+	if err != nil {
+		// The entry is cut off again, so the archive stays readable
+		if rewindErr := p.rewind(start); rewindErr != nil {
+			log.Printf("Warning: Failed to remove incomplete entry %s from %s: %v", key, p.archive, rewindErr)
+			p.closeArchive()
+		}
+		return fmt.Errorf("failed to pack %s: %w", key, err)
+	}
+
+	packed := &db.PackedFile{Path: key, Archive: p.archive, Offset: offset, Size: size, PackedAt: header.ModTime}
+	if err := p.database.RecordPackedFile(p.projectName, packed); err != nil {
+		return err
+	}
+	if p.pos >= p.archiveSize {
+		log.Printf("Pack archive %s is full", p.archive)
+		p.closeArchive()
+	}
+	return nil
+}
+
+// open opens the archive files are appended to, continuing the latest one
+// unless it is full
+func (p *packer) open() error {
+	if p.file != nil {
+		return nil
+	}
+	dir := p.storage.PackPath("")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create pack directory: %w", err)
+	}
+
+	// Archive numbers are taken from the directory, as the database doesn't
+	// know archives whose files were all deleted
+	last := 0
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read pack directory: %w", err)
+	}
+	for _, entry := range entries {
+		var number int
+		if _, err := fmt.Sscanf(entry.Name(), packArchivePattern, &number); err == nil && number > last {
+			last = number
+		}
+	}
+
+	recorded, end, err := p.database.GetLastPackArchive(p.projectName)
+	if err != nil {
+		return err
+	}
+	archive, pos := fmt.Sprintf(packArchivePattern, last+1), int64(0)
+	if last > 0 && recorded == fmt.Sprintf(packArchivePattern, last) {
+		// The content of the last recorded file is followed by padding
+		if end = (end + tarBlockSize - 1) / tarBlockSize * tarBlockSize; end < p.archiveSize {
+			archive, pos = recorded, end
+		}
+	}
+
+	file, err := os.OpenFile(p.storage.PackPath(archive), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open pack archive: %w", err)
+	}
+	p.archive, p.file = archive, file
+	if err := p.rewind(pos); err != nil {
+		p.closeArchive()
+		return fmt.Errorf("failed to open pack archive: %w", err)
+	}
+	if pos > 0 {
+		log.Printf("Continuing pack archive %s at %d bytes", archive, pos)
+	} else {
+		log.Printf("Starting pack archive %s", archive)
+	}
+	return nil
+}
+
+// rewind cuts the archive off at pos and continues writing there
+func (p *packer) rewind(pos int64) error {
+	if err := p.file.Truncate(pos); err != nil {
+		return err
+	}
+	if _, err := p.file.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+	p.pos = pos
+	p.tw = tar.NewWriter(packWriter{p})
+	return nil
+}
+
+// closeArchive ends the current archive with the tar trailer, which is
+// removed again if the archive is continued
+func (p *packer) closeArchive() {
+	if p.file == nil {
+		return
+	}
+	if err := p.tw.Close(); err != nil {
+		log.Printf("Warning: Failed to finish pack archive %s: %v", p.archive, err)
+	}
+	if err := p.file.Close(); err != nil {
+		log.Printf("Warning: Failed to close pack archive %s: %v", p.archive, err)
+	}
+	p.file, p.tw = nil, nil
+}
+
+func (p *packer) close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closeArchive()
+}
+
+// packWriter writes to the archive file and keeps track of the position
+type packWriter struct {
+	p *packer
+}
+
+func (w packWriter) Write(b []byte) (int, error) {
+	n, err := w.p.file.Write(b)
+	w.p.pos += int64(n)
+	return n, err
+}
+
+// openFile returns the content of a packed file
+func (p *packer) openFile(file *db.PackedFile) (io.ReadCloser, error) {
+	archive, err := os.Open(p.storage.PackPath(file.Archive))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pack archive: %w", err)
+	}
+	return &packedReader{SectionReader: io.NewSectionReader(archive, file.Offset, file.Size), archive: archive}, nil
+}
+
+// scrub overwrites the content of the packed file of key with zeros, as
+// deleting it only drops its record. The entry stays in the archive, so
+// the archive remains readable.
+func (p *packer) scrub(key string) error {
+	file, err := p.database.GetPackedFile(p.projectName, key)
+	if err != nil || file == nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	archive, err := os.OpenFile(p.storage.PackPath(file.Archive), os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open pack archive: %w", err)
+	}
+	defer archive.Close()
+	zeros := io.LimitReader(zeroReader{}, file.Size)
+	if _, err := io.Copy(io.NewOffsetWriter(archive, file.Offset), zeros); err != nil {
+		return fmt.Errorf("failed to scrub %s in pack archive %s: %w", key, file.Archive, err)
+	}
+	return archive.Sync()
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	clear(b)
+	return len(b), nil
+}
+
+type packedReader struct {
+	*io.SectionReader
+	archive *os.File
+}
+
+func (r *packedReader) Close() error {
+	return r.archive.Close()
+}
+
+// packedBackend is a local destination whose small files are packed into
+// archives. Files that aren't packed are stored as usual.
+type packedBackend struct {
+	*localBackend
+	packer *packer
+}
+
+func (b *packedBackend) packed(key string) (*db.PackedFile, error) {
+	return b.packer.database.GetPackedFile(b.packer.projectName, key)
+}
+
+func (b *packedBackend) List(ctx context.Context, prefix string, fn func(minio.ObjectInfo) error) error {
+	if err := b.localBackend.List(ctx, prefix, fn); err != nil {
+		return err
+	}
+	return b.packer.database.ForEachPackedFile(b.packer.projectName, prefix, func(file *db.PackedFile) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(minio.ObjectInfo{Key: file.Path, Size: file.Size, LastModified: file.PackedAt})
+	})
+}
+
+func (b *packedBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := b.packed(key)
+	if err != nil || file == nil {
+		if err == nil {
+			return b.localBackend.Get(ctx, key)
+		}
+		return nil, err
+	}
+	return b.packer.openFile(file)
+}
+
+// Put packs small files and stores the others as usual, removing the other
+// copy of a file that changed from one kind to the other
+func (b *packedBackend) Put(ctx context.Context, key string, reader io.Reader, opts PutOptions) error {
+	if !b.packer.applies(opts.Size) {
+		if err := b.localBackend.Put(ctx, key, reader, opts); err != nil {
+			return err
+		}
+		return b.packer.database.DeletePackedFile(b.packer.projectName, key)
+	}
+	if err := b.packer.add(key, reader, opts.Size); err != nil {
+		return err
+	}
+	return b.storage.DeleteFile(key)
+}
+
+func (b *packedBackend) Stat(ctx context.Context, key string) (*minio.ObjectInfo, error) {
+	file, err := b.packed(key)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return b.localBackend.Stat(ctx, key)
+	}
+	return &minio.ObjectInfo{Key: key, Size: file.Size, LastModified: file.PackedAt}, nil
+}
+
+func (b *packedBackend) Delete(ctx context.Context, key string) error {
+	if err := b.packer.database.DeletePackedFile(b.packer.projectName, key); err != nil {
+		return err
+	}
+	return b.localBackend.Delete(ctx, key)
+}
+
+func (b *packedBackend) Move(ctx context.Context, from, to string) error {
+	file, err := b.packed(from)
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		return b.localBackend.Move(ctx, from, to)
+	}
+	if err := b.packer.database.MovePackedFile(b.packer.projectName, from, to); err != nil {
+		return err
+	}
+	return b.storage.DeleteFile(to)
+}
+
+func (b *packedBackend) Hash(ctx context.Context, key string) (string, error) {
+	reader, err := b.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Extract writes the packed files whose key starts with prefix to
+// outputDir, keeping their keys as relative paths, and returns how many it
+// wrote
+func (s *Service) Extract(ctx context.Context, prefix, outputDir string) (int, error) {
+	if s.packer == nil {
+		return 0, fmt.Errorf("project %s doesn't pack files, set pack in the local section of its config", s.projectName)
+	}
+
+	files := 0
+	err := s.database.ForEachPackedFile(s.projectName, prefix, func(file *db.PackedFile) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		target := filepath.Join(outputDir, filepath.FromSlash(keys.EncodePath(file.Path)))
+		if rel, err := filepath.Rel(outputDir, target); err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("packed file %s is outside the output directory", file.Path)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(target), err)
+		}
+
+		reader, err := s.packer.openFile(file)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		out, err := os.Create(target)
+		if err != nil {
+			return fmt.Errorf("failed to create file %s: %w", target, err)
+		}
+		if _, err := io.Copy(out, reader); err != nil {
+			out.Close()
+			return fmt.Errorf("failed to extract %s: %w", file.Path, err)
+		}
+		if err := out.Close(); err != nil {
+			return fmt.Errorf("failed to write file %s: %w", target, err)
+		}
+		os.Chtimes(target, file.PackedAt, file.PackedAt)
+		files++
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,62 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+// errWorkerPanic marks files whose processing panicked
+var errWorkerPanic = errors.New("worker panicked")
+
+// syncFileRecovered is syncFile for the worker pool. A panic while a file
+// is processed, e.g. on a malformed object, fails only that file: it is
+// recorded as error with the stack trace like a failed copy, and the worker
+// goes on with the next file. Panics of the goroutines transferring chunks
+// or parts of the file are recovered by recoverTransfer and fail it the
+// same way.
+func (s *Service) syncFileRecovered(ctx context.Context, workerID int, file *db.FileEntry, opts SyncOptions, listed map[string]bool, stats *runStats, watch *watchdog) (err error) {
+	defer func() {
+		value := recover()
+		if value == nil {
+			return
+		}
+		stack := debug.Stack()
+		err = fmt.Errorf("%w: %v", errWorkerPanic, value)
+		log.Printf("Worker %d: Recovered from a panic while processing %s: %v\n%s", workerID, file.Path, value, stack)
+		// The transfer the panic cut short is no longer watched
+		watch.end(workerID)
+		stats.panics.Add(1)
+		stats.failed.Add(1)
+
+		gaveUp, recordErr := s.failFile(file, fmt.Errorf("%w\n%s", err, stack))
+		if recordErr != nil {
+			log.Printf("Worker %d: Failed to update file status for %s: %v", workerID, file.Path, recordErr)
+		} else if gaveUp {
+			stats.gaveUp.Add(1)
+		}
+	}()
+	err = s.syncFile(ctx, workerID, file, opts, listed, stats, watch)
+	if errors.Is(err, errWorkerPanic) {
+		stats.panics.Add(1)
+	}
+	return err
+}
+
+// recoverTransfer turns a panic of a goroutine transferring part of path
+// into an error wrapping errWorkerPanic, which is set to *err. It must be
+// deferred by the goroutine, as panics can't be recovered by the worker
+// that started it.
+func recoverTransfer(path string, err *error) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	stack := debug.Stack()
+	log.Printf("Recovered from a panic while transferring %s: %v\n%s", path, value, stack)
+	*err = fmt.Errorf("%w: %v\n%s", errWorkerPanic, value, stack)
+}
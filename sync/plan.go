@@ -0,0 +1,263 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+// PlanActionType is what apply does with a file
+type PlanActionType string
+
+const (
+	PlanCopy      PlanActionType = "copy"
+	PlanOverwrite PlanActionType = "overwrite"
+	PlanSkip      PlanActionType = "skip"
+	// PlanKeep leaves a destination object that is newer than the source
+	PlanKeep PlanActionType = "keep"
+)
+
+// PlanAction is a single reviewed change to the main destination
+type PlanAction struct {
+	Action PlanActionType `json:"action"`
+	Path   string         `json:"path"`
+	Size   int64          `json:"size"`
+	ETag   string         `json:"etag"`
+	Reason string         `json:"reason"`
+}
+
+// Plan is the list of actions a sync would perform, saved for review and
+// executed later by ApplyPlan
+type Plan struct {
+	Project   string       `json:"project"`
+	CreatedAt time.Time    `json:"createdAt"`
+	Actions   []PlanAction `json:"actions"`
+}
+
+// Counts returns the number of actions and bytes per action type
+func (p *Plan) Counts() (counts map[PlanActionType]int, sizes map[PlanActionType]int64) {
+	counts = make(map[PlanActionType]int)
+	sizes = make(map[PlanActionType]int64)
+	for _, action := range p.Actions {
+		counts[action.Action]++
+		sizes[action.Action] += action.Size
+	}
+	return counts, sizes
+}
+
+// Save writes the plan as indented JSON so it can be reviewed and approved
+func (p *Plan) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write plan file: %w", err)
+	}
+	return nil
+}
+
+// LoadPlan reads a plan saved by Save
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan file: %w", err)
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan file: %w", err)
+	}
+	return &plan, nil
+}
+
+// Plan checks every pending file against the main destination and returns
+// the actions a sync would perform, without changing anything
+func (s *Service) Plan(ctx context.Context, workers int) (*Plan, error) {
+	files, err := s.database.GetPendingFiles(s.projectName, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending files: %w", err)
+	}
+	// Files left out by the filter are marked by the next sync
+	kept := files[:0]
+	for _, file := range files {
+		if s.filter.reason(file.Path, file.Size, file.LastModified) == "" {
+			kept = append(kept, file)
+		}
+	}
+	files = kept
+
+	log.Printf("Planning %d pending files with %d workers...", len(files), workers)
+	s.destStats.reset()
+
+	plan := &Plan{Project: s.projectName, CreatedAt: time.Now()}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	filesChan := make(chan *db.FileEntry, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range filesChan {
+				action, err := s.planAction(ctx, file)
+				if err != nil {
+					// Copying is the safe choice when the destination cannot be checked
+					action.Action = PlanCopy
+					action.Reason = fmt.Sprintf("destination check failed: %v", err)
+				}
+				mu.Lock()
+				plan.Actions = append(plan.Actions, action)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, file := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		filesChan <- file
+	}
+	close(filesChan)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("planning interrupted: %w", ctx.Err())
+	}
+
+	sort.Slice(plan.Actions, func(i, j int) bool { return plan.Actions[i].Path < plan.Actions[j].Path })
+	return plan, nil
+}
+
+// planAction decides what a sync has to do with a file by comparing it with
+// the main destination
+func (s *Service) planAction(ctx context.Context, file *db.FileEntry) (PlanAction, error) {
+	action := PlanAction{Path: file.Path, Size: file.Size, ETag: file.ETag}
+
+	newer, err := s.destinationNewer(ctx, file)
+	if err != nil {
+		return action, err
+	}
+	if newer {
+		action.Action, action.Reason = PlanKeep, destNewerReason
+		return action, nil
+	}
+
+	info, err := s.statDestination(ctx, file.Path)
+	if err != nil {
+		if minio.IsNotFound(err) {
+			action.Action, action.Reason = PlanCopy, "not at destination"
+			return action, nil
+		}
+		return action, err
+	}
+	if policyAction, reason := s.overwriteAction(file, info); policyAction != "" {
+		action.Action, action.Reason = policyAction, reason
+		return action, nil
+	}
+
+	// With -checksum, local copies of the right size are compared by content
+	if s.checksums && !comparableETags(file, info) && info.Size == file.Size {
+		mismatch, err := s.sameChecksum(ctx, file)
+		if err != nil {
+			return action, err
+		}
+		if mismatch != "" {
+			action.Action, action.Reason = PlanOverwrite, mismatch
+		} else {
+			action.Action, action.Reason = PlanSkip, "destination file has the same SHA256 checksum"
+		}
+		return action, nil
+	}
+
+	switch {
+	case !comparableETags(file, info) && info.Size != file.Size:
+		action.Action = PlanOverwrite
+		action.Reason = fmt.Sprintf("destination file has size %d, source %d", info.Size, file.Size)
+	case !comparableETags(file, info) && compareModified(info.LastModified, file.LastModified, s.clockSkew) < 0:
+		action.Action = PlanOverwrite
+		action.Reason = "destination file is older than the source"
+	case !comparableETags(file, info):
+		action.Action, action.Reason = PlanSkip, "destination file exists with same size"
+	case info.Size == file.Size && info.ETag == file.ETag:
+		action.Action, action.Reason = PlanSkip, "destination object exists with same size and ETag"
+	default:
+		action.Action = PlanOverwrite
+		action.Reason = fmt.Sprintf("destination object differs (size %d, ETag %s)", info.Size, info.ETag)
+	}
+	return action, nil
+}
+
+// ApplyPlan executes a saved plan. Files that changed or were synced since
+// the plan was made are left alone; everything else is done exactly as
+// planned, without checking the destination again.
+func (s *Service) ApplyPlan(ctx context.Context, plan *Plan, opts SyncOptions) (*SyncResult, error) {
+	if plan.Project != s.projectName {
+		return nil, fmt.Errorf("plan was made for project %s, not %s", plan.Project, s.projectName)
+	}
+
+	log.Printf("Applying plan from %s with %d actions", plan.CreatedAt.Format(time.RFC3339), len(plan.Actions))
+
+	var (
+		files []*db.FileEntry
+		stale int
+	)
+	for _, action := range plan.Actions {
+		file, err := s.database.GetFileByPath(s.projectName, action.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up %s: %w", action.Path, err)
+		}
+		if file == nil || file.ETag != action.ETag ||
+			(file.Status != db.StatusPending && file.Status != db.StatusError && file.Status != db.StatusRestoreRequested) {
+			log.Printf("Warning: %s changed since the plan was made, skipping it", action.Path)
+			stale++
+			continue
+		}
+
+		switch action.Action {
+		case PlanCopy, PlanOverwrite:
+			files = append(files, file)
+		case PlanSkip:
+			if err := s.database.UpdateFileStatusReason(file.ID, db.StatusSkippedExisting, action.Reason); err != nil {
+				return nil, fmt.Errorf("failed to update file status: %w", err)
+			}
+		case PlanKeep:
+			if err := s.holdNewer(file); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown action %q for %s", action.Action, action.Path)
+		}
+	}
+
+	if stale > 0 {
+		log.Printf("%d planned files changed since the plan was made and were left for the next plan", stale)
+	}
+
+	result := &SyncResult{}
+	started := time.Now()
+	transferredBefore := s.transferred.Load()
+	defer func() {
+		result.Duration = time.Since(started)
+		result.Transferred = s.transferred.Load() - transferredBefore
+		s.recordStats("apply", result)
+	}()
+
+	defer s.logAccounting()
+	if len(files) > 0 {
+		opts.SkipExisting, opts.Force = false, true
+		if err := s.copyFiles(ctx, opts, files, nil, result); err != nil {
+			return result, err
+		}
+	}
+	return result, s.commitGroups(ctx)
+}
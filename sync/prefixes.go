@@ -0,0 +1,82 @@
+package sync
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+// prefixBudgets splits the files of a run into lanes with their own workers,
+// one per configured prefix and one for the remaining files
+type prefixBudgets []config.PrefixBudget
+
+func newPrefixBudgets(budgets []config.PrefixBudget) (prefixBudgets, error) {
+	seen := make(map[string]bool)
+	for _, budget := range budgets {
+		if budget.Prefix == "" {
+			return nil, fmt.Errorf("prefix budget without prefix")
+		}
+		if budget.Workers < 1 {
+			return nil, fmt.Errorf("prefix budget %q needs at least 1 worker", budget.Prefix)
+		}
+		if seen[budget.Prefix] {
+			return nil, fmt.Errorf("prefix %q has more than one budget", budget.Prefix)
+		}
+		seen[budget.Prefix] = true
+	}
+
+	// Files belong to the longest matching prefix
+	sorted := append(prefixBudgets(nil), budgets...)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i].Prefix) > len(sorted[j].Prefix) })
+	return sorted, nil
+}
+
+// lane is a share of the workers of a run and the files it copies
+type lane struct {
+	// prefix is empty for the lane of the files below no budget
+	prefix  string
+	workers int
+	files   []*db.FileEntry
+	ch      chan *db.FileEntry
+}
+
+func (l *lane) String() string {
+	if l.prefix == "" {
+		return "other files"
+	}
+	return l.prefix
+}
+
+// lanes returns the lanes of a run, the last one with workers for the files
+// below no prefix. Without budgets that is the only lane.
+func (b prefixBudgets) lanes(workers int) []*lane {
+	lanes := make([]*lane, 0, len(b)+1)
+	for _, budget := range b {
+		lanes = append(lanes, &lane{prefix: budget.Prefix, workers: budget.Workers})
+	}
+	lanes = append(lanes, &lane{workers: workers})
+	for _, l := range lanes {
+		l.ch = make(chan *db.FileEntry, l.workers)
+	}
+	return lanes
+}
+
+// assign distributes files over the lanes, replacing the files they held
+func (b prefixBudgets) assign(lanes []*lane, files []*db.FileEntry) {
+	for _, l := range lanes {
+		l.files = nil
+	}
+	for _, file := range files {
+		l := lanes[len(lanes)-1]
+		for i, budget := range b {
+			if strings.HasPrefix(file.Path, budget.Prefix) {
+				l = lanes[i]
+				break
+			}
+		}
+		l.files = append(l.files, file)
+	}
+}
@@ -0,0 +1,106 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+// ListPreview is what update-list would record, counted without writing to
+// the database
+type ListPreview struct {
+	// Listed counts the objects within the time window
+	Listed int
+	Size   int64
+	// Included counts the objects that would be recorded for copying, the
+	// rest are left out by the sample, the filters or bucket replication
+	Included     int
+	IncludedSize int64
+	SampledOut   int
+	Excluded     int
+	Replicated   int
+	// Prefixes totals the objects per top-level prefix below the folder
+	Prefixes []PrefixPreview
+}
+
+// PrefixPreview is the number and total size of the objects listed in one
+// top-level prefix, and how many of them would be recorded for copying
+type PrefixPreview struct {
+	Prefix       string
+	Listed       int
+	Size         int64
+	Included     int
+	IncludedSize int64
+}
+
+// PreviewSourceList lists the source like update-list and applies the same
+// sample, filters and time window, but only counts the objects per top-level
+// prefix. The listing cache is not used, so the counts reflect the source.
+func (s *Service) PreviewSourceList(ctx context.Context, opts ListOptions) (*ListPreview, error) {
+	opts = opts.withDefaults()
+	prefix := strings.Trim(s.sourceClient.GetFolderPath(), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	log.Printf("Previewing source file list of prefix %q, nothing is recorded...", prefix)
+
+	// Concurrent listers call back concurrently
+	var mu sync.Mutex
+	preview := &ListPreview{}
+	prefixes := make(map[string]*PrefixPreview)
+	listErr := s.sourceClient.ListObjects(ctx, opts.sourceListOptions(s.skipReplicated), func(obj minio.ObjectInfo) error {
+		if !opts.inWindow(obj.LastModified) {
+			return nil
+		}
+		folder, _, found := strings.Cut(strings.TrimPrefix(obj.Key, prefix), "/")
+		if !found {
+			folder = ""
+		}
+		folder = path.Join(prefix, folder)
+
+		mu.Lock()
+		defer mu.Unlock()
+		entry := prefixes[folder]
+		if entry == nil {
+			entry = &PrefixPreview{Prefix: folder}
+			prefixes[folder] = entry
+		}
+		preview.Listed++
+		preview.Size += obj.Size
+		entry.Listed++
+		entry.Size += obj.Size
+
+		// Filters and replication take precedence over the sample, as in
+		// recordObject
+		switch {
+		case s.filter.reason(obj.Key, obj.Size, obj.LastModified) != "":
+			preview.Excluded++
+		case s.replicationReason(obj) != "":
+			preview.Replicated++
+		case !opts.inSample(obj.Key):
+			preview.SampledOut++
+		default:
+			preview.Included++
+			preview.IncludedSize += obj.Size
+			entry.Included++
+			entry.IncludedSize += obj.Size
+		}
+		return nil
+	})
+
+	for _, entry := range prefixes {
+		preview.Prefixes = append(preview.Prefixes, *entry)
+	}
+	sort.Slice(preview.Prefixes, func(i, j int) bool { return preview.Prefixes[i].Prefix < preview.Prefixes[j].Prefix })
+
+	if listErr != nil {
+		return preview, fmt.Errorf("failed to list objects: %w", listErr)
+	}
+	return preview, nil
+}
@@ -0,0 +1,29 @@
+package sync
+
+import "fmt"
+
+// I/O priorities of SetPriority
+const (
+	// IOPriorityIdle only gets disk time when no other process needs it
+	IOPriorityIdle = "idle"
+	// IOPriorityLow is the lowest best-effort priority
+	IOPriorityLow = "low"
+)
+
+// SetPriority lowers the CPU priority of the process to nice, if not zero,
+// and its disk I/O priority to ioPriority, if not empty, so that copies on a
+// shared host don't starve other services
+func SetPriority(nice int, ioPriority string) error {
+	if nice < -20 || nice > 19 {
+		return fmt.Errorf("invalid nice value %d, must be between -20 and 19", nice)
+	}
+	switch ioPriority {
+	case "", IOPriorityIdle, IOPriorityLow:
+	default:
+		return fmt.Errorf("invalid I/O priority %q, must be %s or %s", ioPriority, IOPriorityIdle, IOPriorityLow)
+	}
+	if nice == 0 && ioPriority == "" {
+		return nil
+	}
+	return setPriority(nice, ioPriority)
+}
@@ -0,0 +1,58 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// ioprio_set(2) arguments
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+)
+
+// setPriority sets the priorities of every thread of the process, as Linux
+// keeps them per thread. Threads started later inherit them.
+func setPriority(nice int, ioPriority string) error {
+	var ioprio uintptr
+	switch ioPriority {
+	case IOPriorityIdle:
+		ioprio = ioprioClassIdle << ioprioClassShift
+	case IOPriorityLow:
+		ioprio = ioprioClassBE<<ioprioClassShift | 7
+	}
+
+	for _, tid := range threadIDs() {
+		if nice != 0 {
+			if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice); err != nil {
+				return fmt.Errorf("failed to set nice value: %w", err)
+			}
+		}
+		if ioprio != 0 {
+			if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprio); errno != 0 {
+				return fmt.Errorf("failed to set I/O priority: %w", errno)
+			}
+		}
+	}
+	return nil
+}
+
+// threadIDs lists the threads of the process, or only the calling one if
+// they can't be read
+func threadIDs() []int {
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return []int{0}
+	}
+	var tids []int
+	for _, entry := range entries {
+		if tid, err := strconv.Atoi(entry.Name()); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	return tids
+}
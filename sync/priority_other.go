@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package sync
+
+import "fmt"
+
+func setPriority(nice int, ioPriority string) error {
+	return fmt.Errorf("process priorities are not supported on this platform")
+}
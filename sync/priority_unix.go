@@ -0,0 +1,20 @@
+//go:build unix && !linux
+
+package sync
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func setPriority(nice int, ioPriority string) error {
+	if nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, nice); err != nil {
+			return fmt.Errorf("failed to set nice value: %w", err)
+		}
+	}
+	if ioPriority != "" {
+		return fmt.Errorf("I/O priorities are only supported on Linux")
+	}
+	return nil
+}
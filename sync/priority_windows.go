@@ -0,0 +1,27 @@
+package sync
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// setPriority maps nice values to priority classes: up to 9 below normal,
+// from 10 idle, and negative values above normal
+func setPriority(nice int, ioPriority string) error {
+	if ioPriority != "" {
+		return fmt.Errorf("I/O priorities are only supported on Linux")
+	}
+
+	class := uint32(windows.BELOW_NORMAL_PRIORITY_CLASS)
+	switch {
+	case nice >= 10:
+		class = windows.IDLE_PRIORITY_CLASS
+	case nice < 0:
+		class = windows.ABOVE_NORMAL_PRIORITY_CLASS
+	}
+	if err := windows.SetPriorityClass(windows.CurrentProcess(), class); err != nil {
+		return fmt.Errorf("failed to set priority class: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package sync
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+const (
+	// Runs shorter or smaller than this say little about an endpoint and
+	// don't update its profile
+	profileMinDuration = 10 * time.Second
+	profileMinBytes    = 16 << 20
+
+	// profilePartDuration is how long uploading one part should take a
+	// worker; longer parts lose more on an interruption, shorter ones pay
+	// the request latency more often
+	profilePartDuration = 30 * time.Second
+	maxProfilePartSize  = 512 << 20
+
+	// latencySampleSize bounds the request latencies kept per run
+	latencySampleSize = 4096
+)
+
+// latencySamples collects the latencies of the requests to an endpoint
+// during a run. Once full, the oldest samples are replaced.
+type latencySamples struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (l *latencySamples) add(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) < latencySampleSize {
+		l.samples = append(l.samples, latency)
+		return
+	}
+	l.samples[l.next] = latency
+	l.next = (l.next + 1) % latencySampleSize
+}
+
+func (l *latencySamples) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples, l.next = l.samples[:0], 0
+}
+
+// p95 returns the p95 latency of the collected requests, zero without any
+func (l *latencySamples) p95() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return percentile95(slices.Clone(l.samples))
+}
+
+// percentile95 returns the p95 of samples, which it sorts, or zero if there
+// are none
+func percentile95(samples []time.Duration) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	slices.Sort(samples)
+	return samples[(len(samples)*95-1)/100]
+}
+
+// profileEndpoint is the endpoint whose profile sets the workers and part
+// size: the destination, or the source for local destinations
+func (s *Service) profileEndpoint() string {
+	if s.destClient != nil {
+		return s.destClient.GetEndpoint()
+	}
+	return s.sourceClient.GetEndpoint()
+}
+
+// fastestProfile returns the profile of the worker count with the highest
+// throughput at endpoint, or nil if no run was profiled there
+func fastestProfile(profiles []db.EndpointProfile, endpoint string) *db.EndpointProfile {
+	var fastest *db.EndpointProfile
+	for i := range profiles {
+		if profiles[i].Endpoint == endpoint && (fastest == nil || profiles[i].Throughput > fastest.Throughput) {
+			fastest = &profiles[i]
+		}
+	}
+	return fastest
+}
+
+// SuggestedWorkers returns the worker count that was fastest in past runs
+// to the endpoint of the project, or fallback if there were none. Runs with
+// other worker counts, set with -workers, extend the profile.
+func (s *Service) SuggestedWorkers(fallback int) int {
+	endpoint := s.profileEndpoint()
+	fastest := fastestProfile(s.profiles, endpoint)
+	if fastest == nil {
+		return fallback
+	}
+	log.Printf("Using %d workers, the fastest in past runs to %s (%.0f bytes per second)", fastest.Workers, endpoint, fastest.Throughput)
+	return fastest.Workers
+}
+
+// profiledPartSize returns the part size a worker uploads to endpoint in
+// about profilePartDuration, judging by the fastest profile, or partSize
+// without a profile
+func profiledPartSize(profiles []db.EndpointProfile, endpoint string, partSize int64) int64 {
+	fastest := fastestProfile(profiles, endpoint)
+	if fastest == nil {
+		return partSize
+	}
+	perWorker := fastest.Throughput / float64(max(fastest.Workers, 1))
+	size := int64(perWorker*profilePartDuration.Seconds()) &^ (1<<20 - 1)
+	return min(max(size, minPartSize), maxProfilePartSize)
+}
+
+// recordProfiles adds a copy run of workers that read bytes in elapsed to
+// the profiles of the source and destination endpoint
+func (s *Service) recordProfiles(workers int, bytes int64, elapsed time.Duration) {
+	if bytes < profileMinBytes || elapsed < profileMinDuration {
+		return
+	}
+	throughput := float64(bytes) / elapsed.Seconds()
+
+	record := func(client *minio.MinioClient, latency *latencySamples) {
+		profile := &db.EndpointProfile{
+			Endpoint:   client.GetEndpoint(),
+			Workers:    workers,
+			Throughput: throughput,
+			LatencyP95: latency.p95(),
+		}
+		if err := s.database.RecordEndpointProfile(s.projectName, profile); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	if s.destClient == nil || s.destClient.GetEndpoint() != s.sourceClient.GetEndpoint() {
+		record(s.sourceClient, s.sourceLatency)
+	}
+	if s.destClient != nil {
+		record(s.destClient, s.destLatency)
+	}
+}
@@ -0,0 +1,110 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// runInfoFile is written next to the project database while files are being
+// copied. It tells other commands where to ask the running process for its
+// progress.
+const runInfoFile = "run.json"
+
+// progressTimeout bounds the request for live progress, so that status
+// doesn't hang on a stuck process
+const progressTimeout = 2 * time.Second
+
+// runInfo is the content of the run info file
+type runInfo struct {
+	PID     int    `json:"pid"`
+	Address string `json:"address"`
+}
+
+// RunProgress is the live progress of a running sync, as reported by the
+// process doing it
+type RunProgress struct {
+	PID         int       `json:"pid"`
+	StartedAt   time.Time `json:"startedAt"`
+	Workers     int       `json:"workers"`
+	Total       int       `json:"total"`
+	Completed   int64     `json:"completed"`
+	Failed      int64     `json:"failed"`
+	Transferred int64     `json:"transferred"`
+}
+
+// serveProgress publishes the progress of a sync on a local port and records
+// the port in the run info file. The returned function stops it again.
+func (s *Service) serveProgress(progress func() RunProgress) func() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Printf("Warning: Failed to publish sync progress: %v", err)
+		return func() {}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/progress", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(progress()); err != nil {
+			log.Printf("Warning: Failed to send sync progress: %v", err)
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+
+	info, _ := json.Marshal(runInfo{PID: os.Getpid(), Address: listener.Addr().String()})
+	if err := os.WriteFile(s.runInfoPath, info, 0644); err != nil {
+		log.Printf("Warning: Failed to write run info: %v", err)
+	}
+
+	s.control.setProgress(progress)
+
+	return func() {
+		s.control.setProgress(nil)
+		os.Remove(s.runInfoPath)
+		server.Close()
+	}
+}
+
+// liveProgress asks the process named in the run info file for its progress.
+// It returns nil when no sync is running.
+func (s *Service) liveProgress() (*RunProgress, error) {
+	data, err := os.ReadFile(s.runInfoPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run info: %w", err)
+	}
+
+	var info runInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse run info: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), progressTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+info.Address+"/progress", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request progress: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// Left behind by a process that was killed
+		log.Printf("Warning: Sync process %d is not responding, ignoring %s", info.PID, s.runInfoPath)
+		return nil, nil
+	}
+	defer resp.Body.Close()
+
+	var progress RunProgress
+	if err := json.NewDecoder(resp.Body).Decode(&progress); err != nil {
+		return nil, fmt.Errorf("failed to decode progress: %w", err)
+	}
+	return &progress, nil
+}
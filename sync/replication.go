@@ -0,0 +1,43 @@
+package sync
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+// replicationCompleted is the replication status of objects the bucket
+// replication of the source has copied
+const replicationCompleted = "COMPLETED"
+
+// replicationReason returns why a listed object is left to the bucket
+// replication of the source, or an empty string if it is copied. Objects
+// whose replication failed or is pending are copied, and so are objects
+// without a status, which no replication rule covers.
+func (s *Service) replicationReason(obj minio.ObjectInfo) string {
+	if !s.skipReplicated || !strings.EqualFold(obj.ReplicationStatus, replicationCompleted) {
+		return ""
+	}
+	return "replicated by the source bucket"
+}
+
+// statReplication fills in the replication status of objects that weren't
+// listed with metadata, e.g. from the listing cache or an imported key list
+func (s *Service) statReplication(ctx context.Context, objects []minio.ObjectInfo) {
+	if !s.skipReplicated {
+		return
+	}
+	for i, obj := range objects {
+		if obj.Metadata != nil || obj.ReplicationStatus != "" {
+			continue
+		}
+		info, err := s.sourceClient.StatObject(ctx, obj.Key)
+		if err != nil {
+			log.Printf("Warning: Failed to get replication status of %s, copying it: %v", obj.Key, err)
+			continue
+		}
+		objects[i].ReplicationStatus = info.ReplicationStatus
+	}
+}
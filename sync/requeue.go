@@ -0,0 +1,139 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+// ParseTimeRange parses the window of the requeue command. Both ends are
+// dates like 2024-05-01 in UTC or RFC 3339 times; an end given as a date
+// includes that whole day.
+func ParseTimeRange(start, end string) (time.Time, time.Time, error) {
+	from, err := ParseListTime(start)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	until, err := ParseListTime(end)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if from.IsZero() || until.IsZero() {
+		return time.Time{}, time.Time{}, fmt.Errorf("both the start and the end of the time range are required")
+	}
+	if _, err := time.Parse("2006-01-02", strings.TrimSpace(end)); err == nil {
+		until = until.AddDate(0, 0, 1)
+	}
+	if !until.After(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("the end of the time range must be after its start")
+	}
+	return from, until, nil
+}
+
+// RequeueModified sets the completed files whose source was last modified
+// within [from, until) back to pending, so the next sync copies exactly that
+// span again, e.g. after the source was repaired from a corruption
+// incident. It returns how many files were requeued.
+func (s *Service) RequeueModified(from, until time.Time) (int64, error) {
+	reason := fmt.Sprintf("requeue: source modified between %s and %s",
+		from.Format(time.RFC3339), until.Format(time.RFC3339))
+	requeued, err := s.database.RequeueModified(s.projectName, from, until, reason)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("Requeued %d completed files last modified between %s and %s",
+		requeued, from.Format(time.RFC3339), until.Format(time.RFC3339))
+	return requeued, nil
+}
+
+// RetryFailed sets the failed files whose path starts with prefix back to
+// pending, e.g. after the credentials were fixed. A non-empty pattern is a
+// regular expression that the error message of a file must match.
+func (s *Service) RetryFailed(prefix, pattern string) (int64, error) {
+	var match func(string) bool
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return 0, fmt.Errorf("invalid error pattern: %w", err)
+		}
+		match = re.MatchString
+	}
+	retried, err := s.database.RetryFailed(s.projectName, prefix, match)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("Set %d failed files back to pending", retried)
+	return retried, nil
+}
+
+// ResetOptions selects the files of the reset command
+type ResetOptions struct {
+	// Statuses limits the reset to files with these statuses, empty means
+	// all files
+	Statuses []db.FileStatus
+	// Before limits the reset to files last updated before it, unless zero
+	Before time.Time
+	// All deletes the files from the database instead of setting them back
+	// to pending, so a project can start over from the next update-list
+	All bool
+}
+
+// ParseStatuses parses a comma-separated list of file statuses
+func ParseStatuses(list string) ([]db.FileStatus, error) {
+	var statuses []db.FileStatus
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		status := db.FileStatus(name)
+		if !slices.Contains(db.FileStatuses, status) {
+			return nil, fmt.Errorf("unknown file status %q", name)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Reset sets the files selected by opts back to pending, or deletes them
+// from the database with opts.All. Their unfinished multipart uploads are
+// aborted. It returns how many files were reset.
+func (s *Service) Reset(ctx context.Context, opts ResetOptions) (int64, error) {
+	if opts.All {
+		deleted, uploads, err := s.database.DeleteFiles(s.projectName, opts.Statuses, opts.Before)
+		if err != nil {
+			return 0, err
+		}
+		s.abortUploads(ctx, uploads)
+		log.Printf("Deleted %d files from the database", deleted)
+		return deleted, nil
+	}
+
+	reset, uploads, err := s.database.ResetFiles(s.projectName, opts.Statuses, opts.Before, "reset")
+	if err != nil {
+		return 0, err
+	}
+	s.abortUploads(ctx, uploads)
+	log.Printf("Set %d files back to pending", reset)
+	return reset, nil
+}
+
+// abortUploads aborts multipart uploads whose state was cleared, so their
+// parts don't stay at the destination. Failures are only logged, as the
+// uploads can no longer be resumed anyway.
+func (s *Service) abortUploads(ctx context.Context, uploads []*db.MultipartUpload) {
+	if s.destClient == nil {
+		return
+	}
+	for _, upload := range uploads {
+		if err := s.destClient.AbortMultipartUpload(ctx, upload.Path, upload.UploadID); err != nil {
+			log.Printf("Warning: Failed to abort upload of %s: %v", upload.Path, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+const (
+	defaultRestoreDays   = 7
+	defaultRestoreTier   = "Standard"
+	defaultRestoreWindow = 12 * time.Hour
+)
+
+// restorePolicy decides what happens to source objects that can't be read
+// because they are archived
+type restorePolicy struct {
+	request bool
+	days    int
+	tier    string
+	window  time.Duration
+}
+
+func newRestorePolicy(cfg config.RestoreConfig) (restorePolicy, error) {
+	policy := restorePolicy{
+		request: cfg.Request,
+		days:    cfg.Days,
+		tier:    cfg.Tier,
+		window:  cfg.Window,
+	}
+	if policy.days <= 0 {
+		policy.days = defaultRestoreDays
+	}
+	if policy.window <= 0 {
+		policy.window = defaultRestoreWindow
+	}
+	switch policy.tier {
+	case "":
+		policy.tier = defaultRestoreTier
+	case "Standard", "Bulk", "Expedited":
+	default:
+		return restorePolicy{}, fmt.Errorf("invalid restore tier %q (must be Standard, Bulk or Expedited)", cfg.Tier)
+	}
+	return policy, nil
+}
+
+// deferArchived handles a file whose source object turned out to be
+// archived: the restore is requested if the policy says so, and the file is
+// set aside until the restore window has passed
+func (s *Service) deferArchived(ctx context.Context, file *db.FileEntry) error {
+	reason := "source object is archived"
+	if s.restore.request {
+		err := s.sourceClient.RestoreObject(ctx, file.Path, s.restore.days, s.restore.tier)
+		switch {
+		case err == nil:
+			reason = fmt.Sprintf("restore requested (tier %s)", s.restore.tier)
+		case minio.IsRestoreInProgress(err):
+			reason = "restore in progress"
+		default:
+			log.Printf("Warning: Failed to request restore of %s: %v", file.Path, err)
+			reason = fmt.Sprintf("restore request failed: %v", err)
+		}
+	}
+
+	retryAt := time.Now().Add(s.restore.window)
+	log.Printf("Source object %s is archived (%s), retrying after %s", file.Path, reason, retryAt.Format(time.RFC3339))
+	return s.writer.requestRestore(file.ID, reason, retryAt)
+}
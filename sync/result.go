@@ -0,0 +1,169 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+// maxFailedFiles caps the failed files listed in a SyncResult; the error
+// tally still counts all of them
+const maxFailedFiles = 20
+
+// ErrorClass groups the errors of failed files by their likely cause
+type ErrorClass string
+
+const (
+	ErrorNotFound     ErrorClass = "not_found"
+	ErrorAccessDenied ErrorClass = "access_denied"
+	ErrorTimeout      ErrorClass = "timeout"
+	ErrorNetwork      ErrorClass = "network"
+	ErrorStalled      ErrorClass = "stalled"
+	ErrorCancelled    ErrorClass = "cancelled"
+	// ErrorThrottled covers servers asking to slow down
+	ErrorThrottled ErrorClass = "throttled"
+	// ErrorServer covers other error responses of MinIO and S3
+	ErrorServer ErrorClass = "server"
+	// ErrorLocalIO covers failures of the local file system
+	ErrorLocalIO  ErrorClass = "local_io"
+	ErrorDiskFull ErrorClass = "disk_full"
+	// ErrorPanic covers files whose processing panicked, see
+	// syncFileRecovered
+	ErrorPanic ErrorClass = "panic"
+	ErrorOther ErrorClass = "other"
+)
+
+// errTransferStalled marks transfers given up after repeated stalls
+var errTransferStalled = errors.New("transfer stalled")
+
+// FailedFile is a file that could not be synced
+type FailedFile struct {
+	Path  string
+	Class ErrorClass
+	Error string
+}
+
+// ErrorCount is the number of failed files of one error class
+type ErrorCount struct {
+	Class ErrorClass
+	Count int
+}
+
+// SyncResult summarizes a sync run. Failed files don't make the run fail;
+// they are counted here and stay in the database for the next run.
+type SyncResult struct {
+	Total     int
+	Completed int
+	Skipped   int
+	Failed    int
+	// RestoreRequested counts the archived source objects set aside until
+	// their restore window has passed
+	RestoreRequested int
+	// DestNewer counts the files not copied because the destination object
+	// was modified after the source
+	DestNewer int
+	// ClaimedElsewhere counts the files left to other instances syncing the
+	// project, see config.DistributedConfig
+	ClaimedElsewhere int
+	// GaveUp counts the failed files set to failed because they failed as
+	// often as the retry policy allows
+	GaveUp int
+	// Panics counts the files whose processing panicked; they are counted
+	// as failed too
+	Panics int
+	// NotDispatched counts the files left pending because the run stopped
+	// early or ordering rules held them back
+	NotDispatched int
+	// DestinationErrors counts the additional destinations that could not
+	// be brought up to date
+	DestinationErrors int
+	// Mirror holds the deletions of a mirror sync, nil without one
+	Mirror *MirrorResult
+
+	Transferred int64
+	Duration    time.Duration
+
+	// FailedFiles lists the first failures, at most maxFailedFiles
+	FailedFiles []FailedFile
+
+	mu     sync.Mutex
+	errors map[ErrorClass]int
+}
+
+// recordFailure counts a failed file and keeps it if the list isn't full
+func (r *SyncResult) recordFailure(path string, err error) {
+	class := classifyError(err)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Failed++
+	if r.errors == nil {
+		r.errors = make(map[ErrorClass]int)
+	}
+	r.errors[class]++
+	if len(r.FailedFiles) < maxFailedFiles {
+		r.FailedFiles = append(r.FailedFiles, FailedFile{Path: path, Class: class, Error: err.Error()})
+	}
+}
+
+// ErrorCounts returns the failures per error class, most frequent first
+func (r *SyncResult) ErrorCounts() []ErrorCount {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make([]ErrorCount, 0, len(r.errors))
+	for class, count := range r.errors {
+		counts = append(counts, ErrorCount{Class: class, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Class < counts[j].Class
+	})
+	return counts
+}
+
+// classifyError determines the error class of a failed transfer
+func classifyError(err error) ErrorClass {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errWorkerPanic):
+		return ErrorPanic
+	case errors.Is(err, errTransferStalled):
+		return ErrorStalled
+	case errors.Is(err, context.Canceled):
+		return ErrorCancelled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorTimeout
+	case minio.IsNotFound(err), errors.Is(err, fs.ErrNotExist):
+		return ErrorNotFound
+	case minio.IsAccessDenied(err), errors.Is(err, fs.ErrPermission):
+		return ErrorAccessDenied
+	case minio.IsThrottled(err):
+		return ErrorThrottled
+	case minio.ErrorCode(err) != "":
+		return ErrorServer
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return ErrorTimeout
+		}
+		return ErrorNetwork
+	}
+
+	if errors.Is(err, syscall.ENOSPC) {
+		return ErrorDiskFull
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return ErrorLocalIO
+	}
+	return ErrorOther
+}
@@ -0,0 +1,113 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/local"
+)
+
+// removeOrphanedParts removes the unfinished files left behind in a local
+// destination, except those of the downloads in keep
+func removeOrphanedParts(storage *local.Storage, keep []string) {
+	removed, err := storage.RemoveOrphanedParts(keep)
+	if err != nil {
+		log.Printf("Warning: Failed to clean up unfinished files: %v", err)
+	} else if removed > 0 {
+		log.Printf("Removed %d unfinished files left behind at the local destination", removed)
+	}
+}
+
+// resumesDownloads reports whether interrupted downloads of files to dest
+// are resumed, which requires the local main destination to store files as
+// they are
+func (s *Service) resumesDownloads(dest StorageBackend) bool {
+	return dest == s.dest && s.localDest != nil && s.localDest.Resumable()
+}
+
+// recordPartialDownload records how much of a file a failed save to dest
+// kept, so that the next attempt continues from there
+func (s *Service) recordPartialDownload(file *db.FileEntry, dest StorageBackend, saveErr error) {
+	written := local.PartialWritten(saveErr)
+	if written == 0 || !s.resumesDownloads(dest) {
+		return
+	}
+	err := s.database.SavePartialDownload(&db.PartialDownload{
+		ProjectName: s.projectName,
+		Path:        file.Path,
+		SourceETag:  file.ETag,
+		Size:        file.Size,
+		Written:     written,
+	})
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	log.Printf("Debug: Kept %d of %d bytes of %s to resume the download", written, file.Size, file.Path)
+}
+
+// forgetPartialDownload deletes the record of an interrupted download
+func (s *Service) forgetPartialDownload(file *db.FileEntry) {
+	if err := s.database.DeletePartialDownload(s.projectName, file.Path); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// resumeDownload continues an interrupted download of file to dest with a
+// ranged request for the missing bytes. It reports whether it handled the
+// file; if not, the file is copied in full. Downloads of another version of
+// the source object start over.
+func (s *Service) resumeDownload(ctx context.Context, file *db.FileEntry, dest StorageBackend) (bool, error) {
+	if !s.resumesDownloads(dest) {
+		return false, nil
+	}
+	download, err := s.database.GetPartialDownload(s.projectName, file.Path)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return false, nil
+	}
+	if download == nil {
+		return false, nil
+	}
+
+	kept, err := s.localDest.PartialSize(file.Path)
+	if err != nil {
+		log.Printf("Warning: Failed to check the unfinished file of %s: %v", file.Path, err)
+	}
+	offset := min(download.Written, kept)
+	if download.SourceETag != file.ETag || download.Size != file.Size || offset <= 0 || offset >= file.Size {
+		log.Printf("Debug: Interrupted download of %s can't be resumed, copying it in full", file.Path)
+		s.forgetPartialDownload(file)
+		return false, nil
+	}
+	if err := protectHeld(ctx, dest, file.Path); err != nil {
+		return true, err
+	}
+
+	reader, err := s.sourceClient.GetObjectRange(ctx, file.Path, offset, file.Size-offset)
+	if err != nil {
+		return true, fmt.Errorf("failed to get file %s: %w", file.Path, err)
+	}
+	defer reader.Close()
+
+	counted := s.toDisk(ctx, s.meter(ctx, reader))
+	if err := s.localDest.ResumeFile(ctx, file.Path, counted, offset, file.LastModified); err != nil {
+		s.recordPartialDownload(file, dest, err)
+		return true, fmt.Errorf("failed to save file %s: %w", file.Path, err)
+	}
+	s.forgetPartialDownload(file)
+
+	log.Printf("Resume: downloaded the last %d bytes of %s, reused %d bytes of the interrupted download",
+		file.Size-offset, file.Path, offset)
+
+	// The checksum covers the whole copy, so it is read once more
+	checksum, err := hashSHA256(ctx, s.dest, file.Path)
+	if err != nil {
+		log.Printf("Warning: Failed to checksum %s: %v", file.Path, err)
+		return true, nil
+	}
+	s.recordSHA256(file, checksum)
+	return true, nil
+}
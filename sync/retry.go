@@ -0,0 +1,60 @@
+package sync
+
+import (
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+// Defaults of the retry policy for failed files, see config.TuningConfig
+const (
+	defaultMaxAttempts  = 5
+	defaultRetryBackoff = time.Minute
+	// maxRetryBackoff caps the doubling pause between attempts
+	maxRetryBackoff = 24 * time.Hour
+)
+
+// retryPolicy decides when a failed file is attempted again and when it is
+// given up
+type retryPolicy struct {
+	maxAttempts int
+	backoff     time.Duration
+}
+
+func newRetryPolicy(cfg config.TuningConfig) retryPolicy {
+	p := retryPolicy{maxAttempts: cfg.MaxRetries, backoff: cfg.RetryBackoff}
+	if p.maxAttempts <= 0 {
+		p.maxAttempts = defaultMaxAttempts
+	}
+	if p.backoff <= 0 {
+		p.backoff = defaultRetryBackoff
+	}
+	return p
+}
+
+// delay returns the pause after the attempts-th failure of a file, doubled
+// with every failure after the first
+func (p retryPolicy) delay(attempts int) time.Duration {
+	delay := p.backoff
+	for i := 1; i < attempts && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryBackoff)
+}
+
+// failFile records a failed copy of file. It reports true if the file has
+// no attempts left and was set to failed.
+func (s *Service) failFile(file *db.FileEntry, copyErr error) (bool, error) {
+	attempts := file.Attempts + 1
+	status := db.StatusError
+	if attempts >= s.retries.maxAttempts {
+		status = db.StatusFailed
+	}
+	retryAt := time.Now().Add(s.retries.delay(attempts))
+
+	err := s.writer.write(func(b *db.Batch) error {
+		return b.RecordFailure(file.ID, status, copyErr.Error(), attempts, retryAt)
+	})
+	return status == db.StatusFailed, err
+}
@@ -0,0 +1,405 @@
+package sync
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+const (
+	// serveMaxKeys is the default and largest page of a listing
+	serveMaxKeys = 1000
+	// serveTimeFormat is how S3 listings format times
+	serveTimeFormat = "2006-01-02T15:04:05.000Z"
+	s3Namespace     = "http://s3.amazonaws.com/doc/2006-03-01/"
+)
+
+// ServeLocal serves the files copied to the local destination over a
+// read-only subset of the S3 API until ctx is cancelled, so restores can be
+// tested without touching the source. The project database is the index:
+// only files it records as present at the destination are listed and
+// served, under the key and bucket name they have in the source. Requests
+// are not authenticated, any credentials are accepted.
+func (s *Service) ServeLocal(ctx context.Context, addr string) error {
+	if s.localDest == nil {
+		return fmt.Errorf("serve-local needs a local destination, not %s", s.destType)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	server := &http.Server{Handler: &localServer{service: s, bucket: s.sourceClient.GetBucketName()}}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("Serving the local copy of bucket %s read-only on http://%s", s.sourceClient.GetBucketName(), listener.Addr())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve local copy: %w", err)
+	}
+	return nil
+}
+
+// localServer answers S3 requests for one bucket, addressed path-style
+type localServer struct {
+	service *Service
+	bucket  string
+}
+
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Buckets []struct {
+		Name         string `xml:"Name"`
+		CreationDate string `xml:"CreationDate"`
+	} `xml:"Buckets>Bucket"`
+}
+
+type listBucketContent struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name            `xml:"ListBucketResult"`
+	Xmlns                 string              `xml:"xmlns,attr"`
+	Name                  string              `xml:"Name"`
+	Prefix                string              `xml:"Prefix"`
+	Delimiter             string              `xml:"Delimiter,omitempty"`
+	EncodingType          string              `xml:"EncodingType,omitempty"`
+	MaxKeys               int                 `xml:"MaxKeys"`
+	IsTruncated           bool                `xml:"IsTruncated"`
+	Marker                *string             `xml:"Marker"`
+	NextMarker            string              `xml:"NextMarker,omitempty"`
+	KeyCount              *int                `xml:"KeyCount"`
+	StartAfter            string              `xml:"StartAfter,omitempty"`
+	ContinuationToken     string              `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string              `xml:"NextContinuationToken,omitempty"`
+	Contents              []listBucketContent `xml:"Contents"`
+	CommonPrefixes        []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+func (l *localServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Debug: %s %s", r.Method, r.URL.RequestURI())
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		l.fail(w, r, http.StatusForbidden, "AccessDenied", "The local copy is served read-only")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case bucket == "":
+		l.listBuckets(w)
+	case bucket != l.bucket:
+		l.fail(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+	case key != "":
+		l.getObject(w, r, key)
+	case r.URL.Query().Has("location"):
+		l.reply(w, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+			Xmlns   string   `xml:"xmlns,attr"`
+		}{Xmlns: s3Namespace})
+	case len(r.URL.Query()) > 0 && !isListQuery(r.URL.Query()):
+		l.fail(w, r, http.StatusNotImplemented, "NotImplemented", "Only listing and reading objects is supported")
+	case r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	default:
+		l.listObjects(w, r)
+	}
+}
+
+// isListQuery reports whether a bucket request only has listing parameters
+func isListQuery(query url.Values) bool {
+	for name := range query {
+		switch name {
+		case "list-type", "prefix", "delimiter", "max-keys", "marker", "start-after",
+			"continuation-token", "encoding-type", "fetch-owner", "metadata":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (l *localServer) reply(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Warning: Failed to send response: %v", err)
+	}
+}
+
+func (l *localServer) fail(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(s3Error{Code: code, Message: message, Resource: r.URL.Path})
+}
+
+func (l *localServer) listBuckets(w http.ResponseWriter) {
+	result := listAllMyBucketsResult{Xmlns: s3Namespace}
+	result.Buckets = append(result.Buckets, struct {
+		Name         string `xml:"Name"`
+		CreationDate string `xml:"CreationDate"`
+	}{Name: l.bucket, CreationDate: time.Unix(0, 0).UTC().Format(serveTimeFormat)})
+	l.reply(w, result)
+}
+
+// listObjects answers ListObjects and ListObjectsV2 from the database
+func (l *localServer) listObjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	v2 := query.Get("list-type") == "2"
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	maxKeys := serveMaxKeys
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			l.fail(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid max-keys")
+			return
+		}
+		maxKeys = min(n, serveMaxKeys)
+	}
+
+	result := listBucketResult{
+		Xmlns:     s3Namespace,
+		Name:      l.bucket,
+		Prefix:    prefix,
+		Delimiter: delimiter,
+		MaxKeys:   maxKeys,
+	}
+	start := query.Get("marker")
+	if v2 {
+		result.StartAfter = query.Get("start-after")
+		result.ContinuationToken = query.Get("continuation-token")
+		start = result.StartAfter
+		if result.ContinuationToken != "" {
+			token, err := base64.URLEncoding.DecodeString(result.ContinuationToken)
+			if err != nil {
+				l.fail(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid continuation token")
+				return
+			}
+			start = string(token)
+		}
+	} else {
+		marker := start
+		result.Marker = &marker
+	}
+
+	// Keys below a common prefix are skipped by resuming after the largest
+	// key the prefix can have
+	last, count := start, 0
+	for count < maxKeys {
+		files, err := l.service.database.ListCopiedFiles(l.service.projectName, prefix, start, maxKeys-count)
+		if err != nil {
+			l.fail(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		for _, file := range files {
+			start = file.Path
+			if delimiter != "" {
+				if i := strings.Index(file.Path[len(prefix):], delimiter); i >= 0 {
+					common := file.Path[:len(prefix)+i+len(delimiter)]
+					result.CommonPrefixes = append(result.CommonPrefixes, struct {
+						Prefix string `xml:"Prefix"`
+					}{Prefix: common})
+					last, start = common, common+string(utf8.MaxRune)
+					count++
+					break
+				}
+			}
+			result.Contents = append(result.Contents, listBucketContent{
+				Key:          file.Path,
+				LastModified: file.LastModified.UTC().Format(serveTimeFormat),
+				ETag:         `"` + file.ETag + `"`,
+				Size:         file.Size,
+				StorageClass: "STANDARD",
+			})
+			last = file.Path
+			count++
+		}
+		if len(files) == 0 {
+			break
+		}
+	}
+	if count >= maxKeys {
+		more, err := l.service.database.ListCopiedFiles(l.service.projectName, prefix, start, 1)
+		if err != nil {
+			l.fail(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		result.IsTruncated = len(more) > 0
+	}
+
+	if result.IsTruncated {
+		if v2 {
+			result.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(start))
+		} else if delimiter != "" {
+			result.NextMarker = last
+		}
+	}
+	if v2 {
+		result.KeyCount = &count
+	}
+	if query.Get("encoding-type") == "url" {
+		encodeListing(&result)
+	}
+	l.reply(w, result)
+}
+
+// encodeListing URL-encodes the keys of a listing, as clients asking for
+// encoding-type=url decode them
+func encodeListing(result *listBucketResult) {
+	result.EncodingType = "url"
+	result.Prefix = url.QueryEscape(result.Prefix)
+	result.Delimiter = url.QueryEscape(result.Delimiter)
+	result.StartAfter = url.QueryEscape(result.StartAfter)
+	if result.Marker != nil {
+		marker := url.QueryEscape(*result.Marker)
+		result.Marker = &marker
+	}
+	result.NextMarker = url.QueryEscape(result.NextMarker)
+	for i := range result.Contents {
+		result.Contents[i].Key = url.QueryEscape(result.Contents[i].Key)
+	}
+	for i := range result.CommonPrefixes {
+		result.CommonPrefixes[i].Prefix = url.QueryEscape(result.CommonPrefixes[i].Prefix)
+	}
+}
+
+// getObject answers GetObject and HeadObject, with a single byte range if
+// one is requested
+func (l *localServer) getObject(w http.ResponseWriter, r *http.Request, key string) {
+	file, err := l.service.database.GetFileByPath(l.service.projectName, key)
+	if err != nil {
+		l.fail(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	if file == nil || (file.Status != db.StatusCompleted && file.Status != db.StatusSkippedExisting) {
+		l.fail(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return
+	}
+	info, err := l.service.dest.Stat(r.Context(), key)
+	if err != nil && !minio.IsNotFound(err) {
+		l.fail(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	if info == nil {
+		log.Printf("Warning: %s is recorded as copied but missing from the local destination", key)
+		l.fail(w, r, http.StatusNotFound, "NoSuchKey", "The specified key is missing from the local copy")
+		return
+	}
+
+	size := info.Size
+	offset, length := int64(0), size
+	status := http.StatusOK
+	if header := r.Header.Get("Range"); header != "" {
+		var ok bool
+		if offset, length, ok = parseRange(header, size); !ok {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			l.fail(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+		status = http.StatusPartialContent
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", `"`+file.ETag+`"`)
+	w.Header().Set("Last-Modified", file.LastModified.UTC().Format(http.TimeFormat))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+
+	reader, err := l.service.dest.Get(r.Context(), key)
+	if err != nil {
+		l.fail(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	defer reader.Close()
+	if offset > 0 {
+		// Encrypted and compressed files can't seek and are read up to the range
+		if seeker, ok := reader.(io.Seeker); ok {
+			_, err = seeker.Seek(offset, io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, reader, offset)
+		}
+		if err != nil {
+			l.fail(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+	}
+
+	w.WriteHeader(status)
+	if _, err := io.CopyN(w, reader, length); err != nil {
+		log.Printf("Warning: Failed to send %s: %v", key, err)
+	}
+}
+
+// parseRange returns the offset and length of a single byte range of an
+// object of the given size
+func parseRange(header string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		// The last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		n = min(n, size)
+		return size - n, n, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end - start + 1, true
+}
@@ -0,0 +1,36 @@
+package sync
+
+import (
+	"log"
+	"strings"
+
+	"github.com/chmdznr/minio-simple-copier/v2/config"
+)
+
+// useServerSideCopy decides whether files are copied to the main destination
+// on the server. That needs a MinIO destination on the same server as the
+// source, reached with the same credentials so that the destination client
+// can read the source bucket; the override of the project config wins.
+func useServerSideCopy(cfg *config.ProjectConfig) bool {
+	if cfg.DestType != config.DestinationMinio && cfg.DestType != config.DestinationS3 {
+		if cfg.ServerSideCopy != nil && *cfg.ServerSideCopy {
+			log.Printf("Warning: Server-side copy needs a MinIO or S3 destination, not %s", cfg.DestType)
+		}
+		return false
+	}
+	if cfg.ServerSideCopy != nil {
+		return *cfg.ServerSideCopy
+	}
+	if cfg.DestType != config.DestinationMinio {
+		return false
+	}
+	return sameServer(&cfg.SourceMinio, &cfg.DestMinio)
+}
+
+// sameServer reports whether two configs reach the same server with the same
+// credentials
+func sameServer(a, b *config.MinioConfig) bool {
+	return strings.EqualFold(strings.TrimSuffix(a.Endpoint, "/"), strings.TrimSuffix(b.Endpoint, "/")) &&
+		a.UseSSL == b.UseSSL &&
+		a.AccessKeyID == b.AccessKeyID
+}
@@ -1,40 +1,112 @@
 package sync
 
 import (
-	"bufio"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"os"
-	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chmdznr/minio-simple-copier/v2/config"
 	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/gcs"
 	"github.com/chmdznr/minio-simple-copier/v2/local"
 	"github.com/chmdznr/minio-simple-copier/v2/minio"
+	"github.com/chmdznr/minio-simple-copier/v2/notify"
+	"github.com/chmdznr/minio-simple-copier/v2/s3"
+	"golang.org/x/sync/errgroup"
 )
 
-type MCListEntry struct {
-	Status       string    `json:"status"`
-	Type         string    `json:"type"`
-	LastModified time.Time `json:"lastModified"`
-	Size         int64     `json:"size"`
-	Key          string    `json:"key"`
-	ETag         string    `json:"etag"`
-}
-
 // Service handles file synchronization
 type Service struct {
-	projectName  string
+	projectName string
+	// source and dest are the storages files are copied between. The
+	// clients behind them are kept for features only they support; destClient
+	// is nil for local destinations and localDest for object stores.
+	source       StorageBackend
+	dest         StorageBackend
 	sourceClient *minio.MinioClient
 	destType     config.DestinationType
 	destClient   *minio.MinioClient
 	localDest    *local.Storage
-	database     *db.Database
+	// packer packs small files at a local destination, nil if they are
+	// stored one by one
+	packer           *packer
+	database         *db.Database
+	listingCachePath string
+	// writer serializes the status updates of the sync workers, nil for
+	// services that only read the database
+	writer *statusWriter
+	// runInfoPath locates the running sync of the project, see serveProgress
+	runInfoPath string
+	// controlPath is the socket of the daemon of the project, see
+	// serveControl; control is only set while running as a daemon
+	controlPath string
+	control     *daemonControl
+	notifier    *notify.Notifier
+	alerts      alertThresholds
+	extraDests  []*extraDestination
+	filter      *objectFilter
+	allowlist   *allowlist
+	erasureFeed *erasureFeed
+	ordering    orderingRules
+	prefixes    prefixBudgets
+	atomic      atomicCommit
+	compression compressionRules
+	multipart   multipartRules
+	chunks      chunkedDownloads
+	leases      leases
+	retries     retryPolicy
+	conflict    config.ConflictPolicy
+	overwrite   config.OverwritePolicy
+	changes     changePolicy
+	budget      *endpointBudget
+	limiter     *rateLimiter
+	diskLimiter *rateLimiter
+	pacer       *adaptivePacer
+	storm       *stormGuard
+	// sourceLatency and destLatency collect request latencies for the
+	// endpoint profiles, see recordProfiles
+	sourceLatency *latencySamples
+	destLatency   *latencySamples
+	profiles      []db.EndpointProfile
+	restore       restorePolicy
+	destStats     *statCache
+
+	// serverSideCopy copies files to the main destination on the server,
+	// see useServerSideCopy
+	serverSideCopy bool
+
+	// protectNewer keeps destination objects modified after the source
+	protectNewer bool
+	// skipReplicated leaves source objects out that the bucket replication
+	// of the source has copied
+	skipReplicated bool
+	// runMarker publishes the statistics of successful syncs, nil if not
+	runMarker *runMarker
+	// preserveMetadata copies the metadata and tags of source objects to
+	// object storage destinations
+	preserveMetadata bool
+	// addMetadata adds configured metadata and tags to copied objects
+	addMetadata metadataRules
+	// runID identifies the current sync, see newRunID
+	runID string
+	// clockSkew is the tolerance of modification time comparisons between
+	// the source and the destination, see compareModified
+	clockSkew time.Duration
+	// checksums compares local copies with the source by their SHA256
+	// checksums, see sameChecksum
+	checksums bool
+
+	// transferred counts the bytes read from the source
+	transferred atomic.Int64
 }
 
 // NewService creates a new sync service
@@ -44,10 +116,15 @@ func NewService(cfg *config.ProjectConfig) (*Service, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create source client: %w", err)
 	}
+	if !cfg.SourceMinio.ETagTrusted() {
+		log.Printf("Not trusting the ETags of the source, changes are detected by size and modification time")
+		sourceClient.DistrustETags(true)
+	}
 
 	// Create destination client based on type
 	var destClient *minio.MinioClient
 	var localDest *local.Storage
+	trustDestETag := true
 
 	switch cfg.DestType {
 	case config.DestinationMinio:
@@ -55,15 +132,206 @@ func NewService(cfg *config.ProjectConfig) (*Service, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to create destination client: %w", err)
 		}
+		trustDestETag = cfg.DestMinio.ETagTrusted()
+	case config.DestinationS3:
+		destClient, err = s3.NewClient(&cfg.DestS3)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create destination client: %w", err)
+		}
+		trustDestETag = cfg.DestS3.ETagTrusted()
+	case config.DestinationGCS:
+		destClient, err = gcs.NewClient(&cfg.DestGCS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create destination client: %w", err)
+		}
+		trustDestETag = cfg.DestGCS.ETagTrusted()
 	case config.DestinationLocal:
 		localDest, err = local.NewStorage(&cfg.DestLocal, cfg.SourceMinio.FolderPath)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create local storage: %w", err)
 		}
 	}
+	if !trustDestETag {
+		log.Printf("Not trusting the ETags of the destination, copies are compared by size and modification time")
+		destClient.DistrustETags(false)
+	}
+
+	serverSideCopy := useServerSideCopy(cfg)
+	if serverSideCopy {
+		log.Printf("Copying files to the destination on the server, without downloading them")
+	}
+
+	// Create additional destinations
+	var extraDests []*extraDestination
+	names := make(map[string]bool)
+	for _, destCfg := range cfg.Destinations {
+		dest, err := newExtraDestination(destCfg, cfg.SourceMinio.FolderPath)
+		if err != nil {
+			return nil, err
+		}
+		if names[dest.name] {
+			return nil, fmt.Errorf("duplicate destination name %s", dest.name)
+		}
+		names[dest.name] = true
+		extraDests = append(extraDests, dest)
+	}
+
+	// Objects under legal hold are protected at every destination
+	hold, err := newLegalHold(cfg.LegalHold)
+	if err != nil {
+		return nil, err
+	}
+	for _, dest := range extraDests {
+		dest.backend = hold.wrap(dest.backend, dest.client)
+	}
+
+	// Every MinIO and S3 client retries failing requests alike
+	clients := []*minio.MinioClient{sourceClient, destClient}
+	for _, dest := range extraDests {
+		clients = append(clients, dest.client)
+	}
+	for _, client := range clients {
+		if client != nil {
+			client.SetRetryPolicy(cfg.Tuning.Retries, cfg.Tuning.RetryInterval)
+		}
+	}
+
+	budget, err := newEndpointBudget(cfg, clients)
+	if err != nil {
+		return nil, err
+	}
+
+	bandwidthLimit, err := config.ParseRate(cfg.Tuning.BandwidthLimit)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bandwidth limit: %w", err)
+	}
+	if bandwidthLimit > 0 {
+		log.Printf("Reads from the source are limited to %d bytes per second", bandwidthLimit)
+	}
+	diskWriteLimit, err := config.ParseRate(cfg.Tuning.DiskWriteLimit)
+	if err != nil {
+		return nil, fmt.Errorf("invalid disk write limit: %w", err)
+	}
+	if diskWriteLimit > 0 {
+		log.Printf("Writes to local destinations are limited to %d bytes per second", diskWriteLimit)
+	}
+
+	if cfg.PreserveMetadata && destClient == nil {
+		log.Printf("Warning: The %s destination doesn't keep object metadata, it is only preserved at additional object storage destinations", cfg.DestType)
+	}
+
+	// Only requests to object storage destinations are measured
+	var pacer *adaptivePacer
+	if cfg.Tuning.PaceLatency > 0 {
+		if destClient == nil {
+			log.Printf("Warning: Adaptive pacing needs an object storage destination, not %s", cfg.DestType)
+		} else {
+			pacer = newAdaptivePacer(cfg.Tuning.PaceLatency)
+		}
+	}
+
+	// Request latencies feed the endpoint profiles and the pacer
+	sourceLatency, destLatency := &latencySamples{}, &latencySamples{}
+	sourceClient.ObserveLatency(sourceLatency.add)
+	if destClient != nil {
+		destClient.ObserveLatency(func(latency time.Duration) {
+			destLatency.add(latency)
+			pacer.observe(latency)
+		})
+	}
+
+	filter, err := newObjectFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	allowlist, err := newAllowlist(cfg)
+	if err != nil {
+		return nil, err
+	}
+	erasureFeed, err := newErasureFeed(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes, err := newPrefixBudgets(cfg.Prefixes)
+	if err != nil {
+		return nil, err
+	}
+
+	ordering, err := newOrderingRules(cfg.Ordering)
+	if err != nil {
+		return nil, err
+	}
+
+	addMetadata, err := newMetadataRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	compression, err := newCompressionRules(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := newChangePolicy(cfg.OnChange)
+	if err != nil {
+		return nil, err
+	}
+
+	multipart, err := newMultipartRules(cfg.Multipart)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := newChunkedDownloads(cfg.Tuning)
+	if err != nil {
+		return nil, err
+	}
+	// Chunks set on the command line or as tuning default also split the
+	// multipart uploads to object storage destinations
+	if cfg.Tuning.ChunkSize != "" {
+		multipart.partSize = max(chunks.size, minPartSize)
+	}
+	if cfg.Tuning.ChunkConcurrency > 0 {
+		multipart.streams = chunks.concurrency
+	}
+
+	conflict, err := newConflictPolicy(cfg.Conflict)
+	if err != nil {
+		return nil, err
+	}
+
+	overwrite, err := newOverwritePolicy(cfg.Tuning.Overwrite)
+	if err != nil {
+		return nil, err
+	}
+
+	restore, err := newRestorePolicy(cfg.Restore)
+	if err != nil {
+		return nil, err
+	}
+
+	marker, err := newRunMarker(cfg.RunMarker, cfg.DestType)
+	if err != nil {
+		return nil, err
+	}
+
+	minThroughput, err := config.ParseSize(cfg.Alerts.MinThroughput)
+	if err != nil {
+		return nil, fmt.Errorf("invalid minimum throughput: %w", err)
+	}
+	alerts := alertThresholds{
+		minThroughput:  minThroughput,
+		maxErrorRate:   cfg.Alerts.MaxErrorRate,
+		breachDuration: cfg.Alerts.BreachDuration,
+		abortOnBreach:  cfg.Alerts.AbortOnBreach,
+		maxPendingAge:  cfg.Alerts.MaxPendingAge,
+	}
+	if alerts.breachDuration <= 0 {
+		alerts.breachDuration = defaultBreachDuration
+	}
 
 	// Create database
-	database, err := db.NewDatabase(cfg.DatabasePath)
+	database, err := db.OpenDatabase(cfg.StateBackend.Driver, cfg.StateBackend.DSN, cfg.DatabasePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create database: %w", err)
 	}
@@ -72,84 +340,305 @@ func NewService(cfg *config.ProjectConfig) (*Service, error) {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 
+	// Unfinished files of recorded partial downloads are resumed, the
+	// others were left behind by crashes
+	if localDest != nil {
+		partial, err := database.GetPartialDownloadPaths(cfg.ProjectName)
+		if err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		removeOrphanedParts(localDest, partial)
+	}
+
+	// Past runs suggest a part size unless one is configured
+	profiles, err := database.GetEndpointProfiles(cfg.ProjectName)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	if cfg.Multipart.PartSize == "" && cfg.Tuning.ChunkSize == "" && destClient != nil {
+		if partSize := profiledPartSize(profiles, destClient.GetEndpoint(), multipart.partSize); partSize != multipart.partSize {
+			log.Printf("Debug: Using parts of %d bytes, judging by past runs to %s", partSize, destClient.GetEndpoint())
+			multipart.partSize = partSize
+		}
+	}
+
+	// Small files can be packed into archives at a local destination
+	dest := newBackend(destClient, localDest)
+	var packs *packer
+	if localDest != nil {
+		if packs, err = newPacker(cfg.DestLocal.Pack, localDest, database, cfg.ProjectName); err != nil {
+			return nil, err
+		}
+		if packs != nil {
+			dest = &packedBackend{localBackend: dest.(*localBackend), packer: packs}
+		}
+	}
+	dest = hold.wrap(dest, destClient)
+
+	return &Service{
+		projectName:      cfg.ProjectName,
+		source:           newBackend(sourceClient, nil),
+		dest:             dest,
+		sourceClient:     sourceClient,
+		destType:         cfg.DestType,
+		destClient:       destClient,
+		localDest:        localDest,
+		packer:           packs,
+		database:         database,
+		writer:           newStatusWriter(database),
+		listingCachePath: cfg.ListingCachePath,
+		runInfoPath:      filepath.Join(filepath.Dir(cfg.DatabasePath), runInfoFile),
+		controlPath:      filepath.Join(filepath.Dir(cfg.DatabasePath), controlSocketFile),
+		notifier:         notify.NewNotifier(cfg.ProjectName, cfg.Alerts.WebhookURL),
+		alerts:           alerts,
+		filter:           filter,
+		allowlist:        allowlist,
+		erasureFeed:      erasureFeed,
+		extraDests:       extraDests,
+		ordering:         ordering,
+		prefixes:         prefixes,
+		atomic:           newAtomicCommit(cfg.AtomicCommit, cfg.SourceMinio.FolderPath),
+		compression:      compression,
+		multipart:        multipart,
+		chunks:           chunks,
+		leases:           newLeases(cfg.Distributed),
+		retries:          newRetryPolicy(cfg.Tuning),
+		conflict:         conflict,
+		overwrite:        overwrite,
+		serverSideCopy:   serverSideCopy,
+		protectNewer:     cfg.ProtectNewer,
+		skipReplicated:   cfg.ReplicationStatus,
+		runMarker:        marker,
+		clockSkew:        cfg.ClockSkew,
+		checksums:        cfg.Tuning.Checksum,
+		changes:          changes,
+		budget:           budget,
+		limiter:          newRateLimiter(bandwidthLimit),
+		diskLimiter:      newRateLimiter(diskWriteLimit),
+		pacer:            pacer,
+		storm:            newStormGuard(),
+		sourceLatency:    sourceLatency,
+		destLatency:      destLatency,
+		profiles:         profiles,
+		preserveMetadata: cfg.PreserveMetadata,
+		addMetadata:      addMetadata,
+		runID:            newRunID(),
+		restore:          restore,
+		destStats:        newStatCache(statCacheSize),
+	}, nil
+}
+
+// NewStatusService creates a service that only reads the project database,
+// e.g. for the status command, and can run alongside a sync
+func NewStatusService(cfg *config.ProjectConfig) (*Service, error) {
+	database, err := db.OpenReadOnlyDatabase(cfg.StateBackend.Driver, cfg.StateBackend.DSN, cfg.DatabasePath)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Service{
-		projectName:  cfg.ProjectName,
-		sourceClient: sourceClient,
-		destType:     cfg.DestType,
-		destClient:   destClient,
-		localDest:    localDest,
-		database:     database,
+		projectName: cfg.ProjectName,
+		database:    database,
+		runInfoPath: filepath.Join(filepath.Dir(cfg.DatabasePath), runInfoFile),
+		controlPath: filepath.Join(filepath.Dir(cfg.DatabasePath), controlSocketFile),
 	}, nil
 }
 
 func (s *Service) Close() error {
+	s.packer.close()
+	if err := s.budget.close(); err != nil {
+		log.Printf("Warning: Failed to close endpoint slots: %v", err)
+	}
+	if s.writer != nil {
+		s.writer.close()
+	}
 	if s.database != nil {
 		return s.database.Close()
 	}
 	return nil
 }
 
-func (s *Service) UpdateSourceList(ctx context.Context) error {
-	log.Printf("Updating source file list...")
+// ListOptions controls which source objects update-list records
+type ListOptions struct {
+	// Depth limits how many folder levels below the source folder are listed.
+	// Zero lists everything recursively; 1 lists only the immediate objects.
+	Depth int
+	// SampleRate keeps only a deterministic fraction (0-1] of the keys, chosen
+	// by hash so a larger rate always includes the keys of a smaller one.
+	// Zero disables sampling.
+	SampleRate float64
+	// CacheMaxAge reads the listing from the bucket listing cache shared
+	// between projects when it is younger than this, refreshing it with a
+	// full bucket listing otherwise. Zero lists the source directly.
+	CacheMaxAge time.Duration
+	// Listers is the number of concurrent listings of a recursive listing
+	Listers int
+	// ShardDepth and ShardDelimiter split a concurrent listing into the
+	// prefixes ShardDepth levels below the source folder
+	ShardDepth     int
+	ShardDelimiter string
+	// BatchSize is the number of listed files written per transaction
+	BatchSize int
+	// FlushInterval writes a partial batch once it is this old
+	FlushInterval time.Duration
+	// ModifiedAfter and ModifiedBefore limit the listing to objects last
+	// modified within [ModifiedAfter, ModifiedBefore), e.g. to migrate in
+	// stages by date. Objects outside the window aren't recorded, and their
+	// existing entries are left as they are. Zero times are open bounds.
+	ModifiedAfter, ModifiedBefore time.Time
+}
+
+// withDefaults fills in the defaults of the listing pipeline
+func (o ListOptions) withDefaults() ListOptions {
+	if o.Listers <= 0 {
+		o.Listers = 1
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 500
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = time.Second
+	}
+	return o
+}
+
+// ParseSampleRate parses a sample rate given as a percentage ("1%") or a
+// fraction ("0.01")
+func ParseSampleRate(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	divisor := 1.0
+	if strings.HasSuffix(value, "%") {
+		value = strings.TrimSuffix(value, "%")
+		divisor = 100
+	}
 
-	// List objects in source bucket
-	objects, err := s.sourceClient.ListObjects(ctx)
+	rate, err := strconv.ParseFloat(value, 64)
 	if err != nil {
-		return fmt.Errorf("failed to list objects: %w", err)
+		return 0, fmt.Errorf("invalid sample rate %q: %w", value, err)
+	}
+	rate /= divisor
+	if rate <= 0 || rate > 1 {
+		return 0, fmt.Errorf("sample rate must be between 0 and 100%%")
 	}
+	return rate, nil
+}
 
-	log.Printf("Found %d files in source bucket", len(objects))
+// sourceListOptions returns the options of a direct listing of the source
+func (o ListOptions) sourceListOptions(withMetadata bool) minio.ListOptions {
+	return minio.ListOptions{
+		Depth:        o.Depth,
+		Listers:      o.Listers,
+		ShardDepth:   o.ShardDepth,
+		Delimiter:    o.ShardDelimiter,
+		WithMetadata: withMetadata,
+	}
+}
 
-	// Add each file to database
-	var added, skipped int
-	for _, obj := range objects {
-		// Skip if file already exists in database
-		exists, err := s.database.GetFileByPath(s.projectName, obj.Key)
-		if err != nil {
-			log.Printf("Warning: Failed to check file existence: %v", err)
-			continue
-		}
+// ParseListTime parses a bound of the listing time window, a date like
+// 2024-01-31 in UTC or an RFC 3339 time
+func ParseListTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected a date like 2024-01-31 or an RFC 3339 time", value)
+	}
+	return t.UTC(), nil
+}
 
-		if exists != nil {
-			// If file exists but ETag is different, update it
-			if exists.ETag != obj.ETag {
-				log.Printf("Debug: Updating file %s (ETag changed: %s -> %s)", obj.Key, exists.ETag, obj.ETag)
-				exists.Size = obj.Size
-				exists.ETag = obj.ETag
-				exists.LastModified = obj.LastModified
-				exists.Status = db.StatusPending
-				exists.ErrorMessage = ""
-				if err := s.database.UpdateFileStatus(exists.ID, exists.Status, exists.ErrorMessage); err != nil {
-					log.Printf("Warning: Failed to update file status: %v", err)
-				}
-				added++
-			} else {
-				log.Printf("Debug: Skipping file %s (already exists with same ETag)", obj.Key)
-				skipped++
-			}
-			continue
-		}
+// inWindow reports whether an object modified at modified falls within the
+// time window
+func (o ListOptions) inWindow(modified time.Time) bool {
+	if !o.ModifiedAfter.IsZero() && modified.Before(o.ModifiedAfter) {
+		return false
+	}
+	return o.ModifiedBefore.IsZero() || modified.Before(o.ModifiedBefore)
+}
 
-		// Add new file to database
-		entry := &db.FileEntry{
-			ProjectName:  s.projectName,
-			Path:         obj.Key,
-			Size:         obj.Size,
-			ETag:         obj.ETag,
-			LastModified: obj.LastModified,
-			Status:       db.StatusPending,
-		}
+// window describes the time window of the listing
+func (o ListOptions) window() string {
+	switch {
+	case o.ModifiedBefore.IsZero():
+		return "since " + o.ModifiedAfter.Format(time.RFC3339)
+	case o.ModifiedAfter.IsZero():
+		return "before " + o.ModifiedBefore.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("from %s until before %s", o.ModifiedAfter.Format(time.RFC3339), o.ModifiedBefore.Format(time.RFC3339))
+}
 
-		if err := s.database.InsertFileEntry(entry); err != nil {
-			log.Printf("Warning: Failed to insert file entry: %v", err)
-			continue
-		}
+// inSample reports whether key falls within the sample
+func (o ListOptions) inSample(key string) bool {
+	if o.SampleRate <= 0 || o.SampleRate >= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return float64(h.Sum64()%1000000) < o.SampleRate*1000000
+}
+
+func (s *Service) UpdateSourceList(ctx context.Context, opts ListOptions) error {
+	log.Printf("Updating source file list...")
+	startedAt := time.Now()
 
-		log.Printf("Debug: Added file to database: %s (size: %d, etag: %s)", obj.Key, obj.Size, obj.ETag)
-		added++
+	opts = opts.withDefaults()
+	if opts.SampleRate > 0 {
+		log.Printf("Sampling %.4g%% of source keys", opts.SampleRate*100)
+	}
+	if !opts.ModifiedAfter.IsZero() || !opts.ModifiedBefore.IsZero() {
+		log.Printf("Only listing objects modified %s", opts.window())
 	}
 
+	// Listers only queue objects, the listing writer records them in batches
+	writer := s.newListingWriter(ctx, opts)
+	enqueue := func(obj minio.ObjectInfo) error {
+		return writer.add(ctx, obj)
+	}
+	var listErr error
+	if opts.CacheMaxAge > 0 {
+		listErr = s.listFromCache(ctx, opts, enqueue)
+	} else {
+		// The listing API can't filter by time, objects outside the window
+		// are dropped before they are queued
+		listErr = s.sourceClient.ListObjects(ctx, opts.sourceListOptions(s.skipReplicated), func(obj minio.ObjectInfo) error {
+			if !opts.inWindow(obj.LastModified) {
+				return nil
+			}
+			return enqueue(obj)
+		})
+	}
+	tally := writer.close()
+	writer.logStats()
+
+	found, foundSize := tally.found, tally.foundSize
+	added, skipped, sampledOut := tally.added, tally.skipped, tally.sampledOut
+
+	log.Printf("Found %d files in source bucket", found)
 	log.Printf("Summary: Added/Updated %d files, Skipped %d files", added, skipped)
+	if sampledOut > 0 {
+		log.Printf("Summary: %d files left out of the sample (status %s)", sampledOut, db.StatusSkippedFiltered)
+	}
+	if tally.excluded > 0 {
+		log.Printf("Summary: %d files left out by the filters (status %s)", tally.excluded, db.StatusSkippedFiltered)
+	}
+	if tally.replicated > 0 {
+		log.Printf("Summary: %d files left to the bucket replication of the source (status %s)", tally.replicated, db.StatusSkippedFiltered)
+	}
+
+	if unlisted := minio.UnlistedRanges(listErr); len(unlisted) > 0 {
+		log.Printf("Warning: Listing incomplete, %d files were recorded before the failure", added+skipped)
+		for _, r := range unlisted {
+			log.Printf("Warning: Unlisted range: %v", r)
+		}
+	}
 
 	// Print status distribution
 	counts, err := s.database.GetStatusCounts(s.projectName)
@@ -162,221 +651,1002 @@ func (s *Service) UpdateSourceList(ctx context.Context) error {
 		}
 	}
 
+	run := &db.ListingRun{
+		ProjectName: s.projectName,
+		Source:      "update-list",
+		ObjectCount: int64(found),
+		TotalSize:   foundSize,
+		Complete:    listErr == nil,
+		StartedAt:   startedAt,
+		FinishedAt:  time.Now(),
+	}
+	if err := s.database.InsertListingRun(run); err != nil {
+		log.Printf("Warning: Failed to record listing run: %v", err)
+	}
+	s.recordStats("update-list", nil)
+
+	if listErr != nil {
+		return fmt.Errorf("failed to list objects: %w", listErr)
+	}
+
+	s.logAccounting()
 	return nil
 }
 
-func (s *Service) StartSync(ctx context.Context, workers int) error {
-	log.Printf("Starting sync with %d workers...", workers)
+// listCounts tallies the outcome of update-list
+type listCounts struct {
+	found, added, skipped, sampledOut, excluded, replicated int
+	foundSize                                               int64
+}
+
+// recordBatch records listed objects in a single transaction, together with
+// the import checkpoint if one is given. The counts are only updated once the
+// transaction is committed.
+func (s *Service) recordBatch(ctx context.Context, objects []minio.ObjectInfo, opts ListOptions, counts *listCounts, checkpoint *db.ImportCheckpoint) error {
+	s.statReplication(ctx, objects)
+	paths := make([]string, len(objects))
+	for i, obj := range objects {
+		paths[i] = obj.Key
+	}
+	existing, err := s.database.GetFilesByPaths(s.projectName, paths)
+	if err != nil {
+		return err
+	}
+	// Files without an answer are recorded, sync holds them back
+	if err := s.allowlist.lookup(ctx, paths); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	erased, err := s.database.GetTombstonedPaths(s.projectName, paths)
+	if err != nil {
+		return err
+	}
+
+	batch, err := s.database.BeginBatch()
+	if err != nil {
+		return err
+	}
+	var batchCounts listCounts
+	for _, obj := range objects {
+		s.recordObject(ctx, batch, existing[obj.Key], obj, opts, erased[obj.Key], &batchCounts)
+	}
+	if checkpoint != nil {
+		if err := batch.SaveImportCheckpoint(checkpoint); err != nil {
+			batch.Rollback()
+			return err
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		batch.Rollback()
+		return err
+	}
+
+	counts.added += batchCounts.added
+	counts.skipped += batchCounts.skipped
+	counts.sampledOut += batchCounts.sampledOut
+	counts.excluded += batchCounts.excluded
+	counts.replicated += batchCounts.replicated
+	return nil
+}
+
+// recordObject adds a listed object to the batch, or updates its existing
+// entry when it changed or joined the sample. Erased objects are never
+// copied again.
+func (s *Service) recordObject(ctx context.Context, batch *db.Batch, exists *db.FileEntry, obj minio.ObjectInfo, opts ListOptions, erased bool, counts *listCounts) {
+	inSample := opts.inSample(obj.Key)
+	excluded := s.filter.reason(obj.Key, obj.Size, obj.LastModified)
+	leftOut := &counts.excluded
+	if excluded == "" {
+		if excluded = s.replicationReason(obj); excluded != "" {
+			leftOut = &counts.replicated
+		}
+	}
+	if excluded == "" {
+		excluded, _ = s.allowlist.reason(obj.Key)
+	}
+	if excluded == "" && erased {
+		excluded = erasedReason
+	}
+
+	if exists != nil {
+		// Lifecycle rules move objects between classes without changing them
+		if obj.StorageClass != "" && exists.StorageClass != obj.StorageClass {
+			exists.StorageClass = obj.StorageClass
+			if err := batch.SetStorageClass(exists.ID, obj.StorageClass); err != nil {
+				log.Printf("Warning: Failed to update storage class of %s: %v", obj.Key, err)
+			}
+		}
+
+		if excluded != "" {
+			// Copies made before the pattern was added are kept
+			pending := exists.Status == db.StatusPending || exists.Status == db.StatusError || exists.Status == db.StatusSkippedFiltered
+			if pending && exists.StatusReason != excluded {
+				if err := batch.UpdateFileStatusReason(exists.ID, db.StatusSkippedFiltered, excluded); err != nil {
+					log.Printf("Warning: Failed to update file status: %v", err)
+				}
+			}
+			*leftOut++
+			return
+		}
+
+		if exists.Status == db.StatusSkippedFiltered {
+			if !inSample {
+				counts.sampledOut++
+				return
+			}
+			// Previously filtered out, now part of the sample
+			log.Printf("Debug: Including previously filtered file %s", obj.Key)
+			if exists.ETag != obj.ETag {
+				// Changed while it was left out, e.g. when its replication
+				// failed after an overwrite
+				if err := s.requeueChanged(batch, exists, obj); err != nil {
+					log.Printf("Warning: Failed to requeue %s: %v", obj.Key, err)
+				}
+			} else if err := batch.UpdateFileStatus(exists.ID, db.StatusPending, ""); err != nil {
+				log.Printf("Warning: Failed to update file status: %v", err)
+			}
+			counts.added++
+			return
+		}
+
+		if exists.Status == db.StatusDeleted {
+			// Deleted by a mirror sync, now back in the source
+			if err := s.requeueChanged(batch, exists, obj); err != nil {
+				log.Printf("Warning: Failed to requeue %s: %v", obj.Key, err)
+			}
+			counts.added++
+			return
+		}
+
+		if exists.ETag != obj.ETag && sameVersion(exists, obj) {
+			// Record the ETag in the form the source reports now
+			exists.ETag = obj.ETag
+			if err := batch.UpdateFileEntry(exists); err != nil {
+				log.Printf("Warning: Failed to update ETag of %s: %v", obj.Key, err)
+			}
+			counts.skipped++
+			return
+		}
+
+		// If file exists but ETag is different, the change policy decides
+		// whether it is copied again
+		if exists.ETag != obj.ETag {
+			requeued, err := s.handleChange(batch, exists, obj)
+			if err != nil {
+				log.Printf("Warning: Failed to handle change of %s: %v", obj.Key, err)
+			}
+			if requeued {
+				counts.added++
+			} else {
+				counts.skipped++
+			}
+			return
+		}
+
+		log.Printf("Debug: Skipping file %s (already exists with same ETag)", obj.Key)
+		if exists.DriftETag != "" {
+			// The source reverted to the copied version
+			if err := batch.SetDriftETag(exists.ID, ""); err != nil {
+				log.Printf("Warning: Failed to clear drift of %s: %v", obj.Key, err)
+			}
+		}
+		counts.skipped++
+		return
+	}
+
+	// Add new file to database
+	entry := &db.FileEntry{
+		ProjectName:  s.projectName,
+		Path:         obj.Key,
+		Size:         obj.Size,
+		ETag:         obj.ETag,
+		LastModified: obj.LastModified,
+		Status:       db.StatusPending,
+		StorageClass: obj.StorageClass,
+	}
+	switch {
+	case excluded != "":
+		entry.Status = db.StatusSkippedFiltered
+		entry.StatusReason = excluded
+	case !inSample:
+		entry.Status = db.StatusSkippedFiltered
+		entry.StatusReason = fmt.Sprintf("not in %.4g%% sample", opts.SampleRate*100)
+	}
+
+	if err := batch.InsertFileEntry(entry); err != nil {
+		log.Printf("Warning: Failed to insert file entry: %v", err)
+		return
+	}
+
+	if excluded != "" {
+		*leftOut++
+		return
+	}
+	if !inSample {
+		counts.sampledOut++
+		return
+	}
+
+	log.Printf("Debug: Added file to database: %s (size: %d, etag: %s)", obj.Key, obj.Size, obj.ETag)
+	counts.added++
+}
+
+// SyncOptions controls a single sync run
+type SyncOptions struct {
+	Workers int
+	// MaxDuration stops dispatching new files once elapsed, letting in-flight
+	// transfers finish. Zero means no limit.
+	MaxDuration time.Duration
+	// SkipExisting checks the destination before copying every file and
+	// marks files that are already present as skipped_existing. Without it
+	// only new and changed files are checked, not those requeued to be
+	// copied again, see checkExisting.
+	SkipExisting bool
+	// Force copies every file without checking the destination first
+	Force bool
+	// ExistingFromListing replaces the per-file checks of SkipExisting with
+	// a single listing of a MinIO or S3 destination
+	ExistingFromListing bool
+	// Delta fetches only the appended bytes of grown files whose local copy
+	// is still a prefix of the source object
+	Delta bool
+	// StallTimeout cancels and retries transfers once nothing was read or
+	// finished for this long. Zero disables the watchdog.
+	StallTimeout time.Duration
+	// StallDump logs a goroutine dump when a stall is detected
+	StallDump bool
+	// Mirror deletes objects from the main destination that no longer exist
+	// in the source; with MirrorDryRun they are only reported
+	Mirror       bool
+	MirrorDryRun bool
+	// Stop stops dispatching new files once closed, like MaxDuration, e.g.
+	// for a graceful shutdown
+	Stop <-chan struct{}
+}
+
+// StartSync copies pending files to the main destination, commits the atomic
+// groups that are complete, mirrors deletions when asked to, then brings every
+// additional destination up to date. Files that fail are counted in the result; an error is only returned
+// when the run itself failed or was cancelled or aborted.
+func (s *Service) StartSync(ctx context.Context, opts SyncOptions) (*SyncResult, error) {
+	result := &SyncResult{}
+	started := time.Now()
+	transferredBefore := s.transferred.Load()
+	s.runID = newRunID()
+	defer func() {
+		result.Duration = time.Since(started)
+		result.Transferred = s.transferred.Load() - transferredBefore
+		s.recordStats("sync", result)
+	}()
+
+	s.destStats.reset()
+	s.applyErasures(ctx)
+	err := s.syncMainDestination(ctx, opts, result)
+	s.checkPendingAge(ctx)
+	if errors.Is(err, ErrAlertAbort) || ctx.Err() != nil {
+		return result, err
+	}
+
+	if commitErr := s.commitGroups(ctx); commitErr != nil {
+		log.Printf("Warning: %v", commitErr)
+		if err == nil {
+			err = commitErr
+		}
+	}
+
+	if opts.Mirror || opts.MirrorDryRun {
+		if err != nil {
+			log.Printf("Warning: Skipping mirror deletions because the sync failed")
+		} else {
+			result.Mirror, err = s.mirror(ctx, opts)
+			if ctx.Err() != nil {
+				return result, err
+			}
+		}
+	}
+	if err == nil {
+		s.writeRunMarker(ctx, started, s.transferred.Load()-transferredBefore, result)
+	}
+
+	select {
+	case <-opts.Stop:
+		if len(s.extraDests) > 0 {
+			log.Printf("Stop requested, leaving the additional destinations for the next run")
+		}
+		return result, err
+	default:
+	}
+
+	for _, dest := range s.extraDests {
+		if destErr := s.syncExtraDestination(ctx, dest, opts.Workers); destErr != nil {
+			log.Printf("Warning: %v", destErr)
+			result.DestinationErrors++
+		}
+	}
+
+	return result, err
+}
+
+func (s *Service) syncMainDestination(ctx context.Context, opts SyncOptions, result *SyncResult) error {
+	log.Printf("Starting sync with %d workers...", opts.Workers)
+	defer s.logAccounting()
+
+	// Skip files found by a single listing of the destination
+	var listed map[string]bool
+	if opts.SkipExisting && opts.ExistingFromListing && s.destType.IsObjectStore() &&
+		!opts.Force && s.overwrite != config.OverwriteAlways {
+		var err error
+		if listed, err = s.skipListedFiles(ctx); err != nil {
+			return fmt.Errorf("failed to list destination: %w", err)
+		}
+	}
 
 	// Get pending files
 	files, err := s.database.GetPendingFiles(s.projectName, 0) // 0 means get all pending files
 	if err != nil {
 		return fmt.Errorf("failed to get pending files: %w", err)
 	}
+	files = s.dropExcluded(files)
+	files = s.dropDenied(ctx, files)
 
 	log.Printf("Found %d pending files to sync", len(files))
 	if len(files) == 0 {
 		return nil
 	}
+	return s.copyFiles(ctx, opts, files, listed, result)
+}
 
-	// Create worker pool
-	var wg sync.WaitGroup
-	filesChan := make(chan *db.FileEntry, workers)
-	errorsChan := make(chan error, workers)
-	doneChan := make(chan bool)
-
-	// Start workers
-	for i := 0; i < workers; i++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-			for file := range filesChan {
-				log.Printf("Worker %d: Processing file: %s", workerID, file.Path)
-
-				// Get file from source
-				reader, err := s.sourceClient.GetObject(ctx, file.Path)
-				if err != nil {
-					log.Printf("Worker %d: Failed to get file %s: %v", workerID, file.Path, err)
-					errorsChan <- fmt.Errorf("failed to get file %s: %w", file.Path, err)
-					continue
-				}
-				defer reader.Close()
+// Reasons for stopping the dispatch of a sync run early
+var (
+	errTimeLimit     = errors.New("time limit reached")
+	errRunAborted    = errors.New("run aborted")
+	errStopRequested = errors.New("stop requested")
+	// ErrEncryptionKeyMissing ends runs at the first object that can't be
+	// read without an SSE-C key, as the others likely can't either
+	ErrEncryptionKeyMissing = errors.New("objects are encrypted with SSE-C, set their key as encryption.key of the bucket in the config")
+)
 
-				log.Printf("Worker %d: Got file %s", workerID, file.Path)
+// copyFiles copies the given files to the main destination with a pool of
+// workers, updates their status and adds the outcome to result. With
+// SkipExisting, a non-nil listed set limits the existence checks to the files
+// it contains.
+func (s *Service) copyFiles(ctx context.Context, opts SyncOptions, files []*db.FileEntry, listed map[string]bool, result *SyncResult) error {
+	total := len(files)
 
-				// Save file to destination
-				if s.destType == config.DestinationLocal {
-					if err := s.localDest.SaveFile(ctx, file.Path, reader); err != nil {
-						log.Printf("Worker %d: Failed to save file %s: %v", workerID, file.Path, err)
-						errorsChan <- fmt.Errorf("failed to save file %s: %w", file.Path, err)
+	// Files held back by ordering rules are only dispatched once everything
+	// else in this run has been processed
+	files, held := s.ordering.split(files)
+	if len(held) > 0 {
+		log.Printf("Holding back %d files until the files they depend on are completed", len(held))
+	}
+
+	// Files below prefixes with a budget are copied by their own workers
+	lanes := s.prefixes.lanes(opts.Workers)
+	s.prefixes.assign(lanes, files)
+	workers := 0
+	for _, l := range lanes {
+		workers += l.workers
+		if len(s.prefixes) > 0 {
+			log.Printf("%s: %d files, %d workers", l, len(l.files), l.workers)
+		}
+	}
+
+	stats := &runStats{}
+	watch := newWatchdog(opts.StallTimeout, opts.StallDump)
+	releaseLeases := s.keepLeases()
+	defer releaseLeases()
+	s.pacer.start(workers)
+
+	startedAt := time.Now()
+	transferredBefore := s.transferred.Load()
+	s.sourceLatency.reset()
+	s.destLatency.reset()
+	stopProgress := s.serveProgress(func() RunProgress {
+		return RunProgress{
+			PID:         os.Getpid(),
+			StartedAt:   startedAt,
+			Workers:     workers,
+			Total:       total,
+			Completed:   stats.completed.Load(),
+			Failed:      stats.failed.Load(),
+			Transferred: s.transferred.Load() - transferredBefore,
+		}
+	})
+	defer stopProgress()
+
+	// The dispatcher and the workers share one group. Failed files are
+	// counted instead of returned, so that one bad file doesn't cancel the
+	// others; the group only ends early when ctx is cancelled.
+	g, groupCtx := errgroup.WithContext(ctx)
+	var inFlight sync.WaitGroup
+
+	// Dispatching stops on cancellation, at the time limit and when alert
+	// thresholds abort the run
+	stopCtx, stopDispatch := context.WithCancelCause(groupCtx)
+	defer stopDispatch(nil)
+	var deadline <-chan time.Time
+	if opts.MaxDuration > 0 {
+		log.Printf("Sync is limited to %s", opts.MaxDuration)
+		timer := time.NewTimer(opts.MaxDuration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	aborted := make(chan struct{})
+	go func() {
+		select {
+		case <-deadline:
+			stopDispatch(errTimeLimit)
+		case <-aborted:
+			stopDispatch(errRunAborted)
+		case <-opts.Stop:
+			stopDispatch(errStopRequested)
+		case <-stopCtx.Done():
+		}
+	}()
+
+	monitorCtx, stopMonitor := context.WithCancel(ctx)
+	defer stopMonitor()
+	go s.monitorAlerts(monitorCtx, stats, func() { close(aborted) })
+	go watch.monitor(monitorCtx, s, stats)
+
+	// dispatched and stopped are only read once the group has finished
+	var dispatched atomic.Int64
+	var stopped atomic.Bool
+
+	workerID := 0
+	for _, l := range lanes {
+		for range l.workers {
+			id := workerID
+			workerID++
+			g.Go(func() error {
+				for file := range l.ch {
+					// Files dispatched while the workers are paused by a
+					// storm of failures stay pending if the run stops
+					probe, err := s.storm.wait(stopCtx)
+					if err != nil {
+						dispatched.Add(-1)
+						inFlight.Done()
 						continue
 					}
-				} else {
-					if err := s.destClient.PutObject(ctx, file.Path, reader, file.Size); err != nil {
-						log.Printf("Worker %d: Failed to save file %s: %v", workerID, file.Path, err)
-						errorsChan <- fmt.Errorf("failed to save file %s: %w", file.Path, err)
-						continue
+					err = s.syncFileRecovered(groupCtx, id, file, opts, listed, stats, watch)
+					if err != nil {
+						result.recordFailure(file.Path, err)
+						if minio.IsEncryptionKeyMissing(err) {
+							stopDispatch(fmt.Errorf("%w: %s: %v", ErrEncryptionKeyMissing, file.Path, err))
+						}
+					}
+					if probe || groupCtx.Err() == nil {
+						if message := s.storm.record(err, probe); message != "" {
+							if err := s.notifier.Notify(groupCtx, "retry_storm", message, nil); err != nil {
+								log.Printf("Warning: Failed to send alert: %v", err)
+							}
+						}
 					}
+					inFlight.Done()
 				}
+				return nil
+			})
+		}
+	}
 
-				log.Printf("Worker %d: Successfully saved file %s", workerID, file.Path)
-
-				// Update file status
-				if err := s.database.UpdateFileStatus(file.ID, db.StatusCompleted, ""); err != nil {
-					log.Printf("Worker %d: Failed to update file status for %s: %v", workerID, file.Path, err)
-					errorsChan <- fmt.Errorf("failed to update file status: %w", err)
+	// Send files to workers, every lane on its own so that a slow lane
+	// doesn't hold back the others
+	stop := func() {
+		if !stopped.Swap(true) {
+			log.Printf("Stopping dispatch (%v), waiting for in-flight transfers to finish...", context.Cause(stopCtx))
+		}
+	}
+	dispatchLane := func(l *lane) bool {
+		for _, file := range l.files {
+			// A paused daemon holds back the remaining files
+			if s.control.wait(stopCtx, nil) == nil && stopCtx.Err() == nil {
+				inFlight.Add(1)
+				select {
+				case l.ch <- file:
+					dispatched.Add(1)
 					continue
+				case <-stopCtx.Done():
+					inFlight.Done()
 				}
-
-				log.Printf("Worker %d: Updated status for file %s", workerID, file.Path)
 			}
-		}(i)
+			stop()
+			return false
+		}
+		return true
 	}
-
-	// Send files to workers
-	go func() {
-		for _, file := range files {
-			filesChan <- file
+	dispatch := func() bool {
+		var wg sync.WaitGroup
+		for _, l := range lanes {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				dispatchLane(l)
+			}()
 		}
-		close(filesChan)
-	}()
-
-	// Wait for workers to finish
-	go func() {
 		wg.Wait()
-		close(errorsChan)
-		doneChan <- true
-	}()
+		return !stopped.Load()
+	}
+	g.Go(func() error {
+		defer func() {
+			for _, l := range lanes {
+				close(l.ch)
+			}
+		}()
+		if !dispatch() || len(held) == 0 {
+			return nil
+		}
 
-	// Collect errors
-	var errors []error
-	for {
+		idle := make(chan struct{})
+		go func() {
+			inFlight.Wait()
+			close(idle)
+		}()
 		select {
-		case err, ok := <-errorsChan:
-			if !ok {
-				continue
-			}
-			errors = append(errors, err)
-		case <-doneChan:
-			if len(errors) > 0 {
-				return fmt.Errorf("sync completed with %d errors", len(errors))
-			}
-			log.Println("Sync completed successfully")
+		case <-stopCtx.Done():
+			stop()
 			return nil
+		case <-idle:
 		}
+		s.prefixes.assign(lanes, s.releaseHeld(held, func(folder string) ([]string, error) {
+			return s.database.GetUnfinishedPaths(s.projectName, folder)
+		}))
+		dispatch()
+		return nil
+	})
+
+	if err := g.Wait(); err != nil {
+		return err
 	}
-}
 
-func (s *Service) GetStatus() (*SyncStatus, error) {
-	counts, err := s.database.GetStatusCounts(s.projectName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get status counts: %w", err)
+	result.Total += total
+	result.Completed += int(stats.completed.Load())
+	result.Skipped += int(stats.skipped.Load())
+	result.RestoreRequested += int(stats.restoring.Load())
+	result.DestNewer += int(stats.destNewer.Load())
+	result.ClaimedElsewhere += int(stats.claimedElsewhere.Load())
+	result.GaveUp += int(stats.gaveUp.Load())
+	result.Panics += int(stats.panics.Load())
+	dispatchedFiles := int(dispatched.Load())
+	result.NotDispatched += total - dispatchedFiles
+
+	// Runs that moved enough data update the profiles of the endpoints. The
+	// worker counts of runs split over prefix budgets aren't comparable.
+	if len(s.prefixes) == 0 {
+		s.recordProfiles(workers, s.transferred.Load()-transferredBefore, time.Since(startedAt))
 	}
 
-	recentErrors, err := s.database.GetRecentErrors(s.projectName, 10)
+	if stopped.Load() {
+		log.Printf("Sync stopped early: dispatched %d of %d files, %d left pending (partial, resumable)",
+			dispatchedFiles, total, total-dispatchedFiles)
+		if message := s.storm.paused(); message != "" {
+			log.Printf("Warning: Workers were paused: %s", message)
+		}
+	} else if dispatchedFiles < total {
+		log.Printf("%d files are still held back by ordering rules and left pending", total-dispatchedFiles)
+	}
+	select {
+	case <-aborted:
+		return fmt.Errorf("%w (%d errors)", ErrAlertAbort, stats.failed.Load())
+	default:
+	}
+	if cause := context.Cause(stopCtx); errors.Is(cause, ErrEncryptionKeyMissing) {
+		return cause
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("sync cancelled: %w", err)
+	}
+	if n := stats.failed.Load(); n > 0 {
+		log.Printf("Sync completed with %d errors", n)
+		return nil
+	}
+	log.Println("Sync completed successfully")
+	return nil
+}
+
+// syncFile copies a single file to the main destination and records the
+// outcome in the database
+func (s *Service) syncFile(ctx context.Context, workerID int, file *db.FileEntry, opts SyncOptions, listed map[string]bool, stats *runStats, watch *watchdog) error {
+	log.Printf("Worker %d: Processing file: %s", workerID, file.Path)
+
+	// Other instances syncing the project may have taken the file
+	claimed, err := s.claim(file)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get recent errors: %w", err)
+		log.Printf("Worker %d: Failed to claim file %s: %v", workerID, file.Path, err)
+		stats.failed.Add(1)
+		return err
+	}
+	if !claimed {
+		log.Printf("Worker %d: Skipping file %s (claimed by another instance)", workerID, file.Path)
+		stats.claimedElsewhere.Add(1)
+		return nil
 	}
 
-	return &SyncStatus{
-		Counts:       counts,
-		RecentErrors: recentErrors,
-	}, nil
-}
+	if s.checkExisting(file, opts) && (listed == nil || listed[file.Path]) {
+		if reason := s.existsAtDestination(ctx, file); reason != "" {
+			log.Printf("Worker %d: Skipping file %s (%s)", workerID, file.Path, reason)
+			if err := s.writer.updateFileStatusReason(file.ID, db.StatusSkippedExisting, reason); err != nil {
+				log.Printf("Worker %d: Failed to update file status for %s: %v", workerID, file.Path, err)
+				return fmt.Errorf("failed to update file status: %w", err)
+			}
+			stats.skipped.Add(1)
+			return nil
+		}
+	}
 
-type SyncStatus struct {
-	Counts       []db.StatusCount
-	RecentErrors []*db.FileEntry
-}
+	// Destination objects changed after the source are left for review
+	newer, err := s.destinationNewer(ctx, file)
+	if err != nil {
+		log.Printf("Worker %d: Failed to check destination of %s: %v", workerID, file.Path, err)
+		stats.failed.Add(1)
+		return err
+	}
+	if newer {
+		log.Printf("Worker %d: Not overwriting %s (%s)", workerID, file.Path, destNewerReason)
+		if err := s.holdNewer(file); err != nil {
+			log.Printf("Worker %d: Failed to update file status for %s: %v", workerID, file.Path, err)
+			return err
+		}
+		stats.destNewer.Add(1)
+		return nil
+	}
 
-// ImportFileList imports a list of file paths into the database
-func (s *Service) ImportFileList(ctx context.Context, paths []string) error {
-	if len(paths) == 0 {
-		return fmt.Errorf("no file paths provided")
+	// Files of an atomic commit group go to the staging prefix first
+	destPath, status := file.Path, db.StatusCompleted
+	if s.atomic.groupOf(file.Path) != "" {
+		destPath, status = s.atomic.stagingPath(file.Path), db.StatusStaged
 	}
 
-	// Read JSON entries from the file
-	file, err := os.Open(paths[0]) // paths[0] is the import file path
+	// Endpoints shared with other projects may have to free a slot first
+	release, err := s.budget.acquire(ctx, s.sourceClient, s.destClient)
 	if err != nil {
-		return fmt.Errorf("failed to open import file: %w", err)
+		log.Printf("Worker %d: Failed to get a transfer slot for %s: %v", workerID, file.Path, err)
+		stats.failed.Add(1)
+		return err
 	}
-	defer file.Close()
+	defer release()
 
-	var entries []MCListEntry
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
+	// A slow destination gets fewer transfers at a time
+	if err := s.pacer.acquire(ctx); err != nil {
+		log.Printf("Worker %d: Stopped waiting to transfer %s: %v", workerID, file.Path, err)
+		stats.failed.Add(1)
+		return err
+	}
+	defer s.pacer.release()
 
-		var entry MCListEntry
-		if err := json.Unmarshal([]byte(line), &entry); err != nil {
-			log.Printf("Warning: Failed to parse JSON line: %v", err)
-			continue
-		}
+	// Stalled transfers are cancelled by the watchdog and retried
+	for attempt := 1; ; attempt++ {
+		transferCtx, end := watch.begin(ctx, workerID, file.Path)
 
-		// Skip non-file entries
-		if entry.Type != "file" {
-			continue
+		// The copy a changed source replaces may have to be kept first
+		copied := false
+		err = s.keepPendingVersion(transferCtx, file)
+		// Grown files can be extended in place at local destinations
+		if err == nil && opts.Delta && s.localDest != nil && destPath == file.Path {
+			copied, err = s.deltaCopy(transferCtx, file)
+		}
+		if err == nil && !copied {
+			err = s.copyFileTo(transferCtx, file, destPath, s.dest)
+		}
+		if !end() || err == nil {
+			break
+		}
+		if attempt > maxStallRequeues {
+			log.Printf("Worker %d: Transfer of %s stalled %d times, leaving it pending", workerID, file.Path, attempt)
+			if err := s.writer.updateFileStatusReason(file.ID, db.StatusPending, "transfer stalled"); err != nil {
+				log.Printf("Worker %d: Failed to update file status for %s: %v", workerID, file.Path, err)
+			}
+			stats.failed.Add(1)
+			return fmt.Errorf("%w: %s", errTransferStalled, file.Path)
+		}
+		log.Printf("Worker %d: Requeueing stalled transfer of %s (attempt %d/%d)", workerID, file.Path, attempt+1, maxStallRequeues+1)
+	}
+	if errors.Is(err, ErrLegalHold) {
+		log.Printf("Worker %d: Not overwriting %s (%s)", workerID, file.Path, legalHoldReason)
+		if err := s.writer.updateFileStatusReason(file.ID, db.StatusSkippedExisting, legalHoldReason); err != nil {
+			log.Printf("Worker %d: Failed to update file status for %s: %v", workerID, file.Path, err)
+			return fmt.Errorf("failed to update file status: %w", err)
+		}
+		stats.skipped.Add(1)
+		return nil
+	}
+	if minio.IsArchived(err) {
+		if err := s.deferArchived(ctx, file); err != nil {
+			log.Printf("Worker %d: Failed to defer archived file %s: %v", workerID, file.Path, err)
+			stats.failed.Add(1)
+			return err
 		}
+		stats.restoring.Add(1)
+		return nil
+	}
+	if err != nil {
+		log.Printf("Worker %d: Failed to copy file %s: %v", workerID, file.Path, err)
+		stats.failed.Add(1)
+		// Transfers cut short by a stopped run stay pending
+		if ctx.Err() == nil {
+			gaveUp, recordErr := s.failFile(file, err)
+			if recordErr != nil {
+				log.Printf("Worker %d: Failed to update file status for %s: %v", workerID, file.Path, recordErr)
+			} else if gaveUp {
+				log.Printf("Worker %d: Giving up on %s after %d attempts", workerID, file.Path, file.Attempts+1)
+				stats.gaveUp.Add(1)
+			}
+		}
+		return err
+	}
+	stats.completed.Add(1)
 
-		entries = append(entries, entry)
+	log.Printf("Worker %d: Successfully saved file %s", workerID, destPath)
+
+	// Update file status
+	if err := s.writer.updateFileStatus(file.ID, status, ""); err != nil {
+		log.Printf("Worker %d: Failed to update file status for %s: %v", workerID, file.Path, err)
+		return fmt.Errorf("failed to update file status: %w", err)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading import file: %w", err)
+	s.recordStoredSize(file, destPath)
+
+	log.Printf("Worker %d: Updated status for file %s", workerID, file.Path)
+	return nil
+}
+
+// recordStoredSize records the size of a copy compressed at a local main
+// destination, which differs from the size of the source
+func (s *Service) recordStoredSize(file *db.FileEntry, destPath string) {
+	if s.localDest == nil || !s.localDest.Compressed() {
+		return
 	}
+	storedSize, err := s.localDest.StoredSize(destPath)
+	if err == nil {
+		err = s.writer.setStoredSize(file.ID, storedSize)
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to record stored size of %s: %v", file.Path, err)
+	}
+}
 
-	log.Printf("Importing %d files...", len(entries))
+// copyFile copies a single file from the source to the main destination
+func (s *Service) copyFile(ctx context.Context, file *db.FileEntry) error {
+	return s.withinBudget(ctx, s.destClient, func() error {
+		return s.copyFileTo(ctx, file, file.Path, s.dest)
+	})
+}
+
+// copyFileTo copies a single file from the source to destPath at the given
+// destination
+func (s *Service) copyFileTo(ctx context.Context, file *db.FileEntry, destPath string, dest StorageBackend) error {
+	if dest == s.dest {
+		defer s.destStats.invalidate(destPath)
+	}
 
-	// Process each entry
-	for _, entry := range entries {
-		// Add folder prefix to path if set
-		filePath := entry.Key
-		if s.sourceClient.GetFolderPath() != "" {
-			filePath = path.Join(s.sourceClient.GetFolderPath(), entry.Key)
+	// Object storage destinations get the metadata and tags of the source,
+	// and those added by the metadata rules. Server-side copies replace the
+	// metadata of the source once any is given, so they keep it this way.
+	serverSide := dest == s.dest && s.serverSideCopy && !s.compression.applies(file)
+	var metadata *minio.ObjectMetadata
+	if _, ok := unheld(dest).(*objectBackend); ok {
+		added := s.addMetadata.applies(file)
+		if s.preserveMetadata || (added && serverSide) {
+			var err error
+			metadata, err = s.sourceClient.GetObjectMetadata(ctx, file.Path)
+			if err != nil {
+				return fmt.Errorf("failed to get metadata of file %s: %w", file.Path, err)
+			}
 		}
+		if added {
+			metadata = s.addMetadata.add(file, s.runID, metadata)
+		}
+	}
 
-		// Skip if file already exists in database
-		exists, err := s.database.GetFileByPath(s.projectName, filePath)
-		if err != nil {
-			log.Printf("Warning: Failed to check file existence: %v", err)
-			continue
+	// Copies within one server don't pass through the copier, unless they
+	// are compressed on the way
+	// They bypass dest.Put, which checks the legal hold of other copies
+	if serverSide {
+		if err := protectHeld(ctx, dest, destPath); err != nil {
+			return err
 		}
-		if exists != nil {
-			log.Printf("Debug: Skipping file %s (already exists in database)", filePath)
-			continue
+		if err := s.destClient.CopyObjectFrom(ctx, s.sourceClient, file.Path, destPath, metadata); err != nil {
+			return fmt.Errorf("failed to copy file %s on the server: %w", file.Path, err)
 		}
+		return nil
+	}
 
-		// Add file to database
-		dbEntry := &db.FileEntry{
-			ProjectName:  s.projectName,
-			Path:         filePath,
-			Size:         entry.Size,
-			ETag:         entry.ETag,
-			LastModified: entry.LastModified,
-			Status:       db.StatusPending,
+	// Large objects go to the main destination in resumable parts
+	if dest == s.dest && s.destClient != nil &&
+		!s.compression.applies(file) && s.multipart.applies(file) {
+		if err := protectHeld(ctx, dest, destPath); err != nil {
+			return err
+		}
+		if err := s.putMultipart(ctx, file, destPath, metadata); err != nil {
+			return fmt.Errorf("failed to save file %s: %w", file.Path, err)
 		}
+		return nil
+	}
 
-		if err := s.database.InsertFileEntry(dbEntry); err != nil {
-			log.Printf("Warning: Failed to insert file entry: %v", err)
-			continue
+	// Interrupted downloads to a local main destination continue where
+	// they stopped, large ones are downloaded in concurrent chunks. Staged
+	// copies are written in one piece.
+	if destPath == file.Path {
+		if resumed, err := s.resumeDownload(ctx, file, dest); resumed || err != nil {
+			return err
+		}
+		if s.resumesDownloads(dest) && s.chunks.applies(file.Size) {
+			return s.downloadChunked(ctx, file)
 		}
+	}
 
-		log.Printf("Debug: Added file to database: %s (size: %d, etag: %s)", filePath, entry.Size, entry.ETag)
+	// Get file from source
+	reader, err := s.source.Get(ctx, file.Path)
+	if err != nil {
+		return fmt.Errorf("failed to get file %s: %w", file.Path, err)
 	}
+	defer reader.Close()
+	counted := s.meter(ctx, reader)
+	if _, ok := unheld(dest).(*localBackend); ok {
+		counted = s.toDisk(ctx, counted)
+	}
+	checksum := s.newChecksumWriter(dest)
 
-	// Print status distribution
+	// Save file to destination
+	err = dest.Put(ctx, destPath, checksum.wrap(counted), PutOptions{
+		Size:         file.Size,
+		SourceETag:   file.ETag,
+		Compress:     s.compression.applies(file),
+		Metadata:     metadata,
+		LastModified: file.LastModified,
+	})
+	if err != nil {
+		s.recordPartialDownload(file, dest, err)
+		return fmt.Errorf("failed to save file %s: %w", file.Path, err)
+	}
+	checksum.record(s, file)
+	return nil
+}
+
+// checkExisting reports whether the destination is checked for a file
+// before it is copied. Files requeued by the requeue, reset or verify
+// commands keep the reason as status reason and are copied again unless
+// SkipExisting asks to check every file, or the overwrite policy protects
+// existing destination objects.
+func (s *Service) checkExisting(file *db.FileEntry, opts SyncOptions) bool {
+	switch {
+	case opts.Force || s.overwrite == config.OverwriteAlways:
+		return false
+	case s.overwrite == config.OverwriteNever || s.overwrite == config.OverwriteIfNewer:
+		return true
+	}
+	return opts.SkipExisting || file.StatusReason == ""
+}
+
+// existsAtDestination returns the reason a file can be skipped because the
+// destination already holds it, or an empty string if it must be copied
+func (s *Service) existsAtDestination(ctx context.Context, file *db.FileEntry) string {
+	action, err := s.planAction(ctx, file)
+	if err != nil {
+		log.Printf("Warning: Failed to check destination of %s: %v", file.Path, err)
+		return ""
+	}
+	if action.Action == PlanSkip {
+		return action.Reason
+	}
+	return ""
+}
+
+func (s *Service) GetStatus() (*SyncStatus, error) {
 	counts, err := s.database.GetStatusCounts(s.projectName)
 	if err != nil {
-		log.Printf("Warning: Failed to get status counts: %v", err)
-	} else {
-		log.Printf("Debug: Status distribution after import:")
-		for _, count := range counts {
-			log.Printf("  - Status %s: %d files (%d bytes)", count.Status, count.Count, count.Size)
-		}
+		return nil, fmt.Errorf("failed to get status counts: %w", err)
 	}
 
-	return nil
+	skipReasons, err := s.database.GetSkipReasonCounts(s.projectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get skip reasons: %w", err)
+	}
+
+	recentErrors, err := s.database.GetRecentErrors(s.projectName, 10)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent errors: %w", err)
+	}
+
+	accounting, err := s.CheckAccounting()
+	if err != nil {
+		return nil, err
+	}
+
+	verifyCounts, err := s.database.GetVerifyCounts(s.projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	destinationCounts, err := s.database.GetDestinationStatusCounts(s.projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	changeCounts, err := s.database.GetFileChangeCounts(s.projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	drifted, err := s.database.CountDriftedFiles(s.projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles, err := s.database.GetEndpointProfiles(s.projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	storageClasses, err := s.database.GetStorageClassCounts(s.projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	storedSizes, err := s.database.GetStoredSizeTotals(s.projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	aging, err := s.database.GetAgingCounts(s.projectName, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	live, err := s.liveProgress()
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	return &SyncStatus{
+		Counts:       counts,
+		SkipReasons:  skipReasons,
+		RecentErrors: recentErrors,
+		Accounting:   accounting,
+		VerifyCounts: verifyCounts,
+
+		DestinationCounts: destinationCounts,
+
+		ChangeCounts: changeCounts,
+		Drifted:      drifted,
+
+		StorageClasses: storageClasses,
+		StoredSizes:    storedSizes,
+
+		Aging: aging,
+
+		Profiles: profiles,
+
+		Live: live,
+	}, nil
+}
+
+type SyncStatus struct {
+	Counts       []db.StatusCount
+	SkipReasons  []db.StatusCount
+	RecentErrors []*db.FileEntry
+	Accounting   *AccountingReport
+	VerifyCounts []db.VerifyCount
+
+	// DestinationCounts covers the additional destinations of the project
+	DestinationCounts []db.DestinationCount
+
+	// ChangeCounts are the source changes after copy per change policy, and
+	// Drifted the kept copies whose source currently differs
+	ChangeCounts []db.ChangeCount
+	Drifted      int64
+
+	// StorageClasses are the tracked files per storage class of the source
+	StorageClasses []db.StorageClassCount
+
+	// StoredSizes compares the size of the copies compressed at a local
+	// destination with their size on disk
+	StoredSizes *db.StoredSizeTotals
+
+	// Aging are the pending and failed files by how long they have waited
+	Aging []db.AgingCount
+
+	// Profiles are the performance of the endpoints observed by past runs
+	Profiles []db.EndpointProfile
+
+	// Live is the progress reported by a running sync, if any
+	Live *RunProgress
 }
@@ -0,0 +1,99 @@
+package sync
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+// statCacheSize bounds the number of destination stat results kept per run
+const statCacheSize = 10000
+
+type statEntry struct {
+	key  string
+	info *minio.ObjectInfo
+	err  error
+}
+
+// statCache is a bounded LRU of destination stat results, so a key evaluated
+// by several code paths during a run is only requested once
+type statCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newStatCache(size int) *statCache {
+	return &statCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *statCache) get(key string) (*statEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*statEntry), true
+}
+
+func (c *statCache) put(key string, info *minio.ObjectInfo, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = &statEntry{key: key, info: info, err: err}
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&statEntry{key: key, info: info, err: err})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*statEntry).key)
+	}
+}
+
+// invalidate drops the result of a key that was just written
+func (c *statCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// reset starts a new run with an empty cache
+func (c *statCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// statDestination returns the stat result of a key at the main destination,
+// answering repeated requests during a run from the cache. Only definite
+// answers are cached, not transient errors.
+func (s *Service) statDestination(ctx context.Context, key string) (*minio.ObjectInfo, error) {
+	if entry, ok := s.destStats.get(key); ok {
+		return entry.info, entry.err
+	}
+
+	info, err := s.dest.Stat(ctx, key)
+	if err == nil || minio.IsNotFound(err) {
+		s.destStats.put(key, info, err)
+	}
+	return info, err
+}
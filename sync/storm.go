@@ -0,0 +1,194 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// stormWindow is the number of recent file outcomes the error rate is
+	// computed from
+	stormWindow = 50
+	// stormMinSamples is the fewest outcomes the workers are paused after
+	stormMinSamples = 20
+	// stormErrorRate is the share of the recent files that must have failed
+	// for the same reason
+	stormErrorRate = 0.9
+	// A paused run retries a single file after stormInitialBackoff, doubling
+	// the pause up to stormMaxBackoff while it keeps failing
+	stormInitialBackoff = 30 * time.Second
+	stormMaxBackoff     = 10 * time.Minute
+)
+
+// stormCauses describes the error classes that affect every file alike
+// and what the operator can do about them. Other failures, such as missing
+// source objects, are left to the error status of the single files.
+var stormCauses = map[ErrorClass]struct{ description, advice string }{
+	ErrorAccessDenied: {"access denied", "check the credentials and permissions of the source and destination"},
+	ErrorThrottled:    {"throttled by the server", "reduce -workers or set -bandwidth-limit"},
+	ErrorNetwork:      {"connection failed", "check that the endpoints are up and reachable"},
+	ErrorTimeout:      {"timed out", "check that the endpoints are up and reachable"},
+	ErrorDiskFull:     {"no space left at the destination", "free up space at the local destination"},
+}
+
+// stormGuard pauses all workers of the main destination once nearly all
+// recent files failed for the same reason, e.g. because credentials
+// expired, instead of letting every worker fail through the backlog. While
+// paused, a single file is tried with exponential backoff; the workers
+// continue once it succeeds or the daemon is resumed with the ctl command.
+type stormGuard struct {
+	mu       sync.Mutex
+	outcomes []ErrorClass
+	next     int
+	// message describes the storm the workers are paused for, empty while
+	// they run
+	message   string
+	backoff   time.Duration
+	nextProbe time.Time
+	probing   bool
+	// changed is closed and replaced when the workers may continue
+	changed chan struct{}
+}
+
+func newStormGuard() *stormGuard {
+	return &stormGuard{changed: make(chan struct{})}
+}
+
+// stormCause returns the class of err if it affects every file alike, or
+// an empty class
+func stormCause(err error) ErrorClass {
+	if err == nil {
+		return ""
+	}
+	class := classifyError(err)
+	if _, ok := stormCauses[class]; !ok {
+		return ""
+	}
+	return class
+}
+
+// wait returns once a file may be copied. probe is set for the single file
+// tried while the workers are paused, whose outcome decides whether they
+// continue.
+func (g *stormGuard) wait(ctx context.Context) (probe bool, err error) {
+	for {
+		g.mu.Lock()
+		if g.message == "" {
+			g.mu.Unlock()
+			return false, nil
+		}
+		delay := time.Until(g.nextProbe)
+		if !g.probing && delay <= 0 {
+			g.probing = true
+			g.mu.Unlock()
+			return true, nil
+		}
+		changed := g.changed
+		g.mu.Unlock()
+
+		// Waiting workers check again when the probe is due or has ended
+		timer := time.NewTimer(max(delay, time.Second))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false, ctx.Err()
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// record adds the outcome of a file and returns the message of a storm it
+// started, or an empty string
+func (g *stormGuard) record(err error, probe bool) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.message != "" {
+		if !probe {
+			// Transfers started before the pause
+			return ""
+		}
+		g.probing = false
+		if errors.Is(err, context.Canceled) {
+			// Another worker tries again
+			g.wake()
+			return ""
+		}
+		if stormCause(err) != "" {
+			g.backoff = min(g.backoff*2, stormMaxBackoff)
+			g.nextProbe = time.Now().Add(g.backoff)
+			log.Printf("Warning: Files still fail (%v), trying again in %v", err, g.backoff)
+			g.wake()
+			return ""
+		}
+		log.Printf("Files can be copied again, resuming all workers")
+		g.reset()
+		return ""
+	}
+
+	cause := stormCause(err)
+	if len(g.outcomes) < stormWindow {
+		g.outcomes = append(g.outcomes, cause)
+	} else {
+		g.outcomes[g.next] = cause
+		g.next = (g.next + 1) % stormWindow
+	}
+	if len(g.outcomes) < stormMinSamples || cause == "" {
+		return ""
+	}
+
+	failed := 0
+	for _, outcome := range g.outcomes {
+		if outcome == cause {
+			failed++
+		}
+	}
+	rate := float64(failed) / float64(len(g.outcomes))
+	if rate < stormErrorRate {
+		return ""
+	}
+
+	g.message = fmt.Sprintf("%s for %.0f%% of requests (%d of the last %d files, last error: %v), %s; workers are paused and a single file is retried with backoff",
+		stormCauses[cause].description, rate*100, failed, len(g.outcomes), err, stormCauses[cause].advice)
+	g.backoff = stormInitialBackoff
+	g.nextProbe = time.Now().Add(g.backoff)
+	return g.message
+}
+
+// resume lets the workers continue at once, e.g. after the operator fixed
+// the credentials
+func (g *stormGuard) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.message != "" {
+		log.Printf("Resuming workers paused by the failure rate")
+		g.reset()
+	}
+}
+
+// paused returns the message of the storm the workers are paused for, or an
+// empty string
+func (g *stormGuard) paused() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.message
+}
+
+// reset forgets the storm and the recent outcomes. It must be called with
+// the mutex held.
+func (g *stormGuard) reset() {
+	g.message, g.probing = "", false
+	g.outcomes, g.next = g.outcomes[:0], 0
+	g.wake()
+}
+
+func (g *stormGuard) wake() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
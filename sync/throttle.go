@@ -0,0 +1,105 @@
+package sync
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleChunk bounds a single read of a throttled reader, so the limit is
+// applied smoothly even when callers read whole parts at once
+const throttleChunk = 64 << 10
+
+// rateLimiter is a token bucket shared by all workers of a service. Readers
+// take the tokens for what they read and wait while the bucket is in debt.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter for bytesPerSecond, or nil for no limit
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSecond)
+	return &rateLimiter{
+		rate:   rate,
+		burst:  max(rate/10, throttleChunk),
+		tokens: max(rate/10, throttleChunk),
+		last:   time.Now(),
+	}
+}
+
+// wait takes n tokens and blocks until the bucket is out of debt
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledReader limits the rate a reader is read at
+type throttledReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rateLimiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// meter wraps a reader of source data, counting the transferred bytes and
+// applying the bandwidth limit
+func (s *Service) meter(ctx context.Context, reader io.Reader) io.Reader {
+	counted := &countingReader{reader: reader, counter: &s.transferred}
+	if s.limiter == nil {
+		return counted
+	}
+	return &throttledReader{ctx: ctx, reader: counted, limiter: s.limiter}
+}
+
+// toDisk applies the disk write limit to a reader of data written to a local
+// destination
+func (s *Service) toDisk(ctx context.Context, reader io.Reader) io.Reader {
+	if s.diskLimiter == nil {
+		return reader
+	}
+	return &throttledReader{ctx: ctx, reader: reader, limiter: s.diskLimiter}
+}
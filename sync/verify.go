@@ -0,0 +1,357 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxVerifyAttempts is how often a file is retried when its copy cannot be
+// checked (e.g. network errors) before it is marked verify_failed
+const maxVerifyAttempts = 3
+
+// VerifyOptions controls a verification run
+type VerifyOptions struct {
+	Workers int
+	// Reverify queues every completed file again, including verified ones
+	Reverify bool
+	// Repair re-copies corrupt files and verifies them again, at most
+	// MaxRepairs times per file over its lifetime
+	Repair     bool
+	MaxRepairs int
+	// Deep compares copies with a fresh stat of the source object instead of
+	// the tracked metadata and checksums local copies
+	Deep bool
+	// Requeue sets files with mismatching copies back to pending, so the
+	// next sync copies them again, instead of quarantining them
+	Requeue bool
+}
+
+// VerifyResult summarizes a verification run
+type VerifyResult struct {
+	Verified int
+	Corrupt  int
+	Failed   int
+	Retry    int
+	Repaired int
+	Requeued int
+}
+
+// Verify checks completed copies against the tracked source metadata. Files
+// move from verify_pending to verified or corrupt, so an interrupted run
+// resumes with the files that were not checked yet.
+func (s *Service) Verify(ctx context.Context, opts VerifyOptions) (*VerifyResult, error) {
+	queued, err := s.database.QueueVerification(s.projectName, opts.Reverify, opts.Repair)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Queued %d completed files for verification", queued)
+
+	files, err := s.database.GetVerifyPendingFiles(s.projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Verifying %d files with %d workers...", len(files), opts.Workers)
+	s.destStats.reset()
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		result VerifyResult
+	)
+	filesChan := make(chan *db.FileEntry, opts.Workers)
+
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			for file := range filesChan {
+				target, status, message := s.verifyTarget(ctx, file, opts.Deep)
+				if status == db.VerifyNone {
+					status, message = s.verifyFile(ctx, target, opts.Deep)
+				}
+				repaired := false
+				for status == db.VerifyCorrupt && opts.Repair && file.RepairAttempts < opts.MaxRepairs {
+					file.RepairAttempts++
+					log.Printf("Worker %d: Re-copying corrupt file %s (repair attempt %d/%d): %s",
+						workerID, file.Path, file.RepairAttempts, opts.MaxRepairs, message)
+					if err := s.database.RecordRepairAttempt(file.ID); err != nil {
+						log.Printf("Worker %d: Failed to record repair attempt for %s: %v", workerID, file.Path, err)
+					}
+					if err := s.copyFile(ctx, target); err != nil {
+						message = fmt.Sprintf("%s (repair failed: %v)", message, err)
+						break
+					}
+					status, message = s.verifyFile(ctx, target, opts.Deep)
+					repaired = status == db.Verified
+				}
+
+				attempts := file.VerifyAttempts + 1
+				if status == db.VerifyPending && attempts >= maxVerifyAttempts {
+					status = db.VerifyFailed
+				}
+
+				if status == db.VerifyCorrupt && opts.Requeue {
+					// Store the metadata of the current source, so the copy
+					// made by the next sync passes the next verification
+					target.Status = db.StatusPending
+					target.StatusReason = "verify: " + message
+					target.ErrorMessage = ""
+					if err := s.database.UpdateFileEntry(target); err != nil {
+						log.Printf("Worker %d: Failed to requeue %s: %v", workerID, file.Path, err)
+					}
+					mu.Lock()
+					result.Requeued++
+					mu.Unlock()
+					log.Printf("Worker %d: Requeued %s: %s", workerID, file.Path, message)
+					continue
+				}
+
+				if err := s.recordVerifyResult(file, status, message, attempts); err != nil {
+					log.Printf("Worker %d: Failed to update verify status for %s: %v", workerID, file.Path, err)
+				}
+
+				mu.Lock()
+				switch status {
+				case db.Verified:
+					result.Verified++
+					if repaired {
+						result.Repaired++
+						log.Printf("Worker %d: Repaired %s", workerID, file.Path)
+					}
+				case db.VerifyCorrupt:
+					result.Corrupt++
+					log.Printf("Worker %d: CORRUPT %s: %s", workerID, file.Path, message)
+				case db.VerifyFailed:
+					result.Failed++
+					log.Printf("Worker %d: Failed to verify %s: %s", workerID, file.Path, message)
+				default:
+					result.Retry++
+					log.Printf("Worker %d: Could not verify %s, will retry: %s", workerID, file.Path, message)
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+
+	for _, file := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		filesChan <- file
+	}
+	close(filesChan)
+	wg.Wait()
+
+	log.Printf("Verification finished: %d verified (%d repaired), %d corrupt, %d requeued, %d failed, %d left for retry",
+		result.Verified, result.Repaired, result.Corrupt, result.Requeued, result.Failed, result.Retry)
+
+	if ctx.Err() != nil {
+		return &result, fmt.Errorf("verification interrupted: %w", ctx.Err())
+	}
+	return &result, nil
+}
+
+// recordVerifyResult stores the outcome of a verification, quarantining
+// corrupt copies and releasing repaired ones
+func (s *Service) recordVerifyResult(file *db.FileEntry, status db.VerifyStatus, message string, attempts int) error {
+	if status == db.VerifyCorrupt {
+		return s.database.QuarantineFile(file.ID, message, attempts)
+	}
+
+	if file.Status != db.StatusCompleted && status == db.Verified {
+		// Corrupt copy that is fine now, release it from quarantine
+		if err := s.database.UpdateFileStatus(file.ID, db.StatusCompleted, ""); err != nil {
+			return err
+		}
+	}
+	return s.database.UpdateVerifyStatus(file.ID, status, message, attempts)
+}
+
+// GetCorruptFiles returns the quarantined files for the corruption report
+func (s *Service) GetCorruptFiles() ([]*db.FileEntry, error) {
+	return s.database.GetCorruptFiles(s.projectName)
+}
+
+// verifyTarget returns the source metadata a copy is checked against: the
+// tracked metadata, or in deep mode the current source object. Files whose
+// source can't be stat'ed get verify_pending.
+func (s *Service) verifyTarget(ctx context.Context, file *db.FileEntry, deep bool) (*db.FileEntry, db.VerifyStatus, string) {
+	if !deep {
+		return file, db.VerifyNone, ""
+	}
+
+	source, err := s.source.Stat(ctx, file.Path)
+	if err != nil {
+		if minio.IsNotFound(err) {
+			return file, db.VerifyPending, "source object is missing"
+		}
+		return file, db.VerifyPending, err.Error()
+	}
+	// A change kept under the keep policy leaves the first copy in place
+	if source.ETag == file.DriftETag || (source.ETag == file.ETag && source.Size == file.Size) {
+		return file, db.VerifyNone, ""
+	}
+
+	target := *file
+	target.Size = source.Size
+	target.ETag = source.ETag
+	target.LastModified = source.LastModified
+	target.SHA256 = ""
+	return &target, db.VerifyNone, ""
+}
+
+// verifyFile compares the destination copy with the source metadata of file,
+// checksumming local copies in deep mode. Copies uploaded in multiple parts
+// are compared by the checksums both servers stored, or in deep mode by
+// downloading both sides when there are none. It returns verify_pending when
+// the copy could not be checked.
+func (s *Service) verifyFile(ctx context.Context, file *db.FileEntry, deep bool) (db.VerifyStatus, string) {
+	info, err := s.statDestination(ctx, file.Path)
+	if err != nil {
+		if minio.IsNotFound(err) {
+			return db.VerifyCorrupt, "destination object is missing"
+		}
+		return db.VerifyPending, err.Error()
+	}
+	if info.Size != file.Size {
+		return db.VerifyCorrupt, fmt.Sprintf("size mismatch: source %d, destination %d", file.Size, info.Size)
+	}
+	// Local copies have no ETag, only their content can be checked
+	if info.ETag == "" {
+		return s.verifyContent(ctx, file, deep)
+	}
+	// Multipart ETags depend on the part size, so only plain MD5 ETags are comparable
+	if !isMultipartETag(file.ETag) && !isMultipartETag(info.ETag) {
+		if info.ETag != file.ETag {
+			return db.VerifyCorrupt, fmt.Sprintf("ETag mismatch: source %s, destination %s", file.ETag, info.ETag)
+		}
+		return db.Verified, ""
+	}
+	// The content of compressed copies differs from the source by design,
+	// and copies kept under the keep policy differ from the current source
+	if info.Compressed || file.DriftETag != "" {
+		return db.Verified, ""
+	}
+
+	status, message, compared := s.compareChecksums(ctx, file)
+	if compared || !deep {
+		return status, message
+	}
+	return s.compareContent(ctx, file.Path)
+}
+
+// verifyContent checksums a copy without an ETag in deep mode or with
+// -checksum. Copies with a recorded SHA256 checksum are compared with it, and
+// with the source checksum when -checksum is set; in deep mode the others are
+// compared with the source ETag, or with the source content when that ETag is
+// not an MD5 checksum.
+func (s *Service) verifyContent(ctx context.Context, file *db.FileEntry, deep bool) (db.VerifyStatus, string) {
+	if file.SHA256 != "" && (deep || s.checksums) {
+		checksum, err := hashSHA256(ctx, s.dest, file.Path)
+		if err != nil {
+			return db.VerifyPending, err.Error()
+		}
+		if checksum != file.SHA256 {
+			return db.VerifyCorrupt, fmt.Sprintf("SHA256 checksum mismatch: recorded %s, destination %s", file.SHA256, checksum)
+		}
+		return db.Verified, ""
+	}
+	if s.checksums {
+		mismatch, err := s.sameChecksum(ctx, file)
+		if err != nil {
+			return db.VerifyPending, err.Error()
+		}
+		if mismatch != "" {
+			return db.VerifyCorrupt, mismatch
+		}
+		return db.Verified, ""
+	}
+	if !deep {
+		return db.Verified, ""
+	}
+	if isMultipartETag(file.ETag) {
+		if file.DriftETag != "" {
+			return db.Verified, ""
+		}
+		return s.compareContent(ctx, file.Path)
+	}
+
+	checksum, err := s.dest.Hash(ctx, file.Path)
+	if err != nil {
+		return db.VerifyPending, err.Error()
+	}
+	if checksum != file.ETag {
+		return db.VerifyCorrupt, fmt.Sprintf("checksum mismatch: source %s, destination %s", file.ETag, checksum)
+	}
+	return db.Verified, ""
+}
+
+// compareChecksums compares the checksums the source and the destination
+// stored for an object, which costs a HEAD request per side instead of a
+// download. It reports whether both sides had a checksum of the same
+// algorithm; without one the copy only passed the size check.
+func (s *Service) compareChecksums(ctx context.Context, file *db.FileEntry) (db.VerifyStatus, string, bool) {
+	dest, err := s.destClient.StatChecksums(ctx, file.Path)
+	if err != nil {
+		return db.VerifyPending, err.Error(), true
+	}
+	if len(dest.Checksums) == 0 {
+		return db.Verified, "", false
+	}
+	source, err := s.sourceClient.StatChecksums(ctx, file.Path)
+	if err != nil {
+		return db.VerifyPending, err.Error(), true
+	}
+	if source.ETag != file.ETag {
+		return db.VerifyPending, "source changed since it was listed", true
+	}
+
+	for _, algorithm := range []string{"SHA256", "SHA1", "CRC32C", "CRC32"} {
+		sourceSum, destSum := source.Checksums[algorithm], dest.Checksums[algorithm]
+		if sourceSum == "" || destSum == "" {
+			continue
+		}
+		if sourceSum != destSum {
+			return db.VerifyCorrupt, fmt.Sprintf("%s checksum mismatch: source %s, destination %s", algorithm, sourceSum, destSum), true
+		}
+		log.Printf("Debug: Verified %s by its %s checksum", file.Path, algorithm)
+		return db.Verified, "", true
+	}
+	return db.Verified, "", false
+}
+
+// compareContent downloads the source object and its copy and compares
+// their MD5 checksums, for copies whose ETags and checksums can't be compared
+func (s *Service) compareContent(ctx context.Context, key string) (db.VerifyStatus, string) {
+	var sourceHash, destHash string
+	g, groupCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		sourceHash, err = s.source.Hash(groupCtx, key)
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		destHash, err = s.dest.Hash(groupCtx, key)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return db.VerifyPending, err.Error()
+	}
+	if sourceHash != destHash {
+		return db.VerifyCorrupt, fmt.Sprintf("content mismatch: source MD5 %s, destination MD5 %s", sourceHash, destHash)
+	}
+	return db.Verified, ""
+}
+
+func isMultipartETag(etag string) bool {
+	return strings.Contains(etag, "-")
+}
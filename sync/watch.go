@@ -0,0 +1,154 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/minio"
+)
+
+const (
+	// watchFlushInterval is how often changed objects reported by bucket
+	// notifications are recorded and synced
+	watchFlushInterval = 2 * time.Second
+	// watchRetryDelay is the pause before subscribing again after the
+	// notifications failed
+	watchRetryDelay = 30 * time.Second
+	watchQueueSize  = 1000
+)
+
+// errNotificationsEnded is returned when the server closed the subscription
+var errNotificationsEnded = errors.New("bucket notifications ended")
+
+// watch keeps the destination in sync from the bucket notifications of the
+// source. The source is listed in full on startup and whenever the
+// subscription had to be renewed, since changes made in between are not
+// reported; after that only the notified objects are recorded and copied.
+func (s *Service) watch(ctx context.Context, opts RunOptions) error {
+	log.Printf("Starting daemon mode (watching bucket notifications, workers: %d)", opts.Workers)
+	for {
+		if !s.waitResumed(ctx, opts) {
+			return nil
+		}
+		s.control.setState(daemonListing, time.Time{})
+		if err := s.UpdateSourceList(ctx, opts.List); err != nil {
+			log.Printf("Warning: Failed to update source file list: %v", err)
+		}
+		if _, err := s.syncPending(ctx, opts); err != nil {
+			return err
+		}
+
+		err := s.followEvents(ctx, opts)
+		if errors.Is(err, ErrAlertAbort) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("Daemon stopped: %v", ctx.Err())
+			return nil
+		case <-opts.Stop:
+			log.Printf("Daemon stopped gracefully")
+			return nil
+		default:
+		}
+
+		log.Printf("Warning: %v, listing the source again in %s", err, watchRetryDelay)
+		select {
+		case <-ctx.Done():
+			log.Printf("Daemon stopped: %v", ctx.Err())
+			return nil
+		case <-opts.Stop:
+			log.Printf("Daemon stopped gracefully")
+			return nil
+		case <-time.After(watchRetryDelay):
+		}
+	}
+}
+
+// followEvents records the objects created in the source as they are
+// reported and syncs them, until the subscription fails or the daemon stops
+func (s *Service) followEvents(ctx context.Context, opts RunOptions) error {
+	listenCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events := make(chan minio.ObjectEvent, watchQueueSize)
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- s.sourceClient.ListenObjectEvents(listenCtx, func(event minio.ObjectEvent) error {
+			select {
+			case events <- event:
+				return nil
+			case <-listenCtx.Done():
+				return listenCtx.Err()
+			}
+		})
+	}()
+	log.Printf("Watching bucket notifications of %s/%s", s.sourceClient.GetBucketName(), s.sourceClient.GetFolderPath())
+
+	ticker := time.NewTicker(watchFlushInterval)
+	defer ticker.Stop()
+	changed := make(map[string]minio.ObjectInfo)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-opts.Stop:
+			return nil
+		case err := <-listenErr:
+			if err == nil {
+				err = errNotificationsEnded
+			}
+			return err
+		case event := <-events:
+			if event.Removed {
+				log.Printf("Debug: Ignoring removal of %s", event.Object.Key)
+				continue
+			}
+			if s.watched(event.Object.Key, opts.List) && opts.List.inWindow(event.Object.LastModified) {
+				changed[event.Object.Key] = event.Object
+			}
+		case <-ticker.C:
+			if len(changed) == 0 {
+				continue
+			}
+			objects := make([]minio.ObjectInfo, 0, len(changed))
+			for _, obj := range changed {
+				objects = append(objects, obj)
+			}
+			clear(changed)
+
+			var tally listCounts
+			if err := s.recordBatch(ctx, objects, opts.List, &tally, nil); err != nil {
+				log.Printf("Warning: Failed to record %d notified objects: %v", len(objects), err)
+				continue
+			}
+			log.Printf("Notified of %d changed objects, %d queued", len(objects), tally.added)
+			if tally.added > 0 {
+				if _, err := s.syncPending(ctx, opts); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// watched reports whether a notified key is part of the listing of the
+// source folder
+func (s *Service) watched(key string, opts ListOptions) bool {
+	if strings.HasSuffix(key, "/") {
+		return false
+	}
+	if opts.Depth > 0 {
+		relative := key
+		if folder := s.sourceClient.GetFolderPath(); folder != "" {
+			relative = strings.TrimPrefix(key, strings.TrimSuffix(folder, "/")+"/")
+		}
+		if strings.Count(relative, "/") >= opts.Depth {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package sync
+
+import (
+	"context"
+	"log"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// maxStallRequeues is how often a stalled transfer is retried within a run
+// before it is left pending for the next run
+const maxStallRequeues = 2
+
+// watchdog detects runs that stopped making progress while transfers are in
+// flight, typically because of hung connections, and cancels the transfers
+// that have been running for longer than the timeout
+type watchdog struct {
+	timeout time.Duration
+	dump    bool
+
+	mu     sync.Mutex
+	active map[int]*activeTransfer
+}
+
+type activeTransfer struct {
+	path      string
+	startedAt time.Time
+	cancel    context.CancelFunc
+	stalled   bool
+}
+
+// newWatchdog returns nil when timeout is zero, disabling stall detection
+func newWatchdog(timeout time.Duration, dump bool) *watchdog {
+	if timeout <= 0 {
+		return nil
+	}
+	return &watchdog{timeout: timeout, dump: dump, active: make(map[int]*activeTransfer)}
+}
+
+// begin registers the transfer of a worker and returns the context to run it
+// with. end unregisters it and reports whether it was cancelled as stalled.
+func (w *watchdog) begin(ctx context.Context, workerID int, path string) (context.Context, func() (stalled bool)) {
+	if w == nil {
+		return ctx, func() bool { return false }
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	transfer := &activeTransfer{path: path, startedAt: time.Now(), cancel: cancel}
+	w.mu.Lock()
+	w.active[workerID] = transfer
+	w.mu.Unlock()
+
+	return ctx, func() bool {
+		w.mu.Lock()
+		delete(w.active, workerID)
+		stalled := transfer.stalled
+		w.mu.Unlock()
+		cancel()
+		return stalled
+	}
+}
+
+// end unregisters and cancels the transfer of a worker that stopped without
+// ending it, e.g. because it panicked
+func (w *watchdog) end(workerID int) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	transfer := w.active[workerID]
+	delete(w.active, workerID)
+	w.mu.Unlock()
+	if transfer != nil {
+		transfer.cancel()
+	}
+}
+
+// monitor checks for progress until ctx is done. Progress is any byte read
+// from the source or any file finishing.
+func (w *watchdog) monitor(ctx context.Context, s *Service, stats *runStats) {
+	if w == nil {
+		return
+	}
+
+	ticker := time.NewTicker(max(w.timeout/4, time.Second))
+	defer ticker.Stop()
+
+	lastBytes := s.transferred.Load()
+	lastFinished := stats.completed.Load() + stats.failed.Load()
+	lastProgress := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			bytes := s.transferred.Load()
+			finished := stats.completed.Load() + stats.failed.Load()
+			if bytes != lastBytes || finished != lastFinished {
+				lastBytes, lastFinished, lastProgress = bytes, finished, now
+				continue
+			}
+			if now.Sub(lastProgress) >= w.timeout && w.cancelStalled(now, now.Sub(lastProgress)) {
+				lastProgress = now
+			}
+		}
+	}
+}
+
+// cancelStalled logs the transfers in flight and cancels the ones running
+// for longer than the timeout. It reports whether any were cancelled.
+func (w *watchdog) cancelStalled(now time.Time, idle time.Duration) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.active) == 0 {
+		return false
+	}
+
+	log.Printf("Warning: No progress for %s with %d transfers in flight", idle.Round(time.Second), len(w.active))
+	if w.dump {
+		log.Printf("Debug: Goroutine dump:")
+		pprof.Lookup("goroutine").WriteTo(log.Writer(), 1)
+	}
+
+	cancelled := false
+	for workerID, transfer := range w.active {
+		running := now.Sub(transfer.startedAt)
+		if running < w.timeout || transfer.stalled {
+			log.Printf("Warning: Worker %d: %s in flight for %s", workerID, transfer.path, running.Round(time.Second))
+			continue
+		}
+		log.Printf("Warning: Worker %d: Cancelling stalled transfer of %s after %s", workerID, transfer.path, running.Round(time.Second))
+		transfer.stalled = true
+		transfer.cancel()
+		cancelled = true
+	}
+	return cancelled
+}
@@ -0,0 +1,126 @@
+package sync
+
+import (
+	"time"
+
+	"github.com/chmdznr/minio-simple-copier/v2/db"
+)
+
+// maxStatusBatch limits how many status updates share a transaction
+const maxStatusBatch = 256
+
+// statusWriter writes the status updates of the sync workers from a single
+// goroutine. Workers queue an update and wait until it is committed; updates
+// queued while a transaction commits are written together in the next one,
+// so many workers neither contend for the SQLite write lock nor fail with
+// "database is locked".
+type statusWriter struct {
+	database *db.Database
+	updates  chan statusUpdate
+	done     chan struct{}
+}
+
+type statusUpdate struct {
+	apply  func(*db.Batch) error
+	result chan error
+}
+
+func newStatusWriter(database *db.Database) *statusWriter {
+	w := &statusWriter{
+		database: database,
+		updates:  make(chan statusUpdate, maxStatusBatch),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// write queues an update and waits until it is committed
+func (w *statusWriter) write(apply func(*db.Batch) error) error {
+	update := statusUpdate{apply: apply, result: make(chan error, 1)}
+	w.updates <- update
+	return <-update.result
+}
+
+// close stops the writer once the queued updates are written. Nothing may
+// be written afterwards.
+func (w *statusWriter) close() {
+	close(w.updates)
+	<-w.done
+}
+
+func (w *statusWriter) run() {
+	defer close(w.done)
+	for update := range w.updates {
+		pending := []statusUpdate{update}
+	drain:
+		for len(pending) < maxStatusBatch {
+			select {
+			case next, ok := <-w.updates:
+				if !ok {
+					break drain
+				}
+				pending = append(pending, next)
+			default:
+				break drain
+			}
+		}
+		w.commit(pending)
+	}
+}
+
+// commit writes updates in one transaction. An update that fails doesn't
+// affect the others, unless the whole transaction fails.
+func (w *statusWriter) commit(updates []statusUpdate) {
+	errs := make([]error, len(updates))
+	batch, err := w.database.BeginBatch()
+	if err == nil {
+		for i, update := range updates {
+			errs[i] = update.apply(batch)
+		}
+		err = batch.Commit()
+	}
+	for i, update := range updates {
+		if err != nil {
+			update.result <- err
+		} else {
+			update.result <- errs[i]
+		}
+	}
+}
+
+func (w *statusWriter) updateFileStatus(id int64, status db.FileStatus, errorMessage string) error {
+	return w.write(func(b *db.Batch) error {
+		return b.UpdateFileStatus(id, status, errorMessage)
+	})
+}
+
+func (w *statusWriter) updateFileStatusReason(id int64, status db.FileStatus, reason string) error {
+	return w.write(func(b *db.Batch) error {
+		return b.UpdateFileStatusReason(id, status, reason)
+	})
+}
+
+func (w *statusWriter) setStoredSize(id int64, storedSize int64) error {
+	return w.write(func(b *db.Batch) error {
+		return b.SetStoredSize(id, storedSize)
+	})
+}
+
+func (w *statusWriter) setSHA256(id int64, checksum string) error {
+	return w.write(func(b *db.Batch) error {
+		return b.SetSHA256(id, checksum)
+	})
+}
+
+func (w *statusWriter) requestRestore(id int64, reason string, retryAt time.Time) error {
+	return w.write(func(b *db.Batch) error {
+		return b.RequestRestore(id, reason, retryAt)
+	})
+}
+
+func (w *statusWriter) setDestinationStatus(projectName, destination string, entry *db.FileEntry, status db.FileStatus, reason, errorMessage string) error {
+	return w.write(func(b *db.Batch) error {
+		return b.SetDestinationStatus(projectName, destination, entry, status, reason, errorMessage)
+	})
+}